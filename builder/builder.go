@@ -0,0 +1,53 @@
+// builder/builder.go
+package builder
+
+import (
+	"fmt"
+	"sort"
+
+	"prepare.sh/dockermock/data"
+)
+
+// Builder builds an image from a build context and records it in the local
+// image store
+type Builder interface {
+	Build(opts Options) (*data.Image, error)
+}
+
+// Instance is a builder instance `docker buildx use` can select
+type Instance struct {
+	Name        string
+	Driver      string
+	Description string
+}
+
+// DefaultInstance builds with the local engine
+const DefaultInstance = "default"
+
+// Instances lists the builder instances, the default first
+var Instances = []Instance{
+	{Name: DefaultInstance, Driver: "docker", Description: "Offline local build engine"},
+	{Name: "github", Driver: "github-actions", Description: "GitHub Actions workflow pushing to ghcr.io"},
+	{Name: "kaniko", Driver: "kubernetes", Description: "Kaniko executor in a Kubernetes Job"},
+}
+
+// LookupInstance returns the builder instance called name
+func LookupInstance(name string) (Instance, error) {
+	for _, inst := range Instances {
+		if inst.Name == name {
+			return inst, nil
+		}
+	}
+	return Instance{}, fmt.Errorf("no builder %q found", name)
+}
+
+// sortedKeys returns the keys of m in order, e.g. to pass build args
+// deterministically
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,181 @@
+// builder/cache.go
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
+)
+
+// stepResult is what a build step adds to the image
+type stepResult struct {
+	layer     *data.Layer // nil for steps that only change the config
+	createdBy string      // history entry
+	checksum  string      // of the files the step copied, if any
+}
+
+// cacheDigest returns a cache key made of parts
+func cacheDigest(parts ...string) string {
+	return oci.Digest([]byte(strings.Join(parts, "\n")))
+}
+
+// stepKey returns the cache key of a step before it runs: the key of the
+// previous step, the instruction and the config and build args it sees.
+// Steps copying files add the checksum of those files once they ran.
+func (bd *build) stepKey(s *stage, inst *data.Instruction, prefix string) string {
+	parts := []string{s.cacheKey, prefix + inst.Original}
+	for _, h := range inst.Heredocs {
+		parts = append(parts, h.Name, h.Content)
+	}
+	config, _ := json.Marshal(s.config.Config)
+	parts = append(parts, string(config))
+	for _, name := range s.argOrder {
+		if value, ok := s.args[name]; ok {
+			parts = append(parts, name+"="+value)
+		}
+	}
+	return cacheDigest(parts...)
+}
+
+// commit adds the result of a step to the stage, or the cached result of
+// an earlier build with the same key, and reports the step as done or
+// CACHED
+func (bd *build) commit(s *stage, v *vertex, key, description string, result stepResult) error {
+	cached, err := bd.record(s, key, description, result)
+	if err != nil {
+		return err
+	}
+	if cached {
+		v.cached()
+	} else {
+		v.done()
+	}
+	return nil
+}
+
+// record adds the result of a step to the stage and saves it in the build
+// cache, or adds the cached result of an earlier build with the same key,
+// layer and created time included. It reports whether the cache was used.
+func (bd *build) record(s *stage, key, description string, result stepResult) (bool, error) {
+	if result.checksum != "" {
+		key = cacheDigest(key, result.checksum)
+	}
+	record, err := bd.lookupCache(key)
+	if err != nil {
+		return false, err
+	}
+	if record != nil {
+		var layer *data.Layer
+		if record.HasLayer() {
+			layer = &data.Layer{Blob: record.Layer, DiffID: record.DiffID, Size: record.LayerSize}
+		}
+		bd.addStep(s, key, layer, record.CreatedBy, record.Created)
+		return true, nil
+	}
+
+	if bd.cache != nil {
+		record := &data.CacheRecord{
+			Key:         key,
+			Parent:      s.cacheKey,
+			Description: description,
+			CreatedBy:   result.createdBy,
+			Created:     bd.now,
+			LastUsed:    bd.now,
+		}
+		if result.layer != nil {
+			record.Layer = result.layer.Blob
+			record.DiffID = result.layer.DiffID
+			record.LayerSize = result.layer.Size
+		}
+		if err := bd.cache.Put(record); err != nil {
+			return false, fmt.Errorf("failed to save build cache: %v", err)
+		}
+	}
+	bd.addStep(s, key, result.layer, result.createdBy, bd.now)
+	return false, nil
+}
+
+// addStep appends the layer and history entry of a step and records its
+// key in the inline cache of the image
+func (bd *build) addStep(s *stage, key string, layer *data.Layer, createdBy string, created time.Time) {
+	entry := oci.BuildCacheEntry{Key: key, Layer: -1}
+	if layer != nil {
+		s.layers = append(s.layers, *layer)
+		entry.Layer = len(s.layers) - 1
+	}
+	bd.historyAt(s, createdBy, layer == nil, created)
+	entry.History = len(s.config.History) - 1
+	s.config.BuildCache = append(s.config.BuildCache, entry)
+	s.cacheKey = key
+}
+
+// lookupCache returns the cached result of the step with key, from the
+// build cache or a --cache-from image; nil if there is none or --no-cache
+// was given
+func (bd *build) lookupCache(key string) (*data.CacheRecord, error) {
+	if bd.opts.NoCache {
+		return nil, nil
+	}
+	if bd.cache != nil {
+		record, err := bd.cache.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read build cache: %v", err)
+		}
+		if record != nil {
+			return record, nil
+		}
+	}
+	return bd.imported[key], nil
+}
+
+// importCache reads the inline cache of the --cache-from images. An image
+// that cannot be used is reported and skipped.
+func (bd *build) importCache() {
+	for _, ref := range bd.opts.CacheFrom {
+		v := bd.p.vertex("importing cache manifest from %s", ref.String())
+		if err := bd.importImageCache(ref); err != nil {
+			v.fail(err)
+			continue
+		}
+		v.done()
+	}
+}
+
+// importImageCache makes the steps recorded in the inline cache of an image
+// available to the build
+func (bd *build) importImageCache(ref reference.Reference) error {
+	img, err := bd.store.ResolveImage(ref.FamiliarString())
+	if err != nil {
+		return fmt.Errorf("%s: not found", ref.String())
+	}
+	content, err := bd.store.ImageContent(img)
+	if err != nil {
+		return err
+	}
+	layers, err := bd.store.ImageLayers(img)
+	if err != nil {
+		return err
+	}
+	history := content.Config.History
+	for _, entry := range content.Config.BuildCache {
+		if entry.History < 0 || entry.History >= len(history) || entry.Layer >= len(layers) {
+			return fmt.Errorf("invalid cache entry %s", entry.Key)
+		}
+		h := history[entry.History]
+		record := &data.CacheRecord{Key: entry.Key, CreatedBy: strings.TrimSuffix(h.CreatedBy, " # buildkit")}
+		if h.Created != nil {
+			record.Created = *h.Created
+		}
+		if entry.Layer >= 0 {
+			layer := layers[entry.Layer]
+			record.Layer, record.DiffID, record.LayerSize = layer.Blob, layer.DiffID, layer.Size
+		}
+		bd.imported[entry.Key] = record
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+// builder/context.go
+package builder
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/data"
+)
+
+// owner is the ownership --chown gives copied files
+type owner struct {
+	uid, gid     int
+	uname, gname string
+}
+
+// parseChown parses a --chown value: user[:group], by name or numeric ID.
+// Names cannot be looked up without running the image, so they are kept
+// as names with root's IDs, except for root itself.
+func parseChown(value string) (owner, error) {
+	user, group, hasGroup := strings.Cut(value, ":")
+	if !hasGroup {
+		group = user
+	}
+	if user == "" || group == "" {
+		return owner{}, fmt.Errorf("invalid chown parameter: %s", value)
+	}
+	var o owner
+	if id, err := strconv.Atoi(user); err == nil {
+		o.uid = id
+	} else if user != "root" {
+		o.uname = user
+	}
+	if id, err := strconv.Atoi(group); err == nil {
+		o.gid = id
+	} else if group != "root" {
+		o.gname = group
+	}
+	return o, nil
+}
+
+// parseChmod parses a --chmod value in octal notation
+func parseChmod(value string) (int64, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("invalid chmod parameter: %s", value)
+	}
+	return int64(mode), nil
+}
+
+// layerWriter collects the files a step adds into a layer tar
+type layerWriter struct {
+	buf   bytes.Buffer
+	tw    *tar.Writer
+	size  int64     // total size of the files written
+	sum   hash.Hash // checksum of the entries, for the cache key of the step
+	dirs  map[string]bool
+	owner owner
+	chmod int64 // permission bits for everything written; zero keeps the source's
+}
+
+func newLayerWriter() *layerWriter {
+	w := &layerWriter{dirs: make(map[string]bool), sum: sha256.New()}
+	w.tw = tar.NewWriter(&w.buf)
+	return w
+}
+
+// write adds an entry to the tar and its checksum. Modification times are
+// left out of the checksum, so touching a file does not invalidate the
+// cache.
+func (w *layerWriter) write(hdr *tar.Header, content []byte) error {
+	fmt.Fprintf(w.sum, "%c %s %o %d:%d %s:%s %s %d\n", hdr.Typeflag, hdr.Name, hdr.Mode,
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname, hdr.Linkname, len(content))
+	w.sum.Write(content)
+	hdr.Size = int64(len(content))
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(content); err != nil {
+		return err
+	}
+	w.size += hdr.Size
+	return nil
+}
+
+// checksum returns the checksum of the entries written so far
+func (w *layerWriter) checksum() string {
+	return fmt.Sprintf("%s%x", data.DigestPrefix, w.sum.Sum(nil))
+}
+
+// header returns a tar header for name, an absolute path in the image
+func (w *layerWriter) header(name string, typeflag byte, mode int64, modTime time.Time) *tar.Header {
+	if w.chmod != 0 {
+		mode = w.chmod
+	}
+	return &tar.Header{
+		Typeflag: typeflag,
+		Name:     strings.TrimPrefix(name, "/"),
+		Mode:     mode,
+		ModTime:  modTime,
+		Uid:      w.owner.uid,
+		Gid:      w.owner.gid,
+		Uname:    w.owner.uname,
+		Gname:    w.owner.gname,
+		Format:   tar.FormatPAX,
+	}
+}
+
+// dir adds a directory and any of its parents not added yet. Parents are
+// created with mode 0755, whatever --chmod says.
+func (w *layerWriter) dir(name string, mode int64, modTime time.Time) error {
+	name = path.Clean(name)
+	if name == "/" || w.dirs[name] {
+		return nil
+	}
+	if err := w.parents(name, modTime); err != nil {
+		return err
+	}
+	w.dirs[name] = true
+	return w.write(w.header(name+"/", tar.TypeDir, mode, modTime), nil)
+}
+
+// parents adds the missing parent directories of name
+func (w *layerWriter) parents(name string, modTime time.Time) error {
+	parent := path.Dir(name)
+	if parent == "/" || w.dirs[parent] {
+		return nil
+	}
+	if err := w.parents(parent, modTime); err != nil {
+		return err
+	}
+	w.dirs[parent] = true
+	hdr := w.header(parent+"/", tar.TypeDir, 0755, modTime)
+	hdr.Mode = 0755
+	return w.write(hdr, nil)
+}
+
+// file adds a regular file, creating its directory
+func (w *layerWriter) file(name string, content []byte, mode int64, modTime time.Time) error {
+	if err := w.parents(name, modTime); err != nil {
+		return err
+	}
+	return w.write(w.header(name, tar.TypeReg, mode, modTime), content)
+}
+
+// symlink adds a symbolic link
+func (w *layerWriter) symlink(name, target string, modTime time.Time) error {
+	if err := w.parents(name, modTime); err != nil {
+		return err
+	}
+	hdr := w.header(name, tar.TypeSymlink, 0777, modTime)
+	hdr.Linkname = target
+	return w.write(hdr, nil)
+}
+
+// layer closes the tar and returns it as a layer
+func (w *layerWriter) layer() (data.Layer, error) {
+	if err := w.tw.Close(); err != nil {
+		return data.Layer{}, err
+	}
+	return data.LayerFromTar(w.buf.Bytes(), w.size)
+}
+
+// buildContext is the directory COPY and ADD read from, less the files
+// its .dockerignore excludes
+type buildContext struct {
+	dir    string
+	ignore *ignoreMatcher
+	keep   map[string]bool // paths relative to dir that are never excluded
+}
+
+// openContext reads the .dockerignore of dir. keep lists paths relative to
+// dir sent even if they are excluded, such as the Dockerfile remote
+// builders need.
+func openContext(dir string, keep ...string) (*buildContext, error) {
+	ignore, err := readDockerignore(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &buildContext{dir: dir, ignore: ignore, keep: make(map[string]bool)}
+	for _, rel := range keep {
+		c.keep[path.Clean(filepath.ToSlash(rel))] = true
+	}
+	return c, nil
+}
+
+// rel returns the slash-separated path of host relative to the context root
+func (c *buildContext) rel(host string) string {
+	rel, err := filepath.Rel(c.dir, host)
+	if err != nil {
+		return filepath.ToSlash(host)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// excluded reports whether .dockerignore excludes host
+func (c *buildContext) excluded(host string) bool {
+	rel := c.rel(host)
+	return rel != "." && !c.keep[rel] && c.ignore.excluded(rel)
+}
+
+// walk calls fn for root and the entries below it that are not excluded.
+// Excluded directories are only entered when an exception or a kept path
+// may bring back files inside them.
+func (c *buildContext) walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !c.excluded(p) {
+			return fn(p, info, nil)
+		}
+		if info.IsDir() && !c.ignore.hasExclusions && !c.keepsBelow(c.rel(p)) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// keepsBelow reports whether a kept path is inside the directory rel
+func (c *buildContext) keepsBelow(rel string) bool {
+	for kept := range c.keep {
+		if strings.HasPrefix(kept, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// size returns the number and total size of the files sent to the builder
+func (c *buildContext) size() (int, int64, error) {
+	var files int
+	var size int64
+	err := c.walk(c.dir, func(p string, info os.FileInfo, err error) error {
+		if info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size, err
+}
+
+// match expands a COPY or ADD source against the context. Sources are
+// relative to the context root and cannot reach outside of it: ".." stops
+// at the root, as with BuildKit. Excluded files do not exist for the build.
+func (c *buildContext) match(source string) ([]string, error) {
+	rel := path.Clean("/" + filepath.ToSlash(source))
+	host := filepath.Join(c.dir, filepath.FromSlash(rel))
+	if !strings.ContainsAny(rel, "*?[") {
+		if _, err := os.Lstat(host); err != nil || c.excluded(host) {
+			return nil, fmt.Errorf("failed to compute cache key: failed to calculate checksum of ref: %q: not found", rel)
+		}
+		return []string{host}, nil
+	}
+	matches, err := filepath.Glob(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source pattern %q: %v", source, err)
+	}
+	kept := matches[:0]
+	for _, m := range matches {
+		if !c.excluded(m) {
+			kept = append(kept, m)
+		}
+	}
+	sort.Strings(kept)
+	return kept, nil
+}
+
+// largeContextSize is the context size above which builds warn that a
+// .dockerignore could help
+const largeContextSize = 100 * 1000 * 1000
+
+// largeContextWarning returns the warning for a context of size bytes, or
+// "" if it is not unusually large
+func largeContextWarning(size int64) string {
+	if size < largeContextSize {
+		return ""
+	}
+	return fmt.Sprintf("WARNING: the build context is %s; add a %s file to exclude what the build does not need", data.HumanSize(size), DockerignoreFile)
+}
+
+// copyPath copies the file or directory at host to dest in the layer. The
+// contents of directories are copied, not the directory itself, less the
+// files the context excludes. dest names the file itself unless toDir is
+// set, in which case files keep their name inside dest.
+func (w *layerWriter) copyPath(c *buildContext, host, dest string, toDir bool) error {
+	info, err := os.Lstat(host)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if toDir {
+			dest = path.Join(dest, filepath.Base(host))
+		}
+		return w.copyEntry(host, dest, info)
+	}
+
+	if err := w.dir(dest, int64(info.Mode().Perm()), info.ModTime()); err != nil {
+		return err
+	}
+	return c.walk(host, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == host {
+			return err
+		}
+		rel, err := filepath.Rel(host, p)
+		if err != nil {
+			return err
+		}
+		return w.copyEntry(p, path.Join(dest, filepath.ToSlash(rel)), info)
+	})
+}
+
+// copyEntry copies one file, directory or symlink from the context
+func (w *layerWriter) copyEntry(host, dest string, info os.FileInfo) error {
+	switch {
+	case info.IsDir():
+		return w.dir(dest, int64(info.Mode().Perm()), info.ModTime())
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(host)
+		if err != nil {
+			return err
+		}
+		return w.symlink(dest, target, info.ModTime())
+	case info.Mode().IsRegular():
+		content, err := os.ReadFile(host)
+		if err != nil {
+			return err
+		}
+		return w.file(dest, content, int64(info.Mode().Perm()), info.ModTime())
+	}
+	// Sockets, devices and pipes are not copied
+	return nil
+}
+
+// extractArchive unpacks a local tar archive, optionally gzip-compressed,
+// into dest the way ADD does. It reports false if the file is not an
+// archive, in which case it is copied as is.
+func (w *layerWriter) extractArchive(host, dest string) (bool, error) {
+	file, err := os.Open(host)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return false, nil
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return false, nil
+	}
+	for ; err == nil; hdr, err = tr.Next() {
+		name := path.Join(dest, path.Clean("/"+hdr.Name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = w.dir(name, hdr.Mode&07777, hdr.ModTime)
+		case tar.TypeReg:
+			var content []byte
+			if content, err = io.ReadAll(tr); err == nil {
+				err = w.file(name, content, hdr.Mode&07777, hdr.ModTime)
+			}
+		case tar.TypeSymlink:
+			err = w.symlink(name, hdr.Linkname, hdr.ModTime)
+		}
+		if err != nil {
+			return true, fmt.Errorf("failed to extract %s: %v", filepath.Base(host), err)
+		}
+	}
+	if err != io.EOF {
+		return true, fmt.Errorf("failed to extract %s: %v", filepath.Base(host), err)
+	}
+	return true, nil
+}
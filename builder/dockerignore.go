@@ -0,0 +1,193 @@
+// builder/dockerignore.go
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DockerignoreFile lists the files of a build context builds do not see
+const DockerignoreFile = ".dockerignore"
+
+// ignorePattern is one line of a .dockerignore file
+type ignorePattern struct {
+	text      string
+	exclusion bool // the line started with !, so matching files are kept
+	re        *regexp.Regexp
+}
+
+// ignoreMatcher decides which files of a context .dockerignore excludes,
+// with the semantics of Docker's pattern matcher: the last matching line
+// wins, a pattern matching a directory matches everything below it, and
+// ** matches any number of directories.
+type ignoreMatcher struct {
+	patterns      []*ignorePattern
+	hasExclusions bool
+}
+
+// readDockerignore reads the .dockerignore of a context. A context without
+// one yields nil, which excludes nothing.
+func readDockerignore(contextDir string) (*ignoreMatcher, error) {
+	file, err := os.Open(filepath.Join(contextDir, DockerignoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines, err := parseDockerignore(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", DockerignoreFile, err)
+	}
+	return newIgnoreMatcher(lines)
+}
+
+// parseDockerignore returns the patterns of a .dockerignore file, cleaned
+// as Docker cleans them: comments and blank lines are dropped, and
+// patterns are made relative to the context root
+func parseDockerignore(r io.Reader) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exclusion := strings.HasPrefix(line, "!")
+		if exclusion {
+			line = strings.TrimSpace(line[1:])
+		}
+		if line != "" {
+			line = path.Clean(filepath.ToSlash(line))
+			if len(line) > 1 && line[0] == '/' {
+				line = line[1:]
+			}
+		}
+		if exclusion {
+			line = "!" + line
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// newIgnoreMatcher compiles .dockerignore patterns
+func newIgnoreMatcher(lines []string) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{}
+	for _, line := range lines {
+		p := &ignorePattern{text: line}
+		if strings.HasPrefix(line, "!") {
+			if len(line) == 1 {
+				return nil, fmt.Errorf("illegal exclusion pattern: %q", line)
+			}
+			p.exclusion, p.text = true, line[1:]
+			m.hasExclusions = true
+		}
+		re, err := compileIgnorePattern(p.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", line, err)
+		}
+		p.re = re
+		m.patterns = append(m.patterns, p)
+	}
+	return m, nil
+}
+
+// compileIgnorePattern turns a pattern into a regular expression on
+// slash-separated paths relative to the context root
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				switch {
+				case i+1 == len(pattern):
+					// A trailing ** matches everything
+					re.WriteString(".*")
+				case pattern[i+1] == '/':
+					// **/ matches any number of directories, including none
+					i++
+					re.WriteString("(.*/)?")
+				default:
+					re.WriteString(".*")
+				}
+				continue
+			}
+			re.WriteString("[^/]*")
+		case '?':
+			re.WriteString("[^/]")
+		case '\\':
+			if i+1 == len(pattern) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// excluded reports whether the file at rel, a slash-separated path relative
+// to the context root, is excluded. A pattern matching one of the parent
+// directories of rel matches rel too.
+func (m *ignoreMatcher) excluded(rel string) bool {
+	if m == nil {
+		return false
+	}
+	var parents []string
+	for i := strings.IndexByte(rel, '/'); i >= 0; i = nextSlash(rel, i) {
+		parents = append(parents, rel[:i])
+	}
+
+	matched := false
+	for _, p := range m.patterns {
+		// Only patterns that can change the outcome are evaluated
+		if p.exclusion != matched {
+			continue
+		}
+		match := p.re.MatchString(rel)
+		for _, parent := range parents {
+			if match {
+				break
+			}
+			match = p.re.MatchString(parent)
+		}
+		if match {
+			matched = !p.exclusion
+		}
+	}
+	return matched
+}
+
+// nextSlash returns the index of the slash after index i in s, or -1
+func nextSlash(s string, i int) int {
+	if j := strings.IndexByte(s[i+1:], '/'); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
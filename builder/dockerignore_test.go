@@ -0,0 +1,209 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreMatcherExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		excluded []string
+		kept     []string
+	}{
+		{
+			name:     "plain names",
+			patterns: []string{"secret.txt", "build"},
+			excluded: []string{"secret.txt", "build", "build/out.o", "build/sub/out.o"},
+			kept:     []string{"src/secret.txt", "builder", "src/build"},
+		},
+		{
+			name:     "star stays in one directory",
+			patterns: []string{"*.log", "tmp/*"},
+			excluded: []string{"a.log", "tmp/x", "tmp/x/y"},
+			kept:     []string{"logs/a.log", "tmp", "a.log.txt"},
+		},
+		{
+			name:     "question mark",
+			patterns: []string{"file?.txt"},
+			excluded: []string{"file1.txt", "fileA.txt"},
+			kept:     []string{"file10.txt", "file/.txt", "file.txt"},
+		},
+		{
+			name:     "leading double star",
+			patterns: []string{"**/*.go"},
+			excluded: []string{"main.go", "cmd/run.go", "a/b/c/d.go"},
+			kept:     []string{"main.go.orig", "cmd/README"},
+		},
+		{
+			name:     "double star in the middle",
+			patterns: []string{"src/**/test"},
+			excluded: []string{"src/test", "src/a/test", "src/a/b/test", "src/a/test/data.json"},
+			kept:     []string{"test", "src/testing", "lib/a/test"},
+		},
+		{
+			name:     "trailing double star",
+			patterns: []string{"vendor/**"},
+			excluded: []string{"vendor/a", "vendor/a/b/c"},
+			kept:     []string{"vendored"},
+		},
+		{
+			name:     "exception overrides an earlier line",
+			patterns: []string{"*.md", "!README.md"},
+			excluded: []string{"CHANGELOG.md"},
+			kept:     []string{"README.md", "main.go"},
+		},
+		{
+			name:     "last match wins",
+			patterns: []string{"*.md", "!README*.md", "README-secret.md"},
+			excluded: []string{"CHANGELOG.md", "README-secret.md"},
+			kept:     []string{"README.md", "README-public.md"},
+		},
+		{
+			name:     "exception for a file in an excluded directory",
+			patterns: []string{"docs", "!docs/keep.md"},
+			excluded: []string{"docs", "docs/a.md", "docs/sub/keep.md"},
+			kept:     []string{"docs/keep.md"},
+		},
+		{
+			name:     "negated class",
+			patterns: []string{"[!a-c]*.txt"},
+			excluded: []string{"d.txt", "zz.txt"},
+			kept:     []string{"a.txt", "b1.txt", "c.txt"},
+		},
+		{
+			name:     "class",
+			patterns: []string{"log[0-9]"},
+			excluded: []string{"log1", "log9"},
+			kept:     []string{"loga", "log10"},
+		},
+		{
+			name:     "escaped characters",
+			patterns: []string{`\*.txt`, `a\?`, `\[x]`},
+			excluded: []string{"*.txt", "a?", "[x]"},
+			kept:     []string{"b.txt", "ab", "x"},
+		},
+		{
+			name:     "regexp metacharacters are literal",
+			patterns: []string{"a+b(c).txt", "v1.0"},
+			excluded: []string{"a+b(c).txt", "v1.0"},
+			kept:     []string{"aab(c).txt", "v100"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newIgnoreMatcher(tt.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rel := range tt.excluded {
+				if !m.excluded(rel) {
+					t.Errorf("%q is kept, want excluded", rel)
+				}
+			}
+			for _, rel := range tt.kept {
+				if m.excluded(rel) {
+					t.Errorf("%q is excluded, want kept", rel)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDockerignore(t *testing.T) {
+	content := "# comment\n\n  *.log  \n/build/\n! keep.log\n./a/../b\n"
+	got, err := parseDockerignore(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"*.log", "build", "!keep.log", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDockerignore() = %q, want %q", got, want)
+	}
+}
+
+func TestNewIgnoreMatcherErrors(t *testing.T) {
+	for _, patterns := range [][]string{{"!"}, {"a["}, {`a\`}} {
+		if _, err := newIgnoreMatcher(patterns); err == nil {
+			t.Errorf("newIgnoreMatcher(%q) succeeded, want an error", patterns)
+		}
+	}
+}
+
+func TestBuildContextWalk(t *testing.T) {
+	tests := []struct {
+		name   string
+		ignore string
+		keep   []string
+		want   []string
+	}{
+		{
+			name: "no dockerignore",
+			want: []string{"Dockerfile", "app", "app/main.go", "docs", "docs/guide.md", "docs/keep.md", "node_modules", "node_modules/x", "node_modules/x/index.js"},
+		},
+		{
+			name:   "excluded directories are skipped",
+			ignore: "node_modules\ndocs\n",
+			want:   []string{".dockerignore", "Dockerfile", "app", "app/main.go"},
+		},
+		{
+			name:   "file re-included from an excluded directory",
+			ignore: "docs\n!docs/keep.md\nnode_modules\n",
+			want:   []string{".dockerignore", "Dockerfile", "app", "app/main.go", "docs/keep.md"},
+		},
+		{
+			name:   "kept paths are sent anyway",
+			ignore: "*\n",
+			keep:   []string{"app/main.go"},
+			want:   []string{"app/main.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{
+				"Dockerfile":              "FROM scratch\n",
+				"app/main.go":             "package main\n",
+				"docs/guide.md":           "# Guide\n",
+				"docs/keep.md":            "# Keep\n",
+				"node_modules/x/index.js": "",
+			}
+			if tt.ignore != "" {
+				files[DockerignoreFile] = tt.ignore
+			}
+			for name, content := range files {
+				p := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c, err := openContext(dir, tt.keep...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			err = c.walk(dir, func(p string, info os.FileInfo, err error) error {
+				if p != dir {
+					got = append(got, c.rel(p))
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("walk() visited %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
+	"prepare.sh/dockermock/runner"
+)
+
+// fakeRunner records the commands it is given and answers them with
+// respond instead of running anything
+type fakeRunner struct {
+	mu      sync.Mutex
+	missing map[string]bool // programs LookPath does not find
+	respond func(cmd runner.Cmd) (string, error)
+	cmds    []runner.Cmd
+}
+
+func (r *fakeRunner) LookPath(name string) error {
+	if r.missing[name] {
+		return fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+	}
+	return nil
+}
+
+func (r *fakeRunner) Run(ctx context.Context, cmd runner.Cmd) error {
+	r.mu.Lock()
+	r.cmds = append(r.cmds, cmd)
+	r.mu.Unlock()
+
+	out, err := "", error(nil)
+	if r.respond != nil {
+		out, err = r.respond(cmd)
+	}
+	if cmd.Stdout != nil {
+		io.WriteString(cmd.Stdout, out)
+	}
+	if err != nil && cmd.Stderr != nil {
+		io.WriteString(cmd.Stderr, err.Error())
+	}
+	return err
+}
+
+// commands returns the command lines run so far
+func (r *fakeRunner) commands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := make([]string, len(r.cmds))
+	for i, cmd := range r.cmds {
+		lines[i] = cmd.String()
+	}
+	return lines
+}
+
+// countingStore is an image store counting the images built into it
+type countingStore struct {
+	*data.ImageManager
+	builds int
+}
+
+func (s *countingStore) BuildImage(config oci.Image, layers []data.Layer, refs ...reference.Reference) (*data.Image, error) {
+	s.builds++
+	return s.ImageManager.BuildImage(config, layers, refs...)
+}
+
+// newTestStore returns an empty image store kept in memory
+func newTestStore(t *testing.T) *countingStore {
+	t.Helper()
+	im, err := data.NewImageManager(data.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &countingStore{ImageManager: im}
+}
+
+// writeContext creates a build context from file contents by path
+func writeContext(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// mustParse parses a normalized, tagged reference
+func mustParse(t *testing.T, s string) reference.Reference {
+	t.Helper()
+	ref, err := reference.ParseNormalizedTagged(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+// containsAll reports the first of want missing from s
+func containsAll(s string, want ...string) (string, bool) {
+	for _, w := range want {
+		if !strings.Contains(s, w) {
+			return w, false
+		}
+	}
+	return "", true
+}
@@ -0,0 +1,306 @@
+// builder/github.go
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/reference"
+	"prepare.sh/dockermock/runner"
+)
+
+// DefaultGitHubRepo is the repository builds are pushed to unless another
+// is given
+const DefaultGitHubRepo = "docker-builds"
+
+// GitHub builds images with GitHub Actions: the build context is pushed to
+// a new branch of a repository of the logged-in user, together with a
+// workflow that builds the image and pushes it to ghcr.io. gh and git do
+// the work.
+type GitHub struct {
+	Store        Store
+	Runner       runner.Runner
+	API          GitHubAPI     // REST API access; `gh api` through Runner if nil
+	Repo         string        // repository name; DefaultGitHubRepo if empty
+	Timeout      time.Duration // limit for the workflow run; 30 minutes if zero
+	PollInterval time.Duration // how often the run is checked; 5 seconds if zero
+}
+
+// NewGitHub returns a GitHub Actions builder using the gh and git installed
+// on this machine. With $GITHUB_TOKEN set, the REST API is called directly
+// with it, at $GITHUB_API_URL if set.
+func NewGitHub(store Store, repo string) *GitHub {
+	b := &GitHub{Store: store, Runner: runner.Exec{}, Repo: repo}
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		base := os.Getenv("GITHUB_API_URL")
+		if base == "" {
+			base = "https://api.github.com"
+		}
+		b.API = &RESTClient{BaseURL: base, Token: token}
+	}
+	return b
+}
+
+// Build pushes the context, follows the workflow run it triggers and, if
+// the run succeeds, records the image it built: ghcr.io/<user>/<name>:<tag>
+// for the first of opts.Tags
+func (b *GitHub) Build(opts Options) (*data.Image, error) {
+	if len(opts.Tags) == 0 {
+		return nil, fmt.Errorf("the GitHub Actions builder needs an image name, given with -t")
+	}
+	out := opts.Out
+	if out == nil {
+		out = io.Discard
+	}
+	dockerfile, err := relativeDockerfile(opts)
+	if err != nil {
+		return nil, err
+	}
+	buildContext, err := openRemoteContext(opts, dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	name, tag := opts.Tags[0].FamiliarName(), opts.Tags[0].Tag
+
+	fmt.Fprintln(out, "Preparing build context...")
+	if err := b.Runner.LookPath("gh"); err != nil {
+		return nil, fmt.Errorf("GitHub CLI (gh) not found. Please install it to use the build command")
+	}
+	if _, err := b.gh(ctx, "auth", "status"); err != nil {
+		return nil, fmt.Errorf("Not logged in to GitHub. Please run 'gh auth login' first")
+	}
+	login, err := b.gh(ctx, "api", "user", "--jq", ".login")
+	if err != nil {
+		return nil, fmt.Errorf("error getting GitHub username: %v", err)
+	}
+	// Image names on ghcr.io are lowercase
+	username := strings.ToLower(login)
+
+	repoName := b.Repo
+	if repoName == "" {
+		repoName = DefaultGitHubRepo
+	}
+	fullRepoName := fmt.Sprintf("%s/%s", username, repoName)
+	htmlURL := "https://github.com/" + fullRepoName
+	if _, err := b.gh(ctx, "repo", "view", fullRepoName, "--json", "name"); err != nil {
+		fmt.Fprintf(out, "Repository %s does not exist. Creating it...\n", fullRepoName)
+		if _, err := b.gh(ctx, "repo", "create", repoName, "--public", "--description", "Docker build repository"); err != nil {
+			return nil, fmt.Errorf("error creating GitHub repository: %v", err)
+		}
+		fmt.Fprintf(out, "Repository created: %s\n", htmlURL)
+	} else {
+		fmt.Fprintf(out, "Using existing repository: %s\n", htmlURL)
+	}
+
+	workDir, err := os.MkdirTemp("", "docker-build-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp directory: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	branch := fmt.Sprintf("build-%s-%d", strings.ReplaceAll(name, "/", "-"), time.Now().Unix())
+	fmt.Fprintln(out, "Cloning repository...")
+	if err := b.git(ctx, "", "clone", htmlURL+".git", workDir); err != nil {
+		return nil, fmt.Errorf("error cloning repository: %v", err)
+	}
+	fmt.Fprintf(out, "Creating new branch: %s\n", branch)
+	if err := b.git(ctx, workDir, "checkout", "-b", branch); err != nil {
+		return nil, err
+	}
+
+	// The branch holds only the build context and the workflow
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading temp directory: %v", err)
+	}
+	for _, e := range entries {
+		if e.Name() != ".git" {
+			os.RemoveAll(filepath.Join(workDir, e.Name()))
+		}
+	}
+	fmt.Fprintln(out, "Copying build context to repository...")
+	if err := buildContext.report(out, fullRepoName); err != nil {
+		return nil, err
+	}
+	if err := copyDir(buildContext, workDir); err != nil {
+		return nil, fmt.Errorf("error copying build context: %v", err)
+	}
+	workflowsDir := filepath.Join(workDir, ".github", "workflows")
+	if err := os.MkdirAll(workflowsDir, 0755); err != nil {
+		return nil, err
+	}
+	workflow := githubWorkflow(branch, username, name, tag, dockerfile, opts)
+	if err := os.WriteFile(filepath.Join(workflowsDir, "docker-build.yml"), []byte(workflow), 0644); err != nil {
+		return nil, fmt.Errorf("error creating workflow file: %v", err)
+	}
+
+	fmt.Fprintln(out, "Committing changes...")
+	steps := [][]string{
+		{"add", "."},
+		{"config", "user.email", "dockercli@example.com"},
+		{"config", "user.name", "Docker CLI"},
+		{"commit", "-m", "Docker build for " + name + ":" + tag},
+	}
+	for _, args := range steps {
+		if err := b.git(ctx, workDir, args...); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprintf(out, "Pushing branch %s to GitHub...\n", branch)
+	if err := b.git(ctx, workDir, "push", "-u", "origin", branch); err != nil {
+		return nil, fmt.Errorf("error pushing to GitHub: %v", err)
+	}
+
+	fmt.Fprintf(out, "Build started. Your image is being built in GitHub Actions at: %s/actions\n", htmlURL)
+	fmt.Fprintf(out, "Branch: %s\n", branch)
+	fmt.Fprintln(out, "Waiting for build to complete...")
+
+	timeout := b.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Minute
+	}
+	interval := b.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	api := b.API
+	if api == nil {
+		api = ghAPI{Runner: b.Runner}
+	}
+	watchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	watcher := &runWatcher{
+		api:      api,
+		repo:     fullRepoName,
+		out:      out,
+		interval: interval,
+		steps:    make(map[string]bool),
+		logged:   make(map[int64]bool),
+	}
+	run, err := watcher.findRun(watchCtx, branch)
+	if err != nil {
+		return nil, fmt.Errorf("%v; see %s/actions", err, htmlURL)
+	}
+	fmt.Fprintf(out, "Workflow run: %s\n\n", run.HTMLURL)
+	if run, err = watcher.watch(watchCtx, run); err != nil {
+		return nil, err
+	}
+	if run.Conclusion != "success" {
+		return nil, fmt.Errorf("build failed with conclusion %q; see %s", run.Conclusion, run.HTMLURL)
+	}
+	fmt.Fprintln(out, "\nBuild completed successfully!")
+	fmt.Fprintln(out, "The build branch will be automatically deleted by the workflow")
+
+	imageName := fmt.Sprintf("ghcr.io/%s/%s", username, name)
+	builtRef, err := reference.ParseNormalizedTagged(imageName + ":" + tag)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Building image %s\n", builtRef.FamiliarString())
+	img, err := recordRemoteBuild(b.Store, buildContext, opts.Labels, builtRef)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "\nSuccessfully built %s (ID: %s)\n", builtRef.FamiliarString(), img.ID[:12])
+	fmt.Fprintf(out, "You can run the image with: docker run %s\n", builtRef.FamiliarString())
+	return img, nil
+}
+
+// gh runs a gh command and returns its trimmed output
+func (b *GitHub) gh(ctx context.Context, args ...string) (string, error) {
+	out, err := runner.Output(ctx, b.Runner, runner.Cmd{Name: "gh", Args: args})
+	return strings.TrimSpace(string(out)), err
+}
+
+// git runs a git command in dir
+func (b *GitHub) git(ctx context.Context, dir string, args ...string) error {
+	_, err := runner.Output(ctx, b.Runner, runner.Cmd{Name: "git", Args: args, Dir: dir})
+	return err
+}
+
+// githubWorkflow returns the workflow building the image on a push to
+// branch and deleting the branch afterwards
+func githubWorkflow(branch, username, name, tag, dockerfile string, opts Options) string {
+	var args strings.Builder
+	if opts.Target != "" {
+		fmt.Fprintf(&args, "        target: %s\n", opts.Target)
+	}
+	if opts.NoCache {
+		args.WriteString("        no-cache: true\n")
+	}
+	if opts.Pull {
+		args.WriteString("        pull: true\n")
+	}
+	if len(opts.CacheFrom) > 0 {
+		args.WriteString("        cache-from: |\n")
+		for _, ref := range opts.CacheFrom {
+			fmt.Fprintf(&args, "          type=registry,ref=%s\n", ref.String())
+		}
+	}
+	if len(opts.BuildArgs) > 0 {
+		args.WriteString("        build-args: |\n")
+		for _, k := range sortedKeys(opts.BuildArgs) {
+			fmt.Fprintf(&args, "          %s=%s\n", k, opts.BuildArgs[k])
+		}
+	}
+	if len(opts.Labels) > 0 {
+		args.WriteString("        labels: |\n")
+		for _, k := range sortedKeys(opts.Labels) {
+			fmt.Fprintf(&args, "          %s=%s\n", k, opts.Labels[k])
+		}
+	}
+	return fmt.Sprintf(`name: Docker Build and Push
+
+on:
+  push:
+    branches: [ %s ]
+
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    permissions:
+      contents: read
+      packages: write
+
+    steps:
+    - name: Checkout code
+      uses: actions/checkout@v4
+
+    - name: Set up Docker Buildx
+      uses: docker/setup-buildx-action@v3
+
+    - name: Login to GitHub Container Registry
+      uses: docker/login-action@v3
+      with:
+        registry: ghcr.io
+        username: ${{ github.actor }}
+        password: ${{ secrets.GITHUB_TOKEN }}
+
+    - name: Get short SHA
+      id: vars
+      run: echo "sha=$(git rev-parse --short HEAD)" >> $GITHUB_OUTPUT
+
+    - name: Build and push
+      uses: docker/build-push-action@v5
+      with:
+        context: .
+        file: %s
+        push: true
+%s        tags: |
+          ghcr.io/%s/%s:%s
+          ghcr.io/%s/%s:${{ steps.vars.outputs.sha }}
+
+    - name: Delete branch
+      uses: dawidd6/action-delete-branch@v3
+      with:
+        github_token: ${{ secrets.GITHUB_TOKEN }}
+        branches: %s
+`, branch, dockerfile, args.String(), username, name, tag, username, name, branch)
+}
@@ -0,0 +1,217 @@
+// builder/github_run.go
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/runner"
+)
+
+// GitHubAPI reads from the GitHub REST API. path is relative to the API
+// root, e.g. "repos/octo/app/actions/runs".
+type GitHubAPI interface {
+	Get(ctx context.Context, path string) ([]byte, error)
+}
+
+// ghAPI calls the REST API through `gh api`, with gh's credentials
+type ghAPI struct {
+	Runner runner.Runner
+}
+
+func (a ghAPI) Get(ctx context.Context, path string) ([]byte, error) {
+	return runner.Output(ctx, a.Runner, runner.Cmd{
+		Name: "gh",
+		Args: []string{"api", "-H", "Accept: application/vnd.github+json", path},
+	})
+}
+
+// RESTClient calls the REST API over HTTP with a token, e.g. in CI where
+// GITHUB_TOKEN is set
+type RESTClient struct {
+	BaseURL string // e.g. https://api.github.com
+	Token   string
+	HTTP    *http.Client
+}
+
+func (c *RESTClient) Get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+"/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// workflowRun is a run of a GitHub Actions workflow
+type workflowRun struct {
+	ID         int64  `json:"id"`
+	HeadBranch string `json:"head_branch"`
+	Status     string `json:"status"`     // queued, in_progress or completed
+	Conclusion string `json:"conclusion"` // success, failure, cancelled... once completed
+	HTMLURL    string `json:"html_url"`
+}
+
+// workflowJob is a job of a workflow run with its steps
+type workflowJob struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	Steps      []struct {
+		Name        string     `json:"name"`
+		Number      int        `json:"number"`
+		Status      string     `json:"status"`
+		Conclusion  string     `json:"conclusion"`
+		StartedAt   *time.Time `json:"started_at"`
+		CompletedAt *time.Time `json:"completed_at"`
+	} `json:"steps"`
+}
+
+// runWatcher follows a workflow run, printing its steps as they complete
+// and the log of each job once it finishes
+type runWatcher struct {
+	api      GitHubAPI
+	repo     string // owner/name
+	out      io.Writer
+	interval time.Duration
+	steps    map[string]bool // job/step already reported
+	logged   map[int64]bool  // jobs whose log was printed
+}
+
+// findRun waits for the run the push to branch triggered
+func (w *runWatcher) findRun(ctx context.Context, branch string) (*workflowRun, error) {
+	path := fmt.Sprintf("repos/%s/actions/runs?branch=%s&event=push&per_page=10", w.repo, url.QueryEscape(branch))
+	for {
+		body, err := w.api.Get(ctx, path)
+		if err != nil && ctx.Err() == nil {
+			return nil, fmt.Errorf("failed to list workflow runs: %v", err)
+		}
+		if err == nil {
+			var list struct {
+				Runs []workflowRun `json:"workflow_runs"`
+			}
+			if err := json.Unmarshal(body, &list); err != nil {
+				return nil, fmt.Errorf("failed to parse workflow runs: %v", err)
+			}
+			for _, run := range list.Runs {
+				if run.HeadBranch == branch {
+					return &run, nil
+				}
+			}
+		}
+		if err := w.sleep(ctx); err != nil {
+			return nil, fmt.Errorf("timed out waiting for a workflow run on branch %s", branch)
+		}
+	}
+}
+
+// watch polls run until it completes and returns it in its final state
+func (w *runWatcher) watch(ctx context.Context, run *workflowRun) (*workflowRun, error) {
+	for {
+		if err := w.report(ctx, run.ID); err != nil {
+			return run, err
+		}
+		if run.Status == "completed" {
+			return run, nil
+		}
+		if err := w.sleep(ctx); err != nil {
+			return run, fmt.Errorf("timed out waiting for workflow run %s", run.HTMLURL)
+		}
+		body, err := w.api.Get(ctx, fmt.Sprintf("repos/%s/actions/runs/%d", w.repo, run.ID))
+		if err != nil {
+			if ctx.Err() != nil {
+				return run, fmt.Errorf("timed out waiting for workflow run %s", run.HTMLURL)
+			}
+			return run, fmt.Errorf("failed to get workflow run: %v", err)
+		}
+		var latest workflowRun
+		if err := json.Unmarshal(body, &latest); err != nil {
+			return run, fmt.Errorf("failed to parse workflow run: %v", err)
+		}
+		run = &latest
+	}
+}
+
+// report prints the steps completed since the last call and the logs of
+// finished jobs
+func (w *runWatcher) report(ctx context.Context, runID int64) error {
+	body, err := w.api.Get(ctx, fmt.Sprintf("repos/%s/actions/runs/%d/jobs", w.repo, runID))
+	if err != nil {
+		return fmt.Errorf("failed to list workflow jobs: %v", err)
+	}
+	var list struct {
+		Jobs []workflowJob `json:"jobs"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return fmt.Errorf("failed to parse workflow jobs: %v", err)
+	}
+
+	for _, job := range list.Jobs {
+		for _, step := range job.Steps {
+			key := fmt.Sprintf("%d/%d", job.ID, step.Number)
+			if step.Status != "completed" || w.steps[key] {
+				continue
+			}
+			w.steps[key] = true
+			took := ""
+			if step.StartedAt != nil && step.CompletedAt != nil {
+				took = fmt.Sprintf(" (%s)", step.CompletedAt.Sub(*step.StartedAt).Round(time.Second))
+			}
+			fmt.Fprintf(w.out, "[%s] %s: %s%s\n", job.Name, step.Name, step.Conclusion, took)
+		}
+		if job.Status == "completed" && !w.logged[job.ID] {
+			w.logged[job.ID] = true
+			w.printLog(ctx, job)
+		}
+	}
+	return nil
+}
+
+// printLog prints the log of a finished job. Logs are a convenience: when
+// they cannot be fetched the build goes on.
+func (w *runWatcher) printLog(ctx context.Context, job workflowJob) {
+	log, err := w.api.Get(ctx, fmt.Sprintf("repos/%s/actions/jobs/%d/logs", w.repo, job.ID))
+	if err != nil {
+		fmt.Fprintf(w.out, "[%s] log unavailable: %v\n", job.Name, err)
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(log), "\n"), "\n") {
+		fmt.Fprintf(w.out, "[%s] | %s\n", job.Name, strings.TrimRight(line, "\r"))
+	}
+}
+
+// sleep waits for the poll interval, failing once ctx is done
+func (w *runWatcher) sleep(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(w.interval):
+		return nil
+	}
+}
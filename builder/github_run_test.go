@@ -0,0 +1,332 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"prepare.sh/dockermock/reference"
+)
+
+const testRunURL = "https://github.com/octo/docker-builds/actions/runs/7"
+
+// runState is a workflow run as the API reports it at one point, with the
+// JSON of its jobs
+type runState struct {
+	status, conclusion string
+	jobs               string
+}
+
+// fakeActions is a GitHub Actions API with one workflow run, number 7.
+// Each poll of the run moves it to the next of states; the last one stays.
+type fakeActions struct {
+	mu          sync.Mutex
+	hiddenPolls int // run list polls before the run shows up; -1 for never
+	states      []runState
+	logs        map[int64]string // job logs; the others are unavailable
+	polls, gets int
+}
+
+func (f *fakeActions) Get(ctx context.Context, path string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	state := f.states[min(f.gets, len(f.states)-1)]
+	var job int64
+	switch {
+	case u.Path == "repos/octo/docker-builds/actions/runs":
+		f.polls++
+		runs := `{"id":3,"head_branch":"main","status":"completed","conclusion":"success"}`
+		if f.hiddenPolls >= 0 && f.polls > f.hiddenPolls {
+			runs += "," + f.run(u.Query().Get("branch"), state)
+		}
+		return []byte(`{"workflow_runs":[` + runs + `]}`), nil
+	case u.Path == "repos/octo/docker-builds/actions/runs/7":
+		f.gets++
+		return []byte(f.run("", f.states[min(f.gets, len(f.states)-1)])), nil
+	case u.Path == "repos/octo/docker-builds/actions/runs/7/jobs":
+		return []byte(`{"jobs":[` + state.jobs + `]}`), nil
+	case scanJobLog(u.Path, &job):
+		if log, ok := f.logs[job]; ok {
+			return []byte(log), nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (f *fakeActions) run(branch string, state runState) string {
+	return fmt.Sprintf(`{"id":7,"head_branch":%q,"status":%q,"conclusion":%q,"html_url":%q}`,
+		branch, state.status, state.conclusion, testRunURL)
+}
+
+// scanJobLog reads the job ID of a job log path
+func scanJobLog(path string, id *int64) bool {
+	_, err := fmt.Sscanf(path, "repos/octo/docker-builds/actions/jobs/%d/logs", id)
+	return err == nil
+}
+
+// client returns the API the watcher talks to: the fake itself, or a
+// RESTClient calling it through an HTTP server that checks the token
+func (f *fakeActions) client(t *testing.T, rest bool) GitHubAPI {
+	if !rest {
+		return f
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Accept") != "application/vnd.github+json" {
+			http.Error(w, "Bad credentials", http.StatusUnauthorized)
+			return
+		}
+		body, err := f.Get(r.Context(), strings.TrimPrefix(r.URL.RequestURI(), "/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return &RESTClient{BaseURL: srv.URL + "/", Token: "secret"}
+}
+
+// apiKinds runs a test against the fake directly and through RESTClient
+var apiKinds = []struct {
+	name string
+	rest bool
+}{{"fake API", false}, {"REST client", true}}
+
+func newWatcher(api GitHubAPI, out *strings.Builder) *runWatcher {
+	return &runWatcher{
+		api:      api,
+		repo:     "octo/docker-builds",
+		out:      out,
+		interval: time.Millisecond,
+		steps:    make(map[string]bool),
+		logged:   make(map[int64]bool),
+	}
+}
+
+func TestRunWatcherFindRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		hiddenPolls int
+		wantErr     string
+	}{
+		{name: "run listed at once"},
+		{name: "run listed later", hiddenPolls: 3},
+		{name: "no run", hiddenPolls: -1, wantErr: "timed out waiting for a workflow run on branch build-x"},
+	}
+	for _, kind := range apiKinds {
+		for _, tt := range tests {
+			t.Run(kind.name+"/"+tt.name, func(t *testing.T) {
+				f := &fakeActions{hiddenPolls: tt.hiddenPolls, states: []runState{{status: "queued"}}}
+				var out strings.Builder
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				run, err := newWatcher(f.client(t, kind.rest), &out).findRun(ctx, "build-x")
+				if tt.wantErr != "" {
+					if err == nil || err.Error() != tt.wantErr {
+						t.Fatalf("findRun() error = %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("findRun() error = %v", err)
+				}
+				if run.ID != 7 || run.HeadBranch != "build-x" || run.HTMLURL != testRunURL {
+					t.Errorf("findRun() = %+v", run)
+				}
+				if f.polls != tt.hiddenPolls+1 {
+					t.Errorf("listed runs %d times, want %d", f.polls, tt.hiddenPolls+1)
+				}
+			})
+		}
+	}
+}
+
+// The jobs of a run going from queued to completed: build finishes its
+// steps one at a time, cleanup has no log
+var (
+	buildStarted = `{"id":1,"name":"build","status":"in_progress","steps":[` +
+		`{"name":"Set up job","number":1,"status":"completed","conclusion":"success",` +
+		`"started_at":"2024-01-01T00:00:00Z","completed_at":"2024-01-01T00:00:03Z"},` +
+		`{"name":"Build and push","number":2,"status":"in_progress"}]}`
+	buildDone = `{"id":1,"name":"build","status":"completed","conclusion":"success","steps":[` +
+		`{"name":"Set up job","number":1,"status":"completed","conclusion":"success",` +
+		`"started_at":"2024-01-01T00:00:00Z","completed_at":"2024-01-01T00:00:03Z"},` +
+		`{"name":"Build and push","number":2,"status":"completed","conclusion":"failure"}]}`
+	cleanupDone = `{"id":2,"name":"cleanup","status":"completed","conclusion":"skipped","steps":[]}`
+
+	runProgress = []runState{
+		{status: "queued"},
+		{status: "in_progress", jobs: buildStarted},
+		{status: "in_progress", jobs: buildStarted},
+		{status: "in_progress", jobs: buildDone},
+		{status: "completed", conclusion: "failure", jobs: buildDone + "," + cleanupDone},
+	}
+)
+
+func TestRunWatcherWatch(t *testing.T) {
+	for _, kind := range apiKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			f := &fakeActions{states: runProgress, logs: map[int64]string{1: "line one\r\nline two\n"}}
+			var out strings.Builder
+			w := newWatcher(f.client(t, kind.rest), &out)
+			run, err := w.watch(context.Background(), &workflowRun{ID: 7, Status: "queued", HTMLURL: testRunURL})
+			if err != nil {
+				t.Fatalf("watch() error = %v", err)
+			}
+			if run.Status != "completed" || run.Conclusion != "failure" {
+				t.Errorf("watch() = %+v, want the completed run", run)
+			}
+
+			want := "[build] Set up job: success (3s)\n" +
+				"[build] Build and push: failure\n" +
+				"[build] | line one\n" +
+				"[build] | line two\n" +
+				"[cleanup] log unavailable: "
+			if got := out.String(); !strings.HasPrefix(got, want) || strings.Count(got, "\n") != 5 {
+				t.Errorf("output:\n%s\nwant:\n%s...", got, want)
+			}
+		})
+	}
+}
+
+func TestRunWatcherWatchTimesOut(t *testing.T) {
+	for _, kind := range apiKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			f := &fakeActions{states: []runState{{status: "in_progress", jobs: buildStarted}}}
+			var out strings.Builder
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := newWatcher(f.client(t, kind.rest), &out).watch(ctx, &workflowRun{ID: 7, Status: "queued", HTMLURL: testRunURL})
+			if err == nil || err.Error() != "timed out waiting for workflow run "+testRunURL {
+				t.Fatalf("watch() error = %v", err)
+			}
+			if strings.Count(out.String(), "Set up job") != 1 {
+				t.Errorf("step reported more than once:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestGitHubBuildFollowsRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		actions    func() *fakeActions
+		wantErr    []string
+		wantOutput []string
+	}{
+		{
+			name: "success",
+			actions: func() *fakeActions {
+				return &fakeActions{hiddenPolls: 1, states: []runState{
+					{status: "queued"},
+					{status: "completed", conclusion: "success", jobs: buildStarted},
+				}}
+			},
+			wantOutput: []string{"Workflow run: " + testRunURL, "[build] Set up job: success (3s)",
+				"Build completed successfully!", "Successfully built ghcr.io/octo/app:1.0"},
+		},
+		{
+			name: "failure",
+			actions: func() *fakeActions {
+				return &fakeActions{states: runProgress, logs: map[int64]string{1: "ERROR: failed to solve\n"}}
+			},
+			wantErr: []string{`build failed with conclusion "failure"`, testRunURL},
+			wantOutput: []string{"[build] Build and push: failure", "[build] | ERROR: failed to solve",
+				"[cleanup] log unavailable"},
+		},
+		{
+			name: "no run",
+			actions: func() *fakeActions {
+				return &fakeActions{hiddenPolls: -1, states: []runState{{status: "queued"}}}
+			},
+			wantErr: []string{"timed out waiting for a workflow run on branch build-app-",
+				"see https://github.com/octo/docker-builds/actions"},
+		},
+	}
+	for _, kind := range apiKinds {
+		for _, tt := range tests {
+			t.Run(kind.name+"/"+tt.name, func(t *testing.T) {
+				f := tt.actions()
+				store := newTestStore(t)
+				b := &GitHub{
+					Store:        store,
+					Runner:       &fakeRunner{respond: (&fakeGH{repoExists: true}).respond},
+					API:          f.client(t, kind.rest),
+					Timeout:      100 * time.Millisecond,
+					PollInterval: time.Millisecond,
+				}
+				var out strings.Builder
+				img, err := b.Build(Options{
+					ContextDir: writeContext(t, map[string]string{"Dockerfile": "FROM alpine\n"}),
+					Tags:       []reference.Reference{mustParse(t, "app:1.0")},
+					Out:        &out,
+				})
+
+				if tt.wantErr != nil {
+					if err == nil {
+						t.Fatalf("Build() succeeded, want %q", tt.wantErr)
+					}
+					if missing, ok := containsAll(err.Error(), tt.wantErr...); !ok {
+						t.Errorf("Build() error = %v, want %q", err, missing)
+					}
+					if store.builds != 0 {
+						t.Errorf("a failed run recorded an image")
+					}
+				} else {
+					if err != nil {
+						t.Fatalf("Build() error = %v\n%s", err, out.String())
+					}
+					if found, err := store.ResolveImage("ghcr.io/octo/app:1.0"); err != nil || found.ID != img.ID || store.builds != 1 {
+						t.Errorf("the built image is not recorded as ghcr.io/octo/app:1.0: %v", err)
+					}
+				}
+				if missing, ok := containsAll(out.String(), tt.wantOutput...); !ok {
+					t.Errorf("output lacks %q:\n%s", missing, out.String())
+				}
+			})
+		}
+	}
+}
+
+func TestRESTClient(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.URL.Path != "/repos/octo/app" {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"name":"app"}`))
+	}))
+	defer srv.Close()
+
+	c := &RESTClient{BaseURL: srv.URL, Token: "secret"}
+	body, err := c.Get(context.Background(), "repos/octo/app")
+	if err != nil || string(body) != `{"name":"app"}` {
+		t.Errorf("Get() = %q, %v", body, err)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	_, err = c.Get(context.Background(), "repos/octo/nope")
+	if err == nil || err.Error() != `GET repos/octo/nope: 404 Not Found: {"message":"Not Found"}` {
+		t.Errorf("Get() error = %v", err)
+	}
+
+	c.Token = ""
+	if _, err := c.Get(context.Background(), "repos/octo/app"); err != nil || auth != "" {
+		t.Errorf("Get() without a token sent Authorization %q, error %v", auth, err)
+	}
+}
@@ -0,0 +1,208 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"prepare.sh/dockermock/reference"
+	"prepare.sh/dockermock/runner"
+)
+
+// fakeGH answers the gh and git commands of a GitHub Actions build. The
+// workflow run it reports through `gh api` has already succeeded.
+type fakeGH struct {
+	repoExists bool
+	loggedOut  bool
+	fail       string   // command line prefix that fails
+	committed  []string // files of the branch when it was committed
+	workflow   string
+}
+
+func (f *fakeGH) respond(cmd runner.Cmd) (string, error) {
+	line := cmd.String()
+	if f.fail != "" && strings.HasPrefix(line, f.fail) {
+		return "", errors.New("exit status 1")
+	}
+	switch {
+	case line == "gh auth status":
+		if f.loggedOut {
+			return "", errors.New("exit status 1")
+		}
+	case line == "gh api user --jq .login":
+		return "Octo\n", nil
+	case strings.HasPrefix(line, "gh repo view"):
+		if !f.repoExists {
+			return "", errors.New("exit status 1")
+		}
+		return `{"name":"docker-builds"}`, nil
+	case line == "git add .":
+		f.committed = branchFiles(cmd.Dir)
+		workflow, _ := os.ReadFile(filepath.Join(cmd.Dir, ".github", "workflows", "docker-build.yml"))
+		f.workflow = string(workflow)
+	case strings.HasPrefix(line, "gh api") && strings.Contains(line, "/actions/runs?"):
+		u, err := url.Parse("/" + cmd.Args[len(cmd.Args)-1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`{"workflow_runs":[{"id":7,"head_branch":%q,"status":"completed","conclusion":"success","html_url":"https://github.com/octo/docker-builds/actions/runs/7"}]}`,
+			u.Query().Get("branch")), nil
+	case strings.HasPrefix(line, "gh api") && strings.HasSuffix(line, "/actions/runs/7/jobs"):
+		return `{"jobs":[]}`, nil
+	}
+	return "", nil
+}
+
+// branchFiles lists the files of a checkout, without .git
+func branchFiles(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if info.IsDir() {
+			if rel == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+func TestGitHubBuildCommands(t *testing.T) {
+	tests := []struct {
+		name       string
+		repoExists bool
+		wantCreate bool
+	}{
+		{name: "existing repository", repoExists: true},
+		{name: "new repository", wantCreate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := &fakeGH{repoExists: tt.repoExists}
+			fake := &fakeRunner{respond: gh.respond}
+			store := newTestStore(t)
+			b := &GitHub{Store: store, Runner: fake, PollInterval: time.Millisecond}
+
+			var out strings.Builder
+			img, err := b.Build(Options{
+				ContextDir: writeContext(t, map[string]string{
+					"Dockerfile":    "FROM alpine\nCOPY . /app\n",
+					"main.go":       "package main\n",
+					"secret.env":    "TOKEN=x\n",
+					".dockerignore": "*.env\n",
+				}),
+				Tags:   []reference.Reference{mustParse(t, "app/web:1.0")},
+				Labels: map[string]string{"team": "core"},
+				Target: "prod",
+				Out:    &out,
+			})
+			if err != nil {
+				t.Fatalf("Build() error = %v\n%s", err, out.String())
+			}
+
+			cmds := fake.commands()
+			var branch, workDir string
+			for _, cmd := range fake.cmds {
+				if cmd.String() == "git checkout -b "+cmd.Args[len(cmd.Args)-1] {
+					branch, workDir = cmd.Args[len(cmd.Args)-1], cmd.Dir
+				}
+			}
+			if !strings.HasPrefix(branch, "build-app-web-") {
+				t.Fatalf("branch = %q, want build-app-web-<time>", branch)
+			}
+			want := []string{
+				"gh auth status",
+				"gh api user --jq .login",
+				"gh repo view octo/docker-builds --json name",
+			}
+			if tt.wantCreate {
+				want = append(want, "gh repo create docker-builds --public --description Docker build repository")
+			}
+			want = append(want,
+				"git clone https://github.com/octo/docker-builds.git "+workDir,
+				"git checkout -b "+branch,
+				"git add .",
+				"git config user.email dockercli@example.com",
+				"git config user.name Docker CLI",
+				"git commit -m Docker build for app/web:1.0",
+				"git push -u origin "+branch,
+				"gh api -H Accept: application/vnd.github+json repos/octo/docker-builds/actions/runs?branch="+branch+"&event=push&per_page=10",
+				"gh api -H Accept: application/vnd.github+json repos/octo/docker-builds/actions/runs/7/jobs",
+			)
+			if strings.Join(cmds, "\n") != strings.Join(want, "\n") {
+				t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(cmds, "\n"), strings.Join(want, "\n"))
+			}
+			for _, cmd := range fake.cmds {
+				if cmd.Name == "git" && cmd.Args[0] != "clone" && cmd.Dir != workDir {
+					t.Errorf("%s ran in %q, want the clone", cmd.String(), cmd.Dir)
+				}
+			}
+			if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+				t.Errorf("clone %s was not removed", workDir)
+			}
+
+			wantFiles := []string{".dockerignore", ".github/workflows/docker-build.yml", "Dockerfile", "main.go"}
+			if strings.Join(gh.committed, " ") != strings.Join(wantFiles, " ") {
+				t.Errorf("committed %q, want %q", gh.committed, wantFiles)
+			}
+			if missing, ok := containsAll(gh.workflow, "branches: [ "+branch+" ]", "target: prod", "team=core",
+				"ghcr.io/octo/app/web:1.0", "branches: "+branch); !ok {
+				t.Errorf("workflow lacks %q:\n%s", missing, gh.workflow)
+			}
+
+			if found, err := store.ResolveImage("ghcr.io/octo/app/web:1.0"); err != nil || found.ID != img.ID {
+				t.Errorf("the built image is not tagged ghcr.io/octo/app/web:1.0: %v", err)
+			}
+		})
+	}
+}
+
+func TestGitHubBuildFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		gh      *fakeGH
+		missing bool
+		tags    []string
+		wantErr string
+	}{
+		{name: "no tag", gh: &fakeGH{}, wantErr: "needs an image name"},
+		{name: "gh missing", gh: &fakeGH{}, missing: true, tags: []string{"app"}, wantErr: "GitHub CLI (gh) not found"},
+		{name: "logged out", gh: &fakeGH{loggedOut: true}, tags: []string{"app"}, wantErr: "gh auth login"},
+		{name: "repository creation", gh: &fakeGH{fail: "gh repo create"}, tags: []string{"app"}, wantErr: "error creating GitHub repository"},
+		{name: "clone", gh: &fakeGH{repoExists: true, fail: "git clone"}, tags: []string{"app"}, wantErr: "error cloning repository"},
+		{name: "push", gh: &fakeGH{repoExists: true, fail: "git push"}, tags: []string{"app"}, wantErr: "error pushing to GitHub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeRunner{respond: tt.gh.respond}
+			if tt.missing {
+				fake.missing = map[string]bool{"gh": true}
+			}
+			store := newTestStore(t)
+			b := &GitHub{Store: store, Runner: fake, PollInterval: time.Millisecond}
+			opts := Options{ContextDir: writeContext(t, map[string]string{"Dockerfile": "FROM alpine\n"})}
+			for _, tag := range tt.tags {
+				opts.Tags = append(opts.Tags, mustParse(t, tag))
+			}
+			if _, err := b.Build(opts); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Build() error = %v, want %q", err, tt.wantErr)
+			}
+			if store.builds != 0 {
+				t.Errorf("a failed build recorded an image")
+			}
+		})
+	}
+}
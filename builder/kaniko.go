@@ -0,0 +1,189 @@
+// builder/kaniko.go
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/kube"
+)
+
+// DefaultKanikoImage is the executor image Kaniko builds run
+const DefaultKanikoImage = "gcr.io/kaniko-project/executor:latest"
+
+// Kaniko builds images in a Kubernetes cluster: a Job runs the kaniko
+// executor, which reads the build context as a tarball from its standard
+// input. Images tagged for a registry other than Docker Hub are pushed
+// there; the others only exist in the local store afterwards.
+type Kaniko struct {
+	Store    Store
+	Kubectl  *kube.Kubectl
+	Image    string        // executor image; DefaultKanikoImage if empty
+	Timeout  time.Duration // limit for the whole build; 30 minutes if zero
+	Interval time.Duration // how often to check whether the build pod started; a second if zero
+}
+
+// NewKaniko returns a Kaniko builder running Jobs in namespace with the
+// kubectl installed on this machine
+func NewKaniko(store Store, namespace string) *Kaniko {
+	return &Kaniko{Store: store, Kubectl: kube.New(namespace), Image: DefaultKanikoImage}
+}
+
+// Build runs the executor on the context and records the image it built
+func (b *Kaniko) Build(opts Options) (*data.Image, error) {
+	out := opts.Out
+	if out == nil {
+		out = io.Discard
+	}
+	dockerfile, err := relativeDockerfile(opts)
+	if err != nil {
+		return nil, err
+	}
+	buildContext, err := openRemoteContext(opts, dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	timeout := b.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	created, err := b.Kubectl.EnsureNamespace(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create namespace %s: %v", b.Kubectl.Namespace, err)
+	}
+	if created {
+		fmt.Fprintf(out, "Created namespace '%s'\n", b.Kubectl.Namespace)
+	}
+
+	job := fmt.Sprintf("build-%d", time.Now().UnixNano()%1_000_000_000)
+	manifest, err := b.jobManifest(job, dockerfile, opts)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Starting build job %s in namespace %s\n", job, b.Kubectl.Namespace)
+	if err := b.Kubectl.Apply(ctx, manifest); err != nil {
+		return nil, fmt.Errorf("failed to create build job: %v", err)
+	}
+	defer b.Kubectl.Delete(context.Background(), "job", job)
+
+	pod, err := b.waitForPod(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream the context into the executor and its log out of it
+	if err := buildContext.report(out, "pod "+pod); err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		writer.CloseWithError(contextTarball(buildContext, writer))
+	}()
+	if err := b.Kubectl.Run(ctx, reader, out, out, "attach", "-i", pod, "-c", "kaniko"); err != nil {
+		return nil, fmt.Errorf("build job %s failed: %v", job, err)
+	}
+	wait := fmt.Sprintf("--timeout=%ds", int(remaining(ctx).Seconds()))
+	if _, err := b.Kubectl.Output(ctx, "wait", "--for=condition=complete", "job/"+job, wait); err != nil {
+		return nil, fmt.Errorf("build job %s did not complete: %v", job, err)
+	}
+
+	img, err := recordRemoteBuild(b.Store, buildContext, opts.Labels, opts.Tags...)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Successfully built %s\n", img.ID[:12])
+	for _, ref := range opts.Tags {
+		fmt.Fprintf(out, "Successfully tagged %s\n", ref.FamiliarString())
+	}
+	return img, nil
+}
+
+// jobManifest returns the Job running the executor
+func (b *Kaniko) jobManifest(job, dockerfile string, opts Options) ([]byte, error) {
+	args := []string{"--context=tar://stdin", "--dockerfile=" + dockerfile}
+	if opts.Target != "" {
+		args = append(args, "--target="+opts.Target)
+	}
+	pushed := false
+	for _, ref := range opts.Tags {
+		if ref.Domain != "docker.io" {
+			args = append(args, "--destination="+ref.String())
+			pushed = true
+		}
+	}
+	if !pushed {
+		args = append(args, "--no-push")
+	}
+	for _, k := range sortedKeys(opts.BuildArgs) {
+		args = append(args, "--build-arg="+k+"="+opts.BuildArgs[k])
+	}
+	for _, k := range sortedKeys(opts.Labels) {
+		args = append(args, "--label="+k+"="+opts.Labels[k])
+	}
+
+	image := b.Image
+	if image == "" {
+		image = DefaultKanikoImage
+	}
+	manifest := map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]interface{}{
+			"name":   job,
+			"labels": map[string]string{"app.kubernetes.io/managed-by": "dockermock"},
+		},
+		"spec": map[string]interface{}{
+			"backoffLimit": 0,
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"restartPolicy": "Never",
+					"containers": []map[string]interface{}{{
+						"name":      "kaniko",
+						"image":     image,
+						"args":      args,
+						"stdin":     true,
+						"stdinOnce": true,
+					}},
+				},
+			},
+		},
+	}
+	return json.Marshal(manifest)
+}
+
+// waitForPod waits until the Job's pod is running and returns its name
+func (b *Kaniko) waitForPod(ctx context.Context, job string) (string, error) {
+	interval := b.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		pod, err := b.Kubectl.Output(ctx, "get", "pods", "-l", "job-name="+job, "-o", "jsonpath={.items[0].metadata.name}")
+		if err == nil && pod != "" {
+			wait := fmt.Sprintf("--timeout=%ds", int(remaining(ctx).Seconds()))
+			if _, err := b.Kubectl.Output(ctx, "wait", "--for=condition=Ready", "pod/"+pod, wait); err != nil {
+				return "", fmt.Errorf("build pod %s did not start: %v", pod, err)
+			}
+			return pod, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("timed out waiting for the pod of build job %s", job)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// remaining returns the time left before ctx expires
+func remaining(ctx context.Context) time.Duration {
+	deadline, _ := ctx.Deadline()
+	return time.Until(deadline)
+}
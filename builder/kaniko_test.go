@@ -0,0 +1,210 @@
+package builder
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"prepare.sh/dockermock/kube"
+	"prepare.sh/dockermock/reference"
+	"prepare.sh/dockermock/runner"
+)
+
+// fakeCluster answers the kubectl commands of a Kaniko build. fail makes
+// the command starting with that kubectl subcommand line fail.
+type fakeCluster struct {
+	namespaceExists bool
+	fail            string
+	manifest        []byte   // applied with kubectl apply
+	sent            []string // files of the context tarball sent to the pod
+}
+
+func (c *fakeCluster) respond(cmd runner.Cmd) (string, error) {
+	if cmd.Name != "kubectl" || len(cmd.Args) < 3 || cmd.Args[0] != "-n" {
+		return "", errors.New("unexpected command " + cmd.String())
+	}
+	line := strings.Join(cmd.Args[2:], " ")
+	if c.fail != "" && strings.HasPrefix(line, c.fail) {
+		return "", errors.New(c.fail + " failed")
+	}
+	switch {
+	case strings.HasPrefix(line, "get namespace"):
+		if !c.namespaceExists {
+			return "", errors.New("NotFound")
+		}
+	case strings.HasPrefix(line, "apply -f -"):
+		c.manifest, _ = io.ReadAll(cmd.Stdin)
+	case strings.HasPrefix(line, "get pods"):
+		return "build-pod\n", nil
+	case strings.HasPrefix(line, "attach -i build-pod"):
+		// The context arrives as a gzip-compressed tarball
+		gz, err := gzip.NewReader(cmd.Stdin)
+		if err != nil {
+			return "", err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			c.sent = append(c.sent, hdr.Name)
+		}
+		io.Copy(io.Discard, cmd.Stdin)
+		return "INFO[0001] Pushed image\n", nil
+	}
+	return "", nil
+}
+
+// containerArgs returns the executor arguments of the applied Job
+func (c *fakeCluster) containerArgs(t *testing.T) []string {
+	t.Helper()
+	var job struct {
+		Spec struct {
+			Template struct {
+				Spec struct {
+					Containers []struct {
+						Image string   `json:"image"`
+						Args  []string `json:"args"`
+					} `json:"containers"`
+				} `json:"spec"`
+			} `json:"template"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(c.manifest, &job); err != nil {
+		t.Fatalf("applied manifest %q: %v", c.manifest, err)
+	}
+	return job.Spec.Template.Spec.Containers[0].Args
+}
+
+func TestKanikoBuild(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		opts     Options
+		wantArgs []string
+	}{
+		{
+			name:     "Docker Hub tags are not pushed",
+			tags:     []string{"app:1.0"},
+			wantArgs: []string{"--context=tar://stdin", "--dockerfile=Dockerfile", "--no-push"},
+		},
+		{
+			name: "registry tags are pushed",
+			tags: []string{"registry.example.com/team/app:1.0", "app:1.0", "localhost:5000/app"},
+			wantArgs: []string{"--context=tar://stdin", "--dockerfile=Dockerfile",
+				"--destination=registry.example.com/team/app:1.0", "--destination=localhost:5000/app:latest"},
+		},
+		{
+			name: "target, build args and labels",
+			tags: []string{"app:1.0"},
+			opts: Options{Target: "prod", BuildArgs: map[string]string{"B": "2", "A": "1"}, Labels: map[string]string{"team": "core"}},
+			wantArgs: []string{"--context=tar://stdin", "--dockerfile=Dockerfile", "--target=prod", "--no-push",
+				"--build-arg=A=1", "--build-arg=B=2", "--label=team=core"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := &fakeCluster{namespaceExists: true}
+			fake := &fakeRunner{respond: cluster.respond}
+			store := newTestStore(t)
+			b := &Kaniko{Store: store, Kubectl: &kube.Kubectl{Runner: fake, Namespace: "builds"}, Interval: time.Millisecond}
+
+			opts := tt.opts
+			opts.ContextDir = writeContext(t, map[string]string{
+				"Dockerfile":    "FROM alpine\nCOPY . /app\n",
+				"main.go":       "package main\n",
+				"secret.env":    "TOKEN=x\n",
+				".dockerignore": "*.env\n",
+			})
+			for _, tag := range tt.tags {
+				opts.Tags = append(opts.Tags, mustParse(t, tag))
+			}
+			var out strings.Builder
+			opts.Out = &out
+
+			img, err := b.Build(opts)
+			if err != nil {
+				t.Fatalf("Build() error = %v\n%s", err, out.String())
+			}
+			if got := cluster.containerArgs(t); strings.Join(got, " ") != strings.Join(tt.wantArgs, " ") {
+				t.Errorf("executor args = %q, want %q", got, tt.wantArgs)
+			}
+			if got := strings.Join(cluster.sent, " "); got != ".dockerignore Dockerfile main.go" {
+				t.Errorf("context sent = %q", got)
+			}
+			for _, tag := range tt.tags {
+				ref := mustParse(t, tag)
+				if found, err := store.ResolveImage(ref.FamiliarString()); err != nil || found.ID != img.ID {
+					t.Errorf("%s does not resolve to the built image: %v", tag, err)
+				}
+			}
+			if missing, ok := containsAll(out.String(), "Starting build job build-", "Sending build context to pod build-pod", "Pushed image", "Successfully built "+img.ID[:12]); !ok {
+				t.Errorf("output lacks %q:\n%s", missing, out.String())
+			}
+
+			cmds := fake.commands()
+			if last := cmds[len(cmds)-1]; !strings.HasPrefix(last, "kubectl -n builds delete job build-") {
+				t.Errorf("last command = %q, want the job deleted", last)
+			}
+		})
+	}
+}
+
+func TestKanikoBuildFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    string
+		wantErr string
+	}{
+		{"namespace", "create namespace", "failed to create namespace builds"},
+		{"apply", "apply", "failed to create build job"},
+		{"pod never ready", "wait --for=condition=Ready", "build pod build-pod did not start"},
+		{"executor fails", "attach", "build job build-"},
+		{"job fails", "wait --for=condition=complete", "did not complete"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := &fakeCluster{fail: tt.fail}
+			fake := &fakeRunner{respond: cluster.respond}
+			store := newTestStore(t)
+			b := &Kaniko{Store: store, Kubectl: &kube.Kubectl{Runner: fake, Namespace: "builds"}, Interval: time.Millisecond}
+
+			_, err := b.Build(Options{
+				ContextDir: writeContext(t, map[string]string{"Dockerfile": "FROM alpine\n"}),
+				Tags:       []reference.Reference{mustParse(t, "app:1.0")},
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Build() error = %v, want %q", err, tt.wantErr)
+			}
+			if store.builds != 0 {
+				t.Errorf("a failed build recorded an image")
+			}
+			cmds := fake.commands()
+			applied := false
+			for _, cmd := range cmds {
+				applied = applied || strings.HasPrefix(cmd, "kubectl -n builds apply")
+			}
+			if last := cmds[len(cmds)-1]; applied && tt.fail != "apply" && !strings.HasPrefix(last, "kubectl -n builds delete job") {
+				t.Errorf("last command = %q, want the job deleted", last)
+			}
+		})
+	}
+}
+
+func TestKanikoBuildTimesOutWaitingForPod(t *testing.T) {
+	fake := &fakeRunner{respond: func(cmd runner.Cmd) (string, error) {
+		return "", nil // no pod ever shows up
+	}}
+	b := &Kaniko{Store: newTestStore(t), Kubectl: &kube.Kubectl{Runner: fake, Namespace: "builds"},
+		Timeout: 20 * time.Millisecond, Interval: time.Millisecond}
+	_, err := b.Build(Options{ContextDir: writeContext(t, map[string]string{"Dockerfile": "FROM alpine\n"})})
+	if err == nil || !strings.Contains(err.Error(), "timed out waiting for the pod of build job") {
+		t.Fatalf("Build() error = %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// builder/lint.go
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"prepare.sh/dockermock/data"
+)
+
+// Severity ranks lint findings
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // the build is likely to fail or do the wrong thing
+	SeverityWarning Severity = "warning" // a known anti-pattern
+	SeverityInfo    Severity = "info"    // style
+)
+
+// LintRule is a check of the lint catalog
+type LintRule struct {
+	ID          string
+	Severity    Severity
+	Description string
+	URL         string // documentation of the rules BuildKit shares
+	check       func(l *linter, r *LintRule)
+}
+
+// LintFinding is a violation of a rule at a line of the Dockerfile
+type LintFinding struct {
+	Rule        string   `json:"rule"`
+	Severity    Severity `json:"severity"`
+	Line        int      `json:"line"`
+	EndLine     int      `json:"endLine"`
+	Message     string   `json:"message"`
+	Description string   `json:"description"`
+	URL         string   `json:"url,omitempty"`
+}
+
+// LintOptions configures a lint run
+type LintOptions struct {
+	Disabled []string // IDs of rules not checked, besides those the Dockerfile skips
+	// BaseEnv returns the environment of a base image if it is known, so
+	// variables the image defines are not reported as undefined
+	BaseEnv func(ref string) ([]string, bool)
+}
+
+// linter is the state of one lint run
+type linter struct {
+	df       *data.Dockerfile
+	metaArgs []*data.Instruction
+	stages   []*data.Stage
+	lex      shellLex
+	opts     LintOptions
+	skip     map[string]bool
+	ignored  map[int]map[string]bool // rule IDs by line of the instruction they are ignored for
+	findings []LintFinding
+}
+
+// Lint parses a Dockerfile and checks it against LintRules. Rules are
+// skipped for the whole file with the check parser directive, e.g.
+// "# check=skip=MaintainerDeprecated,LatestTag", and for one instruction
+// with a comment above it, e.g. "# lint ignore=AptGetCleanup". Findings are
+// sorted by line.
+func Lint(content []byte, opts LintOptions) ([]LintFinding, error) {
+	df, err := data.ParseDockerfileReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	metaArgs, stages, err := df.Stages()
+	if err != nil {
+		return nil, err
+	}
+	l := &linter{
+		df:       df,
+		metaArgs: metaArgs,
+		stages:   stages,
+		lex:      shellLex{escape: df.Escape},
+		opts:     opts,
+		skip:     make(map[string]bool),
+		ignored:  ignoreComments(string(content)),
+	}
+	for _, id := range opts.Disabled {
+		l.skip[id] = true
+	}
+	for _, id := range checkSkips(df.Directives["check"]) {
+		l.skip[id] = true
+	}
+	if l.skip["all"] {
+		return nil, nil
+	}
+	for _, r := range LintRules {
+		if !l.skip[r.ID] {
+			r.check(l, r)
+		}
+	}
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		return l.findings[i].Line < l.findings[j].Line
+	})
+	return l.findings, nil
+}
+
+// FindLintRule returns the rule with an ID, or nil
+func FindLintRule(id string) *LintRule {
+	for _, r := range LintRules {
+		if strings.EqualFold(r.ID, id) {
+			return r
+		}
+	}
+	return nil
+}
+
+// checkSkips returns the rules the check directive skips, e.g.
+// "skip=JSONArgsRecommended,LatestTag;error=true"
+func checkSkips(directive string) []string {
+	var skips []string
+	for _, part := range strings.Split(directive, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if strings.TrimSpace(key) != "skip" {
+			continue
+		}
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				skips = append(skips, id)
+			}
+		}
+	}
+	return skips
+}
+
+var ignoreCommentPattern = regexp.MustCompile(`^#\s*lint\s+ignore\s*=\s*(.+?)\s*$`)
+
+// ignoreComments finds the "# lint ignore=Rule,..." comments of a Dockerfile
+// and returns the rules they ignore by the line of the instruction they
+// precede. Other comments may come in between.
+func ignoreComments(content string) map[int]map[string]bool {
+	ignored := make(map[int]map[string]bool)
+	var pending []string
+	for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#"):
+			if m := ignoreCommentPattern.FindStringSubmatch(line); m != nil {
+				pending = append(pending, strings.Split(m[1], ",")...)
+			}
+			continue
+		}
+		if len(pending) > 0 {
+			ids := make(map[string]bool)
+			for _, id := range pending {
+				ids[strings.TrimSpace(id)] = true
+			}
+			ignored[i+1] = ids
+			pending = nil
+		}
+	}
+	return ignored
+}
+
+// report records a finding for inst unless the rule is ignored there
+func (l *linter) report(r *LintRule, inst *data.Instruction, format string, args ...interface{}) {
+	if l.ignored[inst.StartLine][r.ID] {
+		return
+	}
+	l.findings = append(l.findings, LintFinding{
+		Rule:        r.ID,
+		Severity:    r.Severity,
+		Line:        inst.StartLine,
+		EndLine:     inst.EndLine,
+		Message:     fmt.Sprintf(format, args...),
+		Description: r.Description,
+		URL:         r.URL,
+	})
+}
+
+// reportLine records a finding for a line no instruction starts at
+func (l *linter) reportLine(r *LintRule, line int, message string) {
+	l.report(r, &data.Instruction{StartLine: line, EndLine: line}, "%s", message)
+}
+
+// stageIndex returns the index of the stage called name among the stages
+// before index, or -1
+func (l *linter) stageIndex(name string, before int) int {
+	for i, s := range l.stages[:before] {
+		if s.Name != "" && s.Name == strings.ToLower(name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,380 @@
+// builder/lint_rules.go
+package builder
+
+import (
+	"regexp"
+	"strings"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/reference"
+)
+
+// buildkitRuleURL documents the rules BuildKit checks as well
+const buildkitRuleURL = "https://docs.docker.com/go/dockerfile/rule/"
+
+// LintRules is the catalog of lint rules, in the order they are checked
+var LintRules = []*LintRule{
+	{
+		ID:          "MissingImageTag",
+		Severity:    SeverityWarning,
+		Description: "Base images should be pinned to a tag or digest so that rebuilds start from the same image",
+		check:       checkMissingImageTag,
+	},
+	{
+		ID:          "LatestTag",
+		Severity:    SeverityWarning,
+		Description: "The latest tag moves with every release; pin a version instead",
+		check:       checkLatestTag,
+	},
+	{
+		ID:          "AptGetCleanup",
+		Severity:    SeverityWarning,
+		Description: "Package lists left behind by apt-get update bloat the image; remove them in the RUN that installs",
+		check:       checkAptGetCleanup,
+	},
+	{
+		ID:          "CopyInsteadOfAdd",
+		Severity:    SeverityWarning,
+		Description: "ADD also fetches URLs and unpacks archives; COPY is clearer for local files and folders",
+		check:       checkCopyInsteadOfAdd,
+	},
+	{
+		ID:          "JSONArgsRecommended",
+		Severity:    SeverityWarning,
+		Description: "JSON arguments recommended for ENTRYPOINT/CMD to prevent unintended behavior related to OS signals",
+		URL:         buildkitRuleURL + "json-args-recommended/",
+		check:       checkJSONArgsRecommended,
+	},
+	{
+		ID:          "MaintainerDeprecated",
+		Severity:    SeverityWarning,
+		Description: "The MAINTAINER instruction is deprecated, use a label to define an image author instead",
+		URL:         buildkitRuleURL + "maintainer-deprecated/",
+		check:       checkMaintainerDeprecated,
+	},
+	{
+		ID:          "MultipleInstructionsDisallowed",
+		Severity:    SeverityWarning,
+		Description: "Multiple instructions of the same type should not be used in the same stage",
+		URL:         buildkitRuleURL + "multiple-instructions-disallowed/",
+		check:       checkMultipleInstructions,
+	},
+	{
+		ID:          "UndefinedArgInFrom",
+		Severity:    SeverityError,
+		Description: "FROM command must use declared ARGs",
+		URL:         buildkitRuleURL + "undefined-arg-in-from/",
+		check:       checkUndefinedArgInFrom,
+	},
+	{
+		ID:          "UndefinedVar",
+		Severity:    SeverityWarning,
+		Description: "Variables should be defined before their use",
+		URL:         buildkitRuleURL + "undefined-var/",
+		check:       checkUndefinedVar,
+	},
+	{
+		ID:          "ConsistentInstructionCasing",
+		Severity:    SeverityInfo,
+		Description: "All commands within the Dockerfile should use the same casing (either upper or lower)",
+		URL:         buildkitRuleURL + "consistent-instruction-casing/",
+		check:       checkInstructionCasing,
+	},
+	{
+		ID:          "FromAsCasing",
+		Severity:    SeverityInfo,
+		Description: "The 'as' keyword should match the case of the 'from' keyword",
+		URL:         buildkitRuleURL + "from-as-casing/",
+		check:       checkFromAsCasing,
+	},
+	{
+		ID:          "NoEmptyContinuation",
+		Severity:    SeverityWarning,
+		Description: "Empty continuation lines will become errors in a future release",
+		URL:         buildkitRuleURL + "no-empty-continuation/",
+		check:       checkEmptyContinuation,
+	},
+}
+
+// metaLookup looks up the global ARGs with their defaults and the automatic
+// platform ARGs, as FROM sees them without build args
+func (l *linter) metaLookup() lookupFunc {
+	args := make(map[string]string)
+	for name, value := range platformArgs {
+		args[name] = value
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := args[name]
+		return value, ok
+	}
+	for _, inst := range l.metaArgs {
+		for _, word := range inst.Args {
+			name, value, hasDefault := strings.Cut(word, "=")
+			if hasDefault {
+				value, _ = l.lex.processWord(value, lookup)
+			}
+			args[name] = value
+		}
+	}
+	return lookup
+}
+
+// baseImage returns the image stage i starts from; false for scratch,
+// earlier stages and references that depend on unset ARGs or are invalid
+func (l *linter) baseImage(i int) (reference.Reference, bool) {
+	lookup := l.metaLookup()
+	base := l.stages[i].BaseName
+	if len(l.lex.undefinedVars(base, lookup)) > 0 {
+		return reference.Reference{}, false
+	}
+	name, err := l.lex.processWord(base, lookup)
+	if err != nil || name == "" || strings.EqualFold(name, "scratch") || l.stageIndex(name, i) >= 0 {
+		return reference.Reference{}, false
+	}
+	ref, err := reference.ParseNormalized(name)
+	if err != nil {
+		return reference.Reference{}, false
+	}
+	return ref, true
+}
+
+func checkMissingImageTag(l *linter, r *LintRule) {
+	for i, s := range l.stages {
+		if ref, ok := l.baseImage(i); ok && ref.IsNameOnly() {
+			l.report(r, s.From, "FROM %s does not pin a version; use e.g. %s:<version>", s.BaseName, ref.FamiliarName())
+		}
+	}
+}
+
+func checkLatestTag(l *linter, r *LintRule) {
+	for i, s := range l.stages {
+		if ref, ok := l.baseImage(i); ok && ref.Tag == reference.DefaultTag && ref.Digest == "" {
+			l.report(r, s.From, "FROM %s uses the latest tag; pin a version instead", s.BaseName)
+		}
+	}
+}
+
+var aptInstallPattern = regexp.MustCompile(`\bapt(-get)?\s+(-\S+\s+)*install\b`)
+
+func checkAptGetCleanup(l *linter, r *LintRule) {
+	for _, inst := range l.df.Instructions {
+		if inst.Command != "RUN" {
+			continue
+		}
+		script := strings.Join(inst.Args, " ")
+		for _, h := range inst.Heredocs {
+			script += "\n" + h.Content
+		}
+		if !aptInstallPattern.MatchString(script) || strings.Contains(script, "/var/lib/apt/lists") {
+			continue
+		}
+		cached := false
+		for _, mount := range inst.FlagValues("mount") {
+			cached = cached || (strings.Contains(mount, "type=cache") && strings.Contains(mount, "/var/lib/apt"))
+		}
+		if !cached {
+			l.report(r, inst, "Delete the apt-get lists after installing packages: add && rm -rf /var/lib/apt/lists/* to this RUN")
+		}
+	}
+}
+
+// archiveSuffixes are the local archives ADD unpacks
+var archiveSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".tar.zst"}
+
+func checkCopyInsteadOfAdd(l *linter, r *LintRule) {
+	for _, inst := range l.df.Instructions {
+		if inst.Command != "ADD" || len(inst.Heredocs) > 0 || len(inst.Args) < 2 {
+			continue
+		}
+		local := true
+		for _, source := range inst.Args[:len(inst.Args)-1] {
+			if isURL(source) || strings.HasPrefix(source, "git://") || hasArchiveSuffix(source) {
+				local = false
+			}
+		}
+		if local {
+			l.report(r, inst, "Use COPY instead of ADD to copy local files and folders")
+		}
+	}
+}
+
+func hasArchiveSuffix(source string) bool {
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(strings.ToLower(source), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkJSONArgsRecommended(l *linter, r *LintRule) {
+	for _, inst := range l.df.Instructions {
+		if (inst.Command == "CMD" || inst.Command == "ENTRYPOINT") && !inst.JSON && len(inst.Heredocs) == 0 {
+			l.report(r, inst, "JSON arguments recommended for %s to prevent unintended behavior related to OS signals", inst.Command)
+		}
+	}
+}
+
+func checkMaintainerDeprecated(l *linter, r *LintRule) {
+	for _, inst := range l.df.Instructions {
+		if inst.Command == "MAINTAINER" {
+			l.report(r, inst, "Maintainer instruction is deprecated in favor of using label")
+		}
+	}
+}
+
+// singleInstructions only take effect once per stage
+var singleInstructions = []string{"CMD", "ENTRYPOINT", "HEALTHCHECK"}
+
+func checkMultipleInstructions(l *linter, r *LintRule) {
+	for _, s := range l.stages {
+		for _, command := range singleInstructions {
+			var seen []*data.Instruction
+			for _, inst := range s.Instructions {
+				if inst.Command == command {
+					seen = append(seen, inst)
+				}
+			}
+			for i := 0; i+1 < len(seen); i++ {
+				l.report(r, seen[i], "Multiple %s instructions should not be used in the same stage because only the last one will be used", command)
+			}
+		}
+	}
+}
+
+func checkUndefinedArgInFrom(l *linter, r *LintRule) {
+	lookup := l.metaLookup()
+	for _, s := range l.stages {
+		for _, name := range uniqueNames(l.lex.undefinedVars(s.BaseName, lookup)) {
+			l.report(r, s.From, "FROM argument '%s' is not declared", name)
+		}
+	}
+}
+
+// varInstructions substitute variables in their arguments; RUN, CMD and
+// ENTRYPOINT leave that to the shell
+var varInstructions = map[string]bool{
+	"ADD": true, "COPY": true, "ENV": true, "EXPOSE": true, "LABEL": true,
+	"STOPSIGNAL": true, "USER": true, "VOLUME": true, "WORKDIR": true,
+}
+
+func checkUndefinedVar(l *linter, r *LintRule) {
+	envs := make([]map[string]bool, len(l.stages))
+	for i, s := range l.stages {
+		// Stages inherit ENV, not ARG, from the stage they start from. When
+		// the environment of the base image is unknown, only PATH, which
+		// every image sets, and what the Dockerfile declares are defined.
+		env := map[string]bool{"PATH": true}
+		if parent := l.stageIndex(s.BaseName, i); parent >= 0 {
+			for name := range envs[parent] {
+				env[name] = true
+			}
+		} else if ref, ok := l.baseImage(i); ok && l.opts.BaseEnv != nil {
+			baseEnv, _ := l.opts.BaseEnv(ref.String())
+			for _, entry := range baseEnv {
+				name, _, _ := strings.Cut(entry, "=")
+				env[name] = true
+			}
+		}
+		envs[i] = env
+
+		defined := make(map[string]bool)
+		for name := range env {
+			defined[name] = true
+		}
+		lookup := func(name string) (string, bool) {
+			return "", defined[name]
+		}
+		for _, inst := range s.Instructions {
+			var words []string
+			switch {
+			case inst.Command == "ARG":
+				for _, word := range inst.Args {
+					if _, value, ok := strings.Cut(word, "="); ok {
+						words = append(words, value)
+					}
+				}
+			case varInstructions[inst.Command] && len(inst.Heredocs) == 0:
+				words = inst.Args
+			}
+			var undefined []string
+			for _, word := range words {
+				undefined = append(undefined, l.lex.undefinedVars(word, lookup)...)
+			}
+			for _, name := range uniqueNames(undefined) {
+				l.report(r, inst, "Usage of undefined variable '$%s'", name)
+			}
+
+			switch inst.Command {
+			case "ARG":
+				for _, word := range inst.Args {
+					name, _, _ := strings.Cut(word, "=")
+					defined[name] = true
+				}
+			case "ENV":
+				for i := 0; i < len(inst.Args); i += 2 {
+					defined[inst.Args[i]] = true
+					env[inst.Args[i]] = true
+				}
+			}
+		}
+	}
+}
+
+// uniqueNames drops repeated names, keeping the first occurrence
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// keyword returns the instruction keyword as written
+func keyword(inst *data.Instruction) string {
+	return strings.Fields(inst.Original)[0]
+}
+
+func checkInstructionCasing(l *linter, r *LintRule) {
+	upper, lower := 0, 0
+	for _, inst := range l.df.Instructions {
+		switch word := keyword(inst); word {
+		case strings.ToUpper(word):
+			upper++
+		case strings.ToLower(word):
+			lower++
+		}
+	}
+	casing, convert := "uppercase", strings.ToUpper
+	if lower > upper {
+		casing, convert = "lowercase", strings.ToLower
+	}
+	for _, inst := range l.df.Instructions {
+		if word := keyword(inst); word != convert(word) {
+			l.report(r, inst, "Command '%s' should match the case of the command majority (%s)", word, casing)
+		}
+	}
+}
+
+func checkFromAsCasing(l *linter, r *LintRule) {
+	for _, s := range l.stages {
+		if len(s.From.Args) < 3 {
+			continue
+		}
+		from, as := keyword(s.From), s.From.Args[1]
+		fromUpper, asUpper := from == strings.ToUpper(from), as == strings.ToUpper(as)
+		if fromUpper != asUpper {
+			l.report(r, s.From, "'%s' and '%s' keywords' casing do not match", as, from)
+		}
+	}
+}
+
+func checkEmptyContinuation(l *linter, r *LintRule) {
+	for _, w := range l.df.Warnings {
+		l.reportLine(r, w.Line, "Empty continuation line")
+	}
+}
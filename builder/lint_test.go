@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLintUndefinedVar(t *testing.T) {
+	// golang:1.22 is the only base image whose environment is known
+	baseEnv := func(ref string) ([]string, bool) {
+		if ref == "docker.io/library/golang:1.22" {
+			return []string{"PATH=/usr/local/go/bin", "GOPATH=/go"}, true
+		}
+		return nil, false
+	}
+	tests := []struct {
+		name       string
+		dockerfile string
+		want       []string // messages of the UndefinedVar findings
+	}{
+		{
+			name:       "unknown base image",
+			dockerfile: "FROM ubuntu:22.04\nARG A\nENV B=$A\nCOPY $UNDEF $B /x\nWORKDIR $PATH\n",
+			want:       []string{"4: Usage of undefined variable '$UNDEF'"},
+		},
+		{
+			name:       "known base image",
+			dockerfile: "FROM golang:1.22\nWORKDIR $GOPATH/src\nCOPY $GOROOT /x\n",
+			want:       []string{"3: Usage of undefined variable '$GOROOT'"},
+		},
+		{
+			name:       "base image from an ARG",
+			dockerfile: "ARG BASE=ubuntu:22.04\nFROM $BASE\nUSER $UID\n",
+			want:       []string{"3: Usage of undefined variable '$UID'"},
+		},
+		{
+			name:       "ENV inherited from a stage, ARG not",
+			dockerfile: "FROM alpine:3 AS base\nENV HOME_DIR=/app\nARG VERSION=1\nFROM base\nWORKDIR $HOME_DIR\nLABEL v=$VERSION\n",
+			want:       []string{"6: Usage of undefined variable '$VERSION'"},
+		},
+		{
+			name:       "used before declared",
+			dockerfile: "FROM scratch\nLABEL v=$VERSION\nARG VERSION\n",
+			want:       []string{"2: Usage of undefined variable '$VERSION'"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := Lint([]byte(tt.dockerfile), LintOptions{BaseEnv: baseEnv})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range findings {
+				if f.Rule == "UndefinedVar" {
+					got = append(got, fmt.Sprintf("%d: %s", f.Line, f.Message))
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,1248 @@
+// builder/local.go
+
+// Package builder builds images from Dockerfiles.
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
+	"prepare.sh/dockermock/registry"
+)
+
+// Store is the image store a build reads base images from and records its
+// result in. *data.ImageManager implements it.
+type Store interface {
+	ResolveImage(ref string) (*data.Image, error)
+	ImageContent(img *data.Image) (*data.ImageContent, error)
+	ImageLayers(img *data.Image) ([]data.Layer, error)
+	PullImage(ref reference.Reference, progress func(data.PulledLayer)) (*data.Image, bool, error)
+	BuildImage(config oci.Image, layers []data.Layer, refs ...reference.Reference) (*data.Image, error)
+}
+
+// Cache keeps the results of build steps across builds. *data.BuildCache
+// implements it.
+type Cache interface {
+	Get(key string) (*data.CacheRecord, error)
+	Put(record *data.CacheRecord) error
+}
+
+// Options describes a build
+type Options struct {
+	ContextDir string                // directory COPY and ADD read from
+	Dockerfile string                // path of the Dockerfile; defaults to Dockerfile in the context
+	Tags       []reference.Reference // references the image is tagged with
+	BuildArgs  map[string]string     // values of ARG instructions, from --build-arg
+	Labels     map[string]string     // labels set on the image, from --label
+	Target     string                // stage to build; the last one if empty
+	NoCache    bool                  // execute every step instead of reusing cached results
+	Pull       bool                  // pull base images even if they are stored locally
+	CacheFrom  []reference.Reference // images whose build steps may be reused
+	Out        io.Writer             // progress output
+}
+
+// Local executes Dockerfiles without network access or a container
+// runtime. Base images come from the local store or a simulated pull;
+// COPY and ADD turn files of the build context into layers; RUN is
+// recorded in the image history but runs nothing, so it adds an empty
+// layer. Step results are reused from Cache when their cache key matches.
+type Local struct {
+	Store Store
+	Cache Cache // nil disables the cache
+}
+
+// NewLocal returns a local builder recording images in store and step
+// results in cache
+func NewLocal(store Store, cache Cache) *Local {
+	return &Local{Store: store, Cache: cache}
+}
+
+// defaultShell runs shell-form commands unless SHELL changes it
+var defaultShell = []string{"/bin/sh", "-c"}
+
+// platformArgs are the automatic platform ARGs; only amd64 Linux images are
+// built
+var platformArgs = map[string]string{
+	"TARGETPLATFORM": "linux/amd64",
+	"TARGETOS":       "linux",
+	"TARGETARCH":     "amd64",
+	"TARGETVARIANT":  "",
+	"BUILDPLATFORM":  "linux/amd64",
+	"BUILDOS":        "linux",
+	"BUILDARCH":      "amd64",
+	"BUILDVARIANT":   "",
+}
+
+// stepInstructions produce a numbered build step; the rest only change the
+// image config
+var stepInstructions = map[string]bool{"RUN": true, "COPY": true, "ADD": true, "WORKDIR": true}
+
+// build is the state of one build
+type build struct {
+	store    Store
+	cache    Cache
+	opts     Options
+	df       *data.Dockerfile
+	lex      shellLex
+	p        *progress
+	now      time.Time
+	metaArgs map[string]string // ARGs declared before the first FROM
+	usedArgs map[string]bool   // build args some ARG consumed
+	stages   []*stage
+	images   map[string]*baseImage        // images stages start from or copy from, by reference
+	imported map[string]*data.CacheRecord // cache records of the --cache-from images
+	context  *buildContext
+}
+
+// baseImage is an image a build reads, found locally or pulled
+type baseImage struct {
+	image  *data.Image
+	pulled []data.PulledLayer
+	fs     *snapshot // read on the first COPY --from the image
+}
+
+// stage is one FROM section of the Dockerfile and the image it builds
+type stage struct {
+	name      string // the AS name, or stage-N
+	named     bool   // the stage has an AS name
+	index     int
+	from      *data.Instruction
+	insts     []*data.Instruction
+	base      string      // expanded FROM reference, "scratch" or the name of parent
+	parent    *stage      // earlier stage the stage starts from
+	deps      []*stage    // stages that must be built first: parent and COPY --from sources
+	baseImage *data.Image // nil for scratch and stages starting from a stage
+	pulled    []data.PulledLayer
+	config    oci.Image
+	layers    []data.Layer
+	args      map[string]string // ARGs in scope with a value
+	argOrder  []string
+	cmdSet    bool // CMD was set in this stage
+	triggers  []string
+	step      int
+	steps     int
+	fs        *snapshot // read on the first COPY --from the stage
+	cacheKey  string    // key of the last step
+}
+
+// lookup returns the value of a variable for substitution: ENV values take
+// precedence over ARGs
+func (s *stage) lookup(name string) (string, bool) {
+	for i := len(s.config.Config.Env) - 1; i >= 0; i-- {
+		if key, value, _ := strings.Cut(s.config.Config.Env[i], "="); key == name {
+			return value, true
+		}
+	}
+	value, ok := s.args[name]
+	return value, ok
+}
+
+// Build executes the Dockerfile and records the image, tagged with
+// opts.Tags. Errors are reported the way BuildKit reports them.
+func (b *Local) Build(opts Options) (*data.Image, error) {
+	if opts.Dockerfile == "" {
+		opts.Dockerfile = filepath.Join(opts.ContextDir, "Dockerfile")
+	}
+	if opts.Out == nil {
+		opts.Out = io.Discard
+	}
+	bd := &build{
+		store:    b.Store,
+		cache:    b.Cache,
+		opts:     opts,
+		p:        newProgress(opts.Out, "default"),
+		now:      time.Now().UTC(),
+		metaArgs: make(map[string]string),
+		usedArgs: make(map[string]bool),
+		images:   make(map[string]*baseImage),
+		imported: make(map[string]*data.CacheRecord),
+	}
+	img, err := bd.execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to solve: %v", err)
+	}
+	return img, nil
+}
+
+// execute runs the build from loading the Dockerfile to exporting the image
+// of the target stage. Stages the target does not depend on are skipped.
+func (bd *build) execute() (*data.Image, error) {
+	if err := bd.load(); err != nil {
+		return nil, err
+	}
+	target := bd.stages[len(bd.stages)-1]
+	if bd.opts.Target != "" {
+		if target = findStage(bd.stages, strings.ToLower(bd.opts.Target), false); target == nil {
+			return nil, fmt.Errorf("target stage %q could not be found", bd.opts.Target)
+		}
+	}
+	for _, s := range bd.stages {
+		if err := bd.plan(s); err != nil {
+			return nil, err
+		}
+	}
+	order, err := schedule(target)
+	if err != nil {
+		return nil, err
+	}
+	bd.reportStages(order, target)
+	bd.importCache()
+
+	for _, s := range order {
+		if err := bd.resolveBase(s); err != nil {
+			return nil, err
+		}
+	}
+	if err := bd.loadContext(order); err != nil {
+		return nil, err
+	}
+	multi := len(bd.stages) > 1
+	for _, s := range order {
+		if err := bd.buildStage(s, multi); err != nil {
+			return nil, err
+		}
+	}
+	img, err := bd.export(target)
+	if err != nil {
+		return nil, err
+	}
+	bd.warnUnusedArgs()
+	return img, nil
+}
+
+// load reads and parses the Dockerfile and splits it into stages
+func (bd *build) load() error {
+	v := bd.p.vertex("[internal] load build definition from %s", filepath.Base(bd.opts.Dockerfile))
+	content, err := os.ReadFile(bd.opts.Dockerfile)
+	if err != nil {
+		err = fmt.Errorf("failed to read dockerfile: %v", err)
+		v.fail(err)
+		return err
+	}
+	v.status("transferring dockerfile: %s done", data.HumanSize(int64(len(content))))
+	v.done()
+
+	df, err := data.ParseDockerfileReader(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	bd.df = df
+	bd.lex = shellLex{escape: df.Escape}
+	if len(df.Instructions) == 0 {
+		return fmt.Errorf("the Dockerfile (%s) cannot be empty", filepath.Base(bd.opts.Dockerfile))
+	}
+	metaArgs, stages, err := df.Stages()
+	if err != nil {
+		return err
+	}
+
+	for name, value := range platformArgs {
+		bd.metaArgs[name] = value
+	}
+	for _, inst := range metaArgs {
+		if err := bd.declareArgs(inst, bd.metaArgs, nil, bd.metaLookup); err != nil {
+			return err
+		}
+	}
+	for i, st := range stages {
+		name := st.Name
+		if name == "" {
+			name = fmt.Sprintf("stage-%d", i)
+		}
+		bd.stages = append(bd.stages, &stage{name: name, named: st.Name != "", index: i, from: st.From, insts: st.Instructions})
+	}
+	return nil
+}
+
+// metaLookup looks up the global ARGs, which FROM and COPY --from may use
+func (bd *build) metaLookup(name string) (string, bool) {
+	value, ok := bd.metaArgs[name]
+	return value, ok
+}
+
+// findStage returns the stage called name among stages or, with byIndex,
+// the stage a number refers to; nil if there is none
+func findStage(stages []*stage, name string, byIndex bool) *stage {
+	for _, s := range stages {
+		if s.named && s.name == strings.ToLower(name) {
+			return s
+		}
+	}
+	if byIndex {
+		if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(stages) {
+			return stages[i]
+		}
+	}
+	return nil
+}
+
+// plan expands the base of a stage and finds the stages it depends on:
+// an earlier stage it starts from and the stages COPY --from reads
+func (bd *build) plan(s *stage) error {
+	base, err := bd.lex.processWord(s.from.Args[0], bd.metaLookup)
+	if err != nil {
+		return bd.processError(s.from, s.from.Args[0], err)
+	}
+	if base == "" {
+		return fmt.Errorf("Dockerfile:%d: base name (%s) should not be blank", s.from.StartLine, s.from.Args[0])
+	}
+	s.base = base
+	if parent := findStage(bd.stages[:s.index], base, false); parent != nil {
+		s.parent = parent
+		s.deps = append(s.deps, parent)
+	}
+	for _, inst := range s.insts {
+		if inst.Command != "COPY" {
+			continue
+		}
+		if _, ok := inst.Flag("from"); !ok {
+			continue
+		}
+		dep, _, err := bd.copyFrom(inst)
+		if err != nil {
+			return err
+		}
+		if dep != nil {
+			s.deps = append(s.deps, dep)
+		}
+	}
+	return nil
+}
+
+// copyFrom returns the stage a COPY --from flag names or, if it names no
+// stage, the image reference
+func (bd *build) copyFrom(inst *data.Instruction) (*stage, string, error) {
+	value, _ := inst.Flag("from")
+	name, err := bd.lex.processWord(value, bd.metaLookup)
+	if err != nil {
+		return nil, "", bd.processError(inst, value, err)
+	}
+	if name == "" {
+		return nil, "", fmt.Errorf("Dockerfile:%d: invalid from flag value %q", inst.StartLine, value)
+	}
+	if dep := findStage(bd.stages, name, true); dep != nil {
+		return dep, "", nil
+	}
+	return nil, name, nil
+}
+
+// schedule returns the stages target needs, each after the stages it
+// depends on, and target last
+func schedule(target *stage) ([]*stage, error) {
+	var order []*stage
+	const visiting, visited = 1, 2
+	state := make(map[*stage]int)
+	var visit func(s *stage) error
+	visit = func(s *stage) error {
+		switch state[s] {
+		case visiting:
+			return fmt.Errorf("circular dependency detected on stage: %s", s.name)
+		case visited:
+			return nil
+		}
+		state[s] = visiting
+		for _, dep := range s.deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[s] = visited
+		order = append(order, s)
+		return nil
+	}
+	return order, visit(target)
+}
+
+// reportStages lists the stages a multi-stage build executes and those it
+// skips because the target does not need them
+func (bd *build) reportStages(order []*stage, target *stage) {
+	if len(bd.stages) < 2 {
+		return
+	}
+	needed := make(map[*stage]bool)
+	var executed, skipped []string
+	for _, s := range order {
+		needed[s] = true
+		name := s.name
+		if s == target {
+			name += " (target)"
+		}
+		executed = append(executed, name)
+	}
+	for _, s := range bd.stages {
+		if !needed[s] {
+			skipped = append(skipped, s.name)
+		}
+	}
+	v := bd.p.vertex("[internal] resolve build stages")
+	v.status("executing stages: %s", strings.Join(executed, ", "))
+	if len(skipped) > 0 {
+		v.status("skipping unused stages: %s", strings.Join(skipped, ", "))
+	}
+	v.done()
+}
+
+// declareArgs processes an ARG instruction, storing the value of each
+// declared argument in args. A --build-arg overrides the default, and an
+// ARG without a default inherits the value of a global ARG.
+func (bd *build) declareArgs(inst *data.Instruction, args map[string]string, order *[]string, lookup lookupFunc) error {
+	for _, word := range inst.Args {
+		name, rawDefault, hasDefault := strings.Cut(word, "=")
+		value, set := "", false
+		if hasDefault {
+			expanded, err := bd.lex.processWord(rawDefault, lookup)
+			if err != nil {
+				return bd.processError(inst, rawDefault, err)
+			}
+			value, set = expanded, true
+		} else if global, ok := bd.metaArgs[name]; ok && order != nil {
+			value, set = global, true
+		}
+		if arg, ok := bd.opts.BuildArgs[name]; ok {
+			value, set = arg, true
+			bd.usedArgs[name] = true
+		}
+		if order != nil {
+			*order = append(*order, name)
+		}
+		if set {
+			args[name] = value
+		} else {
+			delete(args, name)
+		}
+	}
+	return nil
+}
+
+// processError reports a failed substitution in an instruction
+func (bd *build) processError(inst *data.Instruction, word string, err error) error {
+	return fmt.Errorf("Dockerfile:%d: failed to process %q: %v", inst.StartLine, word, err)
+}
+
+// resolveBase finds the base image of a stage, pulling it if it is not
+// stored locally, and the images its COPY --from instructions read
+func (bd *build) resolveBase(s *stage) error {
+	if s.parent == nil {
+		if strings.EqualFold(s.base, "scratch") {
+			s.base = "scratch"
+		} else {
+			ref, err := reference.ParseNormalizedTagged(s.base)
+			if err != nil {
+				return fmt.Errorf("failed to parse stage name %q: %v", s.base, err)
+			}
+			base, err := bd.resolveImage(ref)
+			if err != nil {
+				return err
+			}
+			s.base = ref.String()
+			s.baseImage, s.pulled = base.image, base.pulled
+		}
+	}
+	for _, inst := range s.insts {
+		if _, ok := inst.Flag("from"); !ok || inst.Command != "COPY" {
+			continue
+		}
+		dep, name, err := bd.copyFrom(inst)
+		if err != nil {
+			return err
+		}
+		if dep != nil {
+			continue
+		}
+		ref, err := reference.ParseNormalizedTagged(name)
+		if err != nil {
+			return fmt.Errorf("Dockerfile:%d: invalid from flag value %s: %v", inst.StartLine, name, err)
+		}
+		if _, err := bd.resolveImage(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveImage finds an image in the local store or pulls it. Each image
+// is resolved once per build.
+func (bd *build) resolveImage(ref reference.Reference) (*baseImage, error) {
+	if base, ok := bd.images[ref.String()]; ok {
+		return base, nil
+	}
+	base := &baseImage{}
+	v := bd.p.vertex("[internal] load metadata for %s", ref.String())
+	img, err := bd.store.ResolveImage(ref.FamiliarString())
+	if err != nil || bd.opts.Pull {
+		// Local registries are only reachable over the network
+		if registry.IsLocal(ref.Domain) {
+			reason := "image not found locally"
+			if err == nil {
+				reason = "cannot pull"
+			}
+			err = fmt.Errorf("failed to resolve source metadata for %s: %s and network access is disabled", ref.String(), reason)
+			v.fail(err)
+			return nil, err
+		}
+		// --pull refreshes stored images too
+		img, _, err = bd.store.PullImage(ref, func(layer data.PulledLayer) {
+			base.pulled = append(base.pulled, layer)
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to resolve source metadata for %s: %v", ref.String(), err)
+			v.fail(err)
+			return nil, err
+		}
+	}
+	v.done()
+	base.image = img
+	bd.images[ref.String()] = base
+	return base, nil
+}
+
+// loadContext opens the build context and reports its transfer if any
+// stage copies from it
+func (bd *build) loadContext(stages []*stage) error {
+	c, err := openContext(bd.opts.ContextDir)
+	if err != nil {
+		return err
+	}
+	bd.context = c
+	needed := false
+	for _, s := range stages {
+		for _, inst := range s.insts {
+			if inst.Command == "COPY" || inst.Command == "ADD" {
+				needed = true
+			}
+		}
+	}
+	if !needed {
+		return nil
+	}
+	v := bd.p.vertex("[internal] load %s", DockerignoreFile)
+	var ignoreSize int64
+	if info, err := os.Stat(filepath.Join(bd.opts.ContextDir, DockerignoreFile)); err == nil {
+		ignoreSize = info.Size()
+	}
+	v.status("transferring context: %s done", data.HumanSize(ignoreSize))
+	v.done()
+
+	v = bd.p.vertex("[internal] load build context")
+	_, size, err := c.size()
+	if err != nil {
+		v.fail(err)
+		return err
+	}
+	v.status("transferring context: %s done", data.HumanSize(size))
+	if warning := largeContextWarning(size); warning != "" {
+		v.status("%s", warning)
+	}
+	v.done()
+	return nil
+}
+
+// label returns the name of the next step of s, e.g. "[2/5]" or, in
+// multi-stage builds, "[builder 2/5]"
+func (s *stage) label(multi bool) string {
+	s.step++
+	if multi {
+		return fmt.Sprintf("[%s %d/%d]", s.name, s.step, s.steps)
+	}
+	return fmt.Sprintf("[%d/%d]", s.step, s.steps)
+}
+
+// buildStage starts from the base image and executes the stage's
+// instructions
+func (bd *build) buildStage(s *stage, multi bool) error {
+	if err := bd.initStage(s); err != nil {
+		return err
+	}
+	var triggers []*data.Instruction
+	for _, trigger := range s.triggers {
+		df, err := data.ParseDockerfileReader(strings.NewReader(trigger))
+		if err != nil {
+			return fmt.Errorf("failed to parse ONBUILD trigger %q: %v", trigger, err)
+		}
+		triggers = append(triggers, df.Instructions...)
+	}
+
+	s.steps = 1
+	for _, inst := range append(triggers, s.insts...) {
+		if stepInstructions[inst.Command] {
+			s.steps++
+		}
+	}
+
+	v := bd.p.vertex("%s FROM %s", s.label(multi), bd.baseName(s))
+	if s.baseImage != nil {
+		for _, layer := range s.pulled {
+			if !layer.Exists {
+				size := data.HumanSize(layer.Size)
+				v.status("%s %s / %s done", layer.Digest, size, size)
+			}
+		}
+		for _, layer := range s.pulled {
+			if !layer.Exists {
+				v.status("extracting %s done", layer.Digest)
+			}
+		}
+	}
+	v.done()
+
+	for _, inst := range triggers {
+		if err := bd.dispatch(s, inst, multi, "ONBUILD "); err != nil {
+			return err
+		}
+	}
+	for _, inst := range s.insts {
+		if err := bd.dispatch(s, inst, multi, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// baseName names the base image in the FROM step, pinned by digest when
+// known
+func (bd *build) baseName(s *stage) string {
+	if s.baseImage != nil && s.baseImage.Digest != "" {
+		return s.base + "@" + s.baseImage.Digest
+	}
+	return s.base
+}
+
+// initStage sets up the config and layers a stage starts from
+func (bd *build) initStage(s *stage) error {
+	s.args = make(map[string]string)
+	s.config = oci.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       oci.RootFS{Type: "layers"},
+	}
+	switch {
+	case s.parent != nil:
+		config, err := cloneConfig(s.parent.config)
+		if err != nil {
+			return err
+		}
+		s.config = config
+		s.layers = append([]data.Layer(nil), s.parent.layers...)
+		s.cacheKey = s.parent.cacheKey
+	case s.baseImage != nil:
+		content, err := bd.store.ImageContent(s.baseImage)
+		if err != nil {
+			return fmt.Errorf("failed to load base image %s: %v", s.base, err)
+		}
+		layers, err := bd.store.ImageLayers(s.baseImage)
+		if err != nil {
+			return fmt.Errorf("failed to load base image %s: %v", s.base, err)
+		}
+		s.config = content.Config
+		s.layers = layers
+		// The cache entries of the base image describe how it was built,
+		// not this build
+		s.config.BuildCache = nil
+		s.cacheKey = cacheDigest("FROM", s.baseImage.ID)
+	default:
+		s.cacheKey = cacheDigest("FROM", "scratch")
+	}
+	// The triggers of the base image run now and are not inherited
+	s.triggers = s.config.Config.OnBuild
+	s.config.Config.OnBuild = nil
+	s.config.Created = nil
+
+	hasPath := false
+	for _, env := range s.config.Config.Env {
+		hasPath = hasPath || strings.HasPrefix(env, "PATH=")
+	}
+	if !hasPath {
+		s.config.Config.Env = append([]string{data.DefaultPath}, s.config.Config.Env...)
+	}
+	return nil
+}
+
+// cloneConfig returns a deep copy of the config of a stage, for a stage
+// starting from it
+func cloneConfig(config oci.Image) (oci.Image, error) {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return oci.Image{}, err
+	}
+	var clone oci.Image
+	err = json.Unmarshal(raw, &clone)
+	return clone, err
+}
+
+// dispatch executes one instruction. prefix marks ONBUILD triggers in the
+// step names.
+func (bd *build) dispatch(s *stage, inst *data.Instruction, multi bool, prefix string) error {
+	if !stepInstructions[inst.Command] {
+		// Config changes go through the cache too, so rebuilds keep the
+		// times of their history entries and with them the image ID
+		key := bd.stepKey(s, inst, prefix)
+		createdBy, err := bd.configure(s, inst)
+		if err != nil {
+			return err
+		}
+		if createdBy == "" {
+			return nil
+		}
+		_, err = bd.record(s, key, prefix+inst.Original, stepResult{createdBy: createdBy})
+		return err
+	}
+
+	v := bd.p.vertex("%s %s%s", s.label(multi), prefix, inst.Original)
+	key := bd.stepKey(s, inst, prefix)
+	var result stepResult
+	var err error
+	switch inst.Command {
+	case "RUN":
+		result, err = bd.run(s, inst)
+	case "COPY", "ADD":
+		result, err = bd.copy(s, inst)
+	case "WORKDIR":
+		result, err = bd.workdir(s, inst)
+	}
+	if err == nil {
+		err = bd.commit(s, v, key, prefix+inst.Original, result)
+	}
+	if err != nil {
+		v.fail(err)
+		return err
+	}
+	return nil
+}
+
+// historyAt records a step created at a given time, such as a cached one
+func (bd *build) historyAt(s *stage, createdBy string, empty bool, created time.Time) {
+	s.config.History = append(s.config.History, oci.History{
+		Created:    &created,
+		CreatedBy:  createdBy + " # buildkit",
+		Comment:    "buildkit.dockerfile.v0",
+		EmptyLayer: empty,
+	})
+}
+
+// configure executes an instruction that only changes the image config,
+// returning its history entry
+func (bd *build) configure(s *stage, inst *data.Instruction) (string, error) {
+	config := &s.config.Config
+	switch inst.Command {
+	case "ARG":
+		return "ARG " + strings.Join(inst.Args, " "), bd.declareArgs(inst, s.args, &s.argOrder, s.lookup)
+
+	case "ENV", "LABEL":
+		// All pairs see the values from before the instruction
+		var pairs []string
+		values := make([][2]string, 0, len(inst.Args)/2)
+		for i := 0; i+1 < len(inst.Args); i += 2 {
+			key, err := bd.lex.processWord(inst.Args[i], s.lookup)
+			if err != nil {
+				return "", bd.processError(inst, inst.Args[i], err)
+			}
+			value, err := bd.lex.processWord(inst.Args[i+1], s.lookup)
+			if err != nil {
+				return "", bd.processError(inst, inst.Args[i+1], err)
+			}
+			values = append(values, [2]string{key, value})
+		}
+		for _, kv := range values {
+			if inst.Command == "ENV" {
+				config.Env = setEnv(config.Env, kv[0], kv[1])
+				pairs = append(pairs, kv[0]+"="+kv[1])
+				continue
+			}
+			if config.Labels == nil {
+				config.Labels = make(map[string]string)
+			}
+			config.Labels[kv[0]] = kv[1]
+			pairs = append(pairs, kv[0]+"="+kv[1])
+		}
+		return inst.Command + " " + strings.Join(pairs, " "), nil
+
+	case "CMD", "ENTRYPOINT":
+		command := bd.command(s, inst)
+		if inst.Command == "CMD" {
+			config.Cmd, s.cmdSet = command, true
+		} else {
+			config.Entrypoint = command
+			if !s.cmdSet {
+				// An inherited CMD does not apply to the new entrypoint
+				config.Cmd = nil
+			}
+		}
+		return inst.Command + " " + data.ExecForm(command), nil
+
+	case "SHELL":
+		config.Shell = inst.Args
+		return "SHELL " + data.ExecForm(inst.Args), nil
+
+	case "EXPOSE":
+		var ports []string
+		for _, arg := range inst.Args {
+			words, err := bd.lex.processWords(arg, s.lookup)
+			if err != nil {
+				return "", bd.processError(inst, arg, err)
+			}
+			for _, word := range words {
+				expanded, err := exposedPorts(word)
+				if err != nil {
+					return "", err
+				}
+				ports = append(ports, expanded...)
+			}
+		}
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = make(map[string]struct{})
+		}
+		for _, port := range ports {
+			config.ExposedPorts[port] = struct{}{}
+		}
+		sort.Strings(ports)
+		return "EXPOSE map[" + strings.Join(ports, ":{} ") + ":{}]", nil
+
+	case "VOLUME":
+		var volumes []string
+		for _, arg := range inst.Args {
+			volume, err := bd.lex.processWord(arg, s.lookup)
+			if err != nil {
+				return "", bd.processError(inst, arg, err)
+			}
+			if volume = strings.TrimSpace(volume); volume == "" {
+				return "", fmt.Errorf("VOLUME specified can not be an empty string")
+			}
+			volumes = append(volumes, volume)
+		}
+		if config.Volumes == nil {
+			config.Volumes = make(map[string]struct{})
+		}
+		for _, volume := range volumes {
+			config.Volumes[volume] = struct{}{}
+		}
+		return "VOLUME [" + strings.Join(volumes, " ") + "]", nil
+
+	case "USER", "STOPSIGNAL", "MAINTAINER":
+		value, err := bd.lex.processWord(inst.Args[0], s.lookup)
+		if err != nil {
+			return "", bd.processError(inst, inst.Args[0], err)
+		}
+		switch inst.Command {
+		case "USER":
+			config.User = value
+		case "STOPSIGNAL":
+			config.StopSignal = value
+		case "MAINTAINER":
+			s.config.Author = value
+		}
+		return inst.Command + " " + value, nil
+
+	case "HEALTHCHECK":
+		health, err := healthcheck(s, inst)
+		if err != nil {
+			return "", err
+		}
+		config.Healthcheck = health
+		return fmt.Sprintf("HEALTHCHECK &{%s %q %q %q %q '\\x%02x'}", data.ExecForm(health.Test),
+			health.Interval, health.Timeout, health.StartPeriod, health.StartInterval, health.Retries), nil
+
+	case "ONBUILD":
+		config.OnBuild = append(config.OnBuild, inst.Args[0])
+		return "ONBUILD " + inst.Args[0], nil
+	}
+	return "", fmt.Errorf("Dockerfile:%d: unsupported instruction %s", inst.StartLine, inst.Command)
+}
+
+// command returns the command of CMD or ENTRYPOINT; the shell form runs
+// through the shell
+func (bd *build) command(s *stage, inst *data.Instruction) []string {
+	if inst.JSON || len(inst.Args) == 0 {
+		return inst.Args
+	}
+	return append(append([]string{}, bd.shell(s)...), inst.Args[0])
+}
+
+// shell returns the shell running shell-form commands
+func (bd *build) shell(s *stage) []string {
+	if len(s.config.Config.Shell) > 0 {
+		return s.config.Config.Shell
+	}
+	return defaultShell
+}
+
+// portSpec matches an EXPOSE argument: a port or range with an optional
+// protocol
+var portSpec = regexp.MustCompile(`^(\d+)(?:-(\d+))?(?:/(tcp|udp|sctp))?$`)
+
+// exposedPorts expands an EXPOSE argument into port/protocol keys
+func exposedPorts(spec string) ([]string, error) {
+	m := portSpec.FindStringSubmatch(strings.ToLower(spec))
+	if m == nil {
+		return nil, fmt.Errorf("invalid containerPort: %s", spec)
+	}
+	proto := m[3]
+	if proto == "" {
+		proto = "tcp"
+	}
+	first, err := strconv.Atoi(m[1])
+	last := first
+	if err == nil && m[2] != "" {
+		last, err = strconv.Atoi(m[2])
+	}
+	if err != nil || first < 1 || last > 65535 || last < first {
+		return nil, fmt.Errorf("invalid containerPort: %s", spec)
+	}
+	var ports []string
+	for port := first; port <= last; port++ {
+		ports = append(ports, fmt.Sprintf("%d/%s", port, proto))
+	}
+	return ports, nil
+}
+
+// healthcheck builds the health check HEALTHCHECK sets
+func healthcheck(s *stage, inst *data.Instruction) (*oci.HealthConfig, error) {
+	if inst.Args[0] == "NONE" {
+		return &oci.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+	health := &oci.HealthConfig{}
+	if inst.JSON {
+		health.Test = inst.Args
+	} else {
+		health.Test = []string{"CMD-SHELL", inst.Args[1]}
+	}
+	durations := map[string]*time.Duration{
+		"interval":       &health.Interval,
+		"timeout":        &health.Timeout,
+		"start-period":   &health.StartPeriod,
+		"start-interval": &health.StartInterval,
+	}
+	for name, target := range durations {
+		value, ok := inst.Flag(name)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("Dockerfile:%d: invalid --%s: %v", inst.StartLine, name, err)
+		}
+		if d != 0 && d < time.Millisecond {
+			return nil, fmt.Errorf("Dockerfile:%d: %s cannot be less than 1ms", inst.StartLine, name)
+		}
+		*target = d
+	}
+	if value, ok := inst.Flag("retries"); ok {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("Dockerfile:%d: --retries must be a non-negative integer", inst.StartLine)
+		}
+		health.Retries = retries
+	}
+	return health, nil
+}
+
+// run records a RUN instruction. Commands are not executed, so the layer
+// it adds is empty.
+func (bd *build) run(s *stage, inst *data.Instruction) (stepResult, error) {
+	command := inst.Args
+	if !inst.JSON {
+		command = append(append([]string{}, bd.shell(s)...), inst.Args[0])
+	}
+
+	// The history lists the build args in scope, as BuildKit does
+	var args []string
+	for _, name := range s.argOrder {
+		if value, ok := s.args[name]; ok {
+			args = append(args, name+"="+value)
+		}
+	}
+	createdBy := "RUN " + strings.Join(command, " ")
+	if len(args) > 0 {
+		createdBy = fmt.Sprintf("RUN |%d %s %s", len(args), strings.Join(args, " "), strings.Join(command, " "))
+	}
+
+	layer, err := newLayerWriter().layer()
+	if err != nil {
+		return stepResult{}, err
+	}
+	return stepResult{layer: &layer, createdBy: createdBy}, nil
+}
+
+// workdir executes WORKDIR; relative paths are relative to the previous
+// working directory
+func (bd *build) workdir(s *stage, inst *data.Instruction) (stepResult, error) {
+	dir, err := bd.lex.processWord(inst.Args[0], s.lookup)
+	if err != nil {
+		return stepResult{}, bd.processError(inst, inst.Args[0], err)
+	}
+	if !path.IsAbs(dir) {
+		current := s.config.Config.WorkingDir
+		if current == "" {
+			current = "/"
+		}
+		dir = path.Join(current, dir)
+	}
+	s.config.Config.WorkingDir = path.Clean(dir)
+	return stepResult{createdBy: "WORKDIR " + s.config.Config.WorkingDir}, nil
+}
+
+// copy executes COPY and ADD from the build context, here-documents or,
+// with COPY --from, another stage or an image
+func (bd *build) copy(s *stage, inst *data.Instruction) (stepResult, error) {
+	var from *snapshot
+	if _, ok := inst.Flag("from"); ok {
+		fs, err := bd.copySource(inst)
+		if err != nil {
+			return stepResult{}, err
+		}
+		from = fs
+	}
+
+	w := newLayerWriter()
+	if value, ok := inst.Flag("chown"); ok {
+		expanded, err := bd.lex.processWord(value, s.lookup)
+		if err != nil {
+			return stepResult{}, bd.processError(inst, value, err)
+		}
+		if w.owner, err = parseChown(expanded); err != nil {
+			return stepResult{}, err
+		}
+	}
+	if value, ok := inst.Flag("chmod"); ok {
+		mode, err := parseChmod(value)
+		if err != nil {
+			return stepResult{}, err
+		}
+		w.chmod = mode
+	}
+
+	words := make([]string, len(inst.Args))
+	for i, arg := range inst.Args {
+		word, err := bd.lex.processWord(arg, s.lookup)
+		if err != nil {
+			return stepResult{}, bd.processError(inst, arg, err)
+		}
+		words[i] = word
+	}
+	sources, dest := words[:len(words)-1], words[len(words)-1]
+	toDir := strings.HasSuffix(dest, "/") || dest == "." || dest == ".."
+	if !path.IsAbs(dest) {
+		workdir := s.config.Config.WorkingDir
+		if workdir == "" {
+			workdir = "/"
+		}
+		dest = path.Join(workdir, dest)
+	}
+	dest = path.Clean(dest)
+
+	heredocs := make(map[string]data.Heredoc)
+	for _, h := range inst.Heredocs {
+		heredocs[h.Name] = h
+	}
+
+	var hosts []string
+	for i, source := range sources {
+		if m := heredocMarker.FindStringSubmatch(inst.Args[i]); m != nil && !inst.JSON {
+			if h, ok := heredocs[m[1]]; ok {
+				if err := bd.copyHeredoc(s, w, h, dest, toDir); err != nil {
+					return stepResult{}, err
+				}
+				continue
+			}
+		}
+		if from != nil {
+			matches, err := from.match(source)
+			if err != nil {
+				return stepResult{}, err
+			}
+			if len(matches) > 1 && !toDir {
+				return stepResult{}, fmt.Errorf("When using %s with more than one source file, the destination must be a directory and end with a /", inst.Command)
+			}
+			hosts = append(hosts, matches...)
+			continue
+		}
+		if isURL(source) {
+			if inst.Command == "COPY" {
+				return stepResult{}, fmt.Errorf("source can't be a URL for COPY")
+			}
+			return stepResult{}, fmt.Errorf("failed to load %s: network access is disabled for local builds", source)
+		}
+		matches, err := bd.context.match(source)
+		if err != nil {
+			return stepResult{}, err
+		}
+		if len(matches) > 1 && !toDir {
+			return stepResult{}, fmt.Errorf("When using %s with more than one source file, the destination must be a directory and end with a /", inst.Command)
+		}
+		hosts = append(hosts, matches...)
+	}
+	if len(sources) > 1 && !toDir {
+		return stepResult{}, fmt.Errorf("When using %s with more than one source file, the destination must be a directory and end with a /", inst.Command)
+	}
+	if len(hosts) == 0 && len(inst.Heredocs) == 0 {
+		return stepResult{}, fmt.Errorf("no source files were specified")
+	}
+
+	for _, host := range hosts {
+		if from != nil {
+			if err := w.copySnapshot(from, host, dest, toDir, synthesizedModTime); err != nil {
+				return stepResult{}, err
+			}
+			continue
+		}
+		if inst.Command == "ADD" {
+			extracted, err := w.extractArchive(host, dest)
+			if err != nil {
+				return stepResult{}, err
+			}
+			if extracted {
+				continue
+			}
+		}
+		if err := w.copyPath(bd.context, host, dest, toDir); err != nil {
+			return stepResult{}, err
+		}
+	}
+
+	layer, err := w.layer()
+	if err != nil {
+		return stepResult{}, err
+	}
+	return stepResult{layer: &layer, createdBy: inst.Original, checksum: w.checksum()}, nil
+}
+
+// copySource returns the filesystem COPY --from reads: that of a stage
+// built earlier or of an image
+func (bd *build) copySource(inst *data.Instruction) (*snapshot, error) {
+	dep, name, err := bd.copyFrom(inst)
+	if err != nil {
+		return nil, err
+	}
+	if dep != nil {
+		if dep.fs == nil {
+			if dep.fs, err = readSnapshot(dep.layers); err != nil {
+				return nil, err
+			}
+		}
+		return dep.fs, nil
+	}
+
+	ref, err := reference.ParseNormalizedTagged(name)
+	if err != nil {
+		return nil, fmt.Errorf("Dockerfile:%d: invalid from flag value %s: %v", inst.StartLine, name, err)
+	}
+	base, err := bd.resolveImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	if base.fs == nil {
+		layers, err := bd.store.ImageLayers(base.image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %v", ref.String(), err)
+		}
+		if base.fs, err = readSnapshot(layers); err != nil {
+			return nil, err
+		}
+	}
+	return base.fs, nil
+}
+
+// heredocMarker matches a here-document used as a COPY or ADD source
+var heredocMarker = regexp.MustCompile(`^<<-?["']?([A-Za-z_][A-Za-z0-9_]*)["']?$`)
+
+// copyHeredoc writes a here-document as a file
+func (bd *build) copyHeredoc(s *stage, w *layerWriter, h data.Heredoc, dest string, toDir bool) error {
+	content := h.Content
+	if h.Expand {
+		expanded, err := bd.lex.expandHeredoc(content, s.lookup)
+		if err != nil {
+			return err
+		}
+		content = expanded
+	}
+	if toDir {
+		dest = path.Join(dest, h.Name)
+	}
+	return w.file(dest, []byte(content), 0644, synthesizedModTime)
+}
+
+// synthesizedModTime is the modification time of the files and directories
+// a build makes up itself, so their layers do not depend on when it ran
+var synthesizedModTime = time.Unix(0, 0).UTC()
+
+// isURL reports whether an ADD source is fetched over the network
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "git@")
+}
+
+// export records the image of the final stage
+func (bd *build) export(s *stage) (*data.Image, error) {
+	v := bd.p.vertex("exporting to image")
+	v.status("exporting layers done")
+	// The image is as old as its last step, so rebuilding from the cache
+	// gives the same image
+	created := bd.now
+	if n := len(s.config.History); n > 0 && s.config.History[n-1].Created != nil {
+		created = *s.config.History[n-1].Created
+	}
+	s.config.Created = &created
+	if len(bd.opts.Labels) > 0 && s.config.Config.Labels == nil {
+		s.config.Config.Labels = make(map[string]string)
+	}
+	for key, value := range bd.opts.Labels {
+		s.config.Config.Labels[key] = value
+	}
+	img, err := bd.store.BuildImage(s.config, s.layers, bd.opts.Tags...)
+	if err != nil {
+		v.fail(err)
+		return nil, err
+	}
+	v.status("writing image %s%s done", data.DigestPrefix, img.ID)
+	for _, ref := range bd.opts.Tags {
+		v.status("naming to %s done", ref.String())
+	}
+	v.done()
+	return img, nil
+}
+
+// builtinArgs may be given as build args without a matching ARG
+var builtinArgs = map[string]bool{
+	"HTTP_PROXY": true, "http_proxy": true,
+	"HTTPS_PROXY": true, "https_proxy": true,
+	"FTP_PROXY": true, "ftp_proxy": true,
+	"NO_PROXY": true, "no_proxy": true,
+	"ALL_PROXY": true, "all_proxy": true,
+}
+
+// warnUnusedArgs reports build args no ARG instruction consumed
+func (bd *build) warnUnusedArgs() {
+	var unused []string
+	for name := range bd.opts.BuildArgs {
+		if _, global := bd.metaArgs[name]; !bd.usedArgs[name] && !global && !builtinArgs[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) == 0 {
+		return
+	}
+	sort.Strings(unused)
+	fmt.Fprintf(bd.opts.Out, "[Warning] One or more build-args [%s] were not consumed\n", strings.Join(unused, " "))
+}
+
+// setEnv sets key in env, replacing a previous value
+func setEnv(env []string, key, value string) []string {
+	for i, entry := range env {
+		if k, _, _ := strings.Cut(entry, "="); k == key {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
@@ -0,0 +1,230 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
+)
+
+// localBuild builds a context with a local builder whose store and cache
+// are kept in memory
+type localBuild struct {
+	t     *testing.T
+	store *countingStore
+	b     *Local
+}
+
+func newLocalBuild(t *testing.T) *localBuild {
+	store := newTestStore(t)
+	return &localBuild{t: t, store: store, b: NewLocal(store, data.NewBuildCache(data.NewMemoryStore()))}
+}
+
+// build builds dir, returning the image, its config and files, and the
+// progress output
+func (lb *localBuild) build(opts Options) (*data.Image, oci.Image, map[string]string, string) {
+	lb.t.Helper()
+	var out strings.Builder
+	opts.Out = &out
+	img, err := lb.b.Build(opts)
+	if err != nil {
+		lb.t.Fatalf("Build() error = %v\n%s", err, out.String())
+	}
+	content, err := lb.store.ImageContent(img)
+	if err != nil {
+		lb.t.Fatal(err)
+	}
+	layers, err := lb.store.ImageLayers(img)
+	if err != nil {
+		lb.t.Fatal(err)
+	}
+	fs, err := readSnapshot(layers)
+	if err != nil {
+		lb.t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for p, entry := range fs.entries {
+		if entry.hdr.Typeflag != '5' {
+			files[p] = string(entry.content)
+		}
+	}
+	return img, content.Config, files, out.String()
+}
+
+func TestLocalBuild(t *testing.T) {
+	lb := newLocalBuild(t)
+	dir := writeContext(t, map[string]string{
+		"Dockerfile": "FROM scratch\n" +
+			"ARG VERSION=1.0\n" +
+			"ENV APP_VERSION=$VERSION HOME=/app\n" +
+			"WORKDIR $HOME\n" +
+			"COPY src/ ./\n" +
+			"COPY <<EOF /etc/motd\nversion $APP_VERSION\nEOF\n" +
+			"LABEL org.opencontainers.image.version=$VERSION\n" +
+			"EXPOSE 8080/tcp\n" +
+			"USER 1000\n" +
+			"ENTRYPOINT [\"/app/server\"]\n" +
+			"CMD serve --port 8080\n",
+		"src/server":      "#!/bin/sh\n",
+		"src/conf/a.yaml": "a: 1\n",
+		"notes.txt":       "not copied\n",
+	})
+	img, config, files, _ := lb.build(Options{
+		ContextDir: dir,
+		Tags:       []reference.Reference{mustParse(t, "app:1.0")},
+		BuildArgs:  map[string]string{"VERSION": "2.0"},
+		Labels:     map[string]string{"team": "core"},
+	})
+
+	c := config.Config
+	if strings.Join(c.Env, " ") != data.DefaultPath+" APP_VERSION=2.0 HOME=/app" {
+		t.Errorf("Env = %q", c.Env)
+	}
+	if c.WorkingDir != "/app" || c.User != "1000" {
+		t.Errorf("WorkingDir = %q, User = %q", c.WorkingDir, c.User)
+	}
+	if strings.Join(c.Entrypoint, " ") != "/app/server" || strings.Join(c.Cmd, " ") != "/bin/sh -c serve --port 8080" {
+		t.Errorf("Entrypoint = %q, Cmd = %q", c.Entrypoint, c.Cmd)
+	}
+	if _, ok := c.ExposedPorts["8080/tcp"]; !ok {
+		t.Errorf("ExposedPorts = %v", c.ExposedPorts)
+	}
+	if c.Labels["org.opencontainers.image.version"] != "2.0" || c.Labels["team"] != "core" {
+		t.Errorf("Labels = %v", c.Labels)
+	}
+
+	wantFiles := map[string]string{
+		"/app/server":      "#!/bin/sh\n",
+		"/app/conf/a.yaml": "a: 1\n",
+		"/etc/motd":        "version 2.0\n",
+	}
+	if len(files) != len(wantFiles) {
+		t.Errorf("files = %v, want %v", files, wantFiles)
+	}
+	for p, want := range wantFiles {
+		if files[p] != want {
+			t.Errorf("%s = %q, want %q", p, files[p], want)
+		}
+	}
+
+	var history []string
+	for _, h := range config.History {
+		history = append(history, h.CreatedBy)
+	}
+	wantHistory := []string{
+		"ARG VERSION=1.0 # buildkit",
+		"ENV APP_VERSION=2.0 HOME=/app # buildkit",
+		"WORKDIR /app # buildkit",
+		"COPY src/ ./ # buildkit",
+		"COPY <<EOF /etc/motd # buildkit",
+		"LABEL org.opencontainers.image.version=2.0 # buildkit",
+		"EXPOSE map[8080/tcp:{}] # buildkit",
+		"USER 1000 # buildkit",
+		`ENTRYPOINT ["/app/server"] # buildkit`,
+		`CMD ["/bin/sh" "-c" "serve --port 8080"] # buildkit`,
+	}
+	if strings.Join(history, "\n") != strings.Join(wantHistory, "\n") {
+		t.Errorf("history:\n%s\nwant:\n%s", strings.Join(history, "\n"), strings.Join(wantHistory, "\n"))
+	}
+	if found, err := lb.store.ResolveImage("app:1.0"); err != nil || found.ID != img.ID {
+		t.Errorf("app:1.0 does not resolve to the built image: %v", err)
+	}
+}
+
+func TestLocalBuildIsReproducible(t *testing.T) {
+	lb := newLocalBuild(t)
+	dir := writeContext(t, map[string]string{
+		"Dockerfile": "FROM scratch\nENV A=1\nCOPY . /src/\nCOPY <<EOF /etc/conf\nx\nEOF\nCMD [\"/src/run\"]\n",
+		"run":        "#!/bin/sh\n",
+	})
+	first, _, _, _ := lb.build(Options{ContextDir: dir})
+	second, _, _, out := lb.build(Options{ContextDir: dir})
+	if first.ID != second.ID {
+		t.Errorf("rebuild gave image %s, want %s", second.ID, first.ID)
+	}
+	if strings.Count(out, "CACHED") != 2 {
+		t.Errorf("rebuild output does not show both COPY steps cached:\n%s", out)
+	}
+	if n := len(lb.store.ListImages()); n != 1 {
+		t.Errorf("%d images stored, want 1", n)
+	}
+
+	// Without the cache every step runs again, but files the build writes
+	// itself still end up in the same layers
+	_, config, _, _ := lb.build(Options{ContextDir: dir})
+	third, thirdConfig, _, _ := lb.build(Options{ContextDir: dir, NoCache: true})
+	if strings.Count(strings.Join(layerDigests(t, lb, third), " "), "sha256:") != len(config.RootFS.DiffIDs) ||
+		strings.Join(thirdConfig.RootFS.DiffIDs, " ") != strings.Join(config.RootFS.DiffIDs, " ") {
+		t.Errorf("layers without the cache = %v, want %v", thirdConfig.RootFS.DiffIDs, config.RootFS.DiffIDs)
+	}
+}
+
+// layerDigests returns the diff IDs of the layers of img
+func layerDigests(t *testing.T, lb *localBuild, img *data.Image) []string {
+	t.Helper()
+	layers, err := lb.store.ImageLayers(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests := make([]string, len(layers))
+	for i, layer := range layers {
+		digests[i] = layer.DiffID
+	}
+	return digests
+}
+
+func TestLocalBuildMultiStage(t *testing.T) {
+	lb := newLocalBuild(t)
+	dir := writeContext(t, map[string]string{
+		"Dockerfile": "FROM scratch AS build\nCOPY main.go /src/\nRUN go build -o /out/app /src\n" +
+			"FROM scratch AS unused\nCOPY missing /\n" +
+			"FROM scratch\nCOPY --from=build /src/main.go /bin/\n",
+		"main.go": "package main\n",
+	})
+	_, config, files, out := lb.build(Options{ContextDir: dir})
+	if len(files) != 1 || files["/bin/main.go"] != "package main\n" {
+		t.Errorf("files = %v", files)
+	}
+	if strings.Contains(out, "[unused") {
+		t.Errorf("unneeded stage was built:\n%s", out)
+	}
+	if len(config.History) != 1 {
+		t.Errorf("history of the final stage = %+v", config.History)
+	}
+
+	_, _, files, _ = lb.build(Options{ContextDir: dir, Target: "build"})
+	if len(files) != 1 || files["/src/main.go"] == "" {
+		t.Errorf("files of the build target = %v", files)
+	}
+}
+
+func TestLocalBuildErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		opts       Options
+		wantErr    string
+	}{
+		{"missing source", "FROM scratch\nCOPY nothing /\n", Options{}, `"/nothing": not found`},
+		{"parse error", "FROM scratch\nBOGUS x\n", Options{}, "unknown instruction: BOGUS"},
+		{"unknown target", "FROM scratch AS a\n", Options{Target: "b"}, `target stage "b" could not be found`},
+		{"missing in stage", "FROM scratch AS a\nFROM scratch\nCOPY --from=a /x /y\n", Options{}, `"/x": not found`},
+		{"undefined base", "FROM $BASE\n", Options{}, "base name ($BASE) should not be blank"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := newLocalBuild(t)
+			opts := tt.opts
+			opts.ContextDir = writeContext(t, map[string]string{"Dockerfile": tt.dockerfile})
+			_, err := lb.b.Build(opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Build() error = %v, want %q", err, tt.wantErr)
+			}
+			if lb.store.builds != 0 {
+				t.Errorf("a failed build recorded an image")
+			}
+		})
+	}
+}
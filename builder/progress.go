@@ -0,0 +1,59 @@
+// builder/progress.go
+package builder
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progress prints build steps the way BuildKit's plain progress output
+// does: each step is a numbered vertex followed by its log lines and its
+// outcome
+type progress struct {
+	out  io.Writer
+	next int
+}
+
+// vertex is one step of the build output
+type vertex struct {
+	p     *progress
+	id    int
+	start time.Time
+}
+
+func newProgress(out io.Writer, builder string) *progress {
+	fmt.Fprintf(out, "#0 building with %q instance using docker driver\n", builder)
+	return &progress{out: out, next: 1}
+}
+
+// vertex starts a new step
+func (p *progress) vertex(format string, args ...interface{}) *vertex {
+	v := &vertex{p: p, id: p.next, start: time.Now()}
+	p.next++
+	fmt.Fprintf(p.out, "\n#%d %s\n", v.id, fmt.Sprintf(format, args...))
+	return v
+}
+
+// log prints a line of the step's output, timestamped from its start
+func (v *vertex) log(format string, args ...interface{}) {
+	fmt.Fprintf(v.p.out, "#%d %.3f %s\n", v.id, time.Since(v.start).Seconds(), fmt.Sprintf(format, args...))
+}
+
+// status prints a status line of the step, such as a transfer
+func (v *vertex) status(format string, args ...interface{}) {
+	fmt.Fprintf(v.p.out, "#%d %s\n", v.id, fmt.Sprintf(format, args...))
+}
+
+func (v *vertex) done() {
+	fmt.Fprintf(v.p.out, "#%d DONE %.1fs\n", v.id, time.Since(v.start).Seconds())
+}
+
+// cached reports a step whose result was reused from the build cache
+func (v *vertex) cached() {
+	fmt.Fprintf(v.p.out, "#%d CACHED\n", v.id)
+}
+
+func (v *vertex) fail(err error) {
+	fmt.Fprintf(v.p.out, "#%d ERROR: %v\n", v.id, err)
+}
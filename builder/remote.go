@@ -0,0 +1,202 @@
+// builder/remote.go
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
+)
+
+// recordRemoteBuild records an image built outside the local store, by
+// GitHub Actions or in a cluster. The config carries no creation time and
+// the layer summarizes the context, so rebuilding an unchanged context
+// yields the same image.
+func recordRemoteBuild(store Store, c *buildContext, labels map[string]string, refs ...reference.Reference) (*data.Image, error) {
+	summary, size, err := contextDigest(c)
+	if err != nil {
+		return nil, fmt.Errorf("error reading build context: %v", err)
+	}
+	layer, err := data.NewLayer(map[string][]byte{".dockermock-context": summary}, time.Unix(0, 0), size)
+	if err != nil {
+		return nil, fmt.Errorf("error reading build context: %v", err)
+	}
+	config := oci.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config:       oci.Config{Env: []string{data.DefaultPath}, Labels: labels},
+		History: []oci.History{{
+			CreatedBy: "COPY . . # buildkit",
+			Comment:   "buildkit.dockerfile.v0",
+		}},
+	}
+	img, err := store.BuildImage(config, []data.Layer{layer}, refs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save image data: %v", err)
+	}
+	return img, nil
+}
+
+// contextDigest summarizes a build context as the sorted list of its files
+// and their sha256 digests, and returns the total size of the files
+func contextDigest(c *buildContext) ([]byte, int64, error) {
+	var buf bytes.Buffer
+	var size int64
+	err := c.walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s %x\n", c.rel(path), sha256.Sum256(content))
+		size += int64(len(content))
+		return nil
+	})
+	return buf.Bytes(), size, err
+}
+
+// contextTarball writes the build context as a gzip-compressed tar, the
+// form remote builders receive it in
+func contextTarball(c *buildContext, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := c.walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := c.rel(path)
+		if rel == "." {
+			return nil
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// copyDir copies the build context into dst, skipping .git directories
+func copyDir(c *buildContext, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return c.walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		relPath := c.rel(path)
+		if relPath == "." {
+			return nil
+		}
+
+		dstPath := filepath.Join(dst, filepath.FromSlash(relPath))
+		if info.IsDir() {
+			return os.MkdirAll(dstPath, info.Mode())
+		}
+		// The directory may be excluded while files inside are not
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dstPath, content, info.Mode())
+	})
+}
+
+// openRemoteContext opens the build context of a remote build. The
+// Dockerfile and .dockerignore are sent even if excluded: the remote
+// builder needs the one and applies the other itself.
+func openRemoteContext(opts Options, dockerfile string) (*buildContext, error) {
+	c, err := openContext(opts.ContextDir, dockerfile, DockerignoreFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading build context: %v", err)
+	}
+	return c, nil
+}
+
+// report prints the size of the context sent to destination, warning if
+// it is unusually large
+func (c *buildContext) report(out io.Writer, destination string) error {
+	_, size, err := c.size()
+	if err != nil {
+		return fmt.Errorf("error reading build context: %v", err)
+	}
+	fmt.Fprintf(out, "Sending build context to %s  %s\n", destination, contextSize(size))
+	if warning := largeContextWarning(size); warning != "" {
+		fmt.Fprintln(out, warning)
+	}
+	return nil
+}
+
+// contextSize renders a context size the way the classic builder does,
+// with four significant digits, e.g. "2.048kB"
+func contextSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	s := float64(size)
+	i := 0
+	for s >= 1000 && i < len(units)-1 {
+		s /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", s, units[i])
+}
+
+// relativeDockerfile returns the path of the Dockerfile inside the context,
+// which remote builders receive as part of it
+func relativeDockerfile(opts Options) (string, error) {
+	if opts.Dockerfile == "" {
+		return "Dockerfile", nil
+	}
+	rel, err := filepath.Rel(opts.ContextDir, opts.Dockerfile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("the Dockerfile %s must be inside the build context for remote builders", opts.Dockerfile)
+	}
+	return filepath.ToSlash(rel), nil
+}
@@ -0,0 +1,300 @@
+// builder/shell.go
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lookupFunc returns the value of a build variable and whether it is set
+type lookupFunc func(name string) (string, bool)
+
+// shellLex processes the words of Dockerfile instructions the way the
+// Dockerfile frontend does: quotes and escapes are removed, and $VAR,
+// ${VAR} and the ${VAR:-word}, ${VAR:+word} and ${VAR:?word} forms (with or
+// without the colon) are substituted. Commands run by RUN, CMD and
+// ENTRYPOINT are left to the shell and not processed.
+type shellLex struct {
+	escape rune
+}
+
+// processWord processes a single word; whitespace is kept
+func (l shellLex) processWord(word string, lookup lookupFunc) (string, error) {
+	s := &wordScanner{runes: []rune(word), escape: l.escape, lookup: lookup}
+	words, err := s.scan(false)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(words, ""), nil
+}
+
+// processWords processes s and splits it into words on unquoted whitespace
+func (l shellLex) processWords(s string, lookup lookupFunc) ([]string, error) {
+	scanner := &wordScanner{runes: []rune(s), escape: l.escape, lookup: lookup}
+	return scanner.scan(true)
+}
+
+// expandHeredoc substitutes variables in the body of a here-document. Quotes
+// have no special meaning there.
+func (l shellLex) expandHeredoc(body string, lookup lookupFunc) (string, error) {
+	s := &wordScanner{runes: []rune(body), escape: l.escape, lookup: lookup, literalQuotes: true}
+	words, err := s.scan(false)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(words, ""), nil
+}
+
+// undefinedVars returns the variables word substitutes that lookup does not
+// define. Substitutions with a default or alternative value do not count.
+func (l shellLex) undefinedVars(word string, lookup lookupFunc) []string {
+	var names []string
+	s := &wordScanner{runes: []rune(word), escape: l.escape, lookup: lookup, undefined: func(name string) {
+		names = append(names, name)
+	}}
+	s.scan(false)
+	return names
+}
+
+// wordScanner walks the runes of the text being processed
+type wordScanner struct {
+	runes         []rune
+	pos           int
+	escape        rune
+	lookup        lookupFunc
+	literalQuotes bool              // quotes are ordinary characters, as in here-documents
+	undefined     func(name string) // called for plain substitutions of unset variables
+}
+
+func (s *wordScanner) peek() (rune, bool) {
+	if s.pos >= len(s.runes) {
+		return 0, false
+	}
+	return s.runes[s.pos], true
+}
+
+// scan processes the whole input, splitting it into words if split is set
+func (s *wordScanner) scan(split bool) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for s.pos < len(s.runes) {
+		r := s.runes[s.pos]
+		switch {
+		case split && (r == ' ' || r == '\t' || r == '\n'):
+			s.pos++
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case r == '\'' && !s.literalQuotes:
+			s.pos++
+			text, err := s.singleQuoted()
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(text)
+		case r == '"' && !s.literalQuotes:
+			s.pos++
+			text, err := s.doubleQuoted()
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(text)
+		case r == s.escape:
+			s.pos++
+			if next, ok := s.peek(); ok {
+				if s.literalQuotes && next != '$' && next != s.escape {
+					// Only escapes of $ are meaningful in here-documents
+					word.WriteRune(r)
+				}
+				word.WriteRune(next)
+				s.pos++
+			}
+		case r == '$':
+			s.pos++
+			text, err := s.variable()
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(text)
+		default:
+			s.pos++
+			word.WriteRune(r)
+		}
+		inWord = true
+	}
+	if inWord || !split {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// singleQuoted reads up to the closing single quote; nothing is special
+// inside
+func (s *wordScanner) singleQuoted() (string, error) {
+	var text strings.Builder
+	for {
+		r, ok := s.peek()
+		if !ok {
+			return "", fmt.Errorf("unexpected end of statement while looking for matching single-quote")
+		}
+		s.pos++
+		if r == '\'' {
+			return text.String(), nil
+		}
+		text.WriteRune(r)
+	}
+}
+
+// doubleQuoted reads up to the closing double quote, substituting
+// variables. The escape character only escapes ", $ and itself.
+func (s *wordScanner) doubleQuoted() (string, error) {
+	var text strings.Builder
+	for {
+		r, ok := s.peek()
+		if !ok {
+			return "", fmt.Errorf("unexpected end of statement while looking for matching double-quote")
+		}
+		s.pos++
+		switch r {
+		case '"':
+			return text.String(), nil
+		case '$':
+			value, err := s.variable()
+			if err != nil {
+				return "", err
+			}
+			text.WriteString(value)
+		case s.escape:
+			next, ok := s.peek()
+			if ok && (next == '"' || next == '$' || next == s.escape) {
+				s.pos++
+				text.WriteRune(next)
+			} else {
+				text.WriteRune(r)
+			}
+		default:
+			text.WriteRune(r)
+		}
+	}
+}
+
+// variable substitutes the variable following a $
+func (s *wordScanner) variable() (string, error) {
+	r, ok := s.peek()
+	if !ok {
+		return "$", nil
+	}
+	if r != '{' {
+		name := s.name()
+		if name == "" {
+			return "$", nil
+		}
+		return s.get(name), nil
+	}
+
+	s.pos++
+	name := s.name()
+	if name == "" {
+		return "", fmt.Errorf("bad substitution: missing variable name")
+	}
+	r, ok = s.peek()
+	if !ok {
+		return "", fmt.Errorf("missing '}' in substitution of %s", name)
+	}
+	if r == '}' {
+		s.pos++
+		return s.get(name), nil
+	}
+
+	colon := r == ':'
+	if colon {
+		s.pos++
+		if r, ok = s.peek(); !ok {
+			return "", fmt.Errorf("missing '}' in substitution of %s", name)
+		}
+	}
+	modifier := r
+	if modifier != '-' && modifier != '+' && modifier != '?' {
+		return "", fmt.Errorf("unsupported modifier (%c) in substitution of %s", modifier, name)
+	}
+	s.pos++
+	raw, err := s.braced()
+	if err != nil {
+		return "", err
+	}
+	word, err := (shellLex{escape: s.escape}).processWord(raw, s.lookup)
+	if err != nil {
+		return "", err
+	}
+
+	value, set := s.lookup(name)
+	// With a colon, an empty value counts as unset
+	present := set && (!colon || value != "")
+	switch modifier {
+	case '-':
+		if !present {
+			return word, nil
+		}
+	case '+':
+		if present {
+			return word, nil
+		}
+		return "", nil
+	case '?':
+		if !present {
+			if word == "" {
+				word = "is not allowed to be unset"
+			}
+			return "", fmt.Errorf("%s: %s", name, word)
+		}
+	}
+	return value, nil
+}
+
+// get returns the value of a variable substituted without a modifier
+func (s *wordScanner) get(name string) string {
+	value, ok := s.lookup(name)
+	if !ok && s.undefined != nil {
+		s.undefined(name)
+	}
+	return value
+}
+
+// name reads a variable name
+func (s *wordScanner) name() string {
+	start := s.pos
+	for s.pos < len(s.runes) {
+		r := s.runes[s.pos]
+		isLetter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !isLetter && (s.pos == start || r < '0' || r > '9') {
+			break
+		}
+		s.pos++
+	}
+	return string(s.runes[start:s.pos])
+}
+
+// braced reads the raw word of a ${name:-word} substitution up to its
+// closing brace, allowing nested substitutions
+func (s *wordScanner) braced() (string, error) {
+	start, depth := s.pos, 0
+	for s.pos < len(s.runes) {
+		r := s.runes[s.pos]
+		s.pos++
+		switch {
+		case r == s.escape:
+			s.pos++
+		case r == '{':
+			depth++
+		case r == '}' && depth == 0:
+			return string(s.runes[start : s.pos-1]), nil
+		case r == '}':
+			depth--
+		}
+	}
+	return "", fmt.Errorf("missing '}' in substitution")
+}
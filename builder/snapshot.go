@@ -0,0 +1,198 @@
+// builder/snapshot.go
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/data"
+)
+
+// whiteoutPrefix marks a file deleted by a layer; whiteoutOpaque hides the
+// whole content of a directory from lower layers
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// snapshot is the filesystem of a stage or image, read back from its
+// layers for COPY --from
+type snapshot struct {
+	entries map[string]*snapshotEntry // by absolute path
+}
+
+// snapshotEntry is a file, directory or symlink of a snapshot
+type snapshotEntry struct {
+	hdr     *tar.Header
+	content []byte
+}
+
+// readSnapshot applies layers, lowest first
+func readSnapshot(layers []data.Layer) (*snapshot, error) {
+	fs := &snapshot{entries: make(map[string]*snapshotEntry)}
+	for _, layer := range layers {
+		if err := fs.apply(layer.Blob); err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %v", layer.DiffID, err)
+		}
+	}
+	return fs, nil
+}
+
+// apply adds the entries of a gzip-compressed layer tar
+func (fs *snapshot) apply(blob []byte) error {
+	gz, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			fs.remove(path.Clean(dir), false)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			fs.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), true)
+			continue
+		}
+
+		entry := &snapshotEntry{hdr: hdr}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if entry.content, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			// Hard links become copies of their target
+			target, ok := fs.entries[path.Clean("/"+hdr.Linkname)]
+			if !ok {
+				continue
+			}
+			copied := *target.hdr
+			copied.Name = hdr.Name
+			entry = &snapshotEntry{hdr: &copied, content: target.content}
+		case tar.TypeDir, tar.TypeSymlink:
+		default:
+			continue
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			fs.remove(name, true)
+		}
+		fs.entries[name] = entry
+	}
+}
+
+// remove deletes the entries below name and, with self, name itself
+func (fs *snapshot) remove(name string, self bool) {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p := range fs.entries {
+		if strings.HasPrefix(p, prefix) || (self && p == name) {
+			delete(fs.entries, p)
+		}
+	}
+}
+
+// isDir reports whether name is a directory, declared or implied by the
+// entries below it
+func (fs *snapshot) isDir(name string) bool {
+	if name == "/" {
+		return true
+	}
+	if entry, ok := fs.entries[name]; ok {
+		return entry.hdr.Typeflag == tar.TypeDir
+	}
+	prefix := name + "/"
+	for p := range fs.entries {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// match expands a COPY --from source against the snapshot, returning
+// absolute paths
+func (fs *snapshot) match(source string) ([]string, error) {
+	name := path.Clean("/" + source)
+	if !strings.ContainsAny(name, "*?[") {
+		if _, ok := fs.entries[name]; !ok && !fs.isDir(name) {
+			return nil, fmt.Errorf("failed to compute cache key: failed to calculate checksum of ref: %q: not found", name)
+		}
+		return []string{name}, nil
+	}
+	if _, err := path.Match(name, ""); err != nil {
+		return nil, fmt.Errorf("invalid source pattern %q: %v", source, err)
+	}
+	var matches []string
+	for p := range fs.entries {
+		if ok, _ := path.Match(name, p); ok {
+			matches = append(matches, p)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// copySnapshot copies the file or directory src of fs to dest in the layer,
+// with the same rules as copyPath. Directories only implied by the files
+// below them get mode 0755 and modTime.
+func (w *layerWriter) copySnapshot(fs *snapshot, src, dest string, toDir bool, modTime time.Time) error {
+	if !fs.isDir(src) {
+		if toDir {
+			dest = path.Join(dest, path.Base(src))
+		}
+		return w.copySnapshotEntry(fs.entries[src], dest)
+	}
+
+	mode := int64(0755)
+	if entry, ok := fs.entries[src]; ok {
+		mode, modTime = entry.hdr.Mode&07777, entry.hdr.ModTime
+	}
+	if err := w.dir(dest, mode, modTime); err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(src, "/") + "/"
+	var names []string
+	for p := range fs.entries {
+		if strings.HasPrefix(p, prefix) {
+			names = append(names, p)
+		}
+	}
+	sort.Strings(names)
+	for _, p := range names {
+		if err := w.copySnapshotEntry(fs.entries[p], path.Join(dest, strings.TrimPrefix(p, prefix))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySnapshotEntry copies one entry of a snapshot
+func (w *layerWriter) copySnapshotEntry(entry *snapshotEntry, dest string) error {
+	hdr := entry.hdr
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return w.dir(dest, hdr.Mode&07777, hdr.ModTime)
+	case tar.TypeSymlink:
+		return w.symlink(dest, hdr.Linkname, hdr.ModTime)
+	}
+	return w.file(dest, entry.content, hdr.Mode&07777, hdr.ModTime)
+}
@@ -0,0 +1,452 @@
+// builder/source.go
+package builder
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/runner"
+)
+
+// Stdin is the PATH argument or -f value of docker build that reads from
+// standard input
+const Stdin = "-"
+
+// LoadedContext is a build context ready for a builder: a directory and the
+// Dockerfile to build
+type LoadedContext struct {
+	Dir        string
+	Dockerfile string
+	temp       []string // directories removed by Remove
+}
+
+// Remove deletes the temporary files a context was loaded into
+func (c *LoadedContext) Remove() {
+	for _, dir := range c.temp {
+		os.RemoveAll(dir)
+	}
+}
+
+// ContextLoader prepares the build context named by the PATH | URL | -
+// argument of docker build the way the docker CLI does before sending it:
+// local directories are used as they are, git repositories are cloned,
+// remote tarballs are downloaded and unpacked, and - reads a tarball or a
+// lone Dockerfile from Stdin.
+type ContextLoader struct {
+	Runner runner.Runner // runs git for repository contexts
+	Client *http.Client  // downloads URL contexts; http.DefaultClient if nil
+	Stdin  io.Reader
+	Out    io.Writer // download progress
+}
+
+// NewContextLoader returns a loader reading stdin and running the git
+// installed on this machine
+func NewContextLoader(stdin io.Reader, out io.Writer) *ContextLoader {
+	return &ContextLoader{Runner: runner.Exec{}, Stdin: stdin, Out: out}
+}
+
+// gitURLPattern matches http(s) URLs of git repositories, with an optional
+// #ref:dir fragment
+var gitURLPattern = regexp.MustCompile(`^https?://.*\.git(?:#.+)?$`)
+
+// IsGitURL reports whether a build context argument names a git repository
+func IsGitURL(spec string) bool {
+	if gitURLPattern.MatchString(spec) {
+		return true
+	}
+	for _, prefix := range []string{"git://", "github.com/", "git@"} {
+		if strings.HasPrefix(spec, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHTTPURL reports whether a build context argument is a URL to download
+func isHTTPURL(spec string) bool {
+	return strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://")
+}
+
+// Load prepares the context spec names. dockerfile is the -f value: empty
+// for the Dockerfile at the root of the context, Stdin to read it from
+// Stdin, or a path, relative to the current directory for local contexts
+// and to the root of the context for the others.
+func (l *ContextLoader) Load(spec, dockerfile string) (*LoadedContext, error) {
+	if spec == Stdin && dockerfile == Stdin {
+		return nil, fmt.Errorf("invalid argument: can't use stdin for both build context and dockerfile")
+	}
+	c := &LoadedContext{}
+	local := false
+	var err error
+	switch {
+	case spec == Stdin:
+		err = l.loadStdin(c)
+	case IsGitURL(spec):
+		err = l.loadGit(c, spec)
+	case isHTTPURL(spec):
+		err = l.loadURL(c, spec)
+	default:
+		local = true
+		err = loadDir(c, spec)
+	}
+	if err == nil {
+		err = l.loadDockerfile(c, dockerfile, local)
+	}
+	if err != nil {
+		c.Remove()
+		return nil, fmt.Errorf("unable to prepare context: %v", err)
+	}
+	return c, nil
+}
+
+// tempDir creates a temporary directory removed with the context
+func (c *LoadedContext) tempDir() (string, error) {
+	dir, err := os.MkdirTemp("", "docker-build-context-")
+	if err != nil {
+		return "", err
+	}
+	c.temp = append(c.temp, dir)
+	return dir, nil
+}
+
+// loadDir uses a local directory as the context
+func loadDir(c *LoadedContext, path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("path %q not found", path)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("context must be a directory: %s", path)
+	}
+	c.Dir = path
+	return nil
+}
+
+// loadStdin reads a tarball context, or a Dockerfile without context, from
+// Stdin
+func (l *ContextLoader) loadStdin(c *LoadedContext) error {
+	if l.Stdin == nil {
+		return fmt.Errorf("no input on stdin")
+	}
+	return l.loadStream(c, l.Stdin, "stdin")
+}
+
+// loadURL downloads a tarball context, or a Dockerfile without context
+func (l *ContextLoader) loadURL(c *LoadedContext, url string) error {
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("unable to download remote context %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unable to download remote context %s: %s", url, resp.Status)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to download remote context %s: %v", url, err)
+	}
+	if l.Out != nil {
+		fmt.Fprintf(l.Out, "Downloading build context from remote url: %s  %s\n", url, contextSize(int64(len(content))))
+	}
+	return l.loadStream(c, bytes.NewReader(content), url)
+}
+
+// loadStream unpacks a context read from r. Anything that is not a
+// (compressed) tarball is taken as a Dockerfile.
+func (l *ContextLoader) loadStream(c *LoadedContext, r io.Reader, source string) error {
+	dir, err := c.tempDir()
+	if err != nil {
+		return err
+	}
+	c.Dir = dir
+
+	stream, err := decompress(bufio.NewReader(r))
+	if err != nil {
+		return fmt.Errorf("failed to read context from %s: %v", source, err)
+	}
+	header, err := stream.Peek(512)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read context from %s: %v", source, err)
+	}
+	if !isTar(header) {
+		content, err := io.ReadAll(stream)
+		if err != nil {
+			return fmt.Errorf("failed to read context from %s: %v", source, err)
+		}
+		return os.WriteFile(filepath.Join(dir, "Dockerfile"), content, 0644)
+	}
+	if err := untar(stream, dir); err != nil {
+		return fmt.Errorf("failed to unpack context from %s: %v", source, err)
+	}
+	return nil
+}
+
+// decompress returns the uncompressed content of a gzip or bzip2 stream,
+// or the stream itself
+func decompress(r *bufio.Reader) (*bufio.Reader, error) {
+	magic, err := r.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return bufio.NewReader(gz), nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bufio.NewReader(bzip2.NewReader(r)), nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return nil, fmt.Errorf("xz compressed contexts are not supported")
+	}
+	return r, nil
+}
+
+// isTar reports whether header starts a tar archive
+func isTar(header []byte) bool {
+	return len(header) >= 262 && string(header[257:262]) == "ustar"
+}
+
+// untar unpacks a tar archive into dir, refusing entries outside of it
+func untar(r io.Reader, dir string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if rel == "." {
+			continue
+		}
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		target := filepath.Join(root, rel)
+		// Earlier entries may have made symlinks of the directories leading
+		// to target: check them before creating anything through them
+		if !parentsInside(root, rel) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if !insideDir(root, target) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		// An entry replaces whatever an earlier one left at its path, so
+		// nothing is written through an existing symlink
+		if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+		mode := os.FileMode(hdr.Mode & 07777)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tr)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			dest := filepath.FromSlash(hdr.Linkname)
+			if !filepath.IsAbs(dest) {
+				dest = filepath.Join(filepath.Dir(target), dest)
+			}
+			if !within(root, filepath.Clean(dest)) {
+				return fmt.Errorf("invalid link in archive: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+			// Links through other links may still lead out once resolved
+			if resolved, err := filepath.EvalSymlinks(target); err == nil && !within(root, resolved) {
+				os.Remove(target)
+				return fmt.Errorf("invalid link in archive: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+		case tar.TypeLink:
+			link := filepath.Join(root, filepath.FromSlash(hdr.Linkname))
+			if !insideDir(root, link) {
+				return fmt.Errorf("invalid link in archive: %s", hdr.Linkname)
+			}
+			if err := os.Link(link, target); err != nil {
+				return err
+			}
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
+
+// parentsInside reports whether the directories leading to rel, as far as
+// they exist below root, stay inside root once symlinks are resolved
+func parentsInside(root, rel string) bool {
+	path := root
+	for _, name := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if name == "." {
+			break
+		}
+		path = filepath.Join(path, name)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			// The rest does not exist yet and is created as plain directories
+			return os.IsNotExist(err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			resolved, err := filepath.EvalSymlinks(path)
+			if err != nil || !within(root, resolved) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// insideDir reports whether the parent directory of path is root or below
+// it once symlinks are resolved
+func insideDir(root, path string) bool {
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	return err == nil && within(root, parent)
+}
+
+// within reports whether the clean path is root or below it
+func within(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// loadGit clones a repository context. A #ref:dir fragment selects the
+// branch, tag or commit to check out and the directory used as context.
+func (l *ContextLoader) loadGit(c *LoadedContext, spec string) error {
+	url, fragment, _ := strings.Cut(spec, "#")
+	ref, subdir, _ := strings.Cut(fragment, ":")
+	if strings.HasPrefix(url, "github.com/") {
+		url = "https://" + url
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	dir, err := c.tempDir()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	git := func(args ...string) error {
+		_, err := runner.Output(ctx, l.Runner, runner.Cmd{Name: "git", Args: args, Dir: dir})
+		return err
+	}
+	err = git("init", "--quiet")
+	if err == nil {
+		err = git("remote", "add", "origin", url)
+	}
+	if err == nil {
+		// Servers without shallow clone support send the whole history
+		if err = git("fetch", "--quiet", "--depth", "1", "origin", ref); err != nil {
+			err = git("fetch", "--quiet", "origin", ref)
+		}
+	}
+	if err == nil {
+		err = git("checkout", "--quiet", "FETCH_HEAD")
+	}
+	if err == nil {
+		err = git("submodule", "update", "--quiet", "--init", "--recursive")
+	}
+	if err != nil {
+		return fmt.Errorf("unable to checkout git repository %s: %v", spec, err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		return err
+	}
+
+	c.Dir = dir
+	if subdir != "" {
+		rel := filepath.Clean(filepath.FromSlash(subdir))
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("subdirectory %s is outside of the repository", subdir)
+		}
+		c.Dir = filepath.Join(dir, rel)
+		if info, err := os.Stat(c.Dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("subdirectory %s not found in git repository %s", subdir, url)
+		}
+	}
+	return nil
+}
+
+// loadDockerfile finds the Dockerfile of a loaded context, reading it from
+// Stdin for -f -. A relative path is relative to the current directory for
+// local contexts and to the context root otherwise.
+func (l *ContextLoader) loadDockerfile(c *LoadedContext, dockerfile string, local bool) error {
+	switch {
+	case dockerfile == Stdin:
+		if l.Stdin == nil {
+			return fmt.Errorf("no input on stdin")
+		}
+		content, err := io.ReadAll(l.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read Dockerfile from stdin: %v", err)
+		}
+		dir, err := c.tempDir()
+		if err != nil {
+			return err
+		}
+		c.Dockerfile = filepath.Join(dir, "Dockerfile")
+		return os.WriteFile(c.Dockerfile, content, 0644)
+	case dockerfile == "":
+		c.Dockerfile = filepath.Join(c.Dir, "Dockerfile")
+		if _, err := os.Lstat(c.Dockerfile); os.IsNotExist(err) {
+			// Like docker, fall back to a lower-case dockerfile
+			if _, err := os.Lstat(filepath.Join(c.Dir, "dockerfile")); err == nil {
+				c.Dockerfile = filepath.Join(c.Dir, "dockerfile")
+			}
+		}
+	case local || filepath.IsAbs(dockerfile):
+		c.Dockerfile = dockerfile
+	default:
+		c.Dockerfile = filepath.Join(c.Dir, dockerfile)
+	}
+	if _, err := os.Stat(c.Dockerfile); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("the Dockerfile %s does not exist", c.Dockerfile)
+		}
+		return err
+	}
+	return nil
+}
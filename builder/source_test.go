@@ -0,0 +1,213 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is one entry of an archive built by makeTar; content makes a
+// regular file, linkname a symlink, hardlink a hard link and dir a directory
+type tarEntry struct {
+	name     string
+	content  string
+	linkname string
+	hardlink string
+	dir      bool
+}
+
+func makeTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		case e.linkname != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.linkname, 0
+		case e.hardlink != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, e.hardlink, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUntarStaysInsideDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func(outside string) []tarEntry
+		wantErr bool
+		check   func(t *testing.T, dir string)
+	}{
+		{
+			name: "file replacing symlink to outside",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{
+					{name: "x", linkname: filepath.Join(outside, "victim")},
+					{name: "x", content: "PWNED"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "file through symlinked directory",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{
+					{name: "d", linkname: outside},
+					{name: "d/victim", content: "PWNED"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "new directory through symlinked directory",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{
+					{name: "d", linkname: outside},
+					{name: "d/sub/f", content: "PWNED"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative symlink leaving the root",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{{name: "x", linkname: "../../../../../../../../../.."}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "symlink leaving through another symlink",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{
+					{name: "d/up", linkname: ".."},
+					{name: "x", linkname: "d/up/.."},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "hard link to outside",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{{name: "x", hardlink: "../victim"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "path leaving the root",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{{name: "../victim", content: "PWNED"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "file replacing symlink inside",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{
+					{name: "y", content: "keep"},
+					{name: "x", linkname: "y"},
+					{name: "x", content: "new"},
+				}
+			},
+			check: func(t *testing.T, dir string) {
+				if got := readFile(t, filepath.Join(dir, "y")); got != "keep" {
+					t.Errorf("y = %q, want %q", got, "keep")
+				}
+				fi, err := os.Lstat(filepath.Join(dir, "x"))
+				if err != nil || !fi.Mode().IsRegular() {
+					t.Fatalf("x is not a regular file: %v %v", fi, err)
+				}
+				if got := readFile(t, filepath.Join(dir, "x")); got != "new" {
+					t.Errorf("x = %q, want %q", got, "new")
+				}
+			},
+		},
+		{
+			name: "links inside the root",
+			entries: func(outside string) []tarEntry {
+				return []tarEntry{
+					{name: "a/", dir: true},
+					{name: "a/f", content: "data"},
+					{name: "b", linkname: "a"},
+					{name: "b/g", content: "more"},
+					{name: "h", hardlink: "a/f"},
+				}
+			},
+			check: func(t *testing.T, dir string) {
+				if got := readFile(t, filepath.Join(dir, "a", "g")); got != "more" {
+					t.Errorf("a/g = %q, want %q", got, "more")
+				}
+				if got := readFile(t, filepath.Join(dir, "h")); got != "data" {
+					t.Errorf("h = %q, want %q", got, "data")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			outside := filepath.Join(base, "outside")
+			dir := filepath.Join(base, "ctx", "root")
+			for _, d := range []string{outside, dir} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			victim := filepath.Join(outside, "victim")
+			if err := os.WriteFile(victim, []byte("original"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := untar(bytes.NewReader(makeTar(t, tt.entries(outside))), dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("untar() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := readFile(t, victim); got != "original" {
+				t.Errorf("file outside the root changed to %q", got)
+			}
+			entries, _ := os.ReadDir(outside)
+			if len(entries) != 1 {
+				t.Errorf("files created outside the root: %v", entries)
+			}
+			if _, err := os.Stat(filepath.Join(base, "ctx", "victim")); err == nil {
+				t.Errorf("file created next to the root")
+			}
+			if tt.check != nil {
+				tt.check(t, dir)
+			}
+		})
+	}
+}
+
+func TestUntarRejectsAbsoluteNames(t *testing.T) {
+	archive := makeTar(t, []tarEntry{{name: "/etc/victim", content: "PWNED"}})
+	err := untar(bytes.NewReader(archive), t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Fatalf("untar() error = %v, want invalid path", err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -4,14 +4,15 @@ package cmd
 import (
 	"bufio"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/builder"
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/reference"
 )
 
 var (
@@ -19,14 +20,34 @@ var (
 	buildNoCache     bool
 	buildPull        bool
 	buildContextPath string
-	buildRepoName    string // New flag to specify repository name
+	buildRepoName    string // GitHub repository the github builder pushes to
+	buildBuilder     string
+	buildTarget      string
+	buildCacheFrom   []string
+	buildFile        string
+	buildArgs        []string
+	buildLabels      []string
+	buildIIDFile     string
+	buildQuiet       bool
+	buildCheck       bool
 )
 
 var buildCmd = &cobra.Command{
 	Use:   "build [OPTIONS] PATH | URL | -",
 	Short: "Build an image from a Dockerfile",
-	Long:  `Build an image from a Dockerfile using GitHub Actions CI/CD.`,
-	Args:  cobra.MaximumNArgs(1),
+	Long: `Build an image from a Dockerfile.
+
+The builder instance selected with --builder, $BUILDX_BUILDER or
+"docker buildx use" runs the build; see "docker buildx ls". The default
+instance builds locally and offline: base images come from the local image
+store, COPY and ADD read from the build context and RUN steps are recorded
+without being executed. The github instance builds in GitHub Actions and
+the kaniko instance in a Kubernetes Job.
+
+The context is a local directory, a git repository URL (with an optional
+#ref:dir fragment), the URL of a tarball or, with -, a tarball or a
+Dockerfile read from standard input.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// Set context path
 		if len(args) > 0 {
@@ -35,308 +56,165 @@ var buildCmd = &cobra.Command{
 			buildContextPath = "."
 		}
 
-		// Validate context path
-		if _, err := os.Stat(buildContextPath); os.IsNotExist(err) {
-			fmt.Printf("Error: build context path '%s' does not exist\n", buildContextPath)
-			os.Exit(1)
-		}
-
-		// Check if Dockerfile exists
-		dockerfilePath := filepath.Join(buildContextPath, "Dockerfile")
-		if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) {
-			fmt.Printf("Error: Dockerfile not found in '%s'\n", buildContextPath)
-			os.Exit(1)
-		}
-
-		// Prompt for image name if tag is not provided
-		imageName := buildTag
-		if imageName == "" {
-			reader := bufio.NewReader(os.Stdin)
-			fmt.Print("Enter image name (e.g., myapp:1.0): ")
-			imageName, _ = reader.ReadString('\n')
-			imageName = strings.TrimSpace(imageName)
+		var out io.Writer = os.Stdout
+		if buildQuiet {
+			out = io.Discard
 		}
 
-		// Split into name and tag
-		nameTag := strings.Split(imageName, ":")
-		name := strings.ToLower(nameTag[0]) // Ensure name is lowercase
-		tag := "latest"
-		if len(nameTag) > 1 {
-			tag = nameTag[1]
+		if buildCheck {
+			runBuildCheck()
+			return
 		}
 
-		// Create temporary directory for GitHub repo
-		tempDir, err := ioutil.TempDir("", "docker-build-")
-		if err != nil {
-			fmt.Printf("Error creating temp directory: %v\n", err)
-			os.Exit(1)
+		// A failed build must not leave the ID of an earlier one behind
+		if buildIIDFile != "" {
+			if err := os.Remove(buildIIDFile); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "ERROR: removing image ID file: %v\n", err)
+				os.Exit(1)
+			}
 		}
-		defer os.RemoveAll(tempDir)
-
-		fmt.Println("Preparing build context...")
 
-		// Check if GitHub CLI is installed
-		_, err = exec.LookPath("gh")
-		if err != nil {
-			fmt.Println("Error: GitHub CLI (gh) not found. Please install it to use the build command")
-			os.Exit(1)
+		name := buildBuilder
+		if name == "" && buildRepoName != "" {
+			// --repo predates --builder and implies GitHub Actions
+			name = "github"
 		}
-
-		// Check if user is logged in to GitHub
-		checkLoginCmd := exec.Command("gh", "auth", "status")
-		err = checkLoginCmd.Run()
+		instance, err := selectBuilder(name)
 		if err != nil {
-			fmt.Println("Error: Not logged in to GitHub. Please run 'gh auth login' first")
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 			os.Exit(1)
 		}
 
-		// Get current user's username (in lowercase)
-		userCmd := exec.Command("gh", "api", "user", "--jq", ".login")
-		userOutput, err := userCmd.Output()
-		if err != nil {
-			fmt.Printf("Error getting GitHub username: %v\n", err)
-			os.Exit(1)
+		imageName := buildTag
+		if imageName == "" && instance.Name == "github" {
+			// Images built in GitHub Actions are pushed, so they need a name
+			reader := bufio.NewReader(os.Stdin)
+			fmt.Print("Enter image name (e.g., myapp:1.0): ")
+			imageName, _ = reader.ReadString('\n')
+			imageName = strings.TrimSpace(imageName)
 		}
-		username := strings.TrimSpace(string(userOutput))
-		username = strings.ToLower(username) // Ensure username is lowercase
-
-		// Generate a unique branch name for this build
-		branchName := fmt.Sprintf("build-%s-%d", strings.ReplaceAll(name, "/", "-"), time.Now().Unix())
-
-		// Use the specified repo or the default one
-		repoName := buildRepoName
-		if repoName == "" {
-			repoName = "docker-builds" // Default repository name
+		var tags []reference.Reference
+		if imageName != "" {
+			ref, err := reference.ParseNormalizedTagged(imageName)
+			if err != nil || ref.Digest != "" {
+				fmt.Fprintf(os.Stderr, "invalid argument %q for \"-t, --tag\" flag: invalid reference format\n", imageName)
+				os.Exit(1)
+			}
+			tags = append(tags, ref)
 		}
-		fullRepoName := fmt.Sprintf("%s/%s", username, repoName)
-		cloneURL := fmt.Sprintf("https://github.com/%s.git", fullRepoName)
-		htmlURL := fmt.Sprintf("https://github.com/%s", fullRepoName)
 
-		// Check if the repository exists, if not create it
-		repoCheckCmd := exec.Command("gh", "repo", "view", fullRepoName, "--json", "name")
-		if err := repoCheckCmd.Run(); err != nil {
-			fmt.Printf("Repository %s does not exist. Creating it...\n", fullRepoName)
-			createCmd := exec.Command("gh", "repo", "create", repoName, "--public", "--description", "Docker build repository")
-			createOutput, err := createCmd.CombinedOutput()
+		var cacheFrom []reference.Reference
+		for _, value := range buildCacheFrom {
+			ref, err := reference.ParseNormalizedTagged(strings.TrimPrefix(value, "type=registry,ref="))
 			if err != nil {
-				fmt.Printf("Error creating GitHub repository: %v\n%s\n", err, string(createOutput))
+				fmt.Fprintf(os.Stderr, "invalid argument %q for \"--cache-from\" flag: invalid reference format\n", value)
 				os.Exit(1)
 			}
-			fmt.Printf("Repository created: %s\n", htmlURL)
-		} else {
-			fmt.Printf("Using existing repository: %s\n", htmlURL)
+			cacheFrom = append(cacheFrom, ref)
 		}
 
-		// Save the Dockerfile and any other necessary files to a temporary location
-		buildContextTempDir, err := ioutil.TempDir("", "build-context-")
+		source, err := builder.NewContextLoader(os.Stdin, out).Load(buildContextPath, buildFile)
 		if err != nil {
-			fmt.Printf("Error creating temp directory for build context: %v\n", err)
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 			os.Exit(1)
 		}
-		defer os.RemoveAll(buildContextTempDir)
-
-		// Copy build context to the temporary storage
-		copyDir(buildContextPath, buildContextTempDir)
-
-		// Clean the temporary directory to ensure it's empty before cloning
-		os.RemoveAll(tempDir)
-		os.MkdirAll(tempDir, 0755)
-
-		// Clone the repository
-		fmt.Println("Cloning repository...")
-		cloneCmd := exec.Command("git", "clone", cloneURL, tempDir)
-		cloneOutput, err := cloneCmd.CombinedOutput()
+		img, err := newBuilder(instance).Build(builder.Options{
+			ContextDir: source.Dir,
+			Dockerfile: source.Dockerfile,
+			Tags:       tags,
+			BuildArgs:  parseBuildArgs(buildArgs),
+			Labels:     parseLabels(buildLabels),
+			Target:     buildTarget,
+			NoCache:    buildNoCache,
+			Pull:       buildPull,
+			CacheFrom:  cacheFrom,
+			Out:        out,
+		})
+		source.Remove()
 		if err != nil {
-			fmt.Printf("Error cloning repository: %v\n%s\n", err, string(cloneOutput))
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 			os.Exit(1)
 		}
 
-		// Create a new branch
-		fmt.Printf("Creating new branch: %s\n", branchName)
-		runCommand(tempDir, "git", "checkout", "-b", branchName)
-
-		// Remove all files except .git directory
-		files, err := ioutil.ReadDir(tempDir)
-		if err != nil {
-			fmt.Printf("Error reading temp directory: %v\n", err)
-			os.Exit(1)
-		}
-		for _, f := range files {
-			if f.Name() != ".git" {
-				os.RemoveAll(filepath.Join(tempDir, f.Name()))
+		id := data.DigestPrefix + img.ID
+		if buildIIDFile != "" {
+			if err := os.WriteFile(buildIIDFile, []byte(id), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: writing image ID file: %v\n", err)
+				os.Exit(1)
 			}
 		}
-
-		// Copy build context from temporary storage to the repo directory
-		fmt.Println("Copying build context to repository...")
-		copyDir(buildContextTempDir, tempDir)
-
-		// Add GitHub Actions workflow file
-		workflowsDir := filepath.Join(tempDir, ".github", "workflows")
-		os.MkdirAll(workflowsDir, 0755)
-		workflowContent := fmt.Sprintf(`name: Docker Build and Push
-
-on:
-  push:
-    branches: [ %s ]
-
-jobs:
-  build:
-    runs-on: ubuntu-latest
-    permissions:
-      contents: read
-      packages: write
-    
-    steps:
-    - name: Checkout code
-      uses: actions/checkout@v4
-    
-    - name: Set up Docker Buildx
-      uses: docker/setup-buildx-action@v3
-      
-    - name: Login to GitHub Container Registry
-      uses: docker/login-action@v3
-      with:
-        registry: ghcr.io
-        username: ${{ github.actor }}
-        password: ${{ secrets.GITHUB_TOKEN }}
-    
-    - name: Get short SHA
-      id: vars
-      run: echo "sha=$(git rev-parse --short HEAD)" >> $GITHUB_OUTPUT
-        
-    - name: Build and push
-      uses: docker/build-push-action@v5
-      with:
-        context: .
-        push: true
-        tags: |
-          ghcr.io/%s/%s:%s
-          ghcr.io/%s/%s:${{ steps.vars.outputs.sha }}
-          
-    - name: Delete branch
-      uses: dawidd6/action-delete-branch@v3
-      with:
-        github_token: ${{ secrets.GITHUB_TOKEN }}
-        branches: %s
-`, branchName, username, name, tag, username, name, branchName)
-
-		err = ioutil.WriteFile(filepath.Join(workflowsDir, "docker-build.yml"), []byte(workflowContent), 0644)
-		if err != nil {
-			fmt.Printf("Error creating workflow file: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Commit changes
-		fmt.Println("Committing changes...")
-		runCommand(tempDir, "git", "add", ".")
-		runCommand(tempDir, "git", "config", "user.email", "dockercli@example.com")
-		runCommand(tempDir, "git", "config", "user.name", "Docker CLI")
-		runCommand(tempDir, "git", "commit", "-m", "Docker build for "+name+":"+tag)
-
-		// Push the branch
-		fmt.Printf("Pushing branch %s to GitHub...\n", branchName)
-		pushCmd := exec.Command("git", "push", "-u", "origin", branchName)
-		pushCmd.Dir = tempDir
-		pushOutput, err := pushCmd.CombinedOutput()
-		if err != nil {
-			fmt.Printf("Error pushing to GitHub: %v\n%s\n", err, string(pushOutput))
-			os.Exit(1)
+		if buildQuiet {
+			fmt.Println(id)
 		}
-
-		fmt.Printf("Build started. Your image is being built in GitHub Actions at: %s/actions\n", htmlURL)
-		fmt.Printf("Branch: %s\n", branchName)
-		fmt.Println("Waiting for build to complete...")
-
-		// Define the full image name for GHCR
-		imageFullName := fmt.Sprintf("ghcr.io/%s/%s", username, name)
-
-		// Simulate waiting for build with a more realistic progress display
-		fmt.Println("\nBuild progress:")
-		steps := []string{
-			"Setting up build environment",
-			"Pulling base images",
-			"Building dependencies",
-			"Running build steps",
-			"Pushing to registry",
-		}
-
-		for i, step := range steps {
-			fmt.Printf("[%d/%d] %s\n", i+1, len(steps), step)
-			// Simulate varying build times for different steps
-			time.Sleep(time.Duration((i+1)*500) * time.Millisecond)
-		}
-
-		fmt.Println("\nBuild completed successfully!")
-		fmt.Println("The build branch will be automatically deleted by the workflow")
-
-		// Add image to local registry
-		img := ImageMgr.BuildImage(imageFullName, tag)
-
-		fmt.Printf("\nSuccessfully built %s:%s (ID: %s)\n", imageFullName, tag, img.ID)
-		fmt.Printf("You can run the image with: docker run %s:%s\n", imageFullName, tag)
 	},
 }
 
-// Copy directory recursively
-func copyDir(src, dst string) error {
-	// Create destination directory if it doesn't exist
-	if err := os.MkdirAll(dst, 0755); err != nil {
-		return err
+// runBuildCheck lints the Dockerfile of the build instead of building it,
+// exiting with status 1 if errors or warnings are found
+func runBuildCheck() {
+	source, err := builder.NewContextLoader(os.Stdin, os.Stdout).Load(buildContextPath, buildFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
 	}
+	content, err := os.ReadFile(source.Dockerfile)
+	source.Remove()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed to read dockerfile: %v\n", err)
+		os.Exit(1)
+	}
+	findings, err := builder.Lint(content, builder.LintOptions{BaseEnv: baseImageEnv})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	printBuildChecks(os.Stdout, filepath.Base(source.Dockerfile), content, findings)
+	if lintFailed(findings) {
+		os.Exit(1)
+	}
+}
 
-	// Walk through source directory
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip .git directory
-		if info.IsDir() && info.Name() == ".git" {
-			return filepath.SkipDir
-		}
-
-		// Get relative path
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-
-		// Skip if this is the source root
-		if relPath == "." {
-			return nil
-		}
-
-		// Create destination path
-		dstPath := filepath.Join(dst, relPath)
-
-		// If it's a directory, create it and continue
-		if info.IsDir() {
-			return os.MkdirAll(dstPath, info.Mode())
-		}
-
-		// Copy file
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			return err
+// parseBuildArgs turns --build-arg values into build args. A bare name
+// takes its value from the environment and is left out if it is unset.
+func parseBuildArgs(values []string) map[string]string {
+	args := make(map[string]string, len(values))
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			if value, ok = os.LookupEnv(key); !ok {
+				continue
+			}
 		}
-		return ioutil.WriteFile(dstPath, data, info.Mode())
-	})
+		args[key] = value
+	}
+	return args
 }
 
-func runCommand(dir string, command string, args ...string) error {
-	cmd := exec.Command(command, args...)
-	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// newBuilder returns the builder running builds on instance
+func newBuilder(instance builder.Instance) builder.Builder {
+	switch instance.Name {
+	case "github":
+		return builder.NewGitHub(ImageMgr, buildRepoName)
+	case "kaniko":
+		return builder.NewKaniko(ImageMgr, namespace)
+	}
+	return builder.NewLocal(ImageMgr, data.NewBuildCache(StateStore))
 }
 
 func init() {
 	rootCmd.AddCommand(buildCmd)
 
 	buildCmd.Flags().StringVarP(&buildTag, "tag", "t", "", "Name and optionally a tag in the format 'name:tag'")
+	buildCmd.Flags().StringVarP(&buildFile, "file", "f", "", "Name of the Dockerfile (default: \"PATH/Dockerfile\")")
+	buildCmd.Flags().StringArrayVar(&buildArgs, "build-arg", nil, "Set build-time variables")
+	buildCmd.Flags().StringArrayVar(&buildLabels, "label", nil, "Set metadata for an image")
+	buildCmd.Flags().StringVar(&buildIIDFile, "iidfile", "", "Write the image ID to the file")
+	buildCmd.Flags().BoolVarP(&buildQuiet, "quiet", "q", false, "Suppress the build output and print image ID on success")
+	buildCmd.Flags().BoolVar(&buildCheck, "check", false, "Check the Dockerfile for common mistakes instead of building")
 	buildCmd.Flags().BoolVar(&buildNoCache, "no-cache", false, "Do not use cache when building the image")
 	buildCmd.Flags().BoolVar(&buildPull, "pull", false, "Always attempt to pull a newer version of the image")
-	buildCmd.Flags().StringVar(&buildRepoName, "repo", "", "Name of the GitHub repository to use for builds")
+	buildCmd.Flags().StringArrayVar(&buildCacheFrom, "cache-from", nil, "Images to consider as cache sources")
+	buildCmd.Flags().StringVar(&buildRepoName, "repo", "", "GitHub repository the github builder pushes to (default \"docker-builds\")")
+	buildCmd.Flags().StringVar(&buildBuilder, "builder", "", "Override the configured builder instance")
+	buildCmd.Flags().StringVar(&buildTarget, "target", "", "Set the target build stage to build")
 }
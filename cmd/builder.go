@@ -0,0 +1,99 @@
+// cmd/builder.go
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
+)
+
+var (
+	builderPruneAll         bool
+	builderPruneForce       bool
+	builderPruneFilters     []string
+	builderPruneKeepStorage string
+)
+
+var builderCmd = &cobra.Command{
+	Use:   "builder",
+	Short: "Manage builds",
+}
+
+var builderPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove build cache",
+	Long: `Remove build cache. Without --all, only cache that no image refers to
+is removed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filters, err := parseFilters(builderPruneFilters, "until")
+		if err != nil {
+			printDaemonError(err)
+			os.Exit(1)
+		}
+		opts := data.CachePruneOptions{All: builderPruneAll}
+		for _, value := range filters["until"] {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				printDaemonError(fmt.Errorf("invalid filter 'until=%s': %v", value, err))
+				os.Exit(1)
+			}
+			opts.Until = d
+		}
+		if builderPruneKeepStorage != "" {
+			if opts.KeepStorage, err = parseBytes(builderPruneKeepStorage); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid argument %q for \"--keep-storage\" flag: %v\n", builderPruneKeepStorage, err)
+				os.Exit(1)
+			}
+		}
+
+		if !builderPruneForce {
+			warning := "WARNING! This will remove all dangling build cache."
+			if builderPruneAll {
+				warning = "WARNING! This will remove all build cache."
+			}
+			fmt.Printf("%s Are you sure you want to continue? [y/N] ", warning)
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+				return
+			}
+		}
+
+		if !opts.All {
+			if opts.InUse, err = ImageMgr.BuildCacheInUse(); err != nil {
+				printDaemonError(err)
+				os.Exit(1)
+			}
+		}
+		removed, err := data.NewBuildCache(StateStore).Prune(opts)
+		if err != nil {
+			printDaemonError(err)
+			os.Exit(1)
+		}
+		var reclaimed int64
+		if len(removed) > 0 {
+			fmt.Println("Deleted build cache objects:")
+			for _, record := range removed {
+				fmt.Println(strings.TrimPrefix(record.Key, data.DigestPrefix)[:25])
+				reclaimed += record.Size
+			}
+			fmt.Println()
+		}
+		fmt.Printf("Total reclaimed space: %s\n", data.HumanSize(reclaimed))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(builderCmd)
+	builderCmd.AddCommand(builderPruneCmd)
+
+	builderPruneCmd.Flags().BoolVarP(&builderPruneAll, "all", "a", false, "Remove all unused build cache, not just dangling ones")
+	builderPruneCmd.Flags().BoolVarP(&builderPruneForce, "force", "f", false, "Do not prompt for confirmation")
+	builderPruneCmd.Flags().StringArrayVar(&builderPruneFilters, "filter", nil, "Provide filter values (e.g. \"until=24h\")")
+	builderPruneCmd.Flags().StringVar(&builderPruneKeepStorage, "keep-storage", "", "Amount of disk space to keep for cache")
+}
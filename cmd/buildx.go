@@ -0,0 +1,85 @@
+// cmd/buildx.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/builder"
+	"prepare.sh/dockermock/data"
+)
+
+// builderEnv selects the builder instance, as in the Docker CLI
+const builderEnv = "BUILDX_BUILDER"
+
+var buildxCmd = &cobra.Command{
+	Use:   "buildx",
+	Short: "Manage builder instances",
+}
+
+var buildxUseCmd = &cobra.Command{
+	Use:   "use NAME",
+	Short: "Set the current builder instance",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		instance, err := builder.LookupInstance(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		if err := data.SetCurrentBuilder(instance.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed to save the current builder: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var buildxLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List builder instances",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		current, err := selectBuilder("")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tDRIVER\tDESCRIPTION")
+		for _, instance := range builder.Instances {
+			name := instance.Name
+			if name == current.Name {
+				name += " *"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, instance.Driver, instance.Description)
+		}
+		tw.Flush()
+	},
+}
+
+// selectBuilder returns the builder instance called name or, if name is
+// empty, the one $BUILDX_BUILDER or `docker buildx use` selected
+func selectBuilder(name string) (builder.Instance, error) {
+	if name == "" {
+		name = os.Getenv(builderEnv)
+	}
+	if name == "" {
+		current, err := data.CurrentBuilder()
+		if err != nil {
+			return builder.Instance{}, err
+		}
+		name = current
+	}
+	if name == "" {
+		name = builder.DefaultInstance
+	}
+	return builder.LookupInstance(name)
+}
+
+func init() {
+	rootCmd.AddCommand(buildxCmd)
+	buildxCmd.AddCommand(buildxUseCmd)
+	buildxCmd.AddCommand(buildxLsCmd)
+}
@@ -0,0 +1,24 @@
+// cmd/create.go
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var createCmd = &cobra.Command{
+	Use:   "create [OPTIONS] IMAGE [COMMAND] [ARG...]",
+	Short: "Create a new container",
+	Long: `Create a new container without starting it. The container is recorded in
+the "created" state; use 'docker start' to run it.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		container := createContainer(args)
+		fmt.Println(container.ID)
+	},
+}
+
+func init() {
+	addContainerFlags(createCmd)
+}
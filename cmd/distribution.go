@@ -0,0 +1,80 @@
+// cmd/distribution.go
+package cmd
+
+import (
+	"fmt"
+
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
+	"prepare.sh/dockermock/registry"
+)
+
+// pushToRegistry uploads the image ref points to to its registry over the
+// distribution API, printing progress the way `docker push` does
+func pushToRegistry(ref reference.Reference) error {
+	if ref.Digest != "" {
+		return fmt.Errorf("cannot push a digest reference")
+	}
+	img, err := ImageMgr.ResolveImage(ref.FamiliarString())
+	if err != nil {
+		return fmt.Errorf("An image does not exist locally with the tag: %s", ref.FamiliarName())
+	}
+
+	fmt.Printf("The push refers to repository [%s]\n", ref.Name())
+	client := registry.NewClient(ref.Domain)
+	if err := client.Ping(); err != nil {
+		return err
+	}
+
+	content, err := ImageMgr.ImageContent(img)
+	if err != nil {
+		return err
+	}
+	digest, size, err := registry.Push(client, ref.Path, ref.Tag, content.RawManifest, ImageMgr.Blob, func(id, status string) {
+		if status != "Preparing" {
+			fmt.Printf("%s: %s\n", id, status)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: digest: %s size: %d\n", ref.Tag, digest, size)
+	return ImageMgr.AddRepoDigest(ref, digest)
+}
+
+// pullFromRegistry downloads ref from its registry over the distribution
+// API, printing progress the way `docker pull` does
+func pullFromRegistry(ref reference.Reference) error {
+	target := ref.Tag
+	if ref.Digest != "" {
+		target = ref.Digest
+	}
+	fmt.Printf("%s: Pulling from %s\n", target, ref.Path)
+
+	client := registry.NewClient(ref.Domain)
+	if err := client.Ping(); err != nil {
+		return err
+	}
+	pulled, err := registry.Pull(client, ref.Path, target, func(id, status string) {
+		fmt.Printf("%s: %s\n", id, status)
+	})
+	if err != nil {
+		if regErr, ok := err.(*registry.Error); ok && regErr.Code == registry.CodeManifestUnknown {
+			return fmt.Errorf("manifest for %s not found: %v", ref.FamiliarString(), err)
+		}
+		return err
+	}
+
+	status := "Downloaded newer image for"
+	if existing, err := ImageMgr.ResolveImage(ref.FamiliarString()); err == nil && data.DigestPrefix+existing.ID == oci.Digest(pulled.Config) {
+		status = "Image is up to date for"
+	}
+	if _, err := ImageMgr.ImportImage(ref, pulled.Digest, pulled.Manifest, pulled.Config, pulled.Layers); err != nil {
+		return err
+	}
+	fmt.Printf("Digest: %s\n", pulled.Digest)
+	fmt.Printf("Status: %s %s\n", status, ref.FamiliarString())
+	fmt.Println(ref.FamiliarString())
+	return nil
+}
@@ -3,6 +3,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -14,14 +15,9 @@ var execCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		containerID := args[0]
 		command := args[1:]
-		container, exists := ContainerMgr.GetContainer(containerID)
-		if !exists {
-			fmt.Printf("No such container: '%s'\n", containerID)
-			return
-		}
-		if container.Status != "running" {
-			fmt.Printf("Cannot exec in container '%s' as it is %s\n", containerID, container.Status)
-			return
+		if _, err := ContainerMgr.CheckExec(containerID); err != nil {
+			printDaemonError(err)
+			os.Exit(1)
 		}
 		fmt.Printf("Executing command '%v' in container '%s'\n", command, containerID)
 	},
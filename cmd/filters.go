@@ -0,0 +1,69 @@
+// cmd/filters.go
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filterArgs holds --filter values keyed by filter name. Values for the same
+// name are OR'ed; different names are AND'ed, as in the Docker API.
+type filterArgs map[string][]string
+
+// parseFilters parses "name=value" --filter flags, rejecting names that are
+// not in allowed
+func parseFilters(values []string, allowed ...string) (filterArgs, error) {
+	filters := filterArgs{}
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad format of filter (expected name=value)")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, a := range allowed {
+			if a == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid filter '%s'", name)
+		}
+		filters[name] = append(filters[name], value)
+	}
+	return filters, nil
+}
+
+// has reports whether any value was given for name
+func (f filterArgs) has(name string) bool {
+	return len(f[name]) > 0
+}
+
+// match reports whether fn accepts any value given for name. A filter that
+// was not given matches everything.
+func (f filterArgs) match(name string, fn func(value string) bool) bool {
+	values := f[name]
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabels reports whether labels satisfy every "label" filter; each value
+// is either a key or key=value
+func (f filterArgs) matchLabels(labels map[string]string) bool {
+	for _, want := range f["label"] {
+		key, value, hasValue := strings.Cut(want, "=")
+		got, ok := labels[key]
+		if !ok || (hasValue && got != value) {
+			return false
+		}
+	}
+	return true
+}
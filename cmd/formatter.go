@@ -0,0 +1,120 @@
+// cmd/formatter.go
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// Format directives understood by --format, as in the Docker CLI
+const (
+	tableFormatKey = "table"
+	jsonFormatKey  = "json"
+)
+
+// templateFuncs are the helpers the Docker CLI exposes to --format templates
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"split": strings.Split,
+	"join":  strings.Join,
+	"title": func(s string) string {
+		if s == "" {
+			return s
+		}
+		return strings.ToUpper(s[:1]) + s[1:]
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"pad": func(s string, left, right int) string {
+		return strings.Repeat(" ", left) + s + strings.Repeat(" ", right)
+	},
+	"truncate": func(s string, n int) string {
+		if len(s) > n {
+			return s[:n]
+		}
+		return s
+	},
+	"println": func(args ...interface{}) string {
+		return fmt.Sprintln(args...)
+	},
+}
+
+// parseFormatTemplate parses a --format template with the Docker helpers.
+// Escaped "\t" and "\n" sequences are expanded so shell users can type them.
+func parseFormatTemplate(format string) (*template.Template, error) {
+	format = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(format)
+	tmpl, err := template.New("").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("template parsing error: %v", err)
+	}
+	return tmpl, nil
+}
+
+// writeFormatted renders rows according to a --format value:
+//
+//   - "" or "table" uses defaultTable
+//   - "table TEMPLATE" prints a header row, then TEMPLATE for each row
+//   - "json" prints each row as a JSON object on its own line
+//   - anything else is a Go template executed once per row
+//
+// Headers for table output come from executing the template against headers,
+// which maps row field names to column titles.
+func writeFormatted(w io.Writer, format, defaultTable string, headers map[string]string, rows []interface{}) error {
+	if format == "" || format == tableFormatKey {
+		format = defaultTable
+	}
+
+	if format == jsonFormatKey {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, row := range rows {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	table := strings.HasPrefix(format, tableFormatKey+" ")
+	if table {
+		format = strings.TrimSpace(format[len(tableFormatKey):])
+	}
+	tmpl, err := parseFormatTemplate(format)
+	if err != nil {
+		return err
+	}
+
+	if !table {
+		for _, row := range rows {
+			if err := tmpl.Execute(w, row); err != nil {
+				return fmt.Errorf("template: %v", err)
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	// Map lookups cannot call methods, so missing header keys render empty
+	if err := tmpl.Option("missingkey=zero").Execute(tw, headers); err != nil {
+		// Templates calling row methods (e.g. .Label "x") cannot render a
+		// header; Docker prints no header in that case either
+		tw = tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	} else {
+		fmt.Fprintln(tw)
+	}
+	for _, row := range rows {
+		if err := tmpl.Execute(tw, row); err != nil {
+			return fmt.Errorf("template: %v", err)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
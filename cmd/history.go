@@ -0,0 +1,138 @@
+// cmd/history.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
+)
+
+var (
+	historyHuman   bool
+	historyQuiet   bool
+	historyNoTrunc bool
+	historyFormat  string
+)
+
+const historyDefaultTable = "table {{.ID}}\t{{.CreatedSince}}\t{{.CreatedBy}}\t{{.Size}}\t{{.Comment}}"
+
+// historyHeaders maps historyRow fields to their column titles
+var historyHeaders = map[string]string{
+	"ID":           "IMAGE",
+	"CreatedSince": "CREATED",
+	"CreatedAt":    "CREATED AT",
+	"CreatedBy":    "CREATED BY",
+	"Size":         "SIZE",
+	"Comment":      "COMMENT",
+}
+
+// historyRow is the --format context for one step of an image
+type historyRow struct {
+	ID           string
+	CreatedSince string
+	CreatedAt    string
+	CreatedBy    string
+	Size         string
+	Comment      string
+}
+
+// missingID stands for the steps that have no image of their own
+const missingID = "<missing>"
+
+var historyCmd = &cobra.Command{
+	Use:   "history [OPTIONS] IMAGE",
+	Short: "Show the history of an image",
+	Long: `Show the history of an image: the steps it was made of, newest first,
+with the layer each step created. Only the last step has an image ID;
+earlier ones show <missing>, as for images built with BuildKit or pulled.`,
+	Example: `  docker history nginx
+  docker history --no-trunc -H=false myapp:1.0
+  docker history --format "{{.CreatedBy}}: {{.Size}}" alpine`,
+	Args: cobra.ExactArgs(1),
+	Run:  runHistory,
+}
+
+var imageHistoryCmd = &cobra.Command{
+	Use:   "history [OPTIONS] IMAGE",
+	Short: "Show the history of an image",
+	Args:  cobra.ExactArgs(1),
+	Run:   runHistory,
+}
+
+// runHistory prints the steps of an image, newest first
+func runHistory(cmd *cobra.Command, args []string) {
+	img, err := ImageMgr.ResolveImage(args[0])
+	if err != nil {
+		printDaemonError(err)
+		os.Exit(1)
+	}
+	entries, err := ImageMgr.ImageHistory(img)
+	if err != nil {
+		printDaemonError(err)
+		os.Exit(1)
+	}
+
+	rows := make([]interface{}, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		id := missingID
+		if i == len(entries)-1 {
+			id = shortID(img.ID)
+			if historyNoTrunc {
+				id = data.DigestPrefix + img.ID
+			}
+		}
+		rows = append(rows, newHistoryRow(id, entries[i]))
+	}
+
+	format := historyFormat
+	if historyQuiet {
+		format = "{{.ID}}"
+	}
+	if err := writeFormatted(os.Stdout, format, historyDefaultTable, historyHeaders, rows); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func newHistoryRow(id string, entry data.HistoryEntry) historyRow {
+	row := historyRow{
+		ID:           id,
+		CreatedSince: "N/A",
+		CreatedAt:    "N/A",
+		CreatedBy:    strings.ReplaceAll(entry.CreatedBy, "\t", " "),
+		Comment:      entry.Comment,
+	}
+	if created := entry.Created; created != nil {
+		row.CreatedAt = created.Local().Format(time.RFC3339)
+		row.CreatedSince = row.CreatedAt
+		if historyHuman {
+			row.CreatedSince = timeAgo(*created)
+		}
+	}
+	if !historyNoTrunc {
+		row.CreatedBy = ellipsis(row.CreatedBy, 45)
+	}
+	if historyHuman {
+		row.Size = data.HumanSize(entry.Size)
+	} else {
+		row.Size = strconv.FormatInt(entry.Size, 10)
+	}
+	return row
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	imageCmd.AddCommand(imageHistoryCmd)
+
+	for _, c := range []*cobra.Command{historyCmd, imageHistoryCmd} {
+		c.Flags().BoolVarP(&historyHuman, "human", "H", true, "Print sizes and dates in human readable format")
+		c.Flags().BoolVarP(&historyQuiet, "quiet", "q", false, "Only show image IDs")
+		c.Flags().BoolVar(&historyNoTrunc, "no-trunc", false, "Don't truncate output")
+		c.Flags().StringVar(&historyFormat, "format", "", "Format output using a custom template: 'table', 'table TEMPLATE', 'json' or a Go template")
+	}
+}
@@ -0,0 +1,16 @@
+// cmd/image.go
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Manage images",
+}
+
+func init() {
+	imageCmd.AddCommand(imageInspectCmd)
+	imageCmd.AddCommand(imageRmCmd)
+}
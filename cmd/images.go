@@ -4,21 +4,289 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"text/tabwriter"
+	"path"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
 )
 
+var (
+	imagesAll     bool
+	imagesQuiet   bool
+	imagesDigests bool
+	imagesNoTrunc bool
+	imagesFilters []string
+	imagesFormat  string
+)
+
+const (
+	imagesDefaultTable = "table {{.Repository}}\t{{.Tag}}\t{{.ID}}\t{{.CreatedSince}}\t{{.Size}}"
+	imagesDigestsTable = "table {{.Repository}}\t{{.Tag}}\t{{.Digest}}\t{{.ID}}\t{{.CreatedSince}}\t{{.Size}}"
+)
+
+// imagesHeaders maps imageRow fields to their column titles
+var imagesHeaders = map[string]string{
+	"ID":           "IMAGE ID",
+	"Repository":   "REPOSITORY",
+	"Tag":          "TAG",
+	"Digest":       "DIGEST",
+	"CreatedSince": "CREATED",
+	"CreatedAt":    "CREATED AT",
+	"Size":         "SIZE",
+	"Containers":   "CONTAINERS",
+	"Labels":       "LABELS",
+}
+
+// imageRow is the --format context for one image
+type imageRow struct {
+	ID           string
+	Repository   string
+	Tag          string
+	Digest       string
+	CreatedSince string
+	CreatedAt    string
+	Size         string
+	Containers   string
+	Labels       string
+
+	labels map[string]string
+}
+
+// Label returns the value of a single image label
+func (r imageRow) Label(name string) string {
+	return r.labels[name]
+}
+
 var imagesCmd = &cobra.Command{
-	Use:   "images",
+	Use:   "images [OPTIONS] [REPOSITORY[:TAG]]",
 	Short: "List images",
+	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		images := ImageMgr.ListImages()
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-		fmt.Fprintln(w, "IMAGE ID\tREPOSITORY\tTAG")
-		for _, img := range images {
-			fmt.Fprintf(w, "%s\t%s\t%s\n", img.ID, img.Name, img.Tag)
+		filters, err := parseFilters(imagesFilters, "dangling", "label", "reference", "before", "since")
+		if err != nil {
+			printDaemonError(err)
+			os.Exit(1)
+		}
+		if len(args) > 0 {
+			filters["reference"] = append(filters["reference"], args[0])
+		}
+
+		entries, err := filterImages(ImageMgr.ListImages(), filters)
+		if err != nil {
+			printDaemonError(err)
+			os.Exit(1)
+		}
+
+		rows := make([]interface{}, 0, len(entries))
+		for _, e := range entries {
+			rows = append(rows, newImageRow(e))
+		}
+
+		format := imagesFormat
+		if imagesQuiet {
+			format = "{{.ID}}"
+			rows = uniqueImageIDs(rows)
+		} else if (format == "" || format == tableFormatKey) && imagesDigests {
+			format = imagesDigestsTable
+		}
+		if err := writeFormatted(os.Stdout, format, imagesDefaultTable, imagesHeaders, rows); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
 		}
-		w.Flush()
 	},
 }
+
+// imageEntry is one row of `docker images`: an image under one of its
+// tags, under a repository it is only known by digest in (with an empty
+// tag), or a dangling image with an empty repository and tag
+type imageEntry struct {
+	img        *data.Image
+	repository string
+	tag        string
+}
+
+// imageEntries expands images into one entry per tag, plus one per
+// repository that has a digest reference but no tag
+func imageEntries(images []*data.Image) []imageEntry {
+	entries := []imageEntry{}
+	for _, img := range images {
+		if img.IsDangling() {
+			entries = append(entries, imageEntry{img: img})
+			continue
+		}
+		tagged := map[string]bool{}
+		for _, repoTag := range img.RepoTags {
+			name, tag := data.SplitRepoTag(repoTag)
+			tagged[name] = true
+			entries = append(entries, imageEntry{img: img, repository: name, tag: tag})
+		}
+		for _, repoDigest := range img.RepoDigests {
+			name := repoDigest[:strings.LastIndex(repoDigest, "@")]
+			if !tagged[name] {
+				tagged[name] = true
+				entries = append(entries, imageEntry{img: img, repository: name})
+			}
+		}
+	}
+	return entries
+}
+
+// filterImages applies images flags and filters and returns one entry per
+// tag, sorted newest first
+func filterImages(all []*data.Image, filters filterArgs) ([]imageEntry, error) {
+	var dangling *bool
+	for _, v := range filters["dangling"] {
+		switch strings.ToLower(v) {
+		case "true", "1":
+			t := true
+			dangling = &t
+		case "false", "0":
+			f := false
+			dangling = &f
+		default:
+			return nil, fmt.Errorf("invalid filter 'dangling=%s'", v)
+		}
+	}
+
+	// Resolve before/since references up front so unknown ones are errors
+	bounds := map[string]*data.Image{}
+	for _, key := range []string{"before", "since"} {
+		for _, ref := range filters[key] {
+			img, err := ImageMgr.ResolveImage(ref)
+			if err != nil {
+				return nil, err
+			}
+			bounds[key] = img
+		}
+	}
+
+	list := []imageEntry{}
+	for _, e := range imageEntries(all) {
+		img := e.img
+		if dangling != nil && img.IsDangling() != *dangling {
+			continue
+		}
+		if !filters.match("reference", func(v string) bool { return matchImageReference(e, v) }) {
+			continue
+		}
+		if !filters.matchLabels(img.Labels) {
+			continue
+		}
+		if b := bounds["before"]; b != nil && !imageOlder(img, b) {
+			continue
+		}
+		if s := bounds["since"]; s != nil && !imageOlder(s, img) {
+			continue
+		}
+		list = append(list, e)
+	}
+	sortImages(list)
+	return list, nil
+}
+
+// matchImageReference matches a reference filter, a glob against the
+// repository or, when it contains a tag, against repository:tag
+func matchImageReference(e imageEntry, pattern string) bool {
+	if e.repository == "" {
+		return false
+	}
+	target := e.repository
+	if i := strings.LastIndex(pattern, ":"); i > strings.LastIndex(pattern, "/") {
+		target = e.repository + ":" + e.tag
+	}
+	ok, _ := path.Match(pattern, target)
+	return ok
+}
+
+// imageOlder reports whether a was created before b
+func imageOlder(a, b *data.Image) bool {
+	if !a.Created.Equal(b.Created) {
+		return a.Created.Before(b.Created)
+	}
+	return a.ID < b.ID
+}
+
+// sortImages orders entries newest first, then by repository and tag
+func sortImages(list []imageEntry) {
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if !a.img.Created.Equal(b.img.Created) {
+			return a.img.Created.After(b.img.Created)
+		}
+		if a.repository != b.repository {
+			return a.repository < b.repository
+		}
+		if a.tag != b.tag {
+			return a.tag < b.tag
+		}
+		return a.img.ID < b.img.ID
+	})
+}
+
+// uniqueImageIDs drops rows repeating an ID, as -q lists each image once
+func uniqueImageIDs(rows []interface{}) []interface{} {
+	seen := map[string]bool{}
+	unique := rows[:0]
+	for _, row := range rows {
+		id := row.(imageRow).ID
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, row)
+		}
+	}
+	return unique
+}
+
+func newImageRow(e imageEntry) imageRow {
+	img := e.img
+	id := shortID(img.ID)
+	if imagesNoTrunc {
+		id = data.DigestPrefix + img.ID
+	}
+
+	repository, tag := e.repository, e.tag
+	if repository == "" {
+		repository = data.NoneTag
+	}
+	if tag == "" {
+		tag = data.NoneTag
+	}
+
+	digest := img.DigestFor(e.repository)
+	if digest == "" {
+		digest = data.NoneTag
+	}
+
+	labels := make([]string, 0, len(img.Labels))
+	for k, v := range img.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+
+	return imageRow{
+		ID:           id,
+		Repository:   repository,
+		Tag:          tag,
+		Digest:       digest,
+		CreatedSince: timeAgo(img.Created),
+		CreatedAt:    img.Created.Local().Format("2006-01-02 15:04:05 -0700 MST"),
+		Size:         data.HumanSize(img.Size),
+		Containers:   "N/A",
+		Labels:       strings.Join(labels, ","),
+		labels:       img.Labels,
+	}
+}
+
+func init() {
+	// Builds keep no intermediate images, so there are none to hide and
+	// --all is only accepted for scripts that pass it
+	imagesCmd.Flags().BoolVarP(&imagesAll, "all", "a", false, "")
+	imagesCmd.Flags().MarkHidden("all")
+	imagesCmd.Flags().BoolVarP(&imagesQuiet, "quiet", "q", false, "Only show image IDs")
+	imagesCmd.Flags().BoolVar(&imagesDigests, "digests", false, "Show digests")
+	imagesCmd.Flags().BoolVar(&imagesNoTrunc, "no-trunc", false, "Don't truncate output")
+	imagesCmd.Flags().StringArrayVarP(&imagesFilters, "filter", "f", []string{}, "Filter output based on conditions provided")
+	imagesCmd.Flags().StringVar(&imagesFormat, "format", "", "Format output using a custom template: 'table', 'table TEMPLATE', 'json' or a Go template")
+}
@@ -0,0 +1,421 @@
+// cmd/inspect.go
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/oci"
+)
+
+var (
+	inspectFormat string
+	inspectType   string
+)
+
+// Object types accepted by --type
+const (
+	inspectTypeContainer = "container"
+	inspectTypeImage     = "image"
+)
+
+// containerJSON mirrors the shape of `docker container inspect`
+type containerJSON struct {
+	Id              string
+	Created         time.Time
+	Path            string
+	Args            []string
+	State           containerStateJSON
+	Image           string
+	Name            string
+	RestartCount    int
+	Driver          string
+	Platform        string
+	HostConfig      hostConfigJSON
+	Config          containerConfigJSON
+	NetworkSettings networkSettingsJSON
+}
+
+type containerStateJSON struct {
+	Status     string
+	Running    bool
+	Paused     bool
+	Restarting bool
+	OOMKilled  bool
+	Dead       bool
+	Pid        int
+	ExitCode   int
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+type portBindingJSON struct {
+	HostIp   string
+	HostPort string
+}
+
+type restartPolicyJSON struct {
+	Name              string
+	MaximumRetryCount int
+}
+
+type hostConfigJSON struct {
+	NetworkMode   string
+	PortBindings  map[string][]portBindingJSON
+	RestartPolicy restartPolicyJSON
+	AutoRemove    bool
+}
+
+type containerConfigJSON struct {
+	Hostname     string
+	Env          []string
+	Cmd          []string
+	Image        string
+	WorkingDir   string
+	Entrypoint   []string
+	Labels       map[string]string
+	ExposedPorts map[string]struct{}
+}
+
+type endpointJSON struct {
+	IPAddress   string
+	Gateway     string
+	IPPrefixLen int
+	MacAddress  string
+}
+
+type networkSettingsJSON struct {
+	Ports       map[string][]portBindingJSON
+	IPAddress   string
+	Gateway     string
+	IPPrefixLen int
+	MacAddress  string
+	Networks    map[string]endpointJSON
+}
+
+// imageJSON mirrors the shape of `docker image inspect`
+type imageJSON struct {
+	Id            string
+	RepoTags      []string
+	RepoDigests   []string
+	Parent        string
+	Comment       string
+	Created       time.Time
+	DockerVersion string
+	Author        string
+	Config        imageConfigJSON
+	Architecture  string
+	Os            string
+	Size          int64
+	RootFS        rootFSJSON
+	Metadata      imageMetadataJSON
+}
+
+type imageConfigJSON struct {
+	Env          []string
+	Cmd          []string
+	Entrypoint   []string
+	WorkingDir   string
+	User         string
+	Labels       map[string]string
+	ExposedPorts map[string]struct{}
+	StopSignal   string `json:",omitempty"`
+}
+
+type rootFSJSON struct {
+	Type   string
+	Layers []string
+}
+
+type imageMetadataJSON struct {
+	LastTagTime time.Time
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [OPTIONS] NAME|ID [NAME|ID...]",
+	Short: "Return low-level information on Docker objects",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if inspectType != "" && inspectType != inspectTypeContainer && inspectType != inspectTypeImage {
+			fmt.Fprintf(os.Stderr, "Error: %q is not a valid value for --type\n", inspectType)
+			os.Exit(1)
+		}
+		runInspect(args, inspectType)
+	},
+}
+
+var containerInspectCmd = &cobra.Command{
+	Use:   "inspect [OPTIONS] CONTAINER [CONTAINER...]",
+	Short: "Display detailed information on one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runInspect(args, inspectTypeContainer)
+	},
+}
+
+var imageInspectCmd = &cobra.Command{
+	Use:   "inspect [OPTIONS] IMAGE [IMAGE...]",
+	Short: "Display detailed information on one or more images",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runInspect(args, inspectTypeImage)
+	},
+}
+
+// runInspect prints every object as a JSON array, or renders --format once
+// per object, and exits non-zero if any reference was not found
+func runInspect(refs []string, objectType string) {
+	var objects []interface{}
+	failed := false
+	for _, ref := range refs {
+		obj, err := inspectObject(ref, objectType)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			failed = true
+			continue
+		}
+		objects = append(objects, obj)
+	}
+
+	if inspectFormat == "" {
+		if objects == nil {
+			objects = []interface{}{}
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(objects); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(buf.Bytes())
+	} else {
+		tmpl, err := parseFormatTemplate(inspectFormat)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		for _, obj := range objects {
+			if err := tmpl.Execute(os.Stdout, obj); err != nil {
+				fmt.Fprintln(os.Stderr, "Error: template:", err)
+				os.Exit(1)
+			}
+			fmt.Println()
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// inspectObject resolves ref as a container and then as an image, unless
+// objectType narrows it to one of them. An ID prefix matching several
+// containers or images is an error rather than a miss.
+func inspectObject(ref, objectType string) (interface{}, error) {
+	if objectType != inspectTypeImage {
+		c, err := ContainerMgr.ResolveContainer(ref)
+		if err == nil {
+			return newContainerJSON(c), nil
+		}
+		if _, ambiguous := err.(*data.AmbiguousIDError); ambiguous || objectType == inspectTypeContainer {
+			return nil, err
+		}
+	}
+	img, err := ImageMgr.ResolveImage(ref)
+	if err == nil {
+		return newImageJSON(img), nil
+	}
+	if _, ambiguous := err.(*data.AmbiguousIDError); ambiguous || objectType == inspectTypeImage {
+		return nil, err
+	}
+	return nil, fmt.Errorf("No such object: %s", ref)
+}
+
+func newContainerJSON(c *data.Container) containerJSON {
+	entrypoint, cmd, image := containerProcess(c)
+	var path string
+	args := []string{}
+	if process := append(entrypoint, cmd...); len(process) > 0 {
+		path, args = process[0], append(args, process[1:]...)
+	}
+
+	// The image provides the environment and ports the container starts with
+	env := []string{data.DefaultPath}
+	workingDir := ""
+	exposed := map[string]struct{}{}
+	if image != nil {
+		env = append([]string{}, image.Config.Env...)
+		workingDir = image.Config.WorkingDir
+		for port := range image.Config.ExposedPorts {
+			exposed[port] = struct{}{}
+		}
+	}
+
+	imageID := ""
+	if c.ImageID != "" {
+		imageID = data.DigestPrefix + c.ImageID
+	} else if img, err := ImageMgr.ResolveImage(c.Image); err == nil {
+		imageID = data.DigestPrefix + img.ID
+	}
+
+	bindings := map[string][]portBindingJSON{}
+	for _, spec := range c.Ports {
+		p := parsePortSpec(spec)
+		key := p.ContainerPort + "/" + p.Proto
+		exposed[key] = struct{}{}
+		if p.HostPort != "" {
+			bindings[key] = append(bindings[key], portBindingJSON{HostIp: p.HostIP, HostPort: p.HostPort})
+		}
+	}
+
+	labels := c.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	container := containerJSON{
+		Id:      c.ID,
+		Created: c.Created,
+		Path:    path,
+		Args:    args,
+		State: containerStateJSON{
+			Status:     c.Status,
+			Running:    c.IsRunning(),
+			Paused:     c.Status == data.StatePaused,
+			Restarting: c.Status == data.StateRestarting,
+			Dead:       c.Status == data.StateDead,
+			ExitCode:   c.ExitCode,
+			StartedAt:  c.StartedAt,
+			FinishedAt: c.FinishedAt,
+		},
+		Image:    imageID,
+		Name:     "/" + c.Name,
+		Driver:   "overlay2",
+		Platform: "linux",
+		HostConfig: hostConfigJSON{
+			NetworkMode:   "default",
+			PortBindings:  bindings,
+			RestartPolicy: restartPolicyJSON{Name: "no"},
+		},
+		Config: containerConfigJSON{
+			Hostname:     shortID(c.ID),
+			Env:          append(env, c.Env...),
+			Cmd:          cmd,
+			Image:        c.Image,
+			WorkingDir:   workingDir,
+			Entrypoint:   entrypoint,
+			Labels:       labels,
+			ExposedPorts: exposed,
+		},
+		NetworkSettings: networkSettingsJSON{
+			Ports:    map[string][]portBindingJSON{},
+			Networks: map[string]endpointJSON{},
+		},
+	}
+
+	// Only live containers are attached to the bridge network
+	if c.IsRunning() {
+		container.State.Pid = pseudoPid(c.ID)
+		endpoint := bridgeEndpoint(c.ID)
+		container.NetworkSettings.Ports = bindings
+		container.NetworkSettings.IPAddress = endpoint.IPAddress
+		container.NetworkSettings.Gateway = endpoint.Gateway
+		container.NetworkSettings.IPPrefixLen = endpoint.IPPrefixLen
+		container.NetworkSettings.MacAddress = endpoint.MacAddress
+		container.NetworkSettings.Networks["bridge"] = endpoint
+	}
+	return container
+}
+
+func newImageJSON(img *data.Image) imageJSON {
+	repoTags := append([]string{}, img.RepoTags...)
+
+	// Images whose content cannot be read still show a minimal config
+	config := oci.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config:       oci.Config{Env: []string{data.DefaultPath}},
+	}
+	if content, err := ImageMgr.ImageContent(img); err == nil {
+		config = content.Config
+	}
+
+	labels := config.Config.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	layers := config.RootFS.DiffIDs
+	if layers == nil {
+		layers = []string{}
+	}
+
+	return imageJSON{
+		Id:          data.DigestPrefix + img.ID,
+		RepoTags:    repoTags,
+		RepoDigests: append([]string{}, img.RepoDigests...),
+		Parent:      parentID(img.Parent),
+		Created:     img.Created,
+		Author:      config.Author,
+		Config: imageConfigJSON{
+			Env:          config.Config.Env,
+			Cmd:          config.Config.Cmd,
+			Entrypoint:   config.Config.Entrypoint,
+			WorkingDir:   config.Config.WorkingDir,
+			User:         config.Config.User,
+			Labels:       labels,
+			ExposedPorts: config.Config.ExposedPorts,
+			StopSignal:   config.Config.StopSignal,
+		},
+		Architecture: config.Architecture,
+		Os:           config.OS,
+		Size:         img.Size,
+		RootFS: rootFSJSON{
+			Type:   "layers",
+			Layers: layers,
+		},
+		Metadata: imageMetadataJSON{LastTagTime: img.Created},
+	}
+}
+
+// parentID renders a parent image ID in its long form
+func parentID(id string) string {
+	if id == "" {
+		return ""
+	}
+	return data.DigestPrefix + id
+}
+
+// bridgeEndpoint derives a stable address on the default bridge network
+func bridgeEndpoint(id string) endpointJSON {
+	sum := sha256.Sum256([]byte(id))
+	host := 2 + int(sum[0])%250
+	return endpointJSON{
+		IPAddress:   fmt.Sprintf("172.17.0.%d", host),
+		Gateway:     "172.17.0.1",
+		IPPrefixLen: 16,
+		MacAddress:  fmt.Sprintf("02:42:ac:11:00:%02x", host),
+	}
+}
+
+// pseudoPid derives a stable host PID for a running container
+func pseudoPid(id string) int {
+	sum := sha256.Sum256([]byte(id))
+	return 1000 + (int(sum[1])<<8|int(sum[2]))%30000
+}
+
+func init() {
+	inspectCmd.Flags().StringVarP(&inspectFormat, "format", "f", "", "Format output using a custom template")
+	inspectCmd.Flags().StringVar(&inspectType, "type", "", "Only inspect objects of the given type (container or image)")
+	for _, c := range []*cobra.Command{containerInspectCmd, imageInspectCmd} {
+		c.Flags().StringVarP(&inspectFormat, "format", "f", "", "Format output using a custom template")
+	}
+}
@@ -0,0 +1,180 @@
+// cmd/lint.go
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/builder"
+	"prepare.sh/dockermock/reference"
+)
+
+var (
+	lintFormat   string
+	lintDisabled []string
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [OPTIONS] [DOCKERFILE | -]",
+	Short: "Check a Dockerfile for common mistakes",
+	Long: `Check a Dockerfile for common mistakes: unpinned base images, apt-get
+without cleanup, ADD for local files, shell-form CMD, deprecated or repeated
+instructions, undefined ARGs and inconsistent casing.
+
+Rules are skipped for the whole Dockerfile with the check parser directive,
+e.g. "# check=skip=LatestTag,AptGetCleanup", and for one instruction with a
+"# lint ignore=RULE[,RULE]" comment above it. The exit status is 1 if an
+error or warning is found.`,
+	Example: `  docker lint
+  docker lint --format json app/Dockerfile
+  docker lint --disable ConsistentInstructionCasing - < Dockerfile`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := "Dockerfile"
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if lintFormat != "" && lintFormat != "text" && lintFormat != jsonFormatKey {
+			fmt.Fprintf(os.Stderr, "invalid argument %q for \"--format\" flag: must be text or json\n", lintFormat)
+			os.Exit(1)
+		}
+		disabled, err := lintRuleIDs(lintDisabled)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		var content []byte
+		if path == builder.Stdin {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed to read dockerfile: %v\n", err)
+			os.Exit(1)
+		}
+		findings, err := builder.Lint(content, builder.LintOptions{Disabled: disabled, BaseEnv: baseImageEnv})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+
+		if lintFormat == jsonFormatKey {
+			type result struct {
+				File string `json:"file"`
+				builder.LintFinding
+			}
+			results := make([]result, 0, len(findings))
+			for _, f := range findings {
+				results = append(results, result{File: path, LintFinding: f})
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			enc.Encode(results)
+		} else {
+			for _, f := range findings {
+				fmt.Printf("%s:%d %s %s: %s\n", path, f.Line, f.Rule, f.Severity, f.Message)
+			}
+		}
+		if lintFailed(findings) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+
+	lintCmd.Flags().StringVar(&lintFormat, "format", "text", "Output format: text or json")
+	lintCmd.Flags().StringArrayVar(&lintDisabled, "disable", nil, "Rule to skip (repeatable)")
+}
+
+// lintRuleIDs checks the rule IDs given to --disable
+func lintRuleIDs(values []string) ([]string, error) {
+	var ids []string
+	for _, value := range values {
+		for _, id := range strings.Split(value, ",") {
+			rule := builder.FindLintRule(strings.TrimSpace(id))
+			if rule == nil {
+				return nil, fmt.Errorf("unknown lint rule %q", id)
+			}
+			ids = append(ids, rule.ID)
+		}
+	}
+	return ids, nil
+}
+
+// lintFailed reports whether findings include errors or warnings
+func lintFailed(findings []builder.LintFinding) bool {
+	for _, f := range findings {
+		if f.Severity != builder.SeverityInfo {
+			return true
+		}
+	}
+	return false
+}
+
+// baseImageEnv returns the environment of a base image stored locally, so
+// lint knows the variables it defines
+func baseImageEnv(ref string) ([]string, bool) {
+	parsed, err := reference.ParseNormalizedTagged(ref)
+	if err != nil {
+		return nil, false
+	}
+	img, err := ImageMgr.ResolveImage(parsed.FamiliarString())
+	if err != nil {
+		return nil, false
+	}
+	content, err := ImageMgr.ImageContent(img)
+	if err != nil {
+		return nil, false
+	}
+	return content.Config.Config.Env, true
+}
+
+// printBuildChecks prints findings the way docker build --check does: each
+// with its rule and the lines of the Dockerfile around it, followed by a
+// summary
+func printBuildChecks(w io.Writer, name string, content []byte, findings []builder.LintFinding) {
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	for _, f := range findings {
+		header := fmt.Sprintf("%s: %s", strings.ToUpper(string(f.Severity)), f.Rule)
+		if f.URL != "" {
+			header += " - " + f.URL
+		}
+		fmt.Fprintln(w, header)
+		fmt.Fprintln(w, f.Message)
+		fmt.Fprintf(w, "%s:%d\n", name, f.Line)
+		fmt.Fprintln(w, "--------------------")
+		first, last := f.Line-2, f.EndLine+2
+		if first < 1 {
+			first = 1
+		}
+		if last > len(lines) {
+			last = len(lines)
+		}
+		for n := first; n <= last; n++ {
+			marker := "    "
+			if n >= f.Line && n <= f.EndLine {
+				marker = ">>> "
+			}
+			fmt.Fprintf(w, "%4d | %s%s\n", n, marker, lines[n-1])
+		}
+		fmt.Fprintln(w, "--------------------")
+		fmt.Fprintln(w)
+	}
+	switch len(findings) {
+	case 0:
+		fmt.Fprintln(w, "Check complete, no warnings found.")
+	case 1:
+		fmt.Fprintln(w, "Check complete, 1 warning has been found!")
+	default:
+		fmt.Fprintf(w, "Check complete, %d warnings have been found!\n", len(findings))
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -79,8 +78,8 @@ var loginCmd = &cobra.Command{
 }
 
 func storeCredentials(server, username, token string) error {
-	configDir := filepath.Join(data.StorageDir, "config")
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+	configDir := data.GetConfigDirPath()
+	if err := os.MkdirAll(configDir, 0700); err != nil {
 		return err
 	}
 
@@ -93,7 +92,7 @@ func storeCredentials(server, username, token string) error {
   }
 }`, server, base64Encode(username+":"+token))
 
-	return os.WriteFile(filepath.Join(configDir, "config.json"), []byte(configContent), 0600)
+	return os.WriteFile(data.GetConfigFilePath(), []byte(configContent), 0600)
 }
 
 func base64Encode(input string) string {
@@ -0,0 +1,49 @@
+// cmd/pause.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause CONTAINER [CONTAINER...]",
+	Short: "Pause all processes within one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, identifier := range args {
+			if _, err := ContainerMgr.PauseContainer(identifier); err != nil {
+				printDaemonError(err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Paused container '%s'\n", identifier)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var unpauseCmd = &cobra.Command{
+	Use:   "unpause CONTAINER [CONTAINER...]",
+	Short: "Unpause all processes within one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, identifier := range args {
+			if _, err := ContainerMgr.UnpauseContainer(identifier); err != nil {
+				printDaemonError(err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Unpaused container '%s'\n", identifier)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
 )
 
 var pruneCmd = &cobra.Command{
@@ -14,26 +15,35 @@ var pruneCmd = &cobra.Command{
 		// For demonstration, we'll clear all stopped containers and dangling images
 		removedContainers := 0
 		for _, c := range ContainerMgr.ListContainers() {
-			if c.Status == "stopped" {
-				if ContainerMgr.RemoveContainer(c.ID) {
+			if !c.IsRunning() {
+				if ContainerMgr.RemoveContainer(c.ID, false) == nil {
 					removedContainers++
 				}
 			}
 		}
 
+		// Images not used by any remaining container are removed
+		used := map[string]bool{}
+		for _, c := range ContainerMgr.ListContainers() {
+			if img, err := ImageMgr.ResolveImage(c.Image); err == nil {
+				used[img.ID] = true
+			}
+		}
+
 		removedImages := 0
 		for _, img := range ImageMgr.ListImages() {
-			// Assuming dangling images have no containers associated (for simplicity)
-			// Here, we'll consider images not used by any container as dangling
-			isUsed := false
-			for _, c := range ContainerMgr.ListContainers() {
-				if c.Image == fmt.Sprintf("%s:%s", img.Name, img.Tag) {
-					isUsed = true
-					break
-				}
+			if used[img.ID] {
+				continue
+			}
+			records, err := ImageMgr.RemoveImage(img.ID, data.RemoveImageOptions{
+				Force:      true,
+				Containers: ContainerMgr.ListContainers(),
+			})
+			if err != nil {
+				continue
 			}
-			if !isUsed {
-				if ImageMgr.RemoveImage(img.ID) {
+			for _, r := range records {
+				if r.Deleted != "" {
 					removedImages++
 				}
 			}
@@ -4,21 +4,359 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"text/tabwriter"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
 )
 
+var (
+	psAll     bool
+	psQuiet   bool
+	psFilters []string
+	psFormat  string
+	psNoTrunc bool
+	psLast    int
+	psLatest  bool
+	psSize    bool
+)
+
+const (
+	psDefaultTable = "table {{.ID}}\t{{.Image}}\t{{.Command}}\t{{.RunningFor}}\t{{.Status}}\t{{.Ports}}\t{{.Names}}"
+	psSizeColumn   = "\t{{.Size}}"
+)
+
+// psHeaders maps containerRow fields to their column titles
+var psHeaders = map[string]string{
+	"ID":         "CONTAINER ID",
+	"Image":      "IMAGE",
+	"Command":    "COMMAND",
+	"CreatedAt":  "CREATED AT",
+	"RunningFor": "CREATED",
+	"Ports":      "PORTS",
+	"State":      "STATE",
+	"Status":     "STATUS",
+	"Size":       "SIZE",
+	"Names":      "NAMES",
+	"Labels":     "LABELS",
+}
+
+// containerRow is the --format context for one container
+type containerRow struct {
+	ID         string
+	Image      string
+	Command    string
+	CreatedAt  string
+	RunningFor string
+	Ports      string
+	State      string
+	Status     string
+	Size       string
+	Names      string
+	Labels     string
+
+	labels map[string]string
+}
+
+// Label returns the value of a single container label
+func (r containerRow) Label(name string) string {
+	return r.labels[name]
+}
+
 var psCmd = &cobra.Command{
-	Use:   "ps",
+	Use:   "ps [OPTIONS]",
 	Short: "List containers",
+	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		containers := ContainerMgr.ListContainers()
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-		fmt.Fprintln(w, "CONTAINER ID\tNAME\tIMAGE\tSTATUS")
+		filters, err := parseFilters(psFilters, "status", "name", "ancestor", "label", "id", "exited", "before", "since")
+		if err != nil {
+			printDaemonError(err)
+			os.Exit(1)
+		}
+
+		containers, err := filterContainers(ContainerMgr.ListContainers(), filters)
+		if err != nil {
+			printDaemonError(err)
+			os.Exit(1)
+		}
+
+		rows := make([]interface{}, 0, len(containers))
 		for _, c := range containers {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.ID, c.Name, c.Image, c.Status)
+			rows = append(rows, newContainerRow(c))
+		}
+
+		format := psFormat
+		if psQuiet {
+			format = "{{.ID}}"
+		} else if format == "" || format == tableFormatKey {
+			format = psDefaultTable
+			if psSize {
+				format += psSizeColumn
+			}
+		}
+		if err := writeFormatted(os.Stdout, format, psDefaultTable, psHeaders, rows); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
 		}
-		w.Flush()
 	},
 }
+
+// filterContainers applies ps flags and filters and sorts newest first
+func filterContainers(all []*data.Container, filters filterArgs) ([]*data.Container, error) {
+	sortContainers(all)
+
+	// Resolve before/since references up front so unknown ones are errors
+	bounds := map[string]*data.Container{}
+	for _, key := range []string{"before", "since"} {
+		for _, ref := range filters[key] {
+			c, exists := ContainerMgr.GetContainer(ref)
+			if !exists {
+				return nil, &data.NoSuchContainerError{Identifier: ref}
+			}
+			bounds[key] = c
+		}
+	}
+
+	for _, v := range filters["exited"] {
+		if _, err := strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid filter 'exited=%s'", v)
+		}
+	}
+
+	// A status filter, --last or --latest look beyond running containers
+	showAll := psAll || filters.has("status") || filters.has("exited") || psLast > 0 || psLatest
+
+	list := []*data.Container{}
+	for _, c := range all {
+		if !showAll && !c.IsRunning() {
+			continue
+		}
+		if !matchContainer(c, filters, bounds) {
+			continue
+		}
+		list = append(list, c)
+	}
+
+	limit := psLast
+	if psLatest {
+		limit = 1
+	}
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// matchContainer reports whether c passes every filter
+func matchContainer(c *data.Container, filters filterArgs, bounds map[string]*data.Container) bool {
+	if !filters.match("status", func(v string) bool { return c.Status == v }) {
+		return false
+	}
+	if !filters.match("name", func(v string) bool { return strings.Contains(c.Name, strings.TrimPrefix(v, "/")) }) {
+		return false
+	}
+	if !filters.match("id", func(v string) bool { return strings.HasPrefix(c.ID, v) }) {
+		return false
+	}
+	if !filters.match("ancestor", func(v string) bool { return matchAncestor(c, v) }) {
+		return false
+	}
+	if !filters.match("exited", func(v string) bool {
+		code, _ := strconv.Atoi(v)
+		return c.Status == data.StateExited && c.ExitCode == code
+	}) {
+		return false
+	}
+	if !filters.matchLabels(c.Labels) {
+		return false
+	}
+	if b := bounds["before"]; b != nil && !containerOlder(c, b) {
+		return false
+	}
+	if s := bounds["since"]; s != nil && !containerOlder(s, c) {
+		return false
+	}
+	return true
+}
+
+// matchAncestor reports whether c was created from the image reference,
+// given as name, name:tag or image ID prefix
+func matchAncestor(c *data.Container, ref string) bool {
+	if c.Image == ref {
+		return true
+	}
+	if want, err := reference.ParseNormalizedTagged(ref); err == nil {
+		if have, err := reference.ParseNormalizedTagged(c.Image); err == nil && have.FamiliarString() == want.FamiliarString() {
+			return true
+		}
+	}
+	img, err := ImageMgr.ResolveImage(ref)
+	if err != nil {
+		return false
+	}
+	if c.ImageID != "" {
+		return c.ImageID == img.ID
+	}
+	created, err := ImageMgr.ResolveImage(c.Image)
+	return err == nil && created.ID == img.ID
+}
+
+// containerOlder reports whether a was created before b
+func containerOlder(a, b *data.Container) bool {
+	if !a.Created.Equal(b.Created) {
+		return a.Created.Before(b.Created)
+	}
+	return a.ID < b.ID
+}
+
+// sortContainers orders containers newest first
+func sortContainers(list []*data.Container) {
+	sort.Slice(list, func(i, j int) bool {
+		return containerOlder(list[j], list[i])
+	})
+}
+
+func newContainerRow(c *data.Container) containerRow {
+	entrypoint, cmd, _ := containerProcess(c)
+	command := strings.Join(append(entrypoint, cmd...), " ")
+	if !psNoTrunc {
+		command = ellipsis(command, 20)
+	}
+
+	labels := make([]string, 0, len(c.Labels))
+	for k, v := range c.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+
+	id := c.ID
+	if !psNoTrunc {
+		id = shortID(id)
+	}
+
+	return containerRow{
+		ID:         id,
+		Image:      c.Image,
+		Command:    strconv.Quote(command),
+		CreatedAt:  c.Created.Local().Format("2006-01-02 15:04:05 -0700 MST"),
+		RunningFor: timeAgo(c.Created),
+		Ports:      formatPorts(c.Ports),
+		State:      c.Status,
+		Status:     containerStatus(c),
+		Size:       containerSize(c),
+		Names:      c.Name,
+		Labels:     strings.Join(labels, ","),
+		labels:     c.Labels,
+	}
+}
+
+// containerProcess returns the entrypoint and command a container runs: the
+// command it was created with, or else the default of its image, whose
+// config is returned if it is still known
+func containerProcess(c *data.Container) (entrypoint, cmd []string, config *oci.Image) {
+	ref := c.ImageID
+	if ref == "" {
+		ref = c.Image
+	}
+	config, err := ImageMgr.ImageConfig(ref)
+	if err != nil {
+		return nil, c.Command, nil
+	}
+	cmd = c.Command
+	if len(cmd) == 0 {
+		cmd = config.Config.Cmd
+	}
+	return append([]string{}, config.Config.Entrypoint...), cmd, config
+}
+
+// containerSize renders the SIZE column: the writable layer, which the mock
+// never grows, and the size of the image underneath it
+func containerSize(c *data.Container) string {
+	img, err := ImageMgr.ResolveImage(c.Image)
+	if err != nil {
+		return "0B"
+	}
+	return fmt.Sprintf("0B (virtual %s)", data.HumanSize(img.Size))
+}
+
+// containerStatus renders the STATUS column, e.g. "Up 5 minutes" or
+// "Exited (0) 2 hours ago"
+func containerStatus(c *data.Container) string {
+	switch c.Status {
+	case data.StateRunning:
+		return "Up " + humanDuration(time.Since(c.StartedAt))
+	case data.StatePaused:
+		return "Up " + humanDuration(time.Since(c.StartedAt)) + " (Paused)"
+	case data.StateRestarting:
+		return fmt.Sprintf("Restarting (%d) %s", c.ExitCode, timeAgo(c.FinishedAt))
+	case data.StateExited:
+		return fmt.Sprintf("Exited (%d) %s", c.ExitCode, timeAgo(c.FinishedAt))
+	case data.StateCreated:
+		return "Created"
+	case data.StateRemoving:
+		return "Removal In Progress"
+	case data.StateDead:
+		return "Dead"
+	}
+	return c.Status
+}
+
+// portSpec is a parsed -p flag: [[HOST_IP:]HOST_PORT:]CONTAINER_PORT[/PROTO]
+type portSpec struct {
+	HostIP        string
+	HostPort      string
+	ContainerPort string
+	Proto         string
+}
+
+// parsePortSpec splits a -p flag value into its parts
+func parsePortSpec(spec string) portSpec {
+	p := portSpec{Proto: "tcp"}
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		spec, p.Proto = spec[:i], spec[i+1:]
+	}
+	parts := strings.Split(spec, ":")
+	p.ContainerPort = parts[len(parts)-1]
+	if len(parts) >= 2 {
+		p.HostPort = parts[len(parts)-2]
+	}
+	if len(parts) > 2 {
+		p.HostIP = strings.Join(parts[:len(parts)-2], ":")
+	}
+	return p
+}
+
+// formatPorts renders -p specs the way the PORTS column does, e.g.
+// "8080:80" becomes "0.0.0.0:8080->80/tcp"
+func formatPorts(specs []string) string {
+	ports := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		p := parsePortSpec(spec)
+		if p.HostPort == "" {
+			ports = append(ports, fmt.Sprintf("%s/%s", p.ContainerPort, p.Proto))
+			continue
+		}
+		hostIP := p.HostIP
+		if hostIP == "" {
+			hostIP = "0.0.0.0"
+		}
+		ports = append(ports, fmt.Sprintf("%s:%s->%s/%s", hostIP, p.HostPort, p.ContainerPort, p.Proto))
+	}
+	return strings.Join(ports, ", ")
+}
+
+func init() {
+	psCmd.Flags().BoolVarP(&psAll, "all", "a", false, "Show all containers (default shows just running)")
+	psCmd.Flags().BoolVarP(&psQuiet, "quiet", "q", false, "Only display container IDs")
+	psCmd.Flags().StringArrayVarP(&psFilters, "filter", "f", []string{}, "Filter output based on conditions provided")
+	psCmd.Flags().StringVar(&psFormat, "format", "", "Format output using a custom template: 'table', 'table TEMPLATE', 'json' or a Go template")
+	psCmd.Flags().BoolVar(&psNoTrunc, "no-trunc", false, "Don't truncate output")
+	psCmd.Flags().IntVarP(&psLast, "last", "n", -1, "Show n last created containers (includes all states)")
+	psCmd.Flags().BoolVarP(&psLatest, "latest", "l", false, "Show the latest created container (includes all states)")
+	psCmd.Flags().BoolVarP(&psSize, "size", "s", false, "Display total file sizes")
+}
@@ -6,12 +6,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/reference"
+	"prepare.sh/dockermock/registry"
 )
 
 var pullCmd = &cobra.Command{
@@ -19,11 +20,20 @@ var pullCmd = &cobra.Command{
 	Short: "Pull an image from a registry",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		image := args[0]
-		name, tag := parseImage(image)
+		ref := mustParseReference(args[0])
+		name := ref.FamiliarName()
+
+		// Registries on localhost are real; others are simulated
+		if registry.IsLocal(ref.Domain) {
+			if err := pullFromRegistry(ref); err != nil {
+				printDaemonError(err)
+				os.Exit(1)
+			}
+			return
+		}
 
 		// Check if this is a ghcr.io image
-		if strings.HasPrefix(name, "ghcr.io/") {
+		if ref.Domain == "ghcr.io" {
 			// Verify authentication
 			if !isAuthenticatedForRegistry("ghcr.io") {
 				fmt.Println("Error: Not authenticated to ghcr.io. Please run 'docker login ghcr.io' first")
@@ -32,30 +42,29 @@ var pullCmd = &cobra.Command{
 			fmt.Printf("Using GitHub authentication for %s\n", name)
 		}
 
-		// Simulate network activity for pulling
-		simulatePull(name, tag)
-
-		// Store image in our local database
-		img := ImageMgr.PullImage(name, tag)
-		fmt.Printf("Successfully pulled image '%s:%s' (ID: %s)\n", img.Name, img.Tag, img.ID)
+		img, err := simulatePull(ref)
+		if err != nil {
+			printDaemonError(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Successfully pulled image '%s' (ID: %s)\n", ref.FamiliarString(), shortID(img.ID))
 	},
 }
 
-func parseImage(image string) (name, tag string) {
-	parts := strings.Split(image, ":")
-	if len(parts) == 2 {
-		name = parts[0]
-		tag = parts[1]
-	} else {
-		name = image
-		tag = "latest"
+// mustParseReference parses an image reference, defaulting the tag to
+// latest, and exits with Docker's message if it is invalid
+func mustParseReference(s string) reference.Reference {
+	ref, err := reference.ParseNormalizedTagged(s)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	return
+	return ref
 }
 
 // Check if we have valid authentication for a registry
 func isAuthenticatedForRegistry(registry string) bool {
-	configPath := filepath.Join(data.StorageDir, "config", "config.json")
+	configPath := data.GetConfigFilePath()
 
 	// Check if config file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
@@ -89,29 +98,44 @@ func isAuthenticatedForRegistry(registry string) bool {
 	return false
 }
 
-// Simulate a real Docker pull with progress indicators
-func simulatePull(name, tag string) {
-	fmt.Printf("Pulling from %s\n", name)
-
-	// Simulate downloading different layers
-	layers := []string{
-		"Pulling fs layer",
-		"Downloading",
-		"Download complete",
-		"Extracting",
-		"Pull complete",
+// simulatePull pulls ref from a simulated registry into the local image
+// store, printing per-layer progress the way `docker pull` does
+func simulatePull(ref reference.Reference) (*data.Image, error) {
+	target := ref.Tag
+	if ref.Digest != "" {
+		target = ref.Digest
 	}
+	fmt.Printf("%s: Pulling from %s\n", target, ref.Path)
 
-	// For each simulated layer
-	for i := 0; i < 3; i++ {
-		layerId := fmt.Sprintf("%x", i*12345) // fake layer ID
-
-		for _, action := range layers {
-			fmt.Printf("%s: %s\n", layerId, action)
-			time.Sleep(100 * time.Millisecond)
+	img, pulled, err := ImageMgr.PullImage(ref, func(layer data.PulledLayer) {
+		id := registry.ShortDigest(layer.Digest)
+		if layer.Exists {
+			fmt.Printf("%s: Already exists\n", id)
+			return
+		}
+		total := data.HumanSize(layer.Size)
+		steps := []string{
+			"Pulling fs layer",
+			fmt.Sprintf("Downloading  %s/%s", data.HumanSize(layer.Size/2), total),
+			"Verifying Checksum",
+			"Download complete",
+			fmt.Sprintf("Extracting  %s/%s", total, total),
+			"Pull complete",
 		}
+		for _, step := range steps {
+			fmt.Printf("%s: %s\n", id, step)
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("Digest: sha256:%x\n", time.Now().UnixNano())
-	fmt.Printf("Status: Downloaded newer image for %s:%s\n", name, tag)
+	status := "Downloaded newer image for"
+	if !pulled {
+		status = "Image is up to date for"
+	}
+	fmt.Printf("Digest: %s\n", img.Digest)
+	fmt.Printf("Status: %s %s\n", status, ref.FamiliarString())
+	return img, nil
 }
@@ -3,8 +3,10 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/registry"
 )
 
 var pushCmd = &cobra.Command{
@@ -12,12 +14,21 @@ var pushCmd = &cobra.Command{
 	Short: "Push an image to a registry",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		image := args[0]
-		name, tag := parseImage(image)
-		if ImageMgr.PushImage(name, tag) {
-			fmt.Printf("Successfully pushed image '%s:%s'\n", name, tag)
+		ref := mustParseReference(args[0])
+
+		// Registries on localhost are real; others are simulated
+		if registry.IsLocal(ref.Domain) {
+			if err := pushToRegistry(ref); err != nil {
+				printDaemonError(err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if ImageMgr.PushImage(ref) {
+			fmt.Printf("Successfully pushed image '%s'\n", ref.FamiliarString())
 		} else {
-			fmt.Printf("Image '%s:%s' not found locally\n", name, tag)
+			fmt.Printf("Image '%s' not found locally\n", ref.FamiliarString())
 		}
 	},
 }
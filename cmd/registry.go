@@ -0,0 +1,71 @@
+// cmd/registry.go
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/registry"
+)
+
+var (
+	registryAddr string
+	registryRoot string
+)
+
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Run a local image registry",
+}
+
+var registryServeCmd = &cobra.Command{
+	Use:   "serve [OPTIONS]",
+	Short: "Serve the OCI distribution API from local disk",
+	Long: `Serve an OCI distribution (v2) registry backed by local disk. Images tagged
+for a registry on localhost, e.g. localhost:5000/app:1.0, are pushed to and
+pulled from it over HTTP.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{skipStateAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		root := registryRoot
+		if root == "" {
+			root = filepath.Join(data.DataRoot(), "registry")
+		}
+		if err := os.MkdirAll(root, 0755); err != nil {
+			fmt.Println("Error creating registry storage:", err)
+			os.Exit(1)
+		}
+
+		handler := registry.NewServer(root)
+		handler.Logger = log.New(os.Stdout, "", log.LstdFlags)
+		server := &http.Server{Addr: registryAddr, Handler: handler}
+
+		// Shut down cleanly on Ctrl+C so in-flight uploads complete
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-stop
+			server.Shutdown(context.Background())
+		}()
+
+		fmt.Printf("Registry listening on %s, storing content in %s\n", registryAddr, root)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	registryServeCmd.Flags().StringVar(&registryAddr, "addr", ":5000", "Address to listen on")
+	registryServeCmd.Flags().StringVar(&registryRoot, "root", "", "Directory to store registry content in (default <data-root>/registry)")
+	registryCmd.AddCommand(registryServeCmd)
+}
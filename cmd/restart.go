@@ -0,0 +1,29 @@
+// cmd/restart.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var restartCmd = &cobra.Command{
+	Use:   "restart [OPTIONS] CONTAINER [CONTAINER...]",
+	Short: "Restart one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, identifier := range args {
+			if _, err := ContainerMgr.RestartContainer(identifier); err != nil {
+				printDaemonError(err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Restarted container '%s'\n", identifier)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
@@ -3,21 +3,33 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 )
 
+var rmForce bool
+
 var rmCmd = &cobra.Command{
-	Use:   "rm [OPTIONS] CONTAINER",
+	Use:   "rm [OPTIONS] CONTAINER [CONTAINER...]",
 	Short: "Remove one or more containers",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
 		for _, identifier := range args {
-			if ContainerMgr.RemoveContainer(identifier) {
-				fmt.Printf("Removed container '%s'\n", identifier)
-			} else {
-				fmt.Printf("No such container: '%s'\n", identifier)
+			if err := ContainerMgr.RemoveContainer(identifier, rmForce); err != nil {
+				printDaemonError(err)
+				failed = true
+				continue
 			}
+			fmt.Printf("Removed container '%s'\n", identifier)
+		}
+		if failed {
+			os.Exit(1)
 		}
 	},
 }
+
+func init() {
+	rmCmd.Flags().BoolVarP(&rmForce, "force", "f", false, "Force the removal of a running container")
+}
@@ -0,0 +1,65 @@
+// cmd/rmi.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
+)
+
+var (
+	rmiForce   bool
+	rmiNoPrune bool
+)
+
+var rmiCmd = &cobra.Command{
+	Use:   "rmi [OPTIONS] IMAGE [IMAGE...]",
+	Short: "Remove one or more images",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   runRmi,
+}
+
+var imageRmCmd = &cobra.Command{
+	Use:     "rm [OPTIONS] IMAGE [IMAGE...]",
+	Aliases: []string{"remove"},
+	Short:   "Remove one or more images",
+	Args:    cobra.MinimumNArgs(1),
+	Run:     runRmi,
+}
+
+// runRmi removes every reference, printing each untag and delete step, and
+// exits non-zero if any of them failed
+func runRmi(cmd *cobra.Command, args []string) {
+	failed := false
+	for _, ref := range args {
+		records, err := ImageMgr.RemoveImage(ref, data.RemoveImageOptions{
+			Force:      rmiForce,
+			NoPrune:    rmiNoPrune,
+			Containers: ContainerMgr.ListContainers(),
+		})
+		if err != nil {
+			printDaemonError(err)
+			failed = true
+			continue
+		}
+		for _, r := range records {
+			if r.Untagged != "" {
+				fmt.Println("Untagged:", r.Untagged)
+			} else {
+				fmt.Println("Deleted:", r.Deleted)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	for _, c := range []*cobra.Command{rmiCmd, imageRmCmd} {
+		c.Flags().BoolVarP(&rmiForce, "force", "f", false, "Force removal of the image")
+		c.Flags().BoolVar(&rmiNoPrune, "no-prune", false, "Do not delete untagged parents")
+	}
+}
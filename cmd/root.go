@@ -11,28 +11,74 @@ import (
 )
 
 var (
+	StateStore   data.Store
 	ContainerMgr *data.ContainerManager
 	ImageMgr     *data.ImageManager
+
+	// Global flags
+	dataRootFlag   string
+	stateStoreFlag string
 )
 
+// skipStateAnnotation marks commands that must run without loading state,
+// such as repairing it
+const skipStateAnnotation = "dockermock/skip-state"
+
 var rootCmd = &cobra.Command{
 	Use:   "docker",
 	Short: "A mock Docker CLI for demonstration purposes",
 	Long:  `This is a Prepare.sh Docker (mock) CLI application that simulates Docker for lab environments.`,
+	// Execute prints errors itself so they are not reported twice
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Resolve the state root before anything touches the filesystem
+		data.SetDataRoot(dataRootFlag)
+		if err := data.EnsureStorageDir(); err != nil {
+			return fmt.Errorf("error creating storage directory: %v", err)
+		}
+
+		kind := stateStoreFlag
+		if kind == "" {
+			kind = os.Getenv(data.StoreEnv)
+		}
+		var err error
+		if StateStore, err = data.OpenStore(kind); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		if cmd.Annotations[skipStateAnnotation] != "" {
+			return nil
+		}
+
+		// Initialize managers; a corrupt state file is fatal, not silently reset
+		if ContainerMgr, err = data.NewContainerManager(StateStore); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		if ImageMgr, err = data.NewImageManager(StateStore); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		return nil
+	},
+}
+
+// printDaemonError reports err the way the Docker CLI reports daemon errors
+func printDaemonError(err error) {
+	fmt.Fprintf(os.Stderr, "Error response from daemon: %v\n", err)
 }
 
 // Execute runs the root command
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
 }
 
 func init() {
-	// Initialize managers
-	ContainerMgr = data.NewContainerManager()
-	ImageMgr = data.NewImageManager()
+	rootCmd.PersistentFlags().StringVar(&dataRootFlag, "data-root", "", "Root directory of persistent state (default $"+data.DataRootEnv+" or $XDG_DATA_HOME/dockermock)")
+	rootCmd.PersistentFlags().StringVar(&stateStoreFlag, "state-store", "", "State backend: json, memory or kv (default $"+data.StoreEnv+" or json)")
 
 	// Add subcommands
 	rootCmd.AddCommand(pullCmd)
@@ -40,10 +86,20 @@ func init() {
 	rootCmd.AddCommand(rmCmd)
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(restartCmd)
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(unpauseCmd)
 	rootCmd.AddCommand(execCmd)
 	rootCmd.AddCommand(psCmd)
 	rootCmd.AddCommand(imagesCmd)
+	rootCmd.AddCommand(rmiCmd)
 	rootCmd.AddCommand(pruneCmd)
 	rootCmd.AddCommand(loginCmd)
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(createCmd)
+	rootCmd.AddCommand(systemCmd)
+	rootCmd.AddCommand(registryCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(containerCmd)
+	rootCmd.AddCommand(imageCmd)
 }
@@ -2,18 +2,19 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"math/rand"
 	"os"
-	"os/exec"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
+	"prepare.sh/dockermock/kube"
+	"prepare.sh/dockermock/reference"
 )
 
 var (
@@ -22,6 +23,7 @@ var (
 	containerName string
 	portMappings  []string
 	envVars       []string
+	labels        []string
 	namespace     = "docker" // Default namespace
 )
 
@@ -30,79 +32,140 @@ var runCmd = &cobra.Command{
 	Short: "Run a command in a new container",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		image := args[0]
-		name, tag := parseImage(image)
-		imageFull := fmt.Sprintf("%s:%s", name, tag)
-
-		// Check if image exists locally or in repository
-		imageExists := false
-		for _, img := range ImageMgr.ListImages() {
-			if (img.Name == name && img.Tag == tag) ||
-				(img.ID == name && (tag == "latest" || img.Tag == tag)) {
-				imageExists = true
-				imageFull = fmt.Sprintf("%s:%s", img.Name, img.Tag)
-				break
-			}
-		}
-		if !imageExists {
-			fmt.Printf("Image '%s' not found. Please pull it first.\n", imageFull)
-			return
-		}
-
-		// Ensure the namespace exists
-		ensureNamespace(namespace)
-
-		// Generate a container name if not provided, ensuring Kubernetes compatibility
-		podName := containerName
-		if podName == "" {
-			podName = generateK8sCompatibleName()
-		} else {
-			// Make sure provided name is k8s compatible
-			podName = makeK8sCompatible(podName)
-		}
-
-		// Prepare command argument
-		command := []string{}
-		if len(args) > 1 {
-			command = args[1:]
-		}
+		container := createContainer(args)
 
 		// Run the container on Kubernetes
-		err := runContainerOnK8s(podName, imageFull, command, portMappings, envVars)
+		err := runContainerOnK8s(container.Name, container.Image, container.Command, container.Ports, container.Env, detached)
 		if err != nil {
+			// Docker keeps no record of a container that never started
+			ContainerMgr.RemoveContainer(container.ID, true)
 			fmt.Printf("Failed to run container: %v\n", err)
-			return
+			os.Exit(1)
 		}
 
-		// Create a record in our local database
-		container := ContainerMgr.CreateContainer(podName, imageFull)
+		if _, err := ContainerMgr.StartContainer(container.ID); err != nil {
+			printDaemonError(err)
+			os.Exit(1)
+		}
 		fmt.Printf("Created and started container '%s' (ID: %s) from image '%s'\n", container.Name, container.ID, container.Image)
 
 		// If not detached, follow logs until Ctrl+C
 		if !detached {
-			// Set up signal handling for Ctrl+C
-			sigCh := make(chan os.Signal, 1)
-			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-
-			go func() {
-				<-sigCh
-				fmt.Println("\nStopping container...")
-				deleteContainerFromK8s(podName)
-				ContainerMgr.RemoveContainer(container.ID)
-				cancel()
-				os.Exit(0)
-			}()
-
-			// Follow logs
-			fmt.Println("Attaching to container. Press Ctrl+C to stop.")
-			followPodLogs(ctx, podName)
+			attachContainer(container.ID, container.Name, func() {
+				ContainerMgr.RemoveContainer(container.ID, true)
+			})
 		}
 	},
 }
 
+// createContainer resolves the image and records a new container from the
+// flags shared by run and create. args are IMAGE [COMMAND...]. It exits
+// with status 1 when the container cannot be created.
+func createContainer(args []string) *data.Container {
+	image := args[0]
+
+	// Check if image exists locally, by reference or ID prefix
+	img, err := ImageMgr.ResolveImage(image)
+	if _, ambiguous := err.(*data.AmbiguousIDError); ambiguous {
+		printDaemonError(err)
+		os.Exit(1)
+	}
+	ref, refErr := reference.ParseNormalizedTagged(image)
+	if err != nil {
+		if refErr != nil {
+			fmt.Fprintln(os.Stderr, refErr)
+		} else {
+			fmt.Printf("Image '%s' not found. Please pull it first.\n", ref.FamiliarString())
+		}
+		os.Exit(1)
+	}
+	// Record the familiar reference, or, like Docker, the reference as
+	// given when it names the image by ID
+	imageFull := image
+	if refErr == nil && img.HasTag(ref.FamiliarString()) {
+		imageFull = ref.FamiliarString()
+	}
+
+	// Generate a container name if not provided, ensuring Kubernetes compatibility
+	podName := containerName
+	if podName == "" {
+		podName = generateK8sCompatibleName()
+	} else {
+		// Make sure provided name is k8s compatible
+		podName = makeK8sCompatible(podName)
+	}
+
+	// Prepare command argument
+	command := []string{}
+	if len(args) > 1 {
+		command = args[1:]
+	}
+
+	// Create a record in our local database
+	container, err := ContainerMgr.CreateContainer(data.ContainerConfig{
+		Name:    podName,
+		Image:   imageFull,
+		ImageID: img.ID,
+		Command: command,
+		Env:     envVars,
+		Ports:   portMappings,
+		Labels:  parseLabels(labels),
+	})
+	if err != nil {
+		printDaemonError(err)
+		os.Exit(1)
+	}
+	return container
+}
+
+// attachContainer follows the container's logs until Ctrl+C, then deletes the
+// pod and runs onStop
+func attachContainer(id, podName string, onStop func()) {
+	// Set up signal handling for Ctrl+C
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-sigCh
+		fmt.Println("\nStopping container...")
+		deleteContainerFromK8s(podName)
+		onStop()
+		cancel()
+		os.Exit(0)
+	}()
+
+	// Follow logs
+	fmt.Println("Attaching to container. Press Ctrl+C to stop.")
+	followPodLogs(ctx, podName)
+}
+
+// addContainerFlags registers the container configuration flags shared by
+// run and create
+func addContainerFlags(cmd *cobra.Command) {
+	// Flags after IMAGE belong to the container command, not to us
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().StringVar(&containerName, "name", "", "Assign a name to the container")
+	cmd.Flags().StringArrayVarP(&portMappings, "publish", "p", []string{}, "Publish a container's port(s) to the host")
+	cmd.Flags().StringArrayVarP(&envVars, "env", "e", []string{}, "Set environment variables")
+	cmd.Flags().StringArrayVarP(&labels, "label", "l", []string{}, "Set meta data on a container")
+}
+
+// parseLabels turns "key=value" (or bare "key") flags into a label map
+func parseLabels(values []string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(values))
+	for _, v := range values {
+		key, value, _ := strings.Cut(v, "=")
+		labels[key] = value
+	}
+	return labels
+}
+
 // Generate a Kubernetes-compatible container name
 func generateK8sCompatibleName() string {
 	adjectives := []string{"bold", "brave", "calm", "eager", "fierce", "gentle", "happy", "jolly", "kind", "lively"}
@@ -152,23 +215,27 @@ func makeK8sCompatible(name string) string {
 	return s
 }
 
-// Make sure the namespace exists on the K8s cluster
-func ensureNamespace(ns string) {
-	cmd := exec.Command("kubectl", "get", "namespace", ns)
-	err := cmd.Run()
+// cluster runs containers on the Kubernetes cluster kubectl points to
+var cluster = kube.New(namespace)
 
-	if err != nil {
-		fmt.Printf("Creating namespace '%s'\n", ns)
-		createCmd := exec.Command("kubectl", "create", "namespace", ns)
-		if err := createCmd.Run(); err != nil {
-			fmt.Printf("Warning: Failed to create namespace: %v\n", err)
-		}
+// Make sure the namespace exists on the K8s cluster
+func ensureNamespace() {
+	ctx := context.Background()
+	if cluster.HasNamespace(ctx) {
+		return
+	}
+	fmt.Printf("Creating namespace '%s'\n", cluster.Namespace)
+	if err := cluster.CreateNamespace(ctx); err != nil {
+		fmt.Printf("Warning: Failed to create namespace: %v\n", err)
 	}
 }
 
 // Run a container on Kubernetes
-func runContainerOnK8s(name, image string, command, ports, env []string) error {
-	args := []string{"run", name, "--image", image, "-n", namespace}
+func runContainerOnK8s(name, image string, command, ports, env []string, detach bool) error {
+	// Ensure the namespace exists
+	ensureNamespace()
+
+	args := []string{"run", name, "--image", image}
 
 	// Add port mappings
 	for _, port := range ports {
@@ -180,26 +247,22 @@ func runContainerOnK8s(name, image string, command, ports, env []string) error {
 		args = append(args, "--env", envVar)
 	}
 
-	// Add command if specified
-	if len(command) > 0 {
-		args = append(args, "--command", "--")
-		args = append(args, command...)
-	}
-
 	// If detached, add --restart=Always
-	if detached {
+	if detach {
 		args = append(args, "--restart=Always")
 	} else {
 		// For interactive sessions
 		args = append(args, "--restart=Never")
 	}
 
-	fmt.Printf("Starting container with: kubectl %s\n", strings.Join(args, " "))
-	cmd := exec.Command("kubectl", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Add command if specified; everything after "--" is passed to the container
+	if len(command) > 0 {
+		args = append(args, "--command", "--")
+		args = append(args, command...)
+	}
 
-	return cmd.Run()
+	fmt.Printf("Starting container with: kubectl -n %s %s\n", cluster.Namespace, strings.Join(args, " "))
+	return cluster.Run(context.Background(), nil, os.Stdout, os.Stderr, args...)
 }
 
 // Follow pod logs in real-time
@@ -207,20 +270,13 @@ func followPodLogs(ctx context.Context, podName string) {
 	// Wait a moment for the pod to start
 	time.Sleep(2 * time.Second)
 
-	cmd := exec.CommandContext(ctx, "kubectl", "logs", "-f", podName, "-n", namespace)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run() // Ignore errors as the context might be canceled
+	cluster.Run(ctx, nil, os.Stdout, os.Stderr, "logs", "-f", podName) // Ignore errors as the context might be canceled
 }
 
 // Delete a container from K8s
 func deleteContainerFromK8s(name string) {
-	cmd := exec.Command("kubectl", "delete", "pod", name, "-n", namespace)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("Warning: Failed to delete pod: %v\n%s\n", err, stderr.String())
+	if err := cluster.Delete(context.Background(), "pod", name); err != nil {
+		fmt.Printf("Warning: Failed to delete pod: %v\n", err)
 	} else {
 		fmt.Printf("Container '%s' deleted\n", name)
 	}
@@ -229,7 +285,5 @@ func deleteContainerFromK8s(name string) {
 func init() {
 	// Add flags
 	runCmd.Flags().BoolVarP(&detached, "detach", "d", false, "Run container in background")
-	runCmd.Flags().StringVar(&containerName, "name", "", "Assign a name to the container")
-	runCmd.Flags().StringArrayVarP(&portMappings, "publish", "p", []string{}, "Publish a container's port(s) to the host")
-	runCmd.Flags().StringArrayVarP(&envVars, "env", "e", []string{}, "Set environment variables")
+	addContainerFlags(runCmd)
 }
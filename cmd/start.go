@@ -3,21 +3,56 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 )
 
+var startAttach bool
+
 var startCmd = &cobra.Command{
-	Use:   "start [OPTIONS] CONTAINER",
+	Use:   "start [OPTIONS] CONTAINER [CONTAINER...]",
 	Short: "Start one or more stopped containers",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if startAttach && len(args) > 1 {
+			fmt.Fprintln(os.Stderr, "you cannot start and attach multiple containers at once")
+			os.Exit(1)
+		}
+
+		failed := false
 		for _, identifier := range args {
-			if ContainerMgr.UpdateContainerStatus(identifier, "running") {
-				fmt.Printf("Started container '%s'\n", identifier)
-			} else {
-				fmt.Printf("No such container: '%s'\n", identifier)
+			container, exists := ContainerMgr.GetContainer(identifier)
+			if exists && container.StartedAt.IsZero() {
+				// A container from 'docker create' has never been scheduled
+				err := runContainerOnK8s(container.Name, container.Image, container.Command, container.Ports, container.Env, !startAttach)
+				if err != nil {
+					fmt.Printf("Failed to start container: %v\n", err)
+					failed = true
+					continue
+				}
+			}
+
+			container, err := ContainerMgr.StartContainer(identifier)
+			if err != nil {
+				printDaemonError(err)
+				failed = true
+				continue
 			}
+			if startAttach {
+				attachContainer(container.ID, container.Name, func() {
+					ContainerMgr.StopContainer(container.ID)
+				})
+				continue
+			}
+			fmt.Printf("Started container '%s'\n", identifier)
+		}
+		if failed {
+			os.Exit(1)
 		}
 	},
 }
+
+func init() {
+	startCmd.Flags().BoolVarP(&startAttach, "attach", "a", false, "Attach STDOUT/STDERR and forward signals")
+}
@@ -3,6 +3,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -12,12 +13,17 @@ var stopCmd = &cobra.Command{
 	Short: "Stop one or more running containers",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
 		for _, identifier := range args {
-			if ContainerMgr.UpdateContainerStatus(identifier, "stopped") {
-				fmt.Printf("Stopped container '%s'\n", identifier)
-			} else {
-				fmt.Printf("No such container: '%s'\n", identifier)
+			if _, err := ContainerMgr.StopContainer(identifier); err != nil {
+				printDaemonError(err)
+				failed = true
+				continue
 			}
+			fmt.Printf("Stopped container '%s'\n", identifier)
+		}
+		if failed {
+			os.Exit(1)
 		}
 	},
 }
@@ -0,0 +1,46 @@
+// cmd/system.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"prepare.sh/dockermock/data"
+)
+
+var systemCmd = &cobra.Command{
+	Use:   "system",
+	Short: "Manage Docker",
+}
+
+var systemRepairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Recover from corrupt state files",
+	Long: `Check the persisted container and image state. Documents that cannot be
+parsed are moved aside and replaced by the newest readable migration backup,
+or reset to empty state when no backup is usable.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{skipStateAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		reports, err := data.RepairState(StateStore)
+		for _, r := range reports {
+			switch {
+			case r.Problem == nil:
+				fmt.Printf("%s: OK\n", r.Key)
+			case r.RestoredFrom != "":
+				fmt.Printf("%s: %v\n  moved to %s, restored from %s\n", r.Key, r.Problem, r.MovedTo, r.RestoredFrom)
+			default:
+				fmt.Printf("%s: %v\n  moved to %s, starting with empty state\n", r.Key, r.Problem, r.MovedTo)
+			}
+		}
+		if err != nil {
+			fmt.Println("Error repairing state:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	systemCmd.AddCommand(systemRepairCmd)
+}
@@ -3,7 +3,7 @@ package cmd
 
 import (
 	"fmt"
-	"strings"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -29,30 +29,17 @@ func runTag(cmd *cobra.Command, args []string) {
 	sourceRef := args[0]
 	targetRef := args[1]
 
-	// Parse source image reference
-	sourceName, sourceTag := parseImageReference(sourceRef)
-	if sourceTag == "" {
-		sourceTag = "latest"
+	// Parse target image reference, defaulting the tag to latest
+	target := mustParseReference(targetRef)
+	if target.Digest != "" {
+		fmt.Fprintln(os.Stderr, "refusing to create a tag with a digest reference")
+		os.Exit(1)
 	}
 
-	// Parse target image reference
-	targetName, targetTag := parseImageReference(targetRef)
-	if targetTag == "" {
-		targetTag = "latest"
+	// Add the new reference; the source may be a reference or an image ID
+	if err := ImageMgr.TagImage(sourceRef, target); err != nil {
+		printDaemonError(err)
+		os.Exit(1)
 	}
-
-	// Create the new tag
-	if success := ImageMgr.TagImage(sourceName, sourceTag, targetName, targetTag); success {
-		fmt.Printf("Successfully tagged %s:%s as %s:%s\n", sourceName, sourceTag, targetName, targetTag)
-	} else {
-		fmt.Printf("Error: No such image: %s:%s\n", sourceName, sourceTag)
-	}
-}
-
-func parseImageReference(ref string) (name, tag string) {
-	parts := strings.Split(ref, ":")
-	if len(parts) == 2 {
-		return parts[0], parts[1]
-	}
-	return ref, ""
+	fmt.Printf("Successfully tagged %s as %s\n", sourceRef, target.FamiliarString())
 }
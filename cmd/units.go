@@ -0,0 +1,82 @@
+// cmd/units.go
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// humanDuration renders a duration the way Docker does ("About a minute",
+// "3 hours", "2 weeks")
+func humanDuration(d time.Duration) string {
+	if seconds := int(d.Seconds()); seconds < 1 {
+		return "Less than a second"
+	} else if seconds == 1 {
+		return "1 second"
+	} else if seconds < 60 {
+		return fmt.Sprintf("%d seconds", seconds)
+	} else if minutes := int(d.Minutes()); minutes == 1 {
+		return "About a minute"
+	} else if minutes < 60 {
+		return fmt.Sprintf("%d minutes", minutes)
+	} else if hours := int(d.Hours() + 0.5); hours == 1 {
+		return "About an hour"
+	} else if hours < 48 {
+		return fmt.Sprintf("%d hours", hours)
+	} else if hours < 24*7*2 {
+		return fmt.Sprintf("%d days", hours/24)
+	} else if hours < 24*30*2 {
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	} else if hours < 24*365*2 {
+		return fmt.Sprintf("%d months", hours/24/30)
+	}
+	return fmt.Sprintf("%d years", int(d.Hours())/24/365)
+}
+
+// timeAgo renders how long ago t was, e.g. "5 minutes ago"
+func timeAgo(t time.Time) string {
+	return humanDuration(time.Since(t)) + " ago"
+}
+
+// ellipsis shortens s to at most n runes, marking the cut with "…"
+func ellipsis(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 1 {
+		return string(r[:n])
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// shortID truncates an ID to the 12 characters Docker displays
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// byteSizePattern matches sizes such as "512", "10m" or "1.5GB"
+var byteSizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgtp]?)b?$`)
+
+// parseBytes parses a size given on the command line with binary units, as
+// Docker does for --keep-storage and --memory: "1g" is 1024^3 bytes
+func parseBytes(s string) (int64, error) {
+	m := byteSizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size: '%s'", s)
+	}
+	value, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: '%s'", s)
+	}
+	for i := strings.Index("kmgtp", m[2]); m[2] != "" && i >= 0; i-- {
+		value *= 1024
+	}
+	return int64(value), nil
+}
@@ -0,0 +1,175 @@
+// data/buildcache.go
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheRecord is the stored result of a build step, found again by its
+// cache key: a digest of the previous step, the instruction and the
+// checksums of the files it copied
+type CacheRecord struct {
+	Key         string    `json:"key"`
+	Parent      string    `json:"parent,omitempty"` // key of the previous step
+	Description string    `json:"description"`      // the instruction, e.g. "COPY . ."
+	Layer       []byte    `json:"layer,omitempty"`  // gzip-compressed tar; nil for steps without a layer
+	DiffID      string    `json:"diffID,omitempty"`
+	LayerSize   int64     `json:"layerSize,omitempty"` // size the layer adds to an image
+	CreatedBy   string    `json:"createdBy"`           // image history entry
+	Created     time.Time `json:"created"`
+	LastUsed    time.Time `json:"lastUsed"`
+	UsageCount  int       `json:"usageCount"`
+	Size        int64     `json:"-"` // stored size, set when read
+}
+
+// HasLayer reports whether the step produced a layer
+func (r *CacheRecord) HasLayer() bool {
+	return r.Layer != nil
+}
+
+// CachePruneOptions selects the cache records `docker builder prune` removes
+type CachePruneOptions struct {
+	All         bool          // remove records images still refer to as well
+	Until       time.Duration // only records unused for longer; zero for any
+	KeepStorage int64         // keep the most recently used records up to this size
+	InUse       map[string]bool
+}
+
+// BuildCache stores build step results under BuildCacheDir
+type BuildCache struct {
+	store Store
+}
+
+// NewBuildCache returns the build cache persisted in store
+func NewBuildCache(store Store) *BuildCache {
+	return &BuildCache{store: store}
+}
+
+func cacheKey(key string) string {
+	return BuildCacheDir + "/" + TrimDigestPrefix(key)
+}
+
+// Get returns the record stored under key, or nil if there is none, and
+// counts the use
+func (c *BuildCache) Get(key string) (*CacheRecord, error) {
+	var record *CacheRecord
+	err := c.store.Update(func(tx Tx) error {
+		raw, err := tx.Get(cacheKey(key))
+		if err != nil || raw == nil {
+			return err
+		}
+		record = &CacheRecord{}
+		if err := json.Unmarshal(raw, record); err != nil {
+			return fmt.Errorf("corrupt build cache record %s: %v", key, err)
+		}
+		record.LastUsed = time.Now().UTC()
+		record.UsageCount++
+		return putCacheRecord(tx, record)
+	})
+	return record, err
+}
+
+// Put stores a record, replacing any record with the same key
+func (c *BuildCache) Put(record *CacheRecord) error {
+	return c.store.Update(func(tx Tx) error {
+		return putCacheRecord(tx, record)
+	})
+}
+
+func putCacheRecord(tx Tx, record *CacheRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Put(cacheKey(record.Key), raw)
+}
+
+// Records lists the stored records, most recently used first
+func (c *BuildCache) Records() ([]*CacheRecord, error) {
+	var records []*CacheRecord
+	err := c.store.View(func(tx Tx) error {
+		var err error
+		records, err = readCacheRecords(tx)
+		return err
+	})
+	return records, err
+}
+
+func readCacheRecords(tx Tx) ([]*CacheRecord, error) {
+	keys, err := tx.Keys(BuildCacheDir + "/")
+	if err != nil {
+		return nil, err
+	}
+	var records []*CacheRecord
+	for _, key := range keys {
+		raw, err := tx.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		record := &CacheRecord{}
+		if err := json.Unmarshal(raw, record); err != nil {
+			// A corrupt record is worthless; prune removes it like any other
+			record = &CacheRecord{Key: DigestPrefix + strings.TrimPrefix(key, BuildCacheDir+"/")}
+		}
+		record.Size = int64(len(raw))
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].LastUsed.After(records[j].LastUsed)
+	})
+	return records, nil
+}
+
+// Prune removes the records opts selects and returns them. Without
+// opts.All, records an image in opts.InUse still refers to are kept.
+func (c *BuildCache) Prune(opts CachePruneOptions) ([]*CacheRecord, error) {
+	var removed []*CacheRecord
+	err := c.store.Update(func(tx Tx) error {
+		records, err := readCacheRecords(tx)
+		if err != nil {
+			return err
+		}
+		var kept int64
+		for _, record := range records {
+			switch {
+			case !opts.All && opts.InUse[record.Key]:
+				continue
+			case opts.Until > 0 && time.Since(record.LastUsed) < opts.Until:
+				continue
+			case kept+record.Size <= opts.KeepStorage:
+				// Records are sorted by last use, so the recent ones stay
+				kept += record.Size
+				continue
+			}
+			if err := tx.Delete(cacheKey(record.Key)); err != nil {
+				return err
+			}
+			removed = append(removed, record)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// BuildCacheInUse returns the cache keys the stored images record, which
+// prune keeps unless told to remove everything
+func (im *ImageManager) BuildCacheInUse() (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	for _, img := range im.ListImages() {
+		if img.Manifest == "" {
+			continue
+		}
+		content, err := im.ImageContent(img)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range content.Config.BuildCache {
+			inUse[entry.Key] = true
+		}
+	}
+	return inUse, nil
+}
@@ -0,0 +1,48 @@
+// data/buildx.go
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BuildxDir holds the builder selected with `docker buildx use`, next to
+// the CLI config as in Docker's ~/.docker/buildx
+const BuildxDir = "buildx"
+
+// currentBuilder is the content of buildx/current
+type currentBuilder struct {
+	Name string `json:"Name"`
+}
+
+func currentBuilderPath() string {
+	return filepath.Join(GetConfigDirPath(), BuildxDir, "current")
+}
+
+// CurrentBuilder returns the name of the selected builder, or "" if none
+// was selected
+func CurrentBuilder() (string, error) {
+	content, err := os.ReadFile(currentBuilderPath())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var current currentBuilder
+	if err := json.Unmarshal(content, &current); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %v", currentBuilderPath(), err)
+	}
+	return current.Name, nil
+}
+
+// SetCurrentBuilder selects the builder builds use by default
+func SetCurrentBuilder(name string) error {
+	content, err := json.Marshal(currentBuilder{Name: name})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(currentBuilderPath(), content, 0644)
+}
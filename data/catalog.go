@@ -0,0 +1,270 @@
+// data/catalog.go
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/oci"
+)
+
+// NoneTag is shown for the repository and tag of untagged images
+const NoneTag = "<none>"
+
+// imageProfile describes what a popular image looks like, so pulled images
+// resemble their real counterparts
+type imageProfile struct {
+	size       int64    // uncompressed size of all layers
+	layers     int      // number of filesystem layers
+	env        []string // set in addition to PATH
+	entrypoint []string
+	cmd        []string
+	workdir    string
+	ports      []string
+	stopSignal string
+}
+
+// knownImages holds realistic profiles for popular images
+var knownImages = map[string]imageProfile{
+	"alpine":      {size: 7_800_000, layers: 1, cmd: []string{"/bin/sh"}},
+	"busybox":     {size: 4_260_000, layers: 1, cmd: []string{"sh"}},
+	"hello-world": {size: 13_300, layers: 1, cmd: []string{"/hello"}},
+	"ubuntu":      {size: 77_900_000, layers: 1, cmd: []string{"/bin/bash"}},
+	"debian":      {size: 117_000_000, layers: 1, cmd: []string{"bash"}},
+	"centos":      {size: 231_000_000, layers: 1, cmd: []string{"/bin/bash"}},
+	"nginx": {
+		size: 187_000_000, layers: 7,
+		env:        []string{"NGINX_VERSION=1.27.2", "NJS_VERSION=0.8.7", "PKG_RELEASE=1~bookworm"},
+		entrypoint: []string{"/docker-entrypoint.sh"},
+		cmd:        []string{"nginx", "-g", "daemon off;"},
+		ports:      []string{"80/tcp"},
+		stopSignal: "SIGQUIT",
+	},
+	"httpd": {
+		size: 148_000_000, layers: 5,
+		env:        []string{"HTTPD_PREFIX=/usr/local/apache2", "HTTPD_VERSION=2.4.62"},
+		cmd:        []string{"httpd-foreground"},
+		workdir:    "/usr/local/apache2",
+		ports:      []string{"80/tcp"},
+		stopSignal: "SIGWINCH",
+	},
+	"redis": {
+		size: 138_000_000, layers: 8,
+		env:        []string{"REDIS_VERSION=7.4.1"},
+		entrypoint: []string{"docker-entrypoint.sh"},
+		cmd:        []string{"redis-server"},
+		workdir:    "/data",
+		ports:      []string{"6379/tcp"},
+	},
+	"postgres": {
+		size: 432_000_000, layers: 13,
+		env:        []string{"PG_MAJOR=17", "PG_VERSION=17.0-1.pgdg120+1", "PGDATA=/var/lib/postgresql/data"},
+		entrypoint: []string{"docker-entrypoint.sh"},
+		cmd:        []string{"postgres"},
+		ports:      []string{"5432/tcp"},
+		stopSignal: "SIGINT",
+	},
+	"mysql": {
+		size: 586_000_000, layers: 11,
+		env:        []string{"MYSQL_MAJOR=innovation", "MYSQL_VERSION=9.1.0-1.el9"},
+		entrypoint: []string{"docker-entrypoint.sh"},
+		cmd:        []string{"mysqld"},
+		ports:      []string{"3306/tcp", "33060/tcp"},
+	},
+	"mongo": {
+		size: 757_000_000, layers: 10,
+		env:        []string{"MONGO_MAJOR=8.0", "MONGO_VERSION=8.0.1"},
+		entrypoint: []string{"docker-entrypoint.sh"},
+		cmd:        []string{"mongod"},
+		ports:      []string{"27017/tcp"},
+	},
+	"node": {
+		size: 1_100_000_000, layers: 8,
+		env:        []string{"NODE_VERSION=23.0.0", "YARN_VERSION=1.22.22"},
+		entrypoint: []string{"docker-entrypoint.sh"},
+		cmd:        []string{"node"},
+	},
+	"python": {
+		size: 1_020_000_000, layers: 8,
+		env: []string{"LANG=C.UTF-8", "PYTHON_VERSION=3.13.0"},
+		cmd: []string{"python3"},
+	},
+	"golang": {
+		size: 814_000_000, layers: 7,
+		env:     []string{"GOLANG_VERSION=1.23.2", "GOTOOLCHAIN=local", "GOPATH=/go"},
+		cmd:     []string{"bash"},
+		workdir: "/go",
+	},
+	"openjdk": {
+		size: 470_000_000, layers: 3,
+		env: []string{"JAVA_HOME=/usr/java/openjdk-22", "JAVA_VERSION=22-ea+36"},
+		cmd: []string{"jshell"},
+	},
+}
+
+// profileFor returns the profile of an image repository. Unknown images get
+// a stable profile derived from the name.
+func profileFor(name string) imageProfile {
+	base := name[strings.LastIndex(name, "/")+1:]
+	if p, ok := knownImages[base]; ok {
+		return p
+	}
+	sum := sha256.Sum256([]byte(name))
+	return imageProfile{
+		// Between 20MB and 270MB
+		size:   20_000_000 + int64(binary.BigEndian.Uint32(sum[:4])%250_000_000),
+		layers: 2 + int(sum[4])%5,
+		cmd:    []string{"/bin/sh"},
+	}
+}
+
+// syntheticImageSize returns a plausible size for an image repository. Known
+// images get their real size; others a stable size derived from the name.
+func syntheticImageSize(name string) int64 {
+	return profileFor(name).size
+}
+
+// syntheticDigest returns a stable registry digest for a pulled reference
+func syntheticDigest(name, tag string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(name+":"+tag)))
+}
+
+// syntheticEpoch is the latest creation time of simulated images
+var syntheticEpoch = time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+// syntheticImage returns the config and layers of the image a registry
+// serves for the repository name. seed, usually the pulled reference, makes
+// the content stable: the same seed always yields the same image. The
+// layers add up to size bytes.
+func syntheticImage(name, seed string, size int64) (oci.Image, []Layer, error) {
+	p := profileFor(name)
+	sum := sha256.Sum256([]byte(seed))
+	// Within half a year before the epoch, to the second
+	created := syntheticEpoch.Add(-time.Duration(binary.BigEndian.Uint32(sum[:4])%(180*24*3600)) * time.Second)
+
+	config := oci.Image{
+		Created:      &created,
+		Architecture: "amd64",
+		OS:           "linux",
+		Config: oci.Config{
+			Env:        append([]string{DefaultPath}, p.env...),
+			Entrypoint: p.entrypoint,
+			Cmd:        p.cmd,
+			WorkingDir: p.workdir,
+			StopSignal: p.stopSignal,
+		},
+		RootFS: oci.RootFS{Type: "layers"},
+	}
+	if len(p.ports) > 0 {
+		config.Config.ExposedPorts = make(map[string]struct{}, len(p.ports))
+		for _, port := range p.ports {
+			config.Config.ExposedPorts[port] = struct{}{}
+		}
+	}
+
+	// The base layer holds most of the image; the rest is spread unevenly
+	// over the layers added on top of it. Sizes and the base layer only
+	// depend on the repository, so its tags share a base layer.
+	nameSum := sha256.Sum256([]byte(name))
+	weights := make([]int64, p.layers)
+	var total int64
+	for i := range weights {
+		weights[i] = 1 + int64(nameSum[(4+i)%len(nameSum)]%9)
+		if i == 0 {
+			weights[i] += 10
+		}
+		total += weights[i]
+	}
+
+	layers := make([]Layer, 0, p.layers)
+	remaining := size
+	for i, w := range weights {
+		layerSize := size * w / total
+		if i == len(weights)-1 {
+			layerSize = remaining
+		}
+		remaining -= layerSize
+
+		// The base image was built weeks before; each step on top of it took
+		// a few minutes, finishing when the image was created
+		layerSeed, modTime := seed, created
+		step := created.Add(-time.Duration(len(weights)-i) * time.Duration(1+nameSum[1]%5) * time.Minute)
+		history := oci.History{
+			Created:   &step,
+			CreatedBy: fmt.Sprintf("RUN /bin/sh -c set -eux; install-%s --layer %d # buildkit", name[strings.LastIndex(name, "/")+1:], i),
+			Comment:   "buildkit.dockerfile.v0",
+		}
+		if i == 0 {
+			layerSeed, modTime = name, syntheticEpoch
+			base := created.Add(-time.Duration(7+nameSum[0]%28) * 24 * time.Hour)
+			history = oci.History{Created: &base, CreatedBy: fmt.Sprintf("/bin/sh -c #(nop) ADD file:%x in / ", nameSum)}
+		}
+		layer, err := NewLayer(map[string][]byte{
+			fmt.Sprintf("etc/dockermock/layers/%d", i): []byte(fmt.Sprintf("%s layer %d\n", layerSeed, i)),
+		}, modTime, layerSize)
+		if err != nil {
+			return oci.Image{}, nil, err
+		}
+		layers = append(layers, layer)
+		config.History = append(config.History, history)
+	}
+	config.History = append(config.History, instructionHistory(config.Config, created)...)
+	return config, layers, nil
+}
+
+// instructionHistory returns the empty-layer history entries the
+// instructions producing config leave behind, in Dockerfile order
+func instructionHistory(config oci.Config, created time.Time) []oci.History {
+	var steps []string
+	for _, env := range config.Env {
+		if !strings.HasPrefix(env, "PATH=") {
+			steps = append(steps, "ENV "+env)
+		}
+	}
+	if config.WorkingDir != "" {
+		steps = append(steps, "WORKDIR "+config.WorkingDir)
+	}
+	if len(config.ExposedPorts) > 0 {
+		ports := make([]string, 0, len(config.ExposedPorts))
+		for port := range config.ExposedPorts {
+			ports = append(ports, port+":{}")
+		}
+		sort.Strings(ports)
+		steps = append(steps, "EXPOSE map["+strings.Join(ports, " ")+"]")
+	}
+	if config.StopSignal != "" {
+		steps = append(steps, "STOPSIGNAL "+config.StopSignal)
+	}
+	if len(config.Entrypoint) > 0 {
+		steps = append(steps, "ENTRYPOINT "+ExecForm(config.Entrypoint))
+	}
+	if len(config.Cmd) > 0 {
+		steps = append(steps, "CMD "+ExecForm(config.Cmd))
+	}
+
+	history := make([]oci.History, 0, len(steps))
+	for _, step := range steps {
+		history = append(history, oci.History{
+			Created:    &created,
+			CreatedBy:  step + " # buildkit",
+			Comment:    "buildkit.dockerfile.v0",
+			EmptyLayer: true,
+		})
+	}
+	return history
+}
+
+// ExecForm renders args the way image history shows exec-form instructions,
+// e.g. ["nginx" "-g" "daemon off;"]
+func ExecForm(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = strconv.Quote(a)
+	}
+	return "[" + strings.Join(quoted, " ") + "]"
+}
@@ -4,119 +4,220 @@ package data
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Container represents a mock container
 type Container struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	Image  string `json:"image"`
-	Status string `json:"status"` // e.g., running, stopped
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Image      string            `json:"image"`             // reference as given on the command line
+	ImageID    string            `json:"imageId,omitempty"` // ID the reference resolved to at creation
+	Command    []string          `json:"command,omitempty"`
+	Env        []string          `json:"env,omitempty"`
+	Ports      []string          `json:"ports,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Status     string            `json:"status"` // one of the State* constants
+	ExitCode   int               `json:"exitCode"`
+	Created    time.Time         `json:"created"`
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt time.Time         `json:"finishedAt"`
 }
 
 // ContainerManager manages mock containers
 type ContainerManager struct {
+	store      Store
 	containers map[string]*Container
-	Counter    int // Renamed from 'counter' to 'Counter' to export it
 	mu         sync.Mutex
 }
 
-// NewContainerManager initializes a ContainerManager with persisted data
-func NewContainerManager() *ContainerManager {
+// NewContainerManager initializes a ContainerManager with data persisted in store
+func NewContainerManager(store Store) (*ContainerManager, error) {
 	cm := &ContainerManager{
+		store:      store,
 		containers: make(map[string]*Container),
-		Counter:    1, // Initialize Counter
 	}
 	if err := cm.Load(); err != nil {
-		fmt.Println("Warning: Unable to load containers data:", err)
+		return nil, err
 	}
-	return cm
+	return cm, nil
 }
 
-// Load reads containers data from the JSON file
+// Load reads containers data from the store, upgrading it in place if it was
+// written with an older schema
 func (cm *ContainerManager) Load() error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	path := GetContainersFilePath()
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	return cm.store.Update(cm.load)
+}
+
+// Save writes containers data to the store
+func (cm *ContainerManager) Save() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	return cm.store.Update(cm.save)
+}
+
+// load replaces the in-memory state with the stored document. Callers hold
+// cm.mu.
+func (cm *ContainerManager) load(tx Tx) error {
+	cm.containers = make(map[string]*Container)
+
+	data, err := tx.Get(ContainersFile)
+	if err != nil {
+		return err
+	}
+	if data == nil {
 		// No data to load
 		return nil
 	}
 
-	data, err := ioutil.ReadFile(path)
+	var state containersState
+	version, err := decodeState(data, "containers", containerMigrations, &state)
 	if err != nil {
-		return err
+		return &CorruptStateError{Key: ContainersFile, Err: err}
 	}
 
-	var containers []*Container
-	if err := json.Unmarshal(data, &containers); err != nil {
-		return err
+	for _, c := range state.Containers {
+		cm.containers[c.ID] = c
 	}
 
-	for _, c := range containers {
-		cm.containers[c.ID] = c
-		// Update counter based on existing IDs
-		var idNum int
-		_, err := fmt.Sscanf(c.ID, "c%d", &idNum)
-		if err == nil && idNum >= cm.Counter {
-			cm.Counter = idNum + 1
+	if version < ContainersSchemaVersion {
+		if err := backupState(tx, ContainersFile, data, version); err != nil {
+			return err
 		}
+		return cm.save(tx)
 	}
-
 	return nil
 }
 
-// Save writes containers data to the JSON file
-func (cm *ContainerManager) Save() error {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-
+// save replaces the stored document with the in-memory state. Callers hold
+// cm.mu.
+func (cm *ContainerManager) save(tx Tx) error {
 	containers := []*Container{}
 	for _, c := range cm.containers {
 		containers = append(containers, c)
 	}
 
-	data, err := json.MarshalIndent(containers, "", "  ")
+	data, err := json.MarshalIndent(containersState{
+		Version:    ContainersSchemaVersion,
+		Containers: containers,
+	}, "", "  ")
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to marshal containers: %v", err)
 	}
 
-	return ioutil.WriteFile(GetContainersFilePath(), data, 0644)
+	return tx.Put(ContainersFile, data)
 }
 
-// CreateContainer simulates creating a container
-func (cm *ContainerManager) CreateContainer(name, image string) *Container {
+// update runs fn against the latest stored state inside a store transaction,
+// then persists the result. Other CLI processes block until it completes.
+func (cm *ContainerManager) update(fn func() error) error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	id := fmt.Sprintf("c%03d", cm.Counter)
-	cm.Counter++ // Increment Counter
-	container := &Container{
-		ID:     id,
-		Name:   name,
-		Image:  image,
-		Status: "running",
+	return cm.store.Update(func(tx Tx) error {
+		if err := cm.load(tx); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		return cm.save(tx)
+	})
+}
+
+// find looks up a container by full ID, name or unique ID prefix. Callers
+// hold cm.mu.
+func (cm *ContainerManager) find(identifier string) (*Container, error) {
+	if c, exists := cm.containers[identifier]; exists {
+		return c, nil
+	}
+	name := strings.TrimPrefix(identifier, "/")
+	for _, c := range cm.containers {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+
+	if isIDPrefix(identifier) {
+		var match *Container
+		for id, c := range cm.containers {
+			if strings.HasPrefix(id, identifier) {
+				if match != nil {
+					return nil, &AmbiguousIDError{Prefix: identifier}
+				}
+				match = c
+			}
+		}
+		if match != nil {
+			return match, nil
+		}
+	}
+	return nil, &NoSuchContainerError{Identifier: identifier}
+}
+
+// ContainerConfig describes a container to create
+type ContainerConfig struct {
+	Name    string
+	Image   string
+	ImageID string
+	Command []string
+	Env     []string
+	Ports   []string
+	Labels  map[string]string
+}
+
+// CreateContainer records a new container in the created state
+func (cm *ContainerManager) CreateContainer(config ContainerConfig) (*Container, error) {
+	var container *Container
+	err := cm.update(func() error {
+		for _, existing := range cm.containers {
+			if existing.Name != config.Name {
+				continue
+			}
+			return fmt.Errorf("Conflict. The container name \"/%s\" is already in use by container \"%s\". You have to remove (or rename) that container to be able to reuse that name.", config.Name, existing.ID)
+		}
+
+		id := generateID()
+		container = &Container{
+			ID:      id,
+			Name:    config.Name,
+			Image:   config.Image,
+			ImageID: config.ImageID,
+			Command: config.Command,
+			Env:     config.Env,
+			Ports:   config.Ports,
+			Labels:  config.Labels,
+			Status:  StateCreated,
+			Created: time.Now().UTC(),
+		}
+		cm.containers[id] = container
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	cm.containers[id] = container
-	cm.Save()
-	return container
+	return container, nil
 }
 
-// GetContainer retrieves a container by ID or name
+// GetContainer retrieves a container by ID, name or unique ID prefix
 func (cm *ContainerManager) GetContainer(identifier string) (*Container, bool) {
+	c, err := cm.ResolveContainer(identifier)
+	return c, err == nil
+}
+
+// ResolveContainer retrieves a container by ID, name or unique ID prefix,
+// reporting unknown and ambiguous references as errors
+func (cm *ContainerManager) ResolveContainer(identifier string) (*Container, error) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	for _, c := range cm.containers {
-		if c.ID == identifier || c.Name == identifier {
-			return c, true
-		}
-	}
-	return nil, false
+	return cm.find(identifier)
 }
 
 // ListContainers lists all containers
@@ -131,30 +232,29 @@ func (cm *ContainerManager) ListContainers() []*Container {
 	return list
 }
 
-// RemoveContainer removes a container
-func (cm *ContainerManager) RemoveContainer(identifier string) bool {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-
-	for id, c := range cm.containers {
-		if c.ID == identifier || c.Name == identifier {
-			delete(cm.containers, id)
-			cm.Save()
-			return true
+// RemoveContainer removes a container. Running containers are only removed
+// when force is set, in which case they are killed first.
+func (cm *ContainerManager) RemoveContainer(identifier string, force bool) error {
+	return cm.update(func() error {
+		c, err := cm.find(identifier)
+		if err != nil {
+			return err
 		}
-	}
-	return false
-}
-
-// UpdateContainerStatus updates the status of a container
-func (cm *ContainerManager) UpdateContainerStatus(identifier, status string) bool {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-
-	if c, exists := cm.GetContainer(identifier); exists {
-		c.Status = status
-		cm.Save()
-		return true
-	}
-	return false
+		if c.IsRunning() {
+			if !force {
+				return fmt.Errorf("You cannot remove a running container %s. Stop the container before attempting removal or force remove", c.ID)
+			}
+			if err := c.stop(ExitCodeKilled, time.Now().UTC()); err != nil {
+				return err
+			}
+		}
+		if c.Status == StateRemoving {
+			return fmt.Errorf("removal of container %s is already in progress", c.ID)
+		}
+		if err := c.setState(StateRemoving, time.Now().UTC()); err != nil {
+			return err
+		}
+		delete(cm.containers, c.ID)
+		return nil
+	})
 }
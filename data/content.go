@@ -0,0 +1,403 @@
+// data/content.go
+package data
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
+)
+
+// DefaultPath is the PATH entry Docker images set by default, and that
+// images whose base does not set PATH get
+const DefaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// LayerSizeAnnotation records, on a manifest's layer descriptor, the size
+// the layer adds to an image. Mock layers hold almost no data, so the size
+// of the filesystem they stand for travels with them.
+const LayerSizeAnnotation = "org.dockermock.layer.size"
+
+// Layer is a filesystem layer of an image
+type Layer struct {
+	Blob   []byte // gzip-compressed tar, as stored in registries
+	DiffID string // digest of the uncompressed tar
+	Size   int64  // size the layer adds to the image
+}
+
+// NewLayer returns a layer holding files, stamped with modTime. The same
+// files always yield the same blob. size is the size the layer reports; zero
+// means the size of its tar.
+func NewLayer(files map[string][]byte, modTime time.Time, size int64) (Layer, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var raw bytes.Buffer
+	tw := tar.NewWriter(&raw)
+	for _, name := range names {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(files[name])),
+			ModTime: modTime,
+			Format:  tar.FormatPAX,
+		})
+		if err != nil {
+			return Layer{}, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return Layer{}, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return Layer{}, err
+	}
+
+	if size == 0 {
+		size = int64(raw.Len())
+	}
+	return LayerFromTar(raw.Bytes(), size)
+}
+
+// LayerFromTar compresses an uncompressed layer tar. size is the size the
+// layer reports, usually the size of the files in it.
+func LayerFromTar(raw []byte, size int64) (Layer, error) {
+	var blob bytes.Buffer
+	gz := gzip.NewWriter(&blob)
+	if _, err := gz.Write(raw); err != nil {
+		return Layer{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return Layer{}, err
+	}
+
+	return Layer{Blob: blob.Bytes(), DiffID: oci.Digest(raw), Size: size}, nil
+}
+
+// ImageContent is the stored manifest and config of an image
+type ImageContent struct {
+	Manifest    oci.Manifest
+	Config      oci.Image
+	RawManifest []byte
+	RawConfig   []byte
+}
+
+// LayerSize returns the size the i-th layer adds to the image
+func (c *ImageContent) LayerSize(i int) int64 {
+	return layerSize(c.Manifest.Layers[i])
+}
+
+// layerSize returns the size a layer adds to an image, falling back to the
+// blob size for layers pushed by other tools
+func layerSize(d oci.Descriptor) int64 {
+	if size, err := strconv.ParseInt(d.Annotations[LayerSizeAnnotation], 10, 64); err == nil {
+		return size
+	}
+	return d.Size
+}
+
+// contentKey returns the store key of the blob with digest
+func contentKey(digest string) string {
+	return ContentDir + "/sha256/" + TrimDigestPrefix(digest)
+}
+
+// encodeConfig records the diff IDs of layers in config and serializes it.
+// The digest of the result is the image ID.
+func encodeConfig(config oci.Image, layers []Layer) ([]byte, error) {
+	config.RootFS = oci.RootFS{Type: "layers", DiffIDs: make([]string, 0, len(layers))}
+	for _, l := range layers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, l.DiffID)
+	}
+	return json.Marshal(config)
+}
+
+// storeContent stores rawConfig, layers and a manifest listing them, and
+// records the manifest and size on img
+func storeContent(tx Tx, img *Image, rawConfig []byte, layers []Layer) error {
+	manifest := oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.MediaTypeImageManifest,
+		Config: oci.Descriptor{
+			MediaType: oci.MediaTypeImageConfig,
+			Digest:    oci.Digest(rawConfig),
+			Size:      int64(len(rawConfig)),
+		},
+		Layers: []oci.Descriptor{},
+	}
+	blobs := [][]byte{rawConfig}
+	for _, l := range layers {
+		manifest.Layers = append(manifest.Layers, oci.Descriptor{
+			MediaType:   oci.MediaTypeImageLayerGzip,
+			Digest:      oci.Digest(l.Blob),
+			Size:        int64(len(l.Blob)),
+			Annotations: map[string]string{LayerSizeAnnotation: strconv.FormatInt(l.Size, 10)},
+		})
+		blobs = append(blobs, l.Blob)
+	}
+	rawManifest, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return importContent(tx, img, rawManifest, blobs)
+}
+
+// importContent stores a manifest and the config and layer blobs it refers
+// to, and records the manifest and size on img
+func importContent(tx Tx, img *Image, rawManifest []byte, blobs [][]byte) error {
+	var manifest oci.Manifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return fmt.Errorf("invalid image manifest: %v", err)
+	}
+	for _, blob := range append(blobs, rawManifest) {
+		if err := putBlob(tx, blob); err != nil {
+			return err
+		}
+	}
+
+	img.Manifest = oci.Digest(rawManifest)
+	img.Size = 0
+	for _, l := range manifest.Layers {
+		img.Size += layerSize(l)
+	}
+	return nil
+}
+
+// putBlob stores blob under its digest unless it is already present
+func putBlob(tx Tx, blob []byte) error {
+	key := contentKey(oci.Digest(blob))
+	existing, err := tx.Get(key)
+	if err != nil || existing != nil {
+		return err
+	}
+	return tx.Put(key, blob)
+}
+
+// readBlob loads the blob with digest
+func readBlob(tx Tx, digest string) ([]byte, error) {
+	blob, err := tx.Get(contentKey(digest))
+	if err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		return nil, fmt.Errorf("content %s is missing", digest)
+	}
+	return blob, nil
+}
+
+// readContent loads the manifest and config of img
+func readContent(tx Tx, img *Image) (*ImageContent, error) {
+	if img.Manifest == "" {
+		return nil, fmt.Errorf("image %s has no stored content", img.ID[:12])
+	}
+	content := &ImageContent{}
+	var err error
+	if content.RawManifest, err = readBlob(tx, img.Manifest); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content.RawManifest, &content.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid image manifest %s: %v", img.Manifest, err)
+	}
+	if content.RawConfig, err = readBlob(tx, content.Manifest.Config.Digest); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content.RawConfig, &content.Config); err != nil {
+		return nil, fmt.Errorf("invalid image config %s: %v", content.Manifest.Config.Digest, err)
+	}
+	return content, nil
+}
+
+// ImageContent returns the manifest and config img is made of
+func (im *ImageManager) ImageContent(img *Image) (*ImageContent, error) {
+	var content *ImageContent
+	err := im.store.View(func(tx Tx) error {
+		var err error
+		content, err = readContent(tx, img)
+		return err
+	})
+	return content, err
+}
+
+// ImageConfig returns the config of the image ref resolves to
+func (im *ImageManager) ImageConfig(ref string) (*oci.Image, error) {
+	img, err := im.ResolveImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	content, err := im.ImageContent(img)
+	if err != nil {
+		return nil, err
+	}
+	return &content.Config, nil
+}
+
+// ImageLayers returns the layers of img with their blobs, such as the base
+// layers of an image built on top of it
+func (im *ImageManager) ImageLayers(img *Image) ([]Layer, error) {
+	var layers []Layer
+	err := im.store.View(func(tx Tx) error {
+		content, err := readContent(tx, img)
+		if err != nil {
+			return err
+		}
+		if len(content.Config.RootFS.DiffIDs) != len(content.Manifest.Layers) {
+			return fmt.Errorf("image %s lists %d layers but has %d diff IDs", img.ID[:12], len(content.Manifest.Layers), len(content.Config.RootFS.DiffIDs))
+		}
+		for i, d := range content.Manifest.Layers {
+			blob, err := readBlob(tx, d.Digest)
+			if err != nil {
+				return err
+			}
+			layers = append(layers, Layer{Blob: blob, DiffID: content.Config.RootFS.DiffIDs[i], Size: content.LayerSize(i)})
+		}
+		return nil
+	})
+	return layers, err
+}
+
+// Blob returns the stored blob with digest, such as a layer to push
+func (im *ImageManager) Blob(digest string) ([]byte, error) {
+	var blob []byte
+	err := im.store.View(func(tx Tx) error {
+		var err error
+		blob, err = readBlob(tx, digest)
+		return err
+	})
+	return blob, err
+}
+
+// hasBlob reports whether the blob with digest is stored
+func (im *ImageManager) hasBlob(digest string) bool {
+	found := false
+	im.store.View(func(tx Tx) error {
+		blob, err := tx.Get(contentKey(digest))
+		found = err == nil && blob != nil
+		return err
+	})
+	return found
+}
+
+// pruneContent deletes stored blobs no image refers to anymore. Callers
+// hold im.mu.
+func (im *ImageManager) pruneContent(tx Tx) error {
+	used := make(map[string]bool)
+	for _, img := range im.images {
+		if img.Manifest == "" {
+			continue
+		}
+		used[contentKey(img.Manifest)] = true
+		raw, err := tx.Get(contentKey(img.Manifest))
+		if err != nil {
+			return err
+		}
+		var manifest oci.Manifest
+		if raw == nil || json.Unmarshal(raw, &manifest) != nil {
+			continue
+		}
+		used[contentKey(manifest.Config.Digest)] = true
+		for _, l := range manifest.Layers {
+			used[contentKey(l.Digest)] = true
+		}
+	}
+
+	keys, err := tx.Keys(ContentDir + "/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !used[key] {
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// synthesizeContent gives images recorded before their content was stored
+// a config and layers resembling the image they are named after. Their IDs
+// are kept, so they are not the digest of that config. Callers hold im.mu.
+func (im *ImageManager) synthesizeContent(tx Tx) error {
+	for _, img := range im.images {
+		if img.Manifest != "" {
+			continue
+		}
+		name, seed := "scratch", img.ID
+		if refs := img.references(); len(refs) > 0 {
+			name, _ = SplitRepoTag(refs[0])
+			seed = refs[0]
+		}
+		config, layers, err := syntheticImage(name, seed, img.Size)
+		if err != nil {
+			return err
+		}
+		config.Config.Labels = img.Labels
+		rawConfig, err := encodeConfig(config, layers)
+		if err != nil {
+			return err
+		}
+		if err := storeContent(tx, img, rawConfig, layers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportImage records an image pulled from a registry: the manifest with
+// digest, its config and its layer blobs. The image ID is the digest of
+// config, and ref, a tag or digest reference, points to it afterwards.
+func (im *ImageManager) ImportImage(ref reference.Reference, digest string, manifest, config []byte, layers [][]byte) (*Image, error) {
+	var parsed oci.Image
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid image config: %v", err)
+	}
+	created := time.Now().UTC()
+	if parsed.Created != nil {
+		created = parsed.Created.UTC()
+	}
+
+	var image *Image
+	err := im.updateTx(func(tx Tx) error {
+		id := contentID(config)
+		image = im.images[id]
+		if image == nil {
+			image = &Image{
+				ID:      id,
+				Created: created,
+				Labels:  parsed.Config.Labels,
+			}
+			im.images[id] = image
+		}
+		if err := importContent(tx, image, manifest, append([][]byte{config}, layers...)); err != nil {
+			return err
+		}
+		image.Digest = digest
+		image.addDigest(ref.FamiliarName() + "@" + digest)
+		if ref.Digest == "" {
+			im.tag(image, ref.FamiliarString())
+		}
+		return nil
+	})
+	return image, err
+}
+
+// AddRepoDigest records that the image ref points to is known to its
+// registry under digest, as after a push
+func (im *ImageManager) AddRepoDigest(ref reference.Reference, digest string) error {
+	return im.update(func() error {
+		img := im.findRef(ref)
+		if img == nil {
+			return &NoSuchImageError{Reference: ref.FamiliarString()}
+		}
+		img.addDigest(ref.FamiliarName() + "@" + digest)
+		return nil
+	})
+}
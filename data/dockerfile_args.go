@@ -0,0 +1,369 @@
+// data/dockerfile_args.go
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// instructionParsers parse the arguments of each instruction, after its
+// flags
+var instructionParsers map[string]func(inst *Instruction, rest string, escape rune) error
+
+func init() {
+	instructionParsers = map[string]func(inst *Instruction, rest string, escape rune) error{
+		"FROM":        parseFrom,
+		"RUN":         parseCommand,
+		"CMD":         parseCommand,
+		"ENTRYPOINT":  parseCommand,
+		"SHELL":       parseShell,
+		"COPY":        parseCopy,
+		"ADD":         parseCopy,
+		"ENV":         parseNameValues,
+		"LABEL":       parseNameValues,
+		"ARG":         parseArg,
+		"EXPOSE":      parseList,
+		"VOLUME":      parseList,
+		"USER":        parseString,
+		"WORKDIR":     parseString,
+		"STOPSIGNAL":  parseString,
+		"MAINTAINER":  parseString,
+		"ONBUILD":     parseOnbuild,
+		"HEALTHCHECK": parseHealthcheck,
+	}
+}
+
+// instructionFlags lists the flags each instruction accepts and whether
+// they are boolean. Instructions not listed take no flags.
+var instructionFlags = map[string]map[string]bool{
+	"FROM":        {"platform": false},
+	"RUN":         {"mount": false, "network": false, "security": false},
+	"COPY":        {"from": false, "chown": false, "chmod": false, "link": true, "parents": true, "exclude": false},
+	"ADD":         {"chown": false, "chmod": false, "link": true, "checksum": false, "keep-git-dir": true, "exclude": false},
+	"HEALTHCHECK": {"interval": false, "timeout": false, "start-period": false, "start-interval": false, "retries": false},
+}
+
+// repeatableFlags may be given more than once
+var repeatableFlags = map[string]bool{"mount": true, "exclude": true}
+
+// extractFlags splits the leading --name[=value] words off rest. Quotes in
+// flag values are removed. A bare "--" ends the flags.
+func extractFlags(rest string, escape rune) ([]string, string, error) {
+	var words []string
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if !strings.HasPrefix(rest, "--") {
+			return words, rest, nil
+		}
+		word, remaining, err := unquoteWord(rest, escape)
+		if err != nil {
+			return nil, "", err
+		}
+		rest = remaining
+		if word == "--" {
+			return words, strings.TrimLeft(rest, " \t"), nil
+		}
+		words = append(words, word)
+	}
+}
+
+// parseFlags checks flag words against the flags cmd accepts. Boolean
+// flags given without a value are true.
+func parseFlags(cmd string, words []string) ([]InstructionFlag, error) {
+	allowed := instructionFlags[cmd]
+	seen := make(map[string]bool)
+	var flags []InstructionFlag
+	for _, word := range words {
+		name, value, hasValue := strings.Cut(word[2:], "=")
+		boolean, ok := allowed[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown flag: --%s", name)
+		}
+		if seen[name] && !repeatableFlags[name] {
+			return nil, fmt.Errorf("duplicate flag specified: --%s", name)
+		}
+		seen[name] = true
+
+		switch {
+		case !hasValue && boolean:
+			value = "true"
+		case !hasValue:
+			return nil, fmt.Errorf("missing a value on flag: --%s", name)
+		case boolean && value != "true" && value != "false":
+			return nil, fmt.Errorf("expecting boolean value for flag --%s, not: %s", name, value)
+		}
+		flags = append(flags, InstructionFlag{Name: name, Value: value})
+	}
+	return flags, nil
+}
+
+// unquoteWord reads the first whitespace-delimited word of s, removing
+// quotes and escapes, and returns it with the rest of s
+func unquoteWord(s string, escape rune) (string, string, error) {
+	var word strings.Builder
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == 0 && (r == ' ' || r == '\t'):
+			return word.String(), string(runes[i:]), nil
+		case r == escape && quote != '\'' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case r == quote:
+			quote = 0
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return "", "", fmt.Errorf("unmatched quote in %q", s)
+	}
+	return word.String(), "", nil
+}
+
+// splitWords splits s on whitespace outside quotes. Quotes and escapes are
+// kept in the words.
+func splitWords(s string, escape rune) []string {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quote == 0 && (r == ' ' || r == '\t') {
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		inWord = true
+		word.WriteRune(r)
+		switch {
+		case r == escape && quote != '\'' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case r == quote:
+			quote = 0
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// parseJSON parses rest as a JSON array of strings, reporting whether it is
+// one
+func parseJSON(rest string) ([]string, bool) {
+	rest = strings.TrimSpace(rest)
+	if !strings.HasPrefix(rest, "[") {
+		return nil, false
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(rest), &list); err != nil {
+		return nil, false
+	}
+	return list, true
+}
+
+// heredocPattern matches a here-document marker word, e.g. <<EOF, <<-EOF
+// or <<"EOF"
+var heredocPattern = regexp.MustCompile(`^<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)$`)
+
+// findHeredocs returns the here-documents introduced in a shell-form line
+func findHeredocs(rest string, escape rune) []Heredoc {
+	var heredocs []Heredoc
+	for _, word := range splitWords(rest, escape) {
+		m := heredocPattern.FindStringSubmatch(word)
+		if m == nil || m[2] != m[4] {
+			continue
+		}
+		heredocs = append(heredocs, Heredoc{Name: m[3], Expand: m[2] == "", Chomp: m[1] == "-"})
+	}
+	return heredocs
+}
+
+func parseFrom(inst *Instruction, rest string, escape rune) error {
+	words := splitWords(rest, escape)
+	if len(words) != 1 && (len(words) != 3 || !strings.EqualFold(words[1], "AS")) {
+		return fmt.Errorf("FROM requires either one or three arguments")
+	}
+	inst.Args = words
+	return nil
+}
+
+// parseCommand parses RUN, CMD and ENTRYPOINT in exec or shell form
+func parseCommand(inst *Instruction, rest string, escape rune) error {
+	if list, ok := parseJSON(rest); ok {
+		inst.Args, inst.JSON = list, true
+		return nil
+	}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		if inst.Command == "RUN" {
+			return fmt.Errorf("RUN requires at least one argument")
+		}
+		return nil
+	}
+	inst.Args = []string{rest}
+	if inst.Command == "RUN" {
+		inst.Heredocs = findHeredocs(rest, escape)
+	}
+	return nil
+}
+
+func parseShell(inst *Instruction, rest string, escape rune) error {
+	list, ok := parseJSON(rest)
+	if !ok {
+		return fmt.Errorf("SHELL requires the arguments to be in JSON form")
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("SHELL requires at least one argument")
+	}
+	inst.Args, inst.JSON = list, true
+	return nil
+}
+
+// parseCopy parses the sources and destination of COPY and ADD
+func parseCopy(inst *Instruction, rest string, escape rune) error {
+	if list, ok := parseJSON(rest); ok {
+		inst.Args, inst.JSON = list, true
+	} else {
+		inst.Args = strings.Fields(rest)
+		inst.Heredocs = findHeredocs(rest, escape)
+	}
+	switch len(inst.Args) {
+	case 0:
+		return fmt.Errorf("%s requires at least two arguments", inst.Command)
+	case 1:
+		return fmt.Errorf("%s requires at least two arguments, but only one was provided. Destination could not be determined.", inst.Command)
+	}
+	return nil
+}
+
+// parseNameValues parses the key=value pairs of ENV and LABEL, or the
+// legacy "key value" form setting a single key
+func parseNameValues(inst *Instruction, rest string, escape rune) error {
+	words := splitWords(rest, escape)
+	if len(words) == 0 {
+		return fmt.Errorf("%s requires at least one argument", inst.Command)
+	}
+
+	if !strings.Contains(words[0], "=") {
+		// Legacy form: the value is the rest of the line
+		key := words[0]
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimLeft(rest, " \t"), key))
+		if value == "" {
+			return fmt.Errorf("%s must have two arguments", inst.Command)
+		}
+		inst.Args = []string{key, value}
+		return nil
+	}
+
+	for _, word := range words {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok {
+			return fmt.Errorf("Syntax error - can't find = in %q. Must be of the form: name=value", word)
+		}
+		if key == "" {
+			return fmt.Errorf("%s names can not be blank", inst.Command)
+		}
+		inst.Args = append(inst.Args, key, value)
+	}
+	return nil
+}
+
+func parseArg(inst *Instruction, rest string, escape rune) error {
+	words := splitWords(rest, escape)
+	if len(words) == 0 {
+		return fmt.Errorf("ARG requires at least one argument")
+	}
+	for _, word := range words {
+		if strings.HasPrefix(word, "=") {
+			return fmt.Errorf("ARG names can not be blank")
+		}
+	}
+	inst.Args = words
+	return nil
+}
+
+// parseList parses EXPOSE and VOLUME, whose elements are whitespace
+// separated or, for VOLUME, a JSON array
+func parseList(inst *Instruction, rest string, escape rune) error {
+	if list, ok := parseJSON(rest); ok && inst.Command == "VOLUME" {
+		inst.Args, inst.JSON = list, true
+	} else {
+		inst.Args = strings.Fields(rest)
+	}
+	if len(inst.Args) == 0 {
+		return fmt.Errorf("%s requires at least one argument", inst.Command)
+	}
+	return nil
+}
+
+// parseString parses instructions taking their whole text as one argument
+func parseString(inst *Instruction, rest string, escape rune) error {
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return fmt.Errorf("%s requires exactly one argument", inst.Command)
+	}
+	inst.Args = []string{rest}
+	return nil
+}
+
+func parseOnbuild(inst *Instruction, rest string, escape rune) error {
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return fmt.Errorf("ONBUILD requires at least one argument")
+	}
+	switch trigger := strings.ToUpper(commandSeparator.Split(rest, 2)[0]); trigger {
+	case "ONBUILD":
+		return fmt.Errorf("Chaining ONBUILD via `ONBUILD ONBUILD` isn't allowed")
+	case "FROM", "MAINTAINER":
+		return fmt.Errorf("%s isn't allowed as an ONBUILD trigger", trigger)
+	}
+	next, err := parseInstructionLine(rest, inst.StartLine, escape)
+	var parseErr *DockerfileParseError
+	if errors.As(err, &parseErr) {
+		return errors.New(parseErr.Message)
+	}
+	inst.Args, inst.Next = []string{rest}, next
+	return nil
+}
+
+func parseHealthcheck(inst *Instruction, rest string, escape rune) error {
+	fields := commandSeparator.Split(strings.TrimSpace(rest), 2)
+	switch strings.ToUpper(fields[0]) {
+	case "":
+		return fmt.Errorf("HEALTHCHECK requires at least one argument")
+	case "NONE":
+		if len(fields) > 1 || len(inst.Flags) > 0 {
+			return fmt.Errorf("HEALTHCHECK NONE takes no arguments")
+		}
+		inst.Args = []string{"NONE"}
+	case "CMD":
+		if len(fields) == 1 {
+			return fmt.Errorf("Missing command after HEALTHCHECK CMD")
+		}
+		if list, ok := parseJSON(fields[1]); ok {
+			inst.Args, inst.JSON = append([]string{"CMD"}, list...), true
+		} else {
+			inst.Args = []string{"CMD", strings.TrimSpace(fields[1])}
+		}
+	default:
+		return fmt.Errorf("Unknown type %q in HEALTHCHECK (try CMD)", strings.ToUpper(fields[0]))
+	}
+	return nil
+}
@@ -1,70 +1,344 @@
-// data/dockerfile.go
+// data/dockerfiles.go
 package data
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
 	"strings"
 )
 
-// DockerfileCommand represents a command in a Dockerfile
-type DockerfileCommand struct {
-	Instruction string
-	Arguments   string
+// Dockerfile is a parsed Dockerfile
+type Dockerfile struct {
+	Directives   map[string]string // parser directives by lower-case name, e.g. "syntax"
+	Escape       rune              // escape character, \ unless set with the escape directive
+	Instructions []*Instruction
+	Warnings     []DockerfileWarning
 }
 
-// ParseDockerfile reads and parses a Dockerfile
-func ParseDockerfile(path string) ([]DockerfileCommand, error) {
+// DockerfileWarning is a problem the parser tolerates, such as an empty
+// continuation line
+type DockerfileWarning struct {
+	Line    int
+	Message string
+}
+
+// Instruction is one instruction of a Dockerfile. Arguments are kept as
+// written: quotes are not removed and variables are not expanded, which is
+// left to the build.
+type Instruction struct {
+	Command string            // upper-case name, e.g. "COPY"
+	Flags   []InstructionFlag // --name[=value] flags before the arguments
+	// Args holds the arguments in the shape the instruction takes:
+	//   - FROM, ARG: whitespace-separated words
+	//   - RUN, CMD, ENTRYPOINT: the exec-form elements, or the whole
+	//     shell-form command line as a single element
+	//   - SHELL, COPY, ADD, VOLUME, EXPOSE: the list of elements
+	//   - ENV, LABEL: alternating keys and values
+	//   - USER, WORKDIR, STOPSIGNAL, MAINTAINER, ONBUILD: the whole text
+	//   - HEALTHCHECK: "NONE", or "CMD" followed by the command as for CMD
+	Args      []string
+	JSON      bool         // the command was written in JSON (exec) form
+	Heredocs  []Heredoc    // here-documents, in the order they appear
+	Next      *Instruction // trigger instruction of an ONBUILD
+	Original  string       // source text with line continuations joined
+	StartLine int          // first line, 1-based
+	EndLine   int          // last line, including here-document bodies
+}
+
+// InstructionFlag is a flag given to an instruction, e.g. --from=builder.
+// Boolean flags given without a value have the value "true".
+type InstructionFlag struct {
+	Name  string
+	Value string
+}
+
+// Heredoc is a here-document, e.g. the script of RUN <<EOF
+type Heredoc struct {
+	Name    string // delimiter word
+	Content string
+	Expand  bool // the delimiter was unquoted, so variables are expanded
+	Chomp   bool // <<- strips leading tabs
+}
+
+// DockerfileParseError reports a syntax error in a Dockerfile
+type DockerfileParseError struct {
+	Line    int
+	Message string
+}
+
+func (e *DockerfileParseError) Error() string {
+	return fmt.Sprintf("dockerfile parse error on line %d: %s", e.Line, e.Message)
+}
+
+// Flag returns the value of the last --name flag and whether it was given
+func (inst *Instruction) Flag(name string) (string, bool) {
+	value, found := "", false
+	for _, f := range inst.Flags {
+		if f.Name == name {
+			value, found = f.Value, true
+		}
+	}
+	return value, found
+}
+
+// FlagValues returns the values of a flag that may be repeated, e.g. --mount
+func (inst *Instruction) FlagValues(name string) []string {
+	var values []string
+	for _, f := range inst.Flags {
+		if f.Name == name {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// Stage is a build stage: a FROM instruction and the instructions up to the
+// next FROM
+type Stage struct {
+	Name         string // AS name in lower case, "" if the stage is unnamed
+	BaseName     string // image or earlier stage the stage starts from, unexpanded
+	From         *Instruction
+	Instructions []*Instruction
+}
+
+// stageNamePattern matches valid stage names
+var stageNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-_.]*$`)
+
+// Stages splits the instructions into the ARGs before the first FROM, which
+// are global to the Dockerfile, and the build stages. Stage names must be
+// valid and unique.
+func (df *Dockerfile) Stages() ([]*Instruction, []*Stage, error) {
+	var metaArgs []*Instruction
+	var stages []*Stage
+	names := make(map[string]bool)
+	for _, inst := range df.Instructions {
+		switch {
+		case inst.Command == "FROM":
+			s := &Stage{BaseName: inst.Args[0], From: inst}
+			if len(inst.Args) == 3 {
+				s.Name = strings.ToLower(inst.Args[2])
+				if !stageNamePattern.MatchString(s.Name) {
+					return nil, nil, &DockerfileParseError{Line: inst.StartLine, Message: fmt.Sprintf("invalid name for build stage: %q, name can't start with a number or contain symbols", inst.Args[2])}
+				}
+				if names[s.Name] {
+					return nil, nil, &DockerfileParseError{Line: inst.StartLine, Message: fmt.Sprintf("duplicate stage name %q", s.Name)}
+				}
+				names[s.Name] = true
+			}
+			stages = append(stages, s)
+		case len(stages) > 0:
+			s := stages[len(stages)-1]
+			s.Instructions = append(s.Instructions, inst)
+		case inst.Command == "ARG":
+			metaArgs = append(metaArgs, inst)
+		default:
+			return nil, nil, fmt.Errorf("no build stage in current context")
+		}
+	}
+	if len(stages) == 0 {
+		return nil, nil, fmt.Errorf("no build stage in current context")
+	}
+	return metaArgs, stages, nil
+}
+
+// knownDirectives lists the parser directives; others are comments
+var knownDirectives = map[string]bool{"syntax": true, "escape": true, "check": true}
+
+var directivePattern = regexp.MustCompile(`^#[ \t]*([a-zA-Z][a-zA-Z0-9]*)[ \t]*=[ \t]*(.*?)[ \t]*$`)
+
+// ParseDockerfile reads and parses the Dockerfile at path
+func ParseDockerfile(path string) (*Dockerfile, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open Dockerfile: %v", err)
 	}
 	defer file.Close()
 
-	var commands []DockerfileCommand
-	scanner := bufio.NewScanner(file)
+	return ParseDockerfileReader(file)
+}
 
-	// For handling multi-line commands
-	var currentCommand string
+// ParseDockerfileReader parses a Dockerfile following the Dockerfile
+// reference: parser directives, escape characters, line continuations,
+// comments, JSON and shell forms, flags and here-documents
+func ParseDockerfileReader(r io.Reader) (*Dockerfile, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Dockerfile: %v", err)
+	}
+	text := strings.TrimPrefix(string(content), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	p := &dockerfileParser{
+		lines: strings.Split(text, "\n"),
+		df:    &Dockerfile{Directives: map[string]string{}, Escape: '\\'},
+	}
+	if err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	p.continuation = regexp.MustCompile(regexp.QuoteMeta(string(p.df.Escape)) + `[ \t]*$`)
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+	for p.next < len(p.lines) {
+		inst, err := p.parseInstruction()
+		if err != nil {
+			return nil, err
 		}
+		if inst != nil {
+			p.df.Instructions = append(p.df.Instructions, inst)
+		}
+	}
+	return p.df, nil
+}
+
+// dockerfileParser walks the lines of a Dockerfile
+type dockerfileParser struct {
+	lines        []string
+	next         int // index of the next unread line
+	df           *Dockerfile
+	continuation *regexp.Regexp
+}
+
+// parseDirectives reads the parser directives at the top of the file. They
+// end at the first line that is not a known directive.
+func (p *dockerfileParser) parseDirectives() error {
+	for ; p.next < len(p.lines); p.next++ {
+		m := directivePattern.FindStringSubmatch(p.lines[p.next])
+		if m == nil || !knownDirectives[strings.ToLower(m[1])] {
+			return nil
+		}
+		name, value := strings.ToLower(m[1]), m[2]
+		if _, seen := p.df.Directives[name]; seen {
+			return &DockerfileParseError{Line: p.next + 1, Message: fmt.Sprintf("only one %s parser directive can be used", name)}
+		}
+		if name == "escape" {
+			if value != "`" && value != `\` {
+				return &DockerfileParseError{Line: p.next + 1, Message: fmt.Sprintf("invalid escape token '%s' does not match ` or \\", value)}
+			}
+			p.df.Escape = rune(value[0])
+		}
+		p.df.Directives[name] = value
+	}
+	return nil
+}
 
-		// Handle line continuations
-		if strings.HasSuffix(line, "\\") {
-			currentCommand += line[:len(line)-1] + " "
+// parseInstruction reads the next instruction with its continuation lines
+// and here-documents. Blank and comment lines yield nil.
+func (p *dockerfileParser) parseInstruction() (*Instruction, error) {
+	start := p.next
+	line := strings.TrimLeft(p.lines[p.next], " \t")
+	p.next++
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+
+	logical, complete := p.trimContinuation(line)
+	emptyContinuation := false
+	for !complete && p.next < len(p.lines) {
+		raw := p.lines[p.next]
+		p.next++
+		trimmed := strings.TrimLeft(raw, " \t")
+		if strings.HasPrefix(trimmed, "#") {
+			// Comments may be interleaved with continuation lines
+			continue
+		}
+		if trimmed == "" {
+			emptyContinuation = true
 			continue
 		}
+		var part string
+		part, complete = p.trimContinuation(raw)
+		logical += part
+	}
+	if emptyContinuation {
+		p.df.Warnings = append(p.df.Warnings, DockerfileWarning{
+			Line:    start + 1,
+			Message: "Empty continuation line found in: " + strings.TrimSpace(logical),
+		})
+	}
+
+	inst, err := parseInstructionLine(logical, start+1, p.df.Escape)
+	if err != nil {
+		return nil, err
+	}
 
-		// Complete the multi-line command or process single line
-		if currentCommand != "" {
-			line = currentCommand + line
-			currentCommand = ""
+	// Here-documents follow the line that introduces them
+	target := inst
+	if inst.Next != nil {
+		target = inst.Next
+	}
+	for i := range target.Heredocs {
+		if err := p.readHeredoc(&target.Heredocs[i], start+1); err != nil {
+			return nil, err
 		}
+	}
+	inst.EndLine = p.next
+	if target != inst {
+		target.EndLine = p.next
+	}
+	return inst, nil
+}
+
+// trimContinuation strips a trailing escape character, reporting whether
+// the line completes the instruction
+func (p *dockerfileParser) trimContinuation(line string) (string, bool) {
+	if loc := p.continuation.FindStringIndex(line); loc != nil {
+		return line[:loc[0]], false
+	}
+	return line, true
+}
 
-		// Parse the command
-		parts := strings.SplitN(line, " ", 2)
-		instruction := strings.ToUpper(parts[0])
-		args := ""
-		if len(parts) > 1 {
-			args = parts[1]
+// readHeredoc reads the body of h up to its delimiter line
+func (p *dockerfileParser) readHeredoc(h *Heredoc, line int) error {
+	var body strings.Builder
+	for {
+		if p.next >= len(p.lines) {
+			return &DockerfileParseError{Line: line, Message: fmt.Sprintf("unterminated heredoc %s", h.Name)}
+		}
+		text := p.lines[p.next]
+		p.next++
+		if h.Chomp {
+			text = strings.TrimLeft(text, "\t")
 		}
+		if text == h.Name {
+			h.Content = body.String()
+			return nil
+		}
+		body.WriteString(text)
+		body.WriteByte('\n')
+	}
+}
 
-		commands = append(commands, DockerfileCommand{
-			Instruction: instruction,
-			Arguments:   args,
-		})
+var commandSeparator = regexp.MustCompile(`[ \t]+`)
+
+// parseInstructionLine parses one logical instruction line
+func parseInstructionLine(logical string, line int, escape rune) (*Instruction, error) {
+	fields := commandSeparator.Split(strings.TrimSpace(logical), 2)
+	inst := &Instruction{
+		Command:   strings.ToUpper(fields[0]),
+		Original:  strings.TrimSpace(logical),
+		StartLine: line,
+		EndLine:   line,
+	}
+	rest := ""
+	if len(fields) > 1 {
+		rest = fields[1]
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading Dockerfile: %v", err)
+	parse, known := instructionParsers[inst.Command]
+	if !known {
+		return nil, &DockerfileParseError{Line: line, Message: "unknown instruction: " + fields[0]}
 	}
 
-	return commands, nil
+	flags, rest, err := extractFlags(rest, escape)
+	if err != nil {
+		return nil, &DockerfileParseError{Line: line, Message: err.Error()}
+	}
+	if inst.Flags, err = parseFlags(inst.Command, flags); err != nil {
+		return nil, &DockerfileParseError{Line: line, Message: err.Error()}
+	}
+	if err := parse(inst, rest, escape); err != nil {
+		return nil, &DockerfileParseError{Line: line, Message: err.Error()}
+	}
+	return inst, nil
 }
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, content string) *Dockerfile {
+	t.Helper()
+	df, err := ParseDockerfileReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseDockerfileReader() error = %v", err)
+	}
+	return df
+}
+
+func TestParseDockerfileInstructions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Instruction // compared on the fields set
+	}{
+		{
+			name:    "shell and exec forms",
+			content: "FROM alpine:3.20 AS base\nRUN apk add curl\nCMD [\"sh\", \"-c\", \"echo hi\"]\nENTRYPOINT /entry.sh --flag\n",
+			want: []Instruction{
+				{Command: "FROM", Args: []string{"alpine:3.20", "AS", "base"}, StartLine: 1, EndLine: 1},
+				{Command: "RUN", Args: []string{"apk add curl"}, StartLine: 2, EndLine: 2},
+				{Command: "CMD", Args: []string{"sh", "-c", "echo hi"}, JSON: true, StartLine: 3, EndLine: 3},
+				{Command: "ENTRYPOINT", Args: []string{"/entry.sh --flag"}, StartLine: 4, EndLine: 4},
+			},
+		},
+		{
+			name:    "invalid JSON falls back to shell form",
+			content: "FROM scratch\nCMD [\"sh\", 'single']\nRUN [not json\n",
+			want: []Instruction{
+				{Command: "FROM", Args: []string{"scratch"}, StartLine: 1, EndLine: 1},
+				{Command: "CMD", Args: []string{`["sh", 'single']`}, StartLine: 2, EndLine: 2},
+				{Command: "RUN", Args: []string{"[not json"}, StartLine: 3, EndLine: 3},
+			},
+		},
+		{
+			name:    "lower-case commands",
+			content: "from scratch\ncopy a b\n",
+			want: []Instruction{
+				{Command: "FROM", Args: []string{"scratch"}, Original: "from scratch", StartLine: 1, EndLine: 1},
+				{Command: "COPY", Args: []string{"a", "b"}, Original: "copy a b", StartLine: 2, EndLine: 2},
+			},
+		},
+		{
+			name:    "continuations with comments and blank lines",
+			content: "FROM scratch\n\nRUN apt-get update && \\\n    # install curl\n    apt-get install -y \\\n\n      curl\nUSER app\n",
+			want: []Instruction{
+				{Command: "FROM", StartLine: 1, EndLine: 1},
+				{Command: "RUN", Args: []string{"apt-get update &&     apt-get install -y       curl"}, StartLine: 3, EndLine: 7},
+				{Command: "USER", Args: []string{"app"}, StartLine: 8, EndLine: 8},
+			},
+		},
+		{
+			name:    "escape directive",
+			content: "# escape=`\nFROM scratch\nCOPY C:\\src C:\\dst\nRUN dir `\n  C:\\\n",
+			want: []Instruction{
+				{Command: "FROM", StartLine: 2, EndLine: 2},
+				{Command: "COPY", Args: []string{`C:\src`, `C:\dst`}, StartLine: 3, EndLine: 3},
+				{Command: "RUN", Args: []string{`dir   C:\`}, StartLine: 4, EndLine: 5},
+			},
+		},
+		{
+			name:    "flags",
+			content: "FROM --platform=linux/amd64 golang\nCOPY --from=build --chown=app:app --link /out /bin/\nRUN --mount=type=cache,target=/a --mount=type=secret,id=b make\n",
+			want: []Instruction{
+				{Command: "FROM", Flags: []InstructionFlag{{"platform", "linux/amd64"}}, Args: []string{"golang"}, StartLine: 1, EndLine: 1},
+				{Command: "COPY", Flags: []InstructionFlag{{"from", "build"}, {"chown", "app:app"}, {"link", "true"}}, Args: []string{"/out", "/bin/"}, StartLine: 2, EndLine: 2},
+				{Command: "RUN", Flags: []InstructionFlag{{"mount", "type=cache,target=/a"}, {"mount", "type=secret,id=b"}}, Args: []string{"make"}, StartLine: 3, EndLine: 3},
+			},
+		},
+		{
+			name:    "ENV and LABEL forms",
+			content: "FROM scratch\nENV A=1 B=\"two words\" C=\nENV LEGACY some value\nLABEL \"com.example.vendor\"=\"ACME\"\n",
+			want: []Instruction{
+				{Command: "FROM", StartLine: 1, EndLine: 1},
+				{Command: "ENV", Args: []string{"A", "1", "B", `"two words"`, "C", ""}, StartLine: 2, EndLine: 2},
+				{Command: "ENV", Args: []string{"LEGACY", "some value"}, StartLine: 3, EndLine: 3},
+				{Command: "LABEL", Args: []string{`"com.example.vendor"`, `"ACME"`}, StartLine: 4, EndLine: 4},
+			},
+		},
+		{
+			name:    "ONBUILD and HEALTHCHECK",
+			content: "FROM scratch\nONBUILD COPY . /app\nHEALTHCHECK --interval=5s CMD [\"curl\", \"-f\", \"localhost\"]\nHEALTHCHECK NONE\n",
+			want: []Instruction{
+				{Command: "FROM", StartLine: 1, EndLine: 1},
+				{Command: "ONBUILD", Args: []string{"COPY . /app"}, StartLine: 2, EndLine: 2},
+				{Command: "HEALTHCHECK", Flags: []InstructionFlag{{"interval", "5s"}}, Args: []string{"CMD", "curl", "-f", "localhost"}, JSON: true, StartLine: 3, EndLine: 3},
+				{Command: "HEALTHCHECK", Args: []string{"NONE"}, StartLine: 4, EndLine: 4},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			df := parse(t, tt.content)
+			if len(df.Instructions) != len(tt.want) {
+				t.Fatalf("got %d instructions, want %d", len(df.Instructions), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := df.Instructions[i]
+				if got.Command != want.Command || got.StartLine != want.StartLine || got.EndLine != want.EndLine || got.JSON != want.JSON {
+					t.Errorf("instruction %d = %s lines %d-%d json %v, want %s lines %d-%d json %v", i,
+						got.Command, got.StartLine, got.EndLine, got.JSON, want.Command, want.StartLine, want.EndLine, want.JSON)
+				}
+				if want.Args != nil && !reflect.DeepEqual(got.Args, want.Args) {
+					t.Errorf("instruction %d args = %q, want %q", i, got.Args, want.Args)
+				}
+				if !reflect.DeepEqual(got.Flags, want.Flags) {
+					t.Errorf("instruction %d flags = %v, want %v", i, got.Flags, want.Flags)
+				}
+				if want.Original != "" && got.Original != want.Original {
+					t.Errorf("instruction %d original = %q, want %q", i, got.Original, want.Original)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDockerfileDirectives(t *testing.T) {
+	df := parse(t, "# syntax=docker/dockerfile:1\n#  Escape = `\n# check=skip=LatestTag\nFROM scratch\n")
+	want := map[string]string{"syntax": "docker/dockerfile:1", "escape": "`", "check": "skip=LatestTag"}
+	if !reflect.DeepEqual(df.Directives, want) {
+		t.Errorf("directives = %v, want %v", df.Directives, want)
+	}
+	if df.Escape != '`' {
+		t.Errorf("escape = %q, want '`'", df.Escape)
+	}
+
+	// Directives end at the first other line, even a comment
+	df = parse(t, "# a comment\n# escape=`\nFROM scratch\n")
+	if len(df.Directives) != 0 || df.Escape != '\\' {
+		t.Errorf("directive after a comment was applied: %v", df.Directives)
+	}
+}
+
+func TestParseDockerfileHeredocs(t *testing.T) {
+	content := "FROM scratch\n" +
+		"RUN <<EOF\necho $HOME\nEOF\n" +
+		"COPY <<'CONF' <<-TABS /etc/\nkey=$value\nCONF\n\tindented\n\tTABS\n" +
+		"RUN cat <<A && cat <<\"B\"\na\nA\nb\nB\n" +
+		"USER app\n"
+	df := parse(t, content)
+	tests := []struct {
+		index      int
+		start, end int
+		heredocs   []Heredoc
+	}{
+		{1, 2, 4, []Heredoc{{Name: "EOF", Content: "echo $HOME\n", Expand: true}}},
+		{2, 5, 9, []Heredoc{
+			{Name: "CONF", Content: "key=$value\n"},
+			{Name: "TABS", Content: "indented\n", Expand: true, Chomp: true},
+		}},
+		{3, 10, 14, []Heredoc{{Name: "A", Content: "a\n", Expand: true}, {Name: "B", Content: "b\n"}}},
+		{4, 15, 15, nil},
+	}
+	for _, tt := range tests {
+		inst := df.Instructions[tt.index]
+		if inst.StartLine != tt.start || inst.EndLine != tt.end {
+			t.Errorf("%s lines %d-%d, want %d-%d", inst.Command, inst.StartLine, inst.EndLine, tt.start, tt.end)
+		}
+		if !reflect.DeepEqual(inst.Heredocs, tt.heredocs) {
+			t.Errorf("%s heredocs = %+v, want %+v", inst.Command, inst.Heredocs, tt.heredocs)
+		}
+	}
+}
+
+func TestParseDockerfileWarnings(t *testing.T) {
+	df := parse(t, "FROM scratch\nRUN a \\\n\n  b\n")
+	if len(df.Warnings) != 1 || df.Warnings[0].Line != 2 || !strings.HasPrefix(df.Warnings[0].Message, "Empty continuation line") {
+		t.Errorf("warnings = %+v", df.Warnings)
+	}
+}
+
+func TestParseDockerfileErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		line    int
+		message string
+	}{
+		{"FROM scratch\nFOO bar\n", 2, "unknown instruction: FOO"},
+		{"FROM a b\n", 1, "FROM requires either one or three arguments"},
+		{"FROM scratch\nCOPY onlyone\n", 2, "COPY requires at least two arguments, but only one was provided. Destination could not be determined."},
+		{"FROM scratch\nCOPY --bogus a b\n", 2, "unknown flag: --bogus"},
+		{"FROM scratch\nCOPY --from=a --from=b x y\n", 2, "duplicate flag specified: --from"},
+		{"FROM scratch\nCOPY --from a b\n", 2, "missing a value on flag: --from"},
+		{"FROM scratch\nCOPY --link=maybe a b\n", 2, "expecting boolean value for flag --link, not: maybe"},
+		{"FROM scratch\nENV A=1 B\n", 2, `Syntax error - can't find = in "B". Must be of the form: name=value`},
+		{"FROM scratch\nENV\n", 2, "ENV requires at least one argument"},
+		{"FROM scratch\nSHELL /bin/bash -c\n", 2, "SHELL requires the arguments to be in JSON form"},
+		{"FROM scratch\nONBUILD ONBUILD RUN x\n", 2, "Chaining ONBUILD via `ONBUILD ONBUILD` isn't allowed"},
+		{"FROM scratch\nHEALTHCHECK NONE --x\n", 2, "HEALTHCHECK NONE takes no arguments"},
+		{"FROM scratch\nHEALTHCHECK RUN x\n", 2, `Unknown type "RUN" in HEALTHCHECK (try CMD)`},
+		{"FROM scratch\n\nRUN <<EOF\necho\n", 3, "unterminated heredoc EOF"},
+		{"# escape=x\nFROM scratch\n", 1, "invalid escape token 'x' does not match ` or \\"},
+		{"# escape=`\n# escape=\\\nFROM scratch\n", 2, "only one escape parser directive can be used"},
+	}
+	for _, tt := range tests {
+		_, err := ParseDockerfileReader(strings.NewReader(tt.content))
+		var parseErr *DockerfileParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%q: error = %v, want a parse error", tt.content, err)
+			continue
+		}
+		if parseErr.Line != tt.line || parseErr.Message != tt.message {
+			t.Errorf("%q: error on line %d %q, want line %d %q", tt.content, parseErr.Line, parseErr.Message, tt.line, tt.message)
+		}
+	}
+}
+
+func TestDockerfileStages(t *testing.T) {
+	df := parse(t, "ARG BASE=alpine\nFROM $BASE AS Build\nRUN make\nFROM scratch\nCOPY --from=build /out /\n")
+	metaArgs, stages, err := df.Stages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metaArgs) != 1 || metaArgs[0].Args[0] != "BASE=alpine" {
+		t.Errorf("meta args = %+v", metaArgs)
+	}
+	if len(stages) != 2 || stages[0].Name != "build" || stages[0].BaseName != "$BASE" || len(stages[0].Instructions) != 1 ||
+		stages[1].Name != "" || stages[1].BaseName != "scratch" || len(stages[1].Instructions) != 1 {
+		t.Errorf("stages = %+v %+v", stages[0], stages[1])
+	}
+
+	for _, content := range []string{
+		"FROM a AS x\nFROM b AS X\n",
+		"FROM a AS 1st\n",
+		"RUN x\nFROM a\n",
+		"ARG A\n",
+	} {
+		if _, _, err := parse(t, content).Stages(); err == nil {
+			t.Errorf("Stages() of %q succeeded, want an error", content)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// data/history.go
+package data
+
+import (
+	"prepare.sh/dockermock/oci"
+)
+
+// HistoryEntry is one step of how an image was made, with the size of the
+// layer it created
+type HistoryEntry struct {
+	oci.History
+	Size int64 // zero for steps without a layer
+}
+
+// ImageHistory returns the steps img was made of, oldest first. Each step
+// creating a layer gets the size of the next layer of the image; layers
+// the history does not describe get steps of their own.
+func (im *ImageManager) ImageHistory(img *Image) ([]HistoryEntry, error) {
+	if img.Manifest == "" {
+		// Images recorded before content was stored only know their size
+		created := img.Created
+		return []HistoryEntry{{History: oci.History{Created: &created}, Size: img.Size}}, nil
+	}
+	content, err := im.ImageContent(img)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []HistoryEntry
+	layer := 0
+	for _, h := range content.Config.History {
+		entry := HistoryEntry{History: h}
+		if !h.EmptyLayer && layer < len(content.Manifest.Layers) {
+			entry.Size = content.LayerSize(layer)
+			layer++
+		}
+		entries = append(entries, entry)
+	}
+	for ; layer < len(content.Manifest.Layers); layer++ {
+		entries = append(entries, HistoryEntry{Size: content.LayerSize(layer)})
+	}
+	return entries, nil
+}
@@ -0,0 +1,89 @@
+// data/ids.go
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DigestPrefix marks content-addressable IDs in their long form
+const DigestPrefix = "sha256:"
+
+// AmbiguousIDError reports an ID prefix that matches several objects
+type AmbiguousIDError struct {
+	Prefix string
+}
+
+func (e *AmbiguousIDError) Error() string {
+	return fmt.Sprintf("multiple IDs found with provided prefix: %s", e.Prefix)
+}
+
+// generateID returns a random 64-character hex ID. Like Docker, it avoids
+// IDs whose 12-character short form is all digits, which would be mistaken
+// for a number on the command line.
+func generateID() string {
+	b := make([]byte, 32)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(fmt.Sprintf("failed to read random bytes: %v", err))
+		}
+		id := hex.EncodeToString(b)
+		if _, err := strconv.ParseUint(id[:12], 10, 64); err != nil {
+			return id
+		}
+	}
+}
+
+// contentID returns the hex sha256 of content, the way image IDs are the
+// digest of the image config
+func contentID(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// pulledImageConfig is the content a pulled image's ID is derived from, so
+// pulling the same reference always yields the same ID
+type pulledImageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Digest       string `json:"digest"`
+}
+
+// pulledImageID returns the stable ID of the image a registry serves under
+// digest
+func pulledImageID(digest string) string {
+	config, _ := json.Marshal(pulledImageConfig{
+		Architecture: "amd64",
+		OS:           "linux",
+		Digest:       digest,
+	})
+	return contentID(config)
+}
+
+// isFullID reports whether s is a full 64-character hex ID
+func isFullID(s string) bool {
+	return len(s) == 64 && isIDPrefix(s)
+}
+
+// isIDPrefix reports whether s could be a prefix of a hex ID
+func isIDPrefix(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// TrimDigestPrefix strips a leading "sha256:" from an ID
+func TrimDigestPrefix(id string) string {
+	return strings.TrimPrefix(id, DigestPrefix)
+}
@@ -0,0 +1,225 @@
+// data/image_delete.go
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	"prepare.sh/dockermock/reference"
+)
+
+// RemoveImageOptions controls RemoveImage
+type RemoveImageOptions struct {
+	Force      bool         // remove soft conflicts: stopped containers and other tags
+	NoPrune    bool         // keep untagged parent images
+	Containers []*Container // containers to check for image usage
+}
+
+// Conflicts checked before an image is deleted. Hard conflicts cannot be
+// forced.
+const (
+	conflictDependentChild = 1 << iota
+	conflictRunningContainer
+	conflictActiveReference
+	conflictStoppedContainer
+
+	conflictHard = conflictDependentChild | conflictRunningContainer
+	conflictSoft = conflictActiveReference | conflictStoppedContainer
+)
+
+// ImageConflictError reports why an image cannot be deleted
+type ImageConflictError struct {
+	ID      string
+	Message string
+	Hard    bool
+
+	used bool // the conflict is a container using the image
+}
+
+func (e *ImageConflictError) Error() string {
+	forced := "must be forced"
+	if e.Hard {
+		forced = "cannot be forced"
+	}
+	return fmt.Sprintf("conflict: unable to delete %s (%s) - %s", e.ID[:12], forced, e.Message)
+}
+
+// RemoveImage removes ref the way `docker rmi` does. A tag is untagged, and
+// the image is only deleted once its last tag is gone; an ID deletes the
+// image with all its tags. Images used by containers, tagged in several
+// repositories or with child images are refused unless the conflict can be
+// forced. Deleting an image also deletes its untagged parents unless
+// NoPrune is set. The steps taken are returned in order.
+func (im *ImageManager) RemoveImage(ref string, opts RemoveImageOptions) ([]ImageDelete, error) {
+	var records []ImageDelete
+	err := im.updateTx(func(tx Tx) error {
+		records = nil
+		img, err := im.resolve(ref)
+		if err != nil {
+			return err
+		}
+
+		removedRef := false
+		id := TrimDigestPrefix(ref)
+		if !isIDPrefix(id) || !strings.HasPrefix(img.ID, id) {
+			// A reference was given: untag it, which completes the removal
+			// unless it was the last one. Untagging the last reference of
+			// an image a container uses would leave it dangling, so that
+			// must be forced.
+			named, err := reference.ParseNormalizedTagged(ref)
+			if err != nil {
+				return err
+			}
+			if !opts.Force && singleRepository(img.references()) {
+				if c := im.firstUser(img, opts.Containers, false); c != nil {
+					return fmt.Errorf("conflict: unable to remove repository reference %q (must force) - container %s is using its referenced image %s", ref, c.ID[:12], img.ID[:12])
+				}
+			}
+			name := named.FamiliarName()
+			if named.Digest != "" {
+				img.removeDigest(name + "@" + named.Digest)
+				records = append(records, ImageDelete{Untagged: name + "@" + named.Digest})
+			} else {
+				img.removeTag(named.FamiliarString())
+				records = append(records, ImageDelete{Untagged: named.FamiliarString()})
+
+				// Digest references are dropped with the last tag of their
+				// repository
+				if img.DigestFor(name) != "" && !img.taggedIn(name) {
+					repoDigest := name + "@" + img.DigestFor(name)
+					img.removeDigest(repoDigest)
+					records = append(records, ImageDelete{Untagged: repoDigest})
+				}
+			}
+			if !img.IsDangling() {
+				return nil
+			}
+			removedRef = true
+		} else if singleRepository(img.references()) {
+			// An ID with references in a single repository removes them all
+			mask := conflictHard
+			if !opts.Force {
+				mask |= conflictSoft &^ conflictActiveReference
+			}
+			if conflict := im.deleteConflict(img, opts.Containers, mask); conflict != nil {
+				return conflict
+			}
+			for _, r := range img.references() {
+				records = append(records, ImageDelete{Untagged: r})
+			}
+			img.RepoTags, img.RepoDigests = nil, nil
+		}
+
+		if err := im.deleteImage(img, opts, !opts.NoPrune, removedRef, &records); err != nil {
+			return err
+		}
+		return im.pruneContent(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// deleteImage deletes img and its remaining tags, then prunes its parent if
+// it is untagged and unused. Conflicts are silently ignored when quiet,
+// unless the image is dangling and not used by a container. Callers hold
+// im.mu.
+func (im *ImageManager) deleteImage(img *Image, opts RemoveImageOptions, prune, quiet bool, records *[]ImageDelete) error {
+	mask := conflictHard
+	if !opts.Force {
+		mask |= conflictSoft
+	}
+	if conflict := im.deleteConflict(img, opts.Containers, mask); conflict != nil {
+		if quiet && (!img.IsDangling() || conflict.used) {
+			return nil
+		}
+		return conflict
+	}
+
+	for _, r := range img.references() {
+		*records = append(*records, ImageDelete{Untagged: r})
+	}
+	delete(im.images, img.ID)
+	*records = append(*records, ImageDelete{Deleted: DigestPrefix + img.ID})
+
+	parent := im.images[img.Parent]
+	if !prune || parent == nil {
+		return nil
+	}
+	// Parents are pruned quietly and never forced
+	return im.deleteImage(parent, RemoveImageOptions{Containers: opts.Containers}, true, true, records)
+}
+
+// deleteConflict returns the first conflict in mask that prevents deleting
+// img, or nil. Callers hold im.mu.
+func (im *ImageManager) deleteConflict(img *Image, containers []*Container, mask int) *ImageConflictError {
+	if mask&conflictDependentChild != 0 {
+		for _, other := range im.images {
+			if other.Parent == img.ID {
+				return &ImageConflictError{ID: img.ID, Message: "image has dependent child images", Hard: true}
+			}
+		}
+	}
+	if mask&conflictRunningContainer != 0 {
+		if c := im.firstUser(img, containers, true); c != nil {
+			return &ImageConflictError{ID: img.ID, Message: "image is being used by running container " + c.ID[:12], Hard: true, used: true}
+		}
+	}
+	if mask&conflictActiveReference != 0 && !img.IsDangling() {
+		return &ImageConflictError{ID: img.ID, Message: "image is referenced in multiple repositories"}
+	}
+	if mask&conflictStoppedContainer != 0 {
+		if c := im.firstUser(img, containers, false); c != nil {
+			return &ImageConflictError{ID: img.ID, Message: "image is being used by stopped container " + c.ID[:12], used: true}
+		}
+	}
+	return nil
+}
+
+// firstUser returns a container created from img, only considering running
+// ones if running is set. Callers hold im.mu.
+func (im *ImageManager) firstUser(img *Image, containers []*Container, running bool) *Container {
+	for _, c := range containers {
+		if running && !c.IsRunning() {
+			continue
+		}
+		imageID := c.ImageID
+		if imageID == "" {
+			// Containers created before image IDs were recorded
+			if used, err := im.resolve(c.Image); err == nil {
+				imageID = used.ID
+			}
+		}
+		if imageID == img.ID {
+			return c
+		}
+	}
+	return nil
+}
+
+// references returns the tag and digest references of img
+func (img *Image) references() []string {
+	return append(append([]string{}, img.RepoTags...), img.RepoDigests...)
+}
+
+// taggedIn reports whether img has a tag in repository
+func (img *Image) taggedIn(repository string) bool {
+	for _, t := range img.RepoTags {
+		if name, _ := SplitRepoTag(t); name == repository {
+			return true
+		}
+	}
+	return false
+}
+
+// singleRepository reports whether all refs belong to one repository
+func singleRepository(refs []string) bool {
+	for _, r := range refs {
+		name, _ := SplitRepoTag(r)
+		if first, _ := SplitRepoTag(refs[0]); name != first {
+			return false
+		}
+	}
+	return true
+}
@@ -4,79 +4,216 @@ package data
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
-	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
 )
 
-// Image represents a mock Docker image
+// Image represents a mock Docker image. One image may be known under many
+// references; images without any are dangling. References are stored in
+// their familiar form, e.g. "ubuntu:22.04" rather than
+// "docker.io/library/ubuntu:22.04".
 type Image struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	Tag  string `json:"tag"`
+	ID          string            `json:"id"`
+	RepoTags    []string          `json:"repoTags,omitempty"`    // name:tag references, sorted
+	RepoDigests []string          `json:"repoDigests,omitempty"` // name@digest references, sorted
+	Digest      string            `json:"digest,omitempty"`      // registry digest of the manifest
+	Manifest    string            `json:"manifest,omitempty"`    // digest of the stored manifest
+	Parent      string            `json:"parent,omitempty"`
+	Created     time.Time         `json:"created"`
+	Size        int64             `json:"size"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// ImageDelete records one step of an image removal, either an untagged
+// reference or a deleted image ID, as reported by `docker rmi`
+type ImageDelete struct {
+	Untagged string `json:"Untagged,omitempty"`
+	Deleted  string `json:"Deleted,omitempty"`
+}
+
+// NoSuchImageError reports an unknown image reference
+type NoSuchImageError struct {
+	Reference string
+}
+
+func (e *NoSuchImageError) Error() string {
+	return fmt.Sprintf("No such image: %s", e.Reference)
+}
+
+// IsDangling reports whether the image has no references
+func (img *Image) IsDangling() bool {
+	return len(img.RepoTags) == 0 && len(img.RepoDigests) == 0
+}
+
+// HasTag reports whether repoTag, in name:tag form, refers to the image
+func (img *Image) HasTag(repoTag string) bool {
+	return contains(img.RepoTags, repoTag)
+}
+
+// addTag adds repoTag to the image's references
+func (img *Image) addTag(repoTag string) {
+	img.RepoTags = addString(img.RepoTags, repoTag)
+}
+
+// removeTag drops repoTag from the image's references
+func (img *Image) removeTag(repoTag string) {
+	img.RepoTags = removeString(img.RepoTags, repoTag)
+}
+
+// addDigest adds a name@digest reference to the image
+func (img *Image) addDigest(repoDigest string) {
+	img.RepoDigests = addString(img.RepoDigests, repoDigest)
+}
+
+// removeDigest drops a name@digest reference from the image
+func (img *Image) removeDigest(repoDigest string) {
+	img.RepoDigests = removeString(img.RepoDigests, repoDigest)
+}
+
+// DigestFor returns the digest the image is known under in repository, or
+// an empty string
+func (img *Image) DigestFor(repository string) string {
+	for _, d := range img.RepoDigests {
+		if i := strings.LastIndex(d, "@"); d[:i] == repository {
+			return d[i+1:]
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// addString adds s to the sorted set list
+func addString(list []string, s string) []string {
+	if contains(list, s) {
+		return list
+	}
+	list = append(list, s)
+	sort.Strings(list)
+	return list
+}
+
+// removeString drops s from the set list, returning nil once it is empty
+func removeString(list []string, s string) []string {
+	kept := list[:0]
+	for _, v := range list {
+		if v != s {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return kept
+}
+
+// SplitRepoTag splits a name[:tag][@digest] reference, defaulting the tag
+// to latest
+func SplitRepoTag(ref string) (name, tag string) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// RepoTag joins a name and tag into a name:tag reference
+func RepoTag(name, tag string) string {
+	if tag == "" {
+		tag = "latest"
+	}
+	return name + ":" + tag
 }
 
 // ImageManager manages mock images
 type ImageManager struct {
-	images  map[string]*Image
-	counter int
-	mu      sync.Mutex
+	store  Store
+	images map[string]*Image
+	mu     sync.Mutex
 }
 
-// NewImageManager initializes an ImageManager with persisted data
-func NewImageManager() *ImageManager {
+// NewImageManager initializes an ImageManager with data persisted in store
+func NewImageManager(store Store) (*ImageManager, error) {
 	im := &ImageManager{
-		images:  make(map[string]*Image),
-		counter: 1,
+		store:  store,
+		images: make(map[string]*Image),
 	}
 	if err := im.Load(); err != nil {
-		fmt.Println("Warning: Unable to load images data:", err)
+		return nil, err
 	}
-	return im
+	return im, nil
 }
 
-// Load reads images data from the JSON file
+// Load reads images data from the store, upgrading it in place if it was
+// written with an older schema
 func (im *ImageManager) Load() error {
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
-	path := GetImagesFilePath()
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	return im.store.Update(im.load)
+}
+
+// Save writes images data to the store
+func (im *ImageManager) Save() error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	return im.store.Update(im.save)
+}
+
+// load replaces the in-memory state with the stored document. Callers hold
+// im.mu.
+func (im *ImageManager) load(tx Tx) error {
+	im.images = make(map[string]*Image)
+
+	data, err := tx.Get(ImagesFile)
+	if err != nil {
+		return err
+	}
+	if data == nil {
 		// No data to load
 		return nil
 	}
 
-	data, err := ioutil.ReadFile(path)
+	var state imagesState
+	version, err := decodeState(data, "images", imageMigrations, &state)
 	if err != nil {
-		return err
+		return &CorruptStateError{Key: ImagesFile, Err: err}
 	}
 
-	var images []*Image
-	if err := json.Unmarshal(data, &images); err != nil {
-		return err
+	for _, img := range state.Images {
+		im.images[img.ID] = img
 	}
 
-	for _, img := range images {
-		im.images[img.ID] = img
-		// Update counter based on existing IDs
-		var idNum int
-		_, err := fmt.Sscanf(img.ID, "i%d", &idNum)
-		if err == nil && idNum >= im.counter {
-			im.counter = idNum + 1
+	if version < ImagesSchemaVersion {
+		if err := backupState(tx, ImagesFile, data, version); err != nil {
+			return err
+		}
+		if err := im.synthesizeContent(tx); err != nil {
+			return err
 		}
+		return im.save(tx)
 	}
-
 	return nil
 }
 
-// Save writes images data to the JSON file
-func (im *ImageManager) Save() error {
-	im.mu.Lock()
-	defer im.mu.Unlock()
-
+// save replaces the stored document with the in-memory state. Callers hold
+// im.mu.
+func (im *ImageManager) save(tx Tx) error {
 	// Create slice of images
 	images := make([]*Image, 0, len(im.images))
 	for _, img := range im.images {
@@ -84,221 +221,264 @@ func (im *ImageManager) Save() error {
 	}
 
 	// Marshal the data
-	data, err := json.MarshalIndent(images, "", "  ")
+	data, err := json.MarshalIndent(imagesState{
+		Version: ImagesSchemaVersion,
+		Images:  images,
+	}, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal images: %v", err)
 	}
 
-	// Get the file path
-	filePath := GetImagesFilePath()
-	log.Println(filePath)
-
-	// Ensure the directory exists
-	dir := filepath.Dir(filePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
-	}
-
-	// Write the file
-	if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
-	}
+	return tx.Put(ImagesFile, data)
+}
 
-	fmt.Printf("Successfully saved %d images to %s\n", len(images), filePath)
-	return nil
+// update runs fn against the latest stored state inside a store transaction,
+// then persists the result. Other CLI processes block until it completes.
+func (im *ImageManager) update(fn func() error) error {
+	return im.updateTx(func(Tx) error {
+		return fn()
+	})
 }
 
-// PullImage simulates pulling an image
-func (im *ImageManager) PullImage(name, tag string) *Image {
+// updateTx is update for changes that also write image content in the same
+// transaction
+func (im *ImageManager) updateTx(fn func(tx Tx) error) error {
 	im.mu.Lock()
+	defer im.mu.Unlock()
 
-	// Check if image already exists
+	return im.store.Update(func(tx Tx) error {
+		if err := im.load(tx); err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return im.save(tx)
+	})
+}
+
+// find looks up the image a name:tag reference points to. Callers hold
+// im.mu.
+func (im *ImageManager) find(repoTag string) *Image {
 	for _, img := range im.images {
-		if img.Name == name && img.Tag == tag {
-			im.mu.Unlock() // Release lock before returning
-			fmt.Printf("Image %s:%s already exists\n", name, tag)
+		if img.HasTag(repoTag) {
 			return img
 		}
 	}
+	return nil
+}
 
-	// Simulate download progress
-	fmt.Printf("Pulling image %s:%s\n", name, tag)
-	for i := 0; i <= 100; i += 10 {
-		fmt.Printf("Download progress: %d%%\n", i)
-		time.Sleep(100 * time.Millisecond)
+// findRef looks up the image a parsed reference points to, by digest when
+// it has one and by tag otherwise. Callers hold im.mu.
+func (im *ImageManager) findRef(ref reference.Reference) *Image {
+	if ref.Digest == "" {
+		return im.find(ref.FamiliarString())
 	}
-
-	id := fmt.Sprintf("i%03d", im.counter)
-	im.counter++
-	image := &Image{
-		ID:   id,
-		Name: name,
-		Tag:  tag,
+	repoDigest := ref.FamiliarName() + "@" + ref.Digest
+	for _, img := range im.images {
+		if contains(img.RepoDigests, repoDigest) {
+			return img
+		}
 	}
-	im.images[id] = image
-
-	// Release lock before saving
-	im.mu.Unlock()
+	return nil
+}
 
-	// Save after releasing the lock
-	if err := im.Save(); err != nil {
-		fmt.Printf("Warning: Failed to save image data: %v\n", err)
+// tag points repoTag at img, moving it off any image it referred to before.
+// Callers hold im.mu.
+func (im *ImageManager) tag(img *Image, repoTag string) {
+	if previous := im.find(repoTag); previous != nil && previous != img {
+		previous.removeTag(repoTag)
 	}
-
-	fmt.Printf("Successfully pulled %s:%s\n", name, tag)
-	return image
+	img.addTag(repoTag)
 }
 
-// PushImage simulates pushing an image
-func (im *ImageManager) PushImage(name, tag string) bool {
-	im.mu.Lock()
-	defer im.mu.Unlock()
+// resolve looks up an image by full or partial ID (with or without the
+// sha256: prefix) or by reference. Callers hold im.mu.
+func (im *ImageManager) resolve(ref string) (*Image, error) {
+	id := TrimDigestPrefix(ref)
+	if img, exists := im.images[id]; exists {
+		return img, nil
+	}
+	if !strings.HasPrefix(ref, DigestPrefix) {
+		if named, err := reference.ParseNormalizedTagged(ref); err == nil {
+			if img := im.findRef(named); img != nil {
+				return img, nil
+			}
+		}
+	}
 
-	for _, img := range im.images {
-		if img.Name == name && img.Tag == tag {
-			return true
+	if isIDPrefix(id) {
+		var match *Image
+		for imageID, img := range im.images {
+			if strings.HasPrefix(imageID, id) {
+				if match != nil {
+					return nil, &AmbiguousIDError{Prefix: ref}
+				}
+				match = img
+			}
+		}
+		if match != nil {
+			return match, nil
 		}
 	}
-	return false
+	return nil, &NoSuchImageError{Reference: ref}
 }
 
-// ListImages lists all images
-func (im *ImageManager) ListImages() []*Image {
+// ResolveImage retrieves an image by name[:tag] or unique ID prefix,
+// reporting unknown and ambiguous references as errors
+func (im *ImageManager) ResolveImage(ref string) (*Image, error) {
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
-	list := []*Image{}
-	for _, img := range im.images {
-		list = append(list, img)
-	}
-	return list
+	return im.resolve(ref)
 }
 
-// RemoveImage removes an image
-func (im *ImageManager) RemoveImage(identifier string) bool {
-	im.mu.Lock()
+// PulledLayer reports one layer of a simulated pull
+type PulledLayer struct {
+	Digest string
+	Size   int64 // size the layer adds to the image
+	Exists bool  // the layer is already stored locally
+}
 
-	var found bool
-	for id, img := range im.images {
-		if img.ID == identifier || img.Name == identifier {
-			delete(im.images, id)
-			found = true
-			break
-		}
+// PullImage simulates pulling an image by tag or digest. The image is
+// synthesized from the reference, so pulling the same reference always
+// yields the same image. progress is called for each layer unless the image
+// is already up to date, which is reported as false. Pulling by digest
+// records the digest reference without tagging the image.
+func (im *ImageManager) PullImage(ref reference.Reference, progress func(PulledLayer)) (*Image, bool, error) {
+	name := ref.FamiliarName()
+	config, layers, err := syntheticImage(name, ref.FamiliarString(), syntheticImageSize(name))
+	if err != nil {
+		return nil, false, err
 	}
-
-	im.mu.Unlock()
-
-	if found {
-		im.Save()
-		return true
+	rawConfig, err := encodeConfig(config, layers)
+	if err != nil {
+		return nil, false, err
 	}
-	return false
-}
+	id := contentID(rawConfig)
 
-// BuildImage creates a new image from a build context
-func (im *ImageManager) BuildImage(name, tag string) *Image {
 	im.mu.Lock()
-
-	// Check if image already exists
-	for _, img := range im.images {
-		if img.Name == name && img.Tag == tag {
-			im.mu.Unlock()
-			fmt.Printf("Image %s:%s already exists\n", name, tag)
-			return img
-		}
+	current := im.findRef(ref)
+	im.mu.Unlock()
+	if current != nil && current.ID == id {
+		return current, false, nil
 	}
 
-	// Simulate build process
-	fmt.Printf("Building image %s:%s\n", name, tag)
-
-	// Generate new image ID
-	id := fmt.Sprintf("i%03d", im.counter)
-	im.counter++
-
-	// Create new image
-	image := &Image{
-		ID:   id,
-		Name: name,
-		Tag:  tag,
+	// Report progress without holding any locks
+	for _, l := range layers {
+		digest := oci.Digest(l.Blob)
+		progress(PulledLayer{Digest: digest, Size: l.Size, Exists: im.hasBlob(digest)})
 	}
-	im.images[id] = image
 
-	// Release lock before saving
-	im.mu.Unlock()
-
-	// Save after releasing the lock
-	if err := im.Save(); err != nil {
-		fmt.Printf("Warning: Failed to save image data: %v\n", err)
+	var image *Image
+	err = im.updateTx(func(tx Tx) error {
+		image = im.images[id]
+		if image == nil {
+			image = &Image{ID: id, Created: *config.Created}
+			im.images[id] = image
+			if err := storeContent(tx, image, rawConfig, layers); err != nil {
+				return err
+			}
+		}
+		digest := ref.Digest
+		if digest == "" {
+			digest = image.Manifest
+		}
+		image.Digest = digest
+		image.addDigest(name + "@" + digest)
+		if ref.Digest == "" {
+			im.tag(image, ref.FamiliarString())
+		}
 		return nil
+	})
+	if err != nil {
+		return nil, false, err
 	}
-
-	fmt.Printf("Successfully built %s:%s with ID %s\n", name, tag, id)
-	return image
+	return image, true, nil
 }
 
-// Optional: Add a method to check if a base image exists
-func (im *ImageManager) HasImage(name, tag string) bool {
+// PushImage simulates pushing an image
+func (im *ImageManager) PushImage(ref reference.Reference) bool {
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
-	for _, img := range im.images {
-		if img.Name == name && (img.Tag == tag || tag == "latest") {
-			return true
-		}
-	}
-	return false
+	return im.findRef(ref) != nil
 }
 
-// Optional: Add a method to get image by name and tag
-func (im *ImageManager) GetImage(name, tag string) *Image {
+// ListImages lists all images
+func (im *ImageManager) ListImages() []*Image {
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
+	list := []*Image{}
 	for _, img := range im.images {
-		if img.Name == name && (img.Tag == tag || tag == "latest") {
-			return img
-		}
+		list = append(list, img)
 	}
-	return nil
+	return list
 }
 
-// TagImage creates a new tag for an existing image
-func (im *ImageManager) TagImage(sourceName, sourceTag, targetName, targetTag string) bool {
-	im.mu.Lock()
-
-	// Find the source image
-	var sourceImage *Image
-	for _, img := range im.images {
-		if img.Name == sourceName && img.Tag == sourceTag {
-			sourceImage = img
-			break
-		}
+// BuildImage records an image built from config and layers and points refs
+// to it; without refs the image is left untagged. The ID is the digest of
+// the config, so identical builds share an image ID.
+func (im *ImageManager) BuildImage(config oci.Image, layers []Layer, refs ...reference.Reference) (*Image, error) {
+	rawConfig, err := encodeConfig(config, layers)
+	if err != nil {
+		return nil, err
 	}
-
-	if sourceImage == nil {
-		im.mu.Unlock()
-		return false
+	created := time.Now().UTC()
+	if config.Created != nil {
+		created = config.Created.UTC()
 	}
 
-	// Create new image with the target name/tag but same ID
-	newImage := &Image{
-		ID:   sourceImage.ID, // Use same ID as source image
-		Name: targetName,
-		Tag:  targetTag,
+	var image *Image
+	err = im.updateTx(func(tx Tx) error {
+		id := contentID(rawConfig)
+		image = im.images[id]
+		if image == nil {
+			image = &Image{
+				ID:      id,
+				Created: created,
+				Labels:  config.Config.Labels,
+			}
+			im.images[id] = image
+			if err := storeContent(tx, image, rawConfig, layers); err != nil {
+				return err
+			}
+		}
+		// A rebuild moves the tags; the previous image may be left dangling
+		for _, ref := range refs {
+			im.tag(image, ref.FamiliarString())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return image, nil
+}
 
-	// Add to images map
-	im.images[newImage.ID] = newImage
+// Optional: Add a method to check if a base image exists
+func (im *ImageManager) HasImage(name, tag string) bool {
+	return im.GetImage(name, tag) != nil
+}
 
-	// Release lock before saving
-	im.mu.Unlock()
+// Optional: Add a method to get image by name and tag
+func (im *ImageManager) GetImage(name, tag string) *Image {
+	im.mu.Lock()
+	defer im.mu.Unlock()
 
-	// Save after releasing the lock
-	if err := im.Save(); err != nil {
-		fmt.Printf("Warning: Failed to save image data: %v\n", err)
-		return false
-	}
+	return im.find(RepoTag(name, tag))
+}
 
-	return true
+// TagImage makes the tagged reference target refer to the image source
+// resolves to. Other tags of the image are kept.
+func (im *ImageManager) TagImage(source string, target reference.Reference) error {
+	return im.update(func() error {
+		img, err := im.resolve(source)
+		if err != nil {
+			return err
+		}
+		im.tag(img, target.FamiliarString())
+		return nil
+	})
 }
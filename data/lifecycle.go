@@ -0,0 +1,193 @@
+// data/lifecycle.go
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// Container states, matching Docker's State.Status values
+const (
+	StateCreated    = "created"
+	StateRunning    = "running"
+	StatePaused     = "paused"
+	StateRestarting = "restarting"
+	StateRemoving   = "removing"
+	StateExited     = "exited"
+	StateDead       = "dead"
+)
+
+// Exit codes recorded when the mock stops or kills a container
+const (
+	ExitCodeStopped = 0   // the process handled SIGTERM
+	ExitCodeKilled  = 137 // 128 + SIGKILL
+)
+
+// transitions lists the states each state may move to
+var transitions = map[string][]string{
+	StateCreated:    {StateRunning, StateRemoving},
+	StateRunning:    {StatePaused, StateRestarting, StateExited, StateRemoving},
+	StatePaused:     {StateRunning, StateExited, StateRemoving},
+	StateRestarting: {StateRunning, StateExited, StateRemoving},
+	StateExited:     {StateRunning, StateRestarting, StateRemoving},
+	StateRemoving:   {StateDead},
+	StateDead:       {StateRemoving},
+}
+
+// NoSuchContainerError reports an unknown container reference
+type NoSuchContainerError struct {
+	Identifier string
+}
+
+func (e *NoSuchContainerError) Error() string {
+	return fmt.Sprintf("No such container: %s", e.Identifier)
+}
+
+// canTransition reports whether a container may move from one state to another
+func canTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRunning reports whether the container has a live process, which is the
+// case for running, paused and restarting containers
+func (c *Container) IsRunning() bool {
+	return c.Status == StateRunning || c.Status == StatePaused || c.Status == StateRestarting
+}
+
+// setState moves the container to state, stamping the lifecycle timestamps
+func (c *Container) setState(state string, now time.Time) error {
+	if !canTransition(c.Status, state) {
+		return fmt.Errorf("cannot transition container %s from %s to %s", c.ID, c.Status, state)
+	}
+	switch state {
+	case StateRunning:
+		// Unpausing keeps the original start time
+		if c.Status != StatePaused {
+			c.StartedAt = now
+			c.ExitCode = 0
+		}
+	case StateExited, StateDead:
+		c.FinishedAt = now
+	}
+	c.Status = state
+	return nil
+}
+
+// start validates and applies a start transition
+func (c *Container) start(now time.Time) error {
+	switch c.Status {
+	case StateRunning, StateRestarting:
+		return fmt.Errorf("container %s is already running", c.ID)
+	case StatePaused:
+		return fmt.Errorf("cannot start a paused container, try unpause instead")
+	case StateRemoving, StateDead:
+		return fmt.Errorf("container %s is marked for removal and cannot be started", c.ID)
+	}
+	return c.setState(StateRunning, now)
+}
+
+// stop validates and applies a stop transition; stopping a container that is
+// not running is a no-op, as in Docker
+func (c *Container) stop(exitCode int, now time.Time) error {
+	if !c.IsRunning() {
+		return nil
+	}
+	if err := c.setState(StateExited, now); err != nil {
+		return err
+	}
+	c.ExitCode = exitCode
+	return nil
+}
+
+// StartContainer starts a created or exited container
+func (cm *ContainerManager) StartContainer(identifier string) (*Container, error) {
+	return cm.transition(identifier, func(c *Container, now time.Time) error {
+		return c.start(now)
+	})
+}
+
+// StopContainer stops a running, paused or restarting container
+func (cm *ContainerManager) StopContainer(identifier string) (*Container, error) {
+	return cm.transition(identifier, func(c *Container, now time.Time) error {
+		return c.stop(ExitCodeStopped, now)
+	})
+}
+
+// RestartContainer stops the container if needed and starts it again
+func (cm *ContainerManager) RestartContainer(identifier string) (*Container, error) {
+	return cm.transition(identifier, func(c *Container, now time.Time) error {
+		switch c.Status {
+		case StateCreated:
+			return c.start(now)
+		case StatePaused:
+			if err := c.stop(ExitCodeStopped, now); err != nil {
+				return err
+			}
+		case StateRemoving, StateDead:
+			return fmt.Errorf("container %s is marked for removal and cannot be restarted", c.ID)
+		}
+		if err := c.setState(StateRestarting, now); err != nil {
+			return err
+		}
+		return c.setState(StateRunning, now)
+	})
+}
+
+// PauseContainer suspends a running container
+func (cm *ContainerManager) PauseContainer(identifier string) (*Container, error) {
+	return cm.transition(identifier, func(c *Container, now time.Time) error {
+		switch c.Status {
+		case StatePaused:
+			return fmt.Errorf("Container %s is already paused", c.ID)
+		case StateRunning:
+			return c.setState(StatePaused, now)
+		}
+		return fmt.Errorf("Container %s is not running", c.ID)
+	})
+}
+
+// UnpauseContainer resumes a paused container
+func (cm *ContainerManager) UnpauseContainer(identifier string) (*Container, error) {
+	return cm.transition(identifier, func(c *Container, now time.Time) error {
+		if c.Status != StatePaused {
+			return fmt.Errorf("Container %s is not paused", c.ID)
+		}
+		return c.setState(StateRunning, now)
+	})
+}
+
+// CheckExec returns an error unless commands can be executed in the container
+func (cm *ContainerManager) CheckExec(identifier string) (*Container, error) {
+	c, err := cm.ResolveContainer(identifier)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Status {
+	case StateRunning:
+		return c, nil
+	case StatePaused:
+		return c, fmt.Errorf("container %s is paused, unpause the container before exec", c.ID)
+	case StateRestarting:
+		return c, fmt.Errorf("container %s is restarting, wait until the container is running", c.ID)
+	}
+	return c, fmt.Errorf("container %s is not running", c.ID)
+}
+
+// transition applies fn to the container inside a store update
+func (cm *ContainerManager) transition(identifier string, fn func(c *Container, now time.Time) error) (*Container, error) {
+	var container *Container
+	err := cm.update(func() error {
+		c, err := cm.find(identifier)
+		if err != nil {
+			return err
+		}
+		container = c
+		return fn(c, time.Now().UTC())
+	})
+	return container, err
+}
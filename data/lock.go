@@ -0,0 +1,43 @@
+// data/lock.go
+package data
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileLock is an advisory, cross-process lock on a state file. The lock is
+// taken on a sibling "<file>.lock" so the data file itself can be replaced
+// with an atomic rename while the lock is held.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile blocks until it holds an exclusive lock for path
+func lockFile(path string) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := lockFD(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	return &fileLock{f: f}, nil
+}
+
+// Unlock releases the lock. It is safe to call on a nil lock.
+func (l *fileLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	unlockFD(l.f)
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
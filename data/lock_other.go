@@ -0,0 +1,12 @@
+//go:build !unix
+
+// data/lock_other.go
+package data
+
+import "os"
+
+// Advisory locking is only implemented on unix; elsewhere writes are still
+// atomic but concurrent processes may race on load-modify-save.
+func lockFD(f *os.File) error { return nil }
+
+func unlockFD(f *os.File) error { return nil }
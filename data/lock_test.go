@@ -0,0 +1,207 @@
+//go:build unix
+
+package data
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fileStores opens the on-disk stores that share state between processes,
+// rooted at dir
+var fileStores = []struct {
+	name string
+	open func(t *testing.T, dir string) Store
+}{
+	{StoreJSON, func(t *testing.T, dir string) Store { return NewJSONFileStore(dir) }},
+	{StoreKV, func(t *testing.T, dir string) Store {
+		s, err := OpenKVStore(filepath.Join(dir, KVStoreFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}},
+}
+
+// containerNames lists the names of the containers stored in store
+func containerNames(t *testing.T, store Store) []string {
+	t.Helper()
+	cm, err := NewContainerManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range cm.ListContainers() {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFileLockExcludes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	first, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan *fileLock)
+	go func() {
+		second, err := lockFile(path)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- second
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a second lock was taken while the first was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Unlock()
+	select {
+	case second := <-acquired:
+		second.Unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock was not handed over after Unlock")
+	}
+}
+
+func TestConcurrentMutations(t *testing.T) {
+	const creates = 20
+	for _, kind := range fileStores {
+		t.Run(kind.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// Two managers over separate store handles, as two CLI
+			// processes would have
+			var managers [2]*ContainerManager
+			for i := range managers {
+				cm, err := NewContainerManager(kind.open(t, dir))
+				if err != nil {
+					t.Fatal(err)
+				}
+				managers[i] = cm
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < creates; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					if _, err := managers[i%2].CreateContainer(ContainerConfig{Name: fmt.Sprintf("c%02d", i), Image: "alpine"}); err != nil {
+						t.Error(err)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			if names := containerNames(t, kind.open(t, dir)); len(names) != creates {
+				t.Errorf("%d containers stored, want %d: %v", len(names), creates, names)
+			}
+		})
+	}
+}
+
+// TestCreateContainersProcess is run as a separate process by
+// TestConcurrentMutationsAcrossProcesses
+func TestCreateContainersProcess(t *testing.T) {
+	root := os.Getenv("DOCKERMOCK_TEST_ROOT")
+	if root == "" {
+		t.Skip("only run as a helper process")
+	}
+	n, _ := strconv.Atoi(os.Getenv("DOCKERMOCK_TEST_CREATES"))
+	for _, kind := range fileStores {
+		if kind.name != os.Getenv("DOCKERMOCK_TEST_STORE") {
+			continue
+		}
+		cm, err := NewContainerManager(kind.open(t, root))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			name := fmt.Sprintf("p%d-%d", os.Getpid(), i)
+			if _, err := cm.CreateContainer(ContainerConfig{Name: name, Image: "alpine"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestConcurrentMutationsAcrossProcesses(t *testing.T) {
+	const processes, creates = 4, 5
+	for _, kind := range fileStores {
+		t.Run(kind.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var wg sync.WaitGroup
+			for i := 0; i < processes; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cmd := exec.Command(os.Args[0], "-test.run=^TestCreateContainersProcess$")
+					cmd.Env = append(os.Environ(),
+						"DOCKERMOCK_TEST_ROOT="+dir,
+						"DOCKERMOCK_TEST_STORE="+kind.name,
+						"DOCKERMOCK_TEST_CREATES="+strconv.Itoa(creates))
+					if out, err := cmd.CombinedOutput(); err != nil {
+						t.Errorf("helper process: %v\n%s", err, out)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if names := containerNames(t, kind.open(t, dir)); len(names) != processes*creates {
+				t.Errorf("%d containers stored, want %d: %v", len(names), processes*creates, names)
+			}
+		})
+	}
+}
+
+func TestMutationsRereadState(t *testing.T) {
+	for _, kind := range fileStores {
+		t.Run(kind.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stale, err := NewContainerManager(kind.open(t, dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := NewContainerManager(kind.open(t, dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			web, err := other.CreateContainer(ContainerConfig{Name: "web", Image: "nginx"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// stale loaded before web existed; its mutations must see it
+			if _, err := stale.CreateContainer(ContainerConfig{Name: "web", Image: "nginx"}); err == nil {
+				t.Error("creating a second container named web succeeded")
+			}
+			if _, err := stale.StartContainer(web.ID); err != nil {
+				t.Errorf("StartContainer() of a container created elsewhere: %v", err)
+			}
+			if _, err := stale.CreateContainer(ContainerConfig{Name: "db", Image: "postgres"}); err != nil {
+				t.Fatal(err)
+			}
+
+			// and saving them must not drop what the other manager wrote
+			if names := containerNames(t, kind.open(t, dir)); fmt.Sprint(names) != "[db web]" {
+				t.Errorf("stored containers = %v, want [db web]", names)
+			}
+			if err := other.RemoveContainer("db", false); err != nil {
+				t.Errorf("RemoveContainer() of a container created elsewhere: %v", err)
+			}
+			if c, err := other.ResolveContainer("web"); err != nil || c.Status != StateRunning {
+				t.Errorf("web = %+v, %v, want it running", c, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+// data/lock_unix.go
+package data
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFD(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFD(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,370 @@
+// data/schema.go
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"prepare.sh/dockermock/reference"
+)
+
+// Current schema versions of the persisted state files. Bump the version and
+// append a migration whenever the shape of Container or Image changes.
+const (
+	ContainersSchemaVersion = 3
+	ImagesSchemaVersion     = 6
+)
+
+// containersState is the envelope persisted in containers.json
+type containersState struct {
+	Version    int          `json:"version"`
+	Containers []*Container `json:"containers"`
+}
+
+// imagesState is the envelope persisted in images.json
+type imagesState struct {
+	Version int      `json:"version"`
+	Images  []*Image `json:"images"`
+}
+
+// migration upgrades a decoded state document by exactly one version
+type migration func(doc map[string]interface{}) error
+
+// containerMigrations[n] upgrades a containers document from version n to n+1
+var containerMigrations = []migration{
+	wrapLegacyArray,
+	migrateContainerStates,
+	migrateContainerIDs,
+}
+
+// imageMigrations[n] upgrades an images document from version n to n+1
+var imageMigrations = []migration{
+	wrapLegacyArray,
+	migrateImageMetadata,
+	migrateImageIDs,
+	migrateImageRepoTags,
+	migrateImageReferences,
+	migrateImageContent,
+}
+
+// CorruptStateError reports a state document that cannot be parsed
+type CorruptStateError struct {
+	Key string
+	Err error
+}
+
+func (e *CorruptStateError) Error() string {
+	return fmt.Sprintf("state %s is corrupt: %v (run 'docker system repair' to recover)", e.Key, e.Err)
+}
+
+func (e *CorruptStateError) Unwrap() error {
+	return e.Err
+}
+
+// wrapLegacyArray upgrades version 0, a bare JSON array, into the envelope.
+// decodeState has already moved the array under the items key, so nothing
+// else changes in version 1.
+func wrapLegacyArray(doc map[string]interface{}) error {
+	return nil
+}
+
+// migrateContainerStates upgrades version 1 to 2: the free-form "stopped"
+// status becomes Docker's "exited", and containers get a creation timestamp
+func migrateContainerStates(doc map[string]interface{}) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	return eachItem(doc, "containers", func(c map[string]interface{}) error {
+		switch status, _ := c["status"].(string); status {
+		case StateCreated, StateRunning, StatePaused, StateRestarting, StateExited, StateDead:
+		case StateRemoving:
+			// An interrupted removal
+			c["status"] = StateDead
+		default:
+			c["status"] = StateExited
+			c["exitCode"] = float64(0)
+		}
+		if _, ok := c["created"]; !ok {
+			c["created"] = now
+		}
+		return nil
+	})
+}
+
+// migrateImageMetadata upgrades images version 1 to 2: images gain a
+// creation time and a size, and untagged images use NoneTag
+func migrateImageMetadata(doc map[string]interface{}) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	return eachItem(doc, "images", func(img map[string]interface{}) error {
+		name, _ := img["name"].(string)
+		tag, _ := img["tag"].(string)
+		if _, ok := img["created"]; !ok {
+			img["created"] = now
+		}
+		if _, ok := img["size"]; !ok {
+			img["size"] = float64(syntheticImageSize(name))
+		}
+		if name == "" || tag == "" {
+			img["name"], img["tag"] = NoneTag, NoneTag
+		}
+		return nil
+	})
+}
+
+// migrateContainerIDs upgrades containers version 2 to 3: sequential cNNN
+// IDs are replaced by random 64-character hex IDs
+func migrateContainerIDs(doc map[string]interface{}) error {
+	return eachItem(doc, "containers", func(c map[string]interface{}) error {
+		if id, _ := c["id"].(string); !isFullID(id) {
+			c["id"] = generateID()
+		}
+		return nil
+	})
+}
+
+// migrateImageIDs upgrades images version 2 to 3: sequential iNNN IDs are
+// replaced by content-addressable sha256 IDs and parent references follow.
+// Tagged images get the ID a fresh pull of the same reference would have;
+// dangling ones hash their legacy ID, as their content is unknown.
+func migrateImageIDs(doc map[string]interface{}) error {
+	renamed := map[string]string{}
+	err := eachItem(doc, "images", func(img map[string]interface{}) error {
+		id, _ := img["id"].(string)
+		if isFullID(id) {
+			return nil
+		}
+		name, _ := img["name"].(string)
+		tag, _ := img["tag"].(string)
+		newID := contentID([]byte("legacy:" + id))
+		if name != NoneTag {
+			newID = pulledImageID(syntheticDigest(name, tag))
+		}
+		renamed[id] = newID
+		img["id"] = newID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return eachItem(doc, "images", func(img map[string]interface{}) error {
+		if parent, _ := img["parent"].(string); renamed[parent] != "" {
+			img["parent"] = renamed[parent]
+		}
+		return nil
+	})
+}
+
+// migrateImageRepoTags upgrades images version 3 to 4: the single name and
+// tag of an image become its list of name:tag references
+func migrateImageRepoTags(doc map[string]interface{}) error {
+	return eachItem(doc, "images", func(img map[string]interface{}) error {
+		name, _ := img["name"].(string)
+		tag, _ := img["tag"].(string)
+		if name != NoneTag && tag != NoneTag {
+			img["repoTags"] = []interface{}{RepoTag(name, tag)}
+		}
+		delete(img, "name")
+		delete(img, "tag")
+		return nil
+	})
+}
+
+// migrateImageReferences upgrades images version 4 to 5: tags are stored in
+// their familiar form, so "docker.io/library/ubuntu:latest" becomes
+// "ubuntu:latest", and pulled images record a name@digest reference for
+// each repository they are tagged in
+func migrateImageReferences(doc map[string]interface{}) error {
+	return eachItem(doc, "images", func(img map[string]interface{}) error {
+		digest, _ := img["digest"].(string)
+		tags, _ := img["repoTags"].([]interface{})
+		var repoTags, repoDigests []string
+		for _, t := range tags {
+			repoTag, _ := t.(string)
+			if ref, err := reference.ParseNormalizedTagged(repoTag); err == nil {
+				repoTag = ref.FamiliarString()
+			}
+			repoTags = addString(repoTags, repoTag)
+			if digest != "" {
+				name, _ := SplitRepoTag(repoTag)
+				repoDigests = addString(repoDigests, name+"@"+digest)
+			}
+		}
+		if repoTags != nil {
+			img["repoTags"] = repoTags
+		}
+		if repoDigests != nil {
+			img["repoDigests"] = repoDigests
+		}
+		return nil
+	})
+}
+
+// migrateImageContent upgrades images version 5 to 6: images point to a
+// stored manifest and config. Migrations only see the images document, so
+// the content of existing images is synthesized by ImageManager.load once
+// the upgraded document is decoded.
+func migrateImageContent(doc map[string]interface{}) error {
+	return nil
+}
+
+// eachItem calls fn for every object in doc[key]
+func eachItem(doc map[string]interface{}, key string, fn func(item map[string]interface{}) error) error {
+	items, _ := doc[key].([]interface{})
+	for i, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s[%d] is not an object", key, i)
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeState parses a state document, runs the migrations needed to reach
+// the current version (len(migrations)) and unmarshals it into out. It
+// returns the version found on disk.
+func decodeState(raw []byte, itemsKey string, migrations []migration, out interface{}) (int, error) {
+	var doc map[string]interface{}
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		// Version 0: the pre-envelope format was a bare array
+		var items []interface{}
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return 0, err
+		}
+		doc = map[string]interface{}{"version": float64(0), itemsKey: items}
+	} else if err := json.Unmarshal(trimmed, &doc); err != nil {
+		return 0, err
+	}
+
+	v, ok := doc["version"].(float64)
+	if !ok || v < 0 || v != float64(int(v)) {
+		return 0, fmt.Errorf("missing or invalid schema version")
+	}
+	version := int(v)
+	current := len(migrations)
+	if version > current {
+		return version, fmt.Errorf("schema version %d is newer than supported version %d; upgrade the CLI", version, current)
+	}
+
+	for n := version; n < current; n++ {
+		if err := migrations[n](doc); err != nil {
+			return version, fmt.Errorf("migrating schema from version %d: %v", n, err)
+		}
+		doc["version"] = float64(n + 1)
+	}
+
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return version, err
+	}
+	if err := json.Unmarshal(upgraded, out); err != nil {
+		return version, err
+	}
+	return version, nil
+}
+
+// backupState keeps a copy of a document before it is upgraded in place
+func backupState(tx Tx, key string, raw []byte, version int) error {
+	if err := tx.Put(fmt.Sprintf("%s.v%d.bak", key, version), raw); err != nil {
+		return fmt.Errorf("failed to back up %s: %v", key, err)
+	}
+	return nil
+}
+
+// RepairReport describes what RepairState did to one state document
+type RepairReport struct {
+	Key          string
+	Problem      error  // nil when the document was healthy
+	MovedTo      string // key the corrupt document was moved to
+	RestoredFrom string // backup restored in its place, if any
+}
+
+// stateDocument pairs a persisted state document with a validator for it
+type stateDocument struct {
+	key      string
+	validate func([]byte) error
+}
+
+// stateDocuments lists every persisted state document
+func stateDocuments() []stateDocument {
+	return []stateDocument{
+		{ContainersFile, func(raw []byte) error {
+			_, err := decodeState(raw, "containers", containerMigrations, &containersState{})
+			return err
+		}},
+		{ImagesFile, func(raw []byte) error {
+			_, err := decodeState(raw, "images", imageMigrations, &imagesState{})
+			return err
+		}},
+	}
+}
+
+// RepairState checks every state document. Unparseable documents are moved
+// aside and replaced by the newest readable migration backup, or removed so
+// the CLI starts from empty state.
+func RepairState(store Store) ([]RepairReport, error) {
+	var reports []RepairReport
+	err := store.Update(func(tx Tx) error {
+		for _, doc := range stateDocuments() {
+			report, err := repairStateDocument(tx, doc)
+			if err != nil {
+				return err
+			}
+			reports = append(reports, report)
+		}
+		return nil
+	})
+	return reports, err
+}
+
+func repairStateDocument(tx Tx, doc stateDocument) (RepairReport, error) {
+	report := RepairReport{Key: doc.key}
+
+	raw, err := tx.Get(doc.key)
+	if err != nil || raw == nil {
+		return report, err
+	}
+	if report.Problem = doc.validate(raw); report.Problem == nil {
+		return report, nil
+	}
+
+	report.MovedTo = fmt.Sprintf("%s.corrupt-%d", doc.key, time.Now().Unix())
+	if err := tx.Put(report.MovedTo, raw); err != nil {
+		return report, fmt.Errorf("failed to move %s aside: %v", doc.key, err)
+	}
+	if err := tx.Delete(doc.key); err != nil {
+		return report, err
+	}
+
+	// Prefer the most recent backup that still parses
+	backups, err := tx.Keys(doc.key + ".v")
+	if err != nil {
+		return report, err
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backupVersion(doc.key, backups[i]) > backupVersion(doc.key, backups[j])
+	})
+	for _, backup := range backups {
+		braw, err := tx.Get(backup)
+		if err != nil || braw == nil || doc.validate(braw) != nil {
+			continue
+		}
+		if err := tx.Put(doc.key, braw); err != nil {
+			return report, err
+		}
+		report.RestoredFrom = backup
+		break
+	}
+	return report, nil
+}
+
+// backupVersion extracts N from "<key>.vN.bak"
+func backupVersion(key, backup string) int {
+	var v int
+	fmt.Sscanf(backup[len(key):], ".v%d.bak", &v)
+	return v
+}
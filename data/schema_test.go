@@ -0,0 +1,235 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeState stores raw as the document key of a JSON file store in dir
+func writeState(t *testing.T, dir, key, raw string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, key), []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedVersion returns the schema version of a stored document
+func storedVersion(t *testing.T, store Store, key string) int {
+	t.Helper()
+	var version int
+	store.View(func(tx Tx) error {
+		raw, err := tx.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var state struct{ Version int }
+		if err := json.Unmarshal(raw, &state); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		version = state.Version
+		return nil
+	})
+	return version
+}
+
+// backups lists the migration backups kept for key
+func backups(t *testing.T, store Store, key string) map[string]string {
+	t.Helper()
+	found := map[string]string{}
+	store.View(func(tx Tx) error {
+		keys, err := tx.Keys(key + ".v")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			raw, _ := tx.Get(k)
+			found[k] = string(raw)
+		}
+		return nil
+	})
+	return found
+}
+
+func TestContainerMigrations(t *testing.T) {
+	const fullID = "4f1c2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7"
+	tests := []struct {
+		version    int
+		raw        string
+		wantStatus string
+		wantID     string // kept ID; generated when empty
+	}{
+		{0, `[{"id":"c001","name":"web","image":"nginx","status":"stopped"}]`, StateExited, ""},
+		{1, `{"version":1,"containers":[{"id":"c001","name":"web","image":"nginx","status":"removing"}]}`, StateDead, ""},
+		{1, `{"version":1,"containers":[{"id":"c001","name":"web","image":"nginx","status":"running"}]}`, StateRunning, ""},
+		{2, `{"version":2,"containers":[{"id":"c002","name":"web","image":"nginx","status":"exited","created":"2024-01-01T00:00:00Z"}]}`, StateExited, ""},
+		{2, `{"version":2,"containers":[{"id":"` + fullID + `","name":"web","image":"nginx","status":"paused","created":"2024-01-01T00:00:00Z"}]}`, StatePaused, fullID},
+		{3, `{"version":3,"containers":[{"id":"` + fullID + `","name":"web","image":"nginx","status":"created","created":"2024-01-01T00:00:00Z"}]}`, StateCreated, fullID},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("version %d", tt.version), func(t *testing.T) {
+			dir := t.TempDir()
+			writeState(t, dir, ContainersFile, tt.raw)
+			store := NewJSONFileStore(dir)
+			cm, err := NewContainerManager(store)
+			if err != nil {
+				t.Fatalf("NewContainerManager() error = %v", err)
+			}
+
+			c, err := cm.ResolveContainer("web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Status != tt.wantStatus || c.Image != "nginx" || c.Created.IsZero() {
+				t.Errorf("container = %+v, want status %s", c, tt.wantStatus)
+			}
+			if !isFullID(c.ID) || (tt.wantID != "" && c.ID != tt.wantID) {
+				t.Errorf("ID = %q, want %q", c.ID, tt.wantID)
+			}
+
+			if v := storedVersion(t, store, ContainersFile); v != ContainersSchemaVersion {
+				t.Errorf("stored version = %d, want %d", v, ContainersSchemaVersion)
+			}
+			found := backups(t, store, ContainersFile)
+			if tt.version == ContainersSchemaVersion {
+				if len(found) != 0 {
+					t.Errorf("current document was backed up: %v", found)
+				}
+			} else if raw := found[fmt.Sprintf("%s.v%d.bak", ContainersFile, tt.version)]; raw != tt.raw {
+				t.Errorf("backups = %v, want the version %d document", found, tt.version)
+			}
+		})
+	}
+}
+
+func TestImageMigrations(t *testing.T) {
+	const digest = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	const fullID = "b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2"
+	pulledID := pulledImageID(syntheticDigest("ubuntu", "22.04"))
+	tests := []struct {
+		version         int
+		raw             string
+		wantID          string
+		wantRepoDigests string
+	}{
+		{0, `[{"id":"i001","name":"ubuntu","tag":"22.04"}]`, pulledID, ""},
+		{1, `{"version":1,"images":[{"id":"i001","name":"ubuntu","tag":"22.04"},{"id":"i002","name":"","tag":"","parent":"i001"}]}`, pulledID, ""},
+		{2, `{"version":2,"images":[{"id":"i001","name":"ubuntu","tag":"22.04","created":"2024-01-01T00:00:00Z","size":1000}]}`, pulledID, ""},
+		{3, `{"version":3,"images":[{"id":"` + fullID + `","name":"ubuntu","tag":"22.04","digest":"` + digest + `","created":"2024-01-01T00:00:00Z","size":1000}]}`, fullID, "ubuntu@" + digest},
+		{4, `{"version":4,"images":[{"id":"` + fullID + `","repoTags":["docker.io/library/ubuntu:22.04"],"digest":"` + digest + `","created":"2024-01-01T00:00:00Z","size":1000}]}`, fullID, "ubuntu@" + digest},
+		{5, `{"version":5,"images":[{"id":"` + fullID + `","repoTags":["ubuntu:22.04"],"created":"2024-01-01T00:00:00Z","size":1000}]}`, fullID, ""},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("version %d", tt.version), func(t *testing.T) {
+			dir := t.TempDir()
+			writeState(t, dir, ImagesFile, tt.raw)
+			store := NewJSONFileStore(dir)
+			im, err := NewImageManager(store)
+			if err != nil {
+				t.Fatalf("NewImageManager() error = %v", err)
+			}
+
+			img, err := im.ResolveImage("ubuntu:22.04")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.ID != tt.wantID || strings.Join(img.RepoTags, " ") != "ubuntu:22.04" ||
+				strings.Join(img.RepoDigests, " ") != tt.wantRepoDigests || img.Created.IsZero() {
+				t.Errorf("image = %+v, want ID %s and repo digests %q", img, tt.wantID, tt.wantRepoDigests)
+			}
+			// Content is synthesized for images recorded without it
+			if _, err := im.ImageContent(img); err != nil {
+				t.Errorf("ImageContent() error = %v", err)
+			}
+			if tt.version == 1 {
+				for _, other := range im.ListImages() {
+					if other.ID != img.ID && (!other.IsDangling() || other.Parent != img.ID) {
+						t.Errorf("dangling image = %+v, want parent %s", other, img.ID)
+					}
+				}
+			}
+
+			if v := storedVersion(t, store, ImagesFile); v != ImagesSchemaVersion {
+				t.Errorf("stored version = %d, want %d", v, ImagesSchemaVersion)
+			}
+			if raw := backups(t, store, ImagesFile)[fmt.Sprintf("%s.v%d.bak", ImagesFile, tt.version)]; raw != tt.raw {
+				t.Errorf("backups = %v, want the version %d document", backups(t, store, ImagesFile), tt.version)
+			}
+		})
+	}
+}
+
+func TestCorruptStateAndRepair(t *testing.T) {
+	const legacy = `[{"id":"c001","name":"web","image":"nginx","status":"stopped"}]`
+	tests := []struct {
+		name       string
+		raw        string
+		backup     bool // a migration backup of legacy exists
+		wantErr    string
+		wantNames  string // containers after repair
+		wantBackup string
+	}{
+		{name: "truncated", raw: `{"version":3,"containers":[{"id":`, wantErr: "unexpected end of JSON input"},
+		{name: "restored from backup", raw: `not json`, backup: true, wantErr: "invalid character", wantNames: "web", wantBackup: ContainersFile + ".v0.bak"},
+		{name: "no version", raw: `{"containers":[]}`, wantErr: "missing or invalid schema version"},
+		{name: "newer version", raw: `{"version":99,"containers":[]}`, wantErr: "upgrade the CLI"},
+		{name: "bad item", raw: `{"version":1,"containers":["web"]}`, wantErr: "containers[0] is not an object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.backup {
+				writeState(t, dir, ContainersFile+".v0.bak", legacy)
+			}
+			writeState(t, dir, ContainersFile, tt.raw)
+			store := NewJSONFileStore(dir)
+
+			_, err := NewContainerManager(store)
+			var corrupt *CorruptStateError
+			if !errors.As(err, &corrupt) || corrupt.Key != ContainersFile || !strings.Contains(err.Error(), tt.wantErr) ||
+				!strings.Contains(err.Error(), "docker system repair") {
+				t.Fatalf("NewContainerManager() error = %v, want a corrupt state error with %q", err, tt.wantErr)
+			}
+			// Loading must not touch the corrupt file
+			if raw, _ := os.ReadFile(filepath.Join(dir, ContainersFile)); string(raw) != tt.raw {
+				t.Errorf("corrupt file changed to %q", raw)
+			}
+
+			reports, err := RepairState(store)
+			if err != nil {
+				t.Fatalf("RepairState() error = %v", err)
+			}
+			if len(reports) != 2 || reports[0].Key != ContainersFile || reports[0].Problem == nil || reports[1].Problem != nil {
+				t.Fatalf("RepairState() = %+v", reports)
+			}
+			r := reports[0]
+			if r.RestoredFrom != tt.wantBackup || !strings.HasPrefix(r.MovedTo, ContainersFile+".corrupt-") {
+				t.Errorf("report = %+v, want restored from %q", r, tt.wantBackup)
+			}
+			if raw, _ := os.ReadFile(filepath.Join(dir, r.MovedTo)); string(raw) != tt.raw {
+				t.Errorf("%s = %q, want the corrupt document", r.MovedTo, raw)
+			}
+
+			cm, err := NewContainerManager(store)
+			if err != nil {
+				t.Fatalf("NewContainerManager() after repair: %v", err)
+			}
+			var names []string
+			for _, c := range cm.ListContainers() {
+				names = append(names, c.Name)
+			}
+			if strings.Join(names, " ") != tt.wantNames {
+				t.Errorf("containers after repair = %v, want %q", names, tt.wantNames)
+			}
+
+			// A second repair finds nothing to do
+			if reports, err := RepairState(store); err != nil || reports[0].Problem != nil {
+				t.Errorf("second RepairState() = %+v, %v", reports, err)
+			}
+		})
+	}
+}
@@ -2,27 +2,126 @@
 package data
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 )
 
 const (
-	StorageDir     = "/tmp"
 	ContainersFile = "containers.json"
 	ImagesFile     = "images.json"
+	ContentDir     = "content" // image configs, manifests and layers by digest
+	BuildCacheDir  = "buildcache"
+	ConfigDir      = "config"
+	ConfigFile     = "config.json"
+
+	// DataRootEnv overrides the default state root when --data-root is not given
+	DataRootEnv = "DOCKERMOCK_HOME"
 )
 
-// EnsureStorageDir ensures that the storage directory exists
+// dataRoot holds the explicitly configured state root (from --data-root)
+var dataRoot string
+
+// SetDataRoot sets the state root explicitly. An empty path restores the
+// default resolution order.
+func SetDataRoot(path string) {
+	dataRoot = path
+}
+
+// DataRoot returns the resolved state root. The explicit root set with
+// SetDataRoot wins, then $DOCKERMOCK_HOME, then $XDG_DATA_HOME/dockermock,
+// then ~/.local/share/dockermock.
+func DataRoot() string {
+	if dataRoot != "" {
+		return dataRoot
+	}
+	if env := os.Getenv(DataRootEnv); env != "" {
+		return env
+	}
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return filepath.Join(xdg, "dockermock")
+	}
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		return filepath.Join(home, ".local", "share", "dockermock")
+	}
+	// No home directory (e.g. minimal containers); fall back to a per-user temp dir
+	return filepath.Join(os.TempDir(), "dockermock-"+currentUser())
+}
+
+// currentUser returns a name that keeps temp-dir fallbacks apart between users
+func currentUser() string {
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return "default"
+}
+
+// EnsureStorageDir ensures that the state root and its layout exist
 func EnsureStorageDir() error {
-	return os.MkdirAll(StorageDir, os.ModePerm)
+	if err := os.MkdirAll(DataRoot(), 0755); err != nil {
+		return err
+	}
+	return os.MkdirAll(GetConfigDirPath(), 0700)
 }
 
 // GetContainersFilePath returns the full path to the containers file
 func GetContainersFilePath() string {
-	return filepath.Join(StorageDir, ContainersFile)
+	return filepath.Join(DataRoot(), ContainersFile)
 }
 
 // GetImagesFilePath returns the full path to the images file
 func GetImagesFilePath() string {
-	return filepath.Join(StorageDir, ImagesFile)
+	return filepath.Join(DataRoot(), ImagesFile)
+}
+
+// GetConfigDirPath returns the directory holding the CLI config
+func GetConfigDirPath() string {
+	return filepath.Join(DataRoot(), ConfigDir)
+}
+
+// GetConfigFilePath returns the full path to the CLI config file
+func GetConfigFilePath() string {
+	return filepath.Join(GetConfigDirPath(), ConfigFile)
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into
+// place, so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	tmpName := tmp.Name()
+	// Clean up the temp file on any failure path; a no-op after the rename
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %v", err)
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %v", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace file: %v", err)
+	}
+
+	// Persist the rename itself; not all platforms support syncing directories
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
 }
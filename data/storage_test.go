@@ -0,0 +1,139 @@
+package data
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tempFiles lists the in-flight temp files writeFileAtomic left in dir
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", ContainersFile)
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(path); string(got) != content {
+			t.Errorf("content = %q, want %q", got, content)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, %v, want 0600", info.Mode(), err)
+	}
+	if tmp := tempFiles(t, filepath.Dir(path)); len(tmp) != 0 {
+		t.Errorf("temp files left behind: %v", tmp)
+	}
+}
+
+func TestWriteFileAtomicFailureKeepsTarget(t *testing.T) {
+	// A directory in the way makes the final rename fail after the temp
+	// file was written
+	dir := t.TempDir()
+	path := filepath.Join(dir, ImagesFile)
+	if err := os.MkdirAll(filepath.Join(path, "old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new"), 0644); err == nil || !strings.Contains(err.Error(), "failed to replace file") {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "old")); err != nil {
+		t.Errorf("target was modified: %v", err)
+	}
+	if tmp := tempFiles(t, dir); len(tmp) != 0 {
+		t.Errorf("temp files left behind: %v", tmp)
+	}
+}
+
+func TestInterruptedWriteKeepsState(t *testing.T) {
+	tests := []struct {
+		name  string
+		open  func(t *testing.T, dir string) Store
+		crash func(t *testing.T, dir string) // leaves what a killed writer would
+	}{
+		{
+			name: StoreJSON,
+			open: func(t *testing.T, dir string) Store { return NewJSONFileStore(dir) },
+			crash: func(t *testing.T, dir string) {
+				// Killed before the rename: only a partial temp file exists
+				tmp := filepath.Join(dir, "."+ContainersFile+".tmp-123")
+				if err := os.WriteFile(tmp, []byte(`{"version":3,"containers":[{"id":`), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: StoreKV,
+			open: func(t *testing.T, dir string) Store {
+				s, err := OpenKVStore(filepath.Join(dir, KVStoreFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			crash: func(t *testing.T, dir string) {
+				// Killed while appending: a torn record ends the log
+				var record bytes.Buffer
+				writeKVRecord(&record, kvOpPut, ContainersFile, []byte(`{"version":3,"containers":[]}`))
+				f, err := os.OpenFile(filepath.Join(dir, KVStoreFile), os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if _, err := f.Write(record.Bytes()[:record.Len()-3]); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cm, err := NewContainerManager(tt.open(t, dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cm.CreateContainer(ContainerConfig{Name: "web", Image: "nginx"}); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.crash(t, dir)
+
+			// The state written before the crash loads and can be changed
+			cm, err = NewContainerManager(tt.open(t, dir))
+			if err != nil {
+				t.Fatalf("loading after the interrupted write: %v", err)
+			}
+			if _, err := cm.ResolveContainer("web"); err != nil {
+				t.Fatalf("container written before the crash: %v", err)
+			}
+			if _, err := cm.CreateContainer(ContainerConfig{Name: "db", Image: "postgres"}); err != nil {
+				t.Fatal(err)
+			}
+			cm, err = NewContainerManager(tt.open(t, dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(cm.ListContainers()); n != 2 {
+				t.Errorf("%d containers after the next write, want 2", n)
+			}
+			tt.open(t, dir).View(func(tx Tx) error {
+				if keys, _ := tx.Keys(""); strings.Join(keys, " ") != ContainersFile {
+					t.Errorf("keys = %q, want only %s", keys, ContainersFile)
+				}
+				return nil
+			})
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// data/store.go
+package data
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store kinds accepted by OpenStore
+const (
+	StoreJSON   = "json"
+	StoreMemory = "memory"
+	StoreKV     = "kv"
+
+	// StoreEnv selects the store kind when --state-store is not given
+	StoreEnv = "DOCKERMOCK_STATE_STORE"
+
+	// KVStoreFile is the single file backing the kv store
+	KVStoreFile = "state.db"
+)
+
+// Store persists the named state documents (containers.json, images.json, ...)
+// that the managers read and write. Implementations serialize Update calls,
+// including across processes where the backend is shared on disk, so a
+// transaction sees the latest state and no other writer interleaves with it.
+//
+// Transactions must not be nested: a manager finishes one Update before the
+// next one starts.
+type Store interface {
+	// View runs fn with read access to the documents
+	View(fn func(tx Tx) error) error
+	// Update runs fn with exclusive write access to the documents
+	Update(fn func(tx Tx) error) error
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// Tx gives access to the documents inside a View or Update
+type Tx interface {
+	// Get returns the document stored under key, or nil if there is none
+	Get(key string) ([]byte, error)
+	// Put stores value under key, replacing any previous document
+	Put(key string, value []byte) error
+	// Delete removes key; deleting a missing key is not an error
+	Delete(key string) error
+	// Keys lists the stored keys with the given prefix in sorted order
+	Keys(prefix string) ([]string, error)
+}
+
+// OpenStore opens the store of the given kind rooted at the resolved state
+// root. An empty kind means the JSON file store.
+func OpenStore(kind string) (Store, error) {
+	switch kind {
+	case "", StoreJSON:
+		return NewJSONFileStore(DataRoot()), nil
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreKV:
+		return OpenKVStore(filepath.Join(DataRoot(), KVStoreFile))
+	default:
+		return nil, fmt.Errorf("unknown state store %q (want %s, %s or %s)", kind, StoreJSON, StoreMemory, StoreKV)
+	}
+}
+
+// validateKey rejects keys that could escape a file-backed store
+func validateKey(key string) error {
+	if key == "" || path.IsAbs(key) || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid state key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid state key %q", key)
+		}
+	}
+	return nil
+}
+
+// MemoryStore keeps documents in process memory. State is lost when the
+// process exits, which makes it suitable for tests and embedding.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	docs map[string][]byte
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{docs: make(map[string][]byte)}
+}
+
+// View runs fn under a shared lock
+func (s *MemoryStore) View(fn func(tx Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(&memoryTx{docs: s.docs, readOnly: true})
+}
+
+// Update runs fn under an exclusive lock
+func (s *MemoryStore) Update(fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return fn(&memoryTx{docs: s.docs})
+}
+
+// Close is a no-op for the in-memory store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// memoryTx reads and writes a document map directly
+type memoryTx struct {
+	docs     map[string][]byte
+	readOnly bool
+}
+
+func (tx *memoryTx) Get(key string) ([]byte, error) {
+	value, ok := tx.docs[key]
+	if !ok {
+		return nil, nil
+	}
+	// Hand out a copy so callers cannot mutate stored state. It is never
+	// nil, even for an empty document, as nil means there is none.
+	return append([]byte{}, value...), nil
+}
+
+func (tx *memoryTx) Put(key string, value []byte) error {
+	if tx.readOnly {
+		return fmt.Errorf("cannot write %q in a read-only transaction", key)
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	tx.docs[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (tx *memoryTx) Delete(key string) error {
+	if tx.readOnly {
+		return fmt.Errorf("cannot delete %q in a read-only transaction", key)
+	}
+	delete(tx.docs, key)
+	return nil
+}
+
+func (tx *memoryTx) Keys(prefix string) ([]string, error) {
+	return sortedKeys(tx.docs, prefix), nil
+}
+
+// sortedKeys returns the keys of docs with the given prefix in sorted order
+func sortedKeys(docs map[string][]byte, prefix string) []string {
+	keys := []string{}
+	for key := range docs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,139 @@
+// data/store_json.go
+package data
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// storeLockFile is the lock shared by every transaction on a JSON file store
+const storeLockFile = "state"
+
+// JSONFileStore keeps each document as its own file under a root directory,
+// e.g. <root>/containers.json. Transactions hold an advisory lock on
+// <root>/state.lock and every write is an atomic rename.
+type JSONFileStore struct {
+	root string
+}
+
+// NewJSONFileStore returns a store rooted at dir
+func NewJSONFileStore(dir string) *JSONFileStore {
+	return &JSONFileStore{root: dir}
+}
+
+// Root returns the directory holding the documents
+func (s *JSONFileStore) Root() string {
+	return s.root
+}
+
+// View runs fn while holding the store lock
+func (s *JSONFileStore) View(fn func(tx Tx) error) error {
+	return s.run(fn, true)
+}
+
+// Update runs fn while holding the store lock
+func (s *JSONFileStore) Update(fn func(tx Tx) error) error {
+	return s.run(fn, false)
+}
+
+func (s *JSONFileStore) run(fn func(tx Tx) error, readOnly bool) error {
+	lock, err := lockFile(filepath.Join(s.root, storeLockFile))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return fn(&jsonFileTx{root: s.root, readOnly: readOnly})
+}
+
+// Close is a no-op; the store holds no open files between transactions
+func (s *JSONFileStore) Close() error {
+	return nil
+}
+
+// jsonFileTx maps keys onto files below root
+type jsonFileTx struct {
+	root     string
+	readOnly bool
+}
+
+func (tx *jsonFileTx) path(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(tx.root, filepath.FromSlash(key)), nil
+}
+
+func (tx *jsonFileTx) Get(key string) ([]byte, error) {
+	p, err := tx.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (tx *jsonFileTx) Put(key string, value []byte) error {
+	if tx.readOnly {
+		return fmt.Errorf("cannot write %q in a read-only transaction", key)
+	}
+	p, err := tx.path(key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, value, 0644)
+}
+
+func (tx *jsonFileTx) Delete(key string) error {
+	if tx.readOnly {
+		return fmt.Errorf("cannot delete %q in a read-only transaction", key)
+	}
+	p, err := tx.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (tx *jsonFileTx) Keys(prefix string) ([]string, error) {
+	keys := []string{}
+	err := filepath.WalkDir(tx.root, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(tx.root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		// Skip lock files, in-flight temp files and the CLI config
+		name := d.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".lock") || rel == ConfigDir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
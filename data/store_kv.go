@@ -0,0 +1,290 @@
+// data/store_kv.go
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// kvMagic starts every kv store file
+var kvMagic = []byte("DMKV\x01")
+
+// Record operations in the kv log
+const (
+	kvOpPut    byte = 'P'
+	kvOpDelete byte = 'D'
+)
+
+// kvCompactSlack is how much dead log data is tolerated before compaction
+const kvCompactSlack = 64 * 1024
+
+// KVStore is an embedded key-value store kept in a single file. The file is an
+// append-only log of checksummed put/delete records; a torn record left by a
+// crash is discarded on the next open, and the log is rewritten atomically
+// once dead records outweigh live data.
+type KVStore struct {
+	path string
+}
+
+// OpenKVStore opens (creating if needed) the kv store file at path
+func OpenKVStore(path string) (*KVStore, error) {
+	s := &KVStore{path: path}
+	// Validate the file up front so a foreign file is reported early
+	if err := s.View(func(tx Tx) error { return nil }); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the file backing the store
+func (s *KVStore) Path() string {
+	return s.path
+}
+
+// View runs fn against a snapshot of the store while holding its lock
+func (s *KVStore) View(fn func(tx Tx) error) error {
+	return s.run(fn, true)
+}
+
+// Update runs fn while holding the store lock and appends its writes to the
+// log only if fn succeeds
+func (s *KVStore) Update(fn func(tx Tx) error) error {
+	return s.run(fn, false)
+}
+
+// Close is a no-op; the file is only open during transactions
+func (s *KVStore) Close() error {
+	return nil
+}
+
+func (s *KVStore) run(fn func(tx Tx) error, readOnly bool) error {
+	lock, err := lockFile(s.path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	docs, validLen, fileLen, err := s.replay()
+	if err != nil {
+		return err
+	}
+
+	tx := &kvTx{docs: docs, pending: make(map[string][]byte), readOnly: readOnly}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if readOnly || len(tx.pending) == 0 {
+		return nil
+	}
+	return s.commit(tx, validLen, fileLen)
+}
+
+// replay reads the log into a document map. It returns the length of the
+// valid prefix of the file and the file's total length.
+func (s *KVStore) replay() (map[string][]byte, int64, int64, error) {
+	docs := make(map[string][]byte)
+
+	raw, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return docs, 0, 0, nil
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(raw) == 0 {
+		return docs, 0, 0, nil
+	}
+	if !bytes.HasPrefix(raw, kvMagic) {
+		return nil, 0, 0, fmt.Errorf("%s is not a kv state store", s.path)
+	}
+
+	pos := len(kvMagic)
+	for pos < len(raw) {
+		op, key, value, n, err := readKVRecord(raw[pos:])
+		if err != nil {
+			// A torn or corrupt tail from an interrupted write; ignore it
+			break
+		}
+		switch op {
+		case kvOpPut:
+			docs[key] = value
+		case kvOpDelete:
+			delete(docs, key)
+		}
+		pos += n
+	}
+	return docs, int64(pos), int64(len(raw)), nil
+}
+
+// commit appends the transaction's writes, compacting the log when it has
+// accumulated too much dead data
+func (s *KVStore) commit(tx *kvTx, validLen, fileLen int64) error {
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(tx.pending, "") {
+		value := tx.pending[key]
+		if value == nil {
+			writeKVRecord(&buf, kvOpDelete, key, nil)
+			delete(tx.docs, key)
+		} else {
+			writeKVRecord(&buf, kvOpPut, key, value)
+			tx.docs[key] = value
+		}
+	}
+
+	live := int64(len(kvMagic))
+	for key, value := range tx.docs {
+		live += int64(len(key) + len(value) + 16)
+	}
+	if validLen+int64(buf.Len()) > 2*live+kvCompactSlack {
+		return s.compact(tx.docs)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if validLen == 0 {
+		if _, err := f.Write(kvMagic); err != nil {
+			return err
+		}
+		validLen = int64(len(kvMagic))
+	}
+	// Drop any torn tail so new records follow valid data
+	if fileLen != validLen {
+		if err := f.Truncate(validLen); err != nil {
+			return err
+		}
+	}
+	if _, err := f.WriteAt(buf.Bytes(), validLen); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// compact rewrites the log as a single put record per live key
+func (s *KVStore) compact(docs map[string][]byte) error {
+	var buf bytes.Buffer
+	buf.Write(kvMagic)
+	for _, key := range sortedKeys(docs, "") {
+		writeKVRecord(&buf, kvOpPut, key, docs[key])
+	}
+	return writeFileAtomic(s.path, buf.Bytes(), 0644)
+}
+
+// writeKVRecord encodes op, key and value followed by a CRC32 of the record
+func writeKVRecord(w *bytes.Buffer, op byte, key string, value []byte) {
+	start := w.Len()
+	var n [binary.MaxVarintLen64]byte
+	w.WriteByte(op)
+	w.Write(n[:binary.PutUvarint(n[:], uint64(len(key)))])
+	w.WriteString(key)
+	w.Write(n[:binary.PutUvarint(n[:], uint64(len(value)))])
+	w.Write(value)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(w.Bytes()[start:]))
+	w.Write(sum[:])
+}
+
+// readKVRecord decodes the record at the start of b and returns its length
+func readKVRecord(b []byte) (byte, string, []byte, int, error) {
+	if len(b) == 0 {
+		return 0, "", nil, 0, io.ErrUnexpectedEOF
+	}
+	op := b[0]
+	if op != kvOpPut && op != kvOpDelete {
+		return 0, "", nil, 0, fmt.Errorf("unknown record type %q", op)
+	}
+	pos := 1
+	key, n, err := readKVBytes(b[pos:])
+	if err != nil {
+		return 0, "", nil, 0, err
+	}
+	pos += n
+	value, n, err := readKVBytes(b[pos:])
+	if err != nil {
+		return 0, "", nil, 0, err
+	}
+	pos += n
+
+	if len(b) < pos+4 {
+		return 0, "", nil, 0, io.ErrUnexpectedEOF
+	}
+	if binary.BigEndian.Uint32(b[pos:pos+4]) != crc32.ChecksumIEEE(b[:pos]) {
+		return 0, "", nil, 0, errors.New("checksum mismatch")
+	}
+	return op, string(key), append([]byte(nil), value...), pos + 4, nil
+}
+
+// readKVBytes decodes a uvarint length followed by that many bytes
+func readKVBytes(b []byte) ([]byte, int, error) {
+	size, n := binary.Uvarint(b)
+	if n <= 0 || size > uint64(len(b)-n) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	end := n + int(size)
+	return b[n:end], end, nil
+}
+
+// kvTx reads through to the replayed documents and buffers writes until
+// commit. A nil pending value marks a delete.
+type kvTx struct {
+	docs     map[string][]byte
+	pending  map[string][]byte
+	readOnly bool
+}
+
+func (tx *kvTx) Get(key string) ([]byte, error) {
+	if value, ok := tx.pending[key]; ok {
+		if value == nil {
+			return nil, nil
+		}
+		return append([]byte{}, value...), nil
+	}
+	if value, ok := tx.docs[key]; ok {
+		return append([]byte{}, value...), nil
+	}
+	return nil, nil
+}
+
+func (tx *kvTx) Put(key string, value []byte) error {
+	if tx.readOnly {
+		return fmt.Errorf("cannot write %q in a read-only transaction", key)
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	// Copy into a non-nil slice; nil marks a pending delete
+	tx.pending[key] = append(make([]byte, 0, len(value)), value...)
+	return nil
+}
+
+func (tx *kvTx) Delete(key string) error {
+	if tx.readOnly {
+		return fmt.Errorf("cannot delete %q in a read-only transaction", key)
+	}
+	tx.pending[key] = nil
+	return nil
+}
+
+func (tx *kvTx) Keys(prefix string) ([]string, error) {
+	merged := make(map[string][]byte, len(tx.docs))
+	for key, value := range tx.docs {
+		merged[key] = value
+	}
+	for key, value := range tx.pending {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = value
+		}
+	}
+	return sortedKeys(merged, prefix), nil
+}
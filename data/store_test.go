@@ -0,0 +1,227 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// storeBackends opens an empty store of every kind. reopen returns a new
+// handle on the same state, which for the memory store is the same store.
+var storeBackends = []struct {
+	name string
+	open func(t *testing.T) (store Store, reopen func() Store)
+}{
+	{StoreJSON, func(t *testing.T) (Store, func() Store) {
+		dir := t.TempDir()
+		return NewJSONFileStore(dir), func() Store { return NewJSONFileStore(dir) }
+	}},
+	{StoreMemory, func(t *testing.T) (Store, func() Store) {
+		s := NewMemoryStore()
+		return s, func() Store { return s }
+	}},
+	{StoreKV, func(t *testing.T) (Store, func() Store) {
+		path := filepath.Join(t.TempDir(), KVStoreFile)
+		open := func() Store {
+			s, err := OpenKVStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		}
+		return open(), open
+	}},
+}
+
+// get reads key in a View transaction
+func get(t *testing.T, store Store, key string) []byte {
+	t.Helper()
+	var value []byte
+	if err := store.View(func(tx Tx) (err error) {
+		value, err = tx.Get(key)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// keys lists the keys with prefix in a View transaction
+func keys(t *testing.T, store Store, prefix string) string {
+	t.Helper()
+	var list []string
+	if err := store.View(func(tx Tx) (err error) {
+		list, err = tx.Keys(prefix)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(list, " ")
+}
+
+func TestStoreConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store Store, reopen func() Store)
+	}{
+		{"missing key", func(t *testing.T, store Store, reopen func() Store) {
+			if value := get(t, store, ContainersFile); value != nil {
+				t.Errorf("Get() of a missing key = %q, want nil", value)
+			}
+			if got := keys(t, store, ""); got != "" {
+				t.Errorf("Keys() of an empty store = %q", got)
+			}
+		}},
+		{"put and get", func(t *testing.T, store Store, reopen func() Store) {
+			err := store.Update(func(tx Tx) error {
+				if err := tx.Put(ContainersFile, []byte("first")); err != nil {
+					return err
+				}
+				if err := tx.Put(ContainersFile, []byte("second")); err != nil {
+					return err
+				}
+				// Writes are visible inside their transaction
+				if value, err := tx.Get(ContainersFile); err != nil || string(value) != "second" {
+					t.Errorf("Get() in the transaction = %q, %v", value, err)
+				}
+				return tx.Put("content/sha256/abc", []byte{})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value := get(t, reopen(), ContainersFile); string(value) != "second" {
+				t.Errorf("Get() = %q, want %q", value, "second")
+			}
+			if value := get(t, reopen(), "content/sha256/abc"); value == nil || len(value) != 0 {
+				t.Errorf("Get() of an empty document = %#v, want empty", value)
+			}
+		}},
+		{"returned values are copies", func(t *testing.T, store Store, reopen func() Store) {
+			value := []byte("original")
+			store.Update(func(tx Tx) error { return tx.Put(ImagesFile, value) })
+			value[0] = 'X'
+			got := get(t, store, ImagesFile)
+			got[1] = 'X'
+			if value := get(t, store, ImagesFile); string(value) != "original" {
+				t.Errorf("Get() = %q after changing the slices given and returned", value)
+			}
+		}},
+		{"delete", func(t *testing.T, store Store, reopen func() Store) {
+			store.Update(func(tx Tx) error { return tx.Put(ImagesFile, []byte("x")) })
+			err := store.Update(func(tx Tx) error {
+				if err := tx.Delete(ImagesFile); err != nil {
+					return err
+				}
+				if value, _ := tx.Get(ImagesFile); value != nil {
+					t.Errorf("Get() after Delete() in the transaction = %q", value)
+				}
+				return tx.Delete("never-stored")
+			})
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if value := get(t, reopen(), ImagesFile); value != nil {
+				t.Errorf("Get() after Delete() = %q", value)
+			}
+		}},
+		{"keys", func(t *testing.T, store Store, reopen func() Store) {
+			err := store.Update(func(tx Tx) error {
+				for _, key := range []string{ImagesFile, "content/sha256/b", ContainersFile, "content/sha256/a", "buildcache/x"} {
+					if err := tx.Put(key, []byte(key)); err != nil {
+						return err
+					}
+				}
+				return tx.Delete("buildcache/x")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := reopen()
+			if got := keys(t, s, ""); got != "containers.json content/sha256/a content/sha256/b images.json" {
+				t.Errorf("Keys(\"\") = %q", got)
+			}
+			if got := keys(t, s, "content/"); got != "content/sha256/a content/sha256/b" {
+				t.Errorf("Keys(content/) = %q", got)
+			}
+			if got := keys(t, s, "nothing"); got != "" {
+				t.Errorf("Keys(nothing) = %q", got)
+			}
+		}},
+		{"invalid keys", func(t *testing.T, store Store, reopen func() Store) {
+			for _, key := range []string{"", "/etc/passwd", "../escape", "a/../../b", "a//b", `a\b`, "./a"} {
+				if err := store.Update(func(tx Tx) error { return tx.Put(key, []byte("x")) }); err == nil {
+					t.Errorf("Put(%q) succeeded", key)
+				}
+			}
+			if got := keys(t, store, ""); got != "" {
+				t.Errorf("Keys() after rejected writes = %q", got)
+			}
+		}},
+		{"view is read-only", func(t *testing.T, store Store, reopen func() Store) {
+			store.View(func(tx Tx) error {
+				if err := tx.Put(ImagesFile, []byte("x")); err == nil {
+					t.Error("Put() in a View succeeded")
+				}
+				if err := tx.Delete(ImagesFile); err == nil {
+					t.Error("Delete() in a View succeeded")
+				}
+				return nil
+			})
+			if value := get(t, store, ImagesFile); value != nil {
+				t.Errorf("Get() after a write in a View = %q", value)
+			}
+		}},
+		{"errors propagate", func(t *testing.T, store Store, reopen func() Store) {
+			failed := errors.New("failed")
+			if err := store.View(func(tx Tx) error { return failed }); err != failed {
+				t.Errorf("View() error = %v, want %v", err, failed)
+			}
+			if err := store.Update(func(tx Tx) error { return failed }); err != failed {
+				t.Errorf("Update() error = %v, want %v", err, failed)
+			}
+		}},
+		{"managers", func(t *testing.T, store Store, reopen func() Store) {
+			cm, err := NewContainerManager(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cm.CreateContainer(ContainerConfig{Name: "web", Image: "alpine"}); err != nil {
+				t.Fatal(err)
+			}
+			if cm, err = NewContainerManager(reopen()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cm.ResolveContainer("web"); err != nil {
+				t.Errorf("container not persisted: %v", err)
+			}
+			if err := store.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		}},
+	}
+	for _, backend := range storeBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				store, reopen := backend.open(t)
+				tt.run(t, store, reopen)
+			})
+		}
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	SetDataRoot(t.TempDir())
+	defer SetDataRoot("")
+	for kind, want := range map[string]string{"": "*data.JSONFileStore", StoreJSON: "*data.JSONFileStore",
+		StoreMemory: "*data.MemoryStore", StoreKV: "*data.KVStore"} {
+		store, err := OpenStore(kind)
+		if err != nil || fmt.Sprintf("%T", store) != want {
+			t.Errorf("OpenStore(%q) = %T, %v, want %s", kind, store, err, want)
+		}
+	}
+	if _, err := OpenStore("bolt"); err == nil || !strings.Contains(err.Error(), `unknown state store "bolt"`) {
+		t.Errorf("OpenStore(bolt) error = %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+// data/units.go
+package data
+
+import "fmt"
+
+// HumanSize renders a byte count with decimal units and three significant
+// digits, e.g. "77.9MB", as docker images and BuildKit progress do
+func HumanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	s := float64(size)
+	i := 0
+	for s >= 1000 && i < len(units)-1 {
+		s /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.3g%s", s, units[i])
+}
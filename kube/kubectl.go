@@ -0,0 +1,79 @@
+// kube/kubectl.go
+
+// Package kube drives a Kubernetes cluster through kubectl, which runs
+// containers and in-cluster builds.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"prepare.sh/dockermock/runner"
+)
+
+// Kubectl runs kubectl commands in one namespace
+type Kubectl struct {
+	Runner    runner.Runner
+	Namespace string
+}
+
+// New returns a client running the kubectl on this machine
+func New(namespace string) *Kubectl {
+	return &Kubectl{Runner: runner.Exec{}, Namespace: namespace}
+}
+
+// command returns a kubectl command in the namespace
+func (k *Kubectl) command(args ...string) runner.Cmd {
+	return runner.Cmd{Name: "kubectl", Args: append([]string{"-n", k.Namespace}, args...)}
+}
+
+// Run runs kubectl with args, streaming its output
+func (k *Kubectl) Run(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+	cmd := k.command(args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	return k.Runner.Run(ctx, cmd)
+}
+
+// Output runs kubectl with args and returns its trimmed output
+func (k *Kubectl) Output(ctx context.Context, args ...string) (string, error) {
+	out, err := runner.Output(ctx, k.Runner, k.command(args...))
+	return strings.TrimSpace(string(out)), err
+}
+
+// HasNamespace reports whether the namespace exists
+func (k *Kubectl) HasNamespace(ctx context.Context) bool {
+	_, err := k.Output(ctx, "get", "namespace", k.Namespace)
+	return err == nil
+}
+
+// CreateNamespace creates the namespace
+func (k *Kubectl) CreateNamespace(ctx context.Context) error {
+	_, err := k.Output(ctx, "create", "namespace", k.Namespace)
+	return err
+}
+
+// EnsureNamespace creates the namespace unless it exists, reporting
+// whether it was created
+func (k *Kubectl) EnsureNamespace(ctx context.Context) (bool, error) {
+	if k.HasNamespace(ctx) {
+		return false, nil
+	}
+	return true, k.CreateNamespace(ctx)
+}
+
+// Apply creates or updates the objects of a JSON or YAML manifest
+func (k *Kubectl) Apply(ctx context.Context, manifest []byte) error {
+	cmd := k.command("apply", "-f", "-")
+	cmd.Stdin = bytes.NewReader(manifest)
+	_, err := runner.Output(ctx, k.Runner, cmd)
+	return err
+}
+
+// Delete deletes an object, e.g. Delete(ctx, "pod", "web"); a missing
+// object is not an error
+func (k *Kubectl) Delete(ctx context.Context, kind, name string) error {
+	_, err := k.Output(ctx, "delete", kind, name, "--ignore-not-found")
+	return err
+}
@@ -2,19 +2,10 @@
 package main
 
 import (
-	"fmt"
-	"os"
-
 	"prepare.sh/dockermock/cmd"
-	"prepare.sh/dockermock/data"
 )
 
 func main() {
-	// Ensure storage directory exists
-	if err := data.EnsureStorageDir(); err != nil {
-		fmt.Println("Error creating storage directory:", err)
-		os.Exit(1)
-	}
-
+	// The state root is resolved from --data-root by the root command
 	cmd.Execute()
 }
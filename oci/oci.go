@@ -0,0 +1,148 @@
+// oci/oci.go
+
+// Package oci defines the subset of the OCI image and distribution
+// specifications the mock uses to describe, push and pull images.
+package oci
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Media types of manifests, configs and layers
+const (
+	MediaTypeImageManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageConfig    = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerConfig   = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Descriptor references content by digest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an image manifest: a config and an ordered list of layers
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Index lists the manifests of a multi-platform image
+type Index struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType,omitempty"`
+	Manifests     []PlatformDescriptor `json:"manifests"`
+}
+
+// PlatformDescriptor is an index entry, a manifest for one platform
+type PlatformDescriptor struct {
+	Descriptor
+	Platform *Platform `json:"platform,omitempty"`
+}
+
+// Platform identifies the platform an image runs on
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Image is an image configuration, the document an image ID is the digest
+// of
+type Image struct {
+	Created      *time.Time `json:"created,omitempty"`
+	Author       string     `json:"author,omitempty"`
+	Architecture string     `json:"architecture"`
+	OS           string     `json:"os"`
+	Config       Config     `json:"config,omitempty"`
+	RootFS       RootFS     `json:"rootfs"`
+	History      []History  `json:"history,omitempty"`
+
+	// BuildCache records the cache keys of the build steps that produced
+	// the image, so that builds elsewhere can reuse it with --cache-from
+	BuildCache []BuildCacheEntry `json:"org.dockermock.build.cache,omitempty"`
+}
+
+// BuildCacheEntry ties the cache key of a build step to the history entry
+// and, unless Layer is -1, the layer it produced
+type BuildCacheEntry struct {
+	Key     string `json:"key"`
+	History int    `json:"history"`
+	Layer   int    `json:"layer"`
+}
+
+// Config is the execution configuration of an image
+type Config struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+
+	// Docker extensions to the OCI configuration
+	Healthcheck *HealthConfig `json:"Healthcheck,omitempty"`
+	OnBuild     []string      `json:"OnBuild,omitempty"`
+	Shell       []string      `json:"Shell,omitempty"`
+}
+
+// HealthConfig is the health check of an image, as set by HEALTHCHECK.
+// Durations are in nanoseconds; zero means the default.
+type HealthConfig struct {
+	Test          []string      `json:"Test,omitempty"`
+	Interval      time.Duration `json:"Interval,omitempty"`
+	Timeout       time.Duration `json:"Timeout,omitempty"`
+	StartPeriod   time.Duration `json:"StartPeriod,omitempty"`
+	StartInterval time.Duration `json:"StartInterval,omitempty"`
+	Retries       int           `json:"Retries,omitempty"`
+}
+
+// RootFS lists the uncompressed digests of an image's layers
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History describes how one layer, or one empty step, was created
+type History struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"empty_layer,omitempty"`
+}
+
+// Digest returns the sha256 digest of content in algorithm:hex form
+func Digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// ValidDigest reports whether d is a well-formed sha256 digest
+func ValidDigest(d string) bool {
+	hex := strings.TrimPrefix(d, "sha256:")
+	if len(hex) != 64 || len(hex) == len(d) {
+		return false
+	}
+	for _, r := range hex {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+// reference/normalize.go
+package reference
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultDomain is the registry of references that do not name one
+	DefaultDomain = "docker.io"
+	// DefaultTag is the tag of references that have neither a tag nor a
+	// digest
+	DefaultTag = "latest"
+
+	legacyDefaultDomain = "index.docker.io"
+	officialRepoPrefix  = "library/"
+)
+
+// ParseNormalized parses a reference the way the docker CLI does: names
+// without a registry are on Docker Hub, and single-component Hub names are
+// official images in library/. "ubuntu" and "docker.io/library/ubuntu" are
+// the same reference.
+func ParseNormalized(s string) (Reference, error) {
+	if identifierRegexp.MatchString(s) {
+		return Reference{}, fmt.Errorf("invalid repository name (%s), cannot specify 64-byte hexadecimal strings", s)
+	}
+
+	domain, remainder := splitDockerDomain(s)
+	if i := strings.IndexAny(remainder, ":@"); i >= 0 {
+		if strings.ToLower(remainder[:i]) != remainder[:i] {
+			return Reference{}, ErrNameContainsUppercase
+		}
+	} else if strings.ToLower(remainder) != remainder {
+		return Reference{}, ErrNameContainsUppercase
+	}
+
+	ref, err := Parse(domain + "/" + remainder)
+	if err != nil {
+		return Reference{}, err
+	}
+	return ref, nil
+}
+
+// splitDockerDomain splits a name into its registry and remote name. The
+// first component is a registry only if it looks like a host: it contains a
+// "." or ":", or is "localhost".
+func splitDockerDomain(name string) (domain, remainder string) {
+	i := strings.IndexRune(name, '/')
+	if i == -1 || (!strings.ContainsAny(name[:i], ".:") && name[:i] != "localhost" && strings.ToLower(name[:i]) == name[:i]) {
+		domain, remainder = DefaultDomain, name
+	} else {
+		domain, remainder = name[:i], name[i+1:]
+	}
+	if domain == legacyDefaultDomain {
+		domain = DefaultDomain
+	}
+	if domain == DefaultDomain && !strings.ContainsRune(remainder, '/') {
+		remainder = officialRepoPrefix + remainder
+	}
+	return domain, remainder
+}
+
+// ParseNormalizedTagged parses a reference with ParseNormalized and adds the
+// default tag when it has neither a tag nor a digest
+func ParseNormalizedTagged(s string) (Reference, error) {
+	ref, err := ParseNormalized(s)
+	if err != nil {
+		return Reference{}, err
+	}
+	if ref.IsNameOnly() {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+// FamiliarName returns the shortest form of the repository name, dropping
+// the default registry and the library/ prefix of official images
+func (r Reference) FamiliarName() string {
+	if r.Domain != DefaultDomain {
+		return r.Name()
+	}
+	path := r.Path
+	if strings.HasPrefix(path, officialRepoPrefix) && !strings.ContainsRune(path[len(officialRepoPrefix):], '/') {
+		path = path[len(officialRepoPrefix):]
+	}
+	return path
+}
+
+// FamiliarString returns the shortest form of the full reference, e.g.
+// "ubuntu:22.04"
+func (r Reference) FamiliarString() string {
+	return r.FamiliarName() + r.suffix()
+}
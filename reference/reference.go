@@ -0,0 +1,129 @@
+// reference/reference.go
+
+// Package reference parses and normalizes image references such as
+// "ubuntu", "localhost:5000/app:1.0" or "app@sha256:…", following the
+// distribution reference grammar.
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NameTotalLengthMax is the maximum length of a repository name
+const NameTotalLengthMax = 255
+
+// Validation errors, worded as Docker reports them
+var (
+	ErrReferenceInvalidFormat = errors.New("invalid reference format")
+	ErrTagInvalidFormat       = errors.New("invalid tag format")
+	ErrDigestInvalidFormat    = errors.New("invalid digest format")
+	ErrNameContainsUppercase  = errors.New("invalid reference format: repository name must be lowercase")
+	ErrNameEmpty              = errors.New("repository name must have at least one component")
+	ErrNameTooLong            = fmt.Errorf("repository name must not be more than %d characters", NameTotalLengthMax)
+)
+
+// Reference is a parsed image reference. Domain is empty for references
+// parsed with Parse that do not name a registry.
+type Reference struct {
+	Domain string // registry host, optionally with a port
+	Path   string // repository path within the registry
+	Tag    string
+	Digest string // algorithm:hex
+}
+
+// Parse parses s exactly as written, without normalization
+func Parse(s string) (Reference, error) {
+	matches := referenceRegexp.FindStringSubmatch(s)
+	if matches == nil {
+		if s == "" {
+			return Reference{}, ErrNameEmpty
+		}
+		if referenceRegexp.MatchString(strings.ToLower(s)) {
+			return Reference{}, ErrNameContainsUppercase
+		}
+		return Reference{}, ErrReferenceInvalidFormat
+	}
+
+	name := matches[1]
+	if len(name) > NameTotalLengthMax {
+		return Reference{}, ErrNameTooLong
+	}
+
+	ref := Reference{Tag: matches[2], Digest: matches[3]}
+	if parts := nameRegexp.FindStringSubmatch(name); parts != nil {
+		ref.Domain, ref.Path = parts[1], parts[2]
+	} else {
+		ref.Path = name
+	}
+
+	if ref.Digest != "" {
+		if err := validateDigest(ref.Digest); err != nil {
+			return Reference{}, err
+		}
+	}
+	return ref, nil
+}
+
+// validateDigest checks the length of digests whose algorithm is known
+func validateDigest(digest string) error {
+	i := strings.Index(digest, ":")
+	algorithm, hex := digest[:i], digest[i+1:]
+	sizes := map[string]int{"sha256": 64, "sha384": 96, "sha512": 128}
+	size, known := sizes[algorithm]
+	if !known {
+		return fmt.Errorf("%w: unsupported digest algorithm", ErrDigestInvalidFormat)
+	}
+	if len(hex) != size || strings.ToLower(hex) != hex {
+		return ErrDigestInvalidFormat
+	}
+	return nil
+}
+
+// ValidateTag reports whether tag is a valid tag
+func ValidateTag(tag string) error {
+	if !tagRegexp.MatchString(tag) {
+		return ErrTagInvalidFormat
+	}
+	return nil
+}
+
+// Name returns the repository name, including the domain
+func (r Reference) Name() string {
+	if r.Domain == "" {
+		return r.Path
+	}
+	return r.Domain + "/" + r.Path
+}
+
+// String returns the full reference
+func (r Reference) String() string {
+	return r.Name() + r.suffix()
+}
+
+// suffix returns the ":tag" and "@digest" parts of the reference
+func (r Reference) suffix() string {
+	var s string
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// IsNameOnly reports whether the reference has neither a tag nor a digest
+func (r Reference) IsNameOnly() bool {
+	return r.Tag == "" && r.Digest == ""
+}
+
+// WithTag returns the reference with its tag replaced
+func (r Reference) WithTag(tag string) (Reference, error) {
+	if err := ValidateTag(tag); err != nil {
+		return Reference{}, err
+	}
+	r.Tag = tag
+	return r, nil
+}
@@ -0,0 +1,148 @@
+package reference
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:ba0bf0a3a4e58bd6f9bc3e1a5c5e8a3b0bb8b8f0e9e2b4c5d6f7a8b9c0d1e2f3"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in                        string
+		domain, path, tag, digest string
+	}{
+		{in: "ubuntu", path: "ubuntu"},
+		{in: "ubuntu:22.04", path: "ubuntu", tag: "22.04"},
+		{in: "library/ubuntu", domain: "library", path: "ubuntu"},
+		{in: "localhost:5000/app:1.0", domain: "localhost:5000", path: "app", tag: "1.0"},
+		{in: "ghcr.io/org/team/app", domain: "ghcr.io", path: "org/team/app"},
+		{in: "[::1]:5000/app", domain: "[::1]:5000", path: "app"},
+		{in: "a-b.c_d__e/f", path: "a-b.c_d__e/f"}, // underscores make it no host
+		{in: "app@" + testDigest, path: "app", digest: testDigest},
+		{in: "app:v1@" + testDigest, path: "app", tag: "v1", digest: testDigest},
+		{in: "app:" + strings.Repeat("t", 128), path: "app", tag: strings.Repeat("t", 128)},
+	}
+	for _, tt := range tests {
+		ref, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.in, err)
+			continue
+		}
+		if ref.Domain != tt.domain || ref.Path != tt.path || ref.Tag != tt.tag || ref.Digest != tt.digest {
+			t.Errorf("Parse(%q) = %+v, want domain %q path %q tag %q digest %q", tt.in, ref, tt.domain, tt.path, tt.tag, tt.digest)
+		}
+		if got := ref.String(); got != tt.in {
+			t.Errorf("Parse(%q).String() = %q", tt.in, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want error
+	}{
+		{"", ErrNameEmpty},
+		{"Ubuntu", ErrNameContainsUppercase},
+		{"ubuntu:", ErrReferenceInvalidFormat},
+		{"-app", ErrReferenceInvalidFormat},
+		{"app/", ErrReferenceInvalidFormat},
+		{"app//x", ErrReferenceInvalidFormat},
+		{"app:" + strings.Repeat("t", 129), ErrReferenceInvalidFormat},
+		{"app:-tag", ErrReferenceInvalidFormat},
+		{"app@sha256:abc", ErrReferenceInvalidFormat},
+		{"app@sha256:" + strings.Repeat("a", 63), ErrDigestInvalidFormat},
+		{"app@sha256:" + strings.ToUpper(testDigest[7:]), ErrDigestInvalidFormat},
+		{"app@md5:" + strings.Repeat("a", 32), ErrDigestInvalidFormat},
+		{strings.Repeat("a/", 128) + "a", ErrNameTooLong},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.in); !errors.Is(err, tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestParseNormalized(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     string // full reference
+		familiar string
+	}{
+		{"ubuntu", "docker.io/library/ubuntu", "ubuntu"},
+		{"ubuntu:22.04", "docker.io/library/ubuntu:22.04", "ubuntu:22.04"},
+		{"library/ubuntu", "docker.io/library/ubuntu", "ubuntu"},
+		{"docker.io/ubuntu", "docker.io/library/ubuntu", "ubuntu"},
+		{"index.docker.io/library/ubuntu", "docker.io/library/ubuntu", "ubuntu"},
+		{"bitnami/redis:7", "docker.io/bitnami/redis:7", "bitnami/redis:7"},
+		{"docker.io/library/a/b", "docker.io/library/a/b", "library/a/b"},
+		{"localhost/app", "localhost/app", "localhost/app"},
+		{"localhost:5000/app:1.0", "localhost:5000/app:1.0", "localhost:5000/app:1.0"},
+		{"registry.example.com/team/app", "registry.example.com/team/app", "registry.example.com/team/app"},
+		{"app@" + testDigest, "docker.io/library/app@" + testDigest, "app@" + testDigest},
+	}
+	for _, tt := range tests {
+		ref, err := ParseNormalized(tt.in)
+		if err != nil {
+			t.Errorf("ParseNormalized(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got := ref.String(); got != tt.want {
+			t.Errorf("ParseNormalized(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got := ref.FamiliarString(); got != tt.familiar {
+			t.Errorf("ParseNormalized(%q).FamiliarString() = %q, want %q", tt.in, got, tt.familiar)
+		}
+	}
+}
+
+func TestParseNormalizedErrors(t *testing.T) {
+	tests := []struct {
+		in      string
+		message string
+	}{
+		{"Ubuntu", ErrNameContainsUppercase.Error()},
+		{"org/Repo:Tag", ErrNameContainsUppercase.Error()},
+		{strings.Repeat("ab", 32), "cannot specify 64-byte hexadecimal strings"},
+		{"ubuntu::1", ErrReferenceInvalidFormat.Error()},
+	}
+	for _, tt := range tests {
+		_, err := ParseNormalized(tt.in)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("ParseNormalized(%q) error = %v, want %q", tt.in, err, tt.message)
+		}
+	}
+
+	// An upper-case registry is a registry, not a repository name
+	if ref, err := ParseNormalized("Example.com/app"); err != nil || ref.Domain != "Example.com" {
+		t.Errorf("ParseNormalized(Example.com/app) = %+v, %v", ref, err)
+	}
+}
+
+func TestParseNormalizedTagged(t *testing.T) {
+	tests := map[string]string{
+		"ubuntu":             "docker.io/library/ubuntu:latest",
+		"ubuntu:22.04":       "docker.io/library/ubuntu:22.04",
+		"app@" + testDigest:  "docker.io/library/app@" + testDigest,
+		"localhost:5000/app": "localhost:5000/app:latest",
+	}
+	for in, want := range tests {
+		ref, err := ParseNormalizedTagged(in)
+		if err != nil || ref.String() != want {
+			t.Errorf("ParseNormalizedTagged(%q) = %q, %v, want %q", in, ref.String(), err, want)
+		}
+	}
+}
+
+func TestWithTag(t *testing.T) {
+	ref, _ := ParseNormalized("app:1.0")
+	tagged, err := ref.WithTag("2.0")
+	if err != nil || tagged.FamiliarString() != "app:2.0" || ref.Tag != "1.0" {
+		t.Errorf("WithTag(2.0) = %q, %v", tagged.FamiliarString(), err)
+	}
+	if _, err := ref.WithTag("bad tag"); !errors.Is(err, ErrTagInvalidFormat) {
+		t.Errorf("WithTag(bad tag) error = %v, want %v", err, ErrTagInvalidFormat)
+	}
+}
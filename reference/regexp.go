@@ -0,0 +1,68 @@
+// reference/regexp.go
+package reference
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The grammar below follows the distribution reference specification:
+//
+//	reference            := name [ ":" tag ] [ "@" digest ]
+//	name                 := [domain '/'] remote-name
+//	domain               := host [':' port-number]
+//	host                 := domain-name | IPv4address | \[ IPv6address \]
+//	domain-name          := domain-component ['.' domain-component]*
+//	domain-component     := /([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])/
+//	port-number          := /[0-9]+/
+//	path-component       := alpha-numeric [separator alpha-numeric]*
+//	remote-name          := path-component ['/' path-component]*
+//	alpha-numeric        := /[a-z0-9]+/
+//	separator            := /[_.]|__|[-]*/
+//	tag                  := /[\w][\w.-]{0,127}/
+//	digest               := digest-algorithm ":" digest-hex
+//	digest-algorithm     := component [ /[+.-_]/ component ]*
+//	component            := /[A-Za-z][A-Za-z0-9]*/
+//	digest-hex           := /[0-9a-fA-F]{32,}/
+const (
+	alphanumeric    = `[a-z0-9]+`
+	separator       = `(?:[._]|__|[-]+)`
+	domainComponent = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+	ipv6Address     = `\[(?:[a-fA-F0-9:]+)\]`
+	tag             = `[\w][\w.-]{0,127}`
+	digestPattern   = `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*[:][[:xdigit:]]{32,}`
+	identifier      = `[a-f0-9]{64}`
+)
+
+var (
+	pathComponent = alphanumeric + optional(repeated(separator, alphanumeric))
+	domainName    = domainComponent + optional(repeated(`\.`, domainComponent))
+	host          = `(?:` + domainName + `|` + ipv6Address + `)`
+	domainAndPort = host + optional(`:[0-9]+`)
+	namePattern   = optional(domainAndPort, `/`) + pathComponent + optional(repeated(`/`, pathComponent))
+
+	// referenceRegexp captures the name, tag and digest of a reference
+	referenceRegexp = anchoredRegexp(capture(namePattern), optional(`:`, capture(tag)), optional(`@`, capture(digestPattern)))
+
+	// nameRegexp captures the domain and remote name of a repository name
+	nameRegexp = anchoredRegexp(optional(capture(domainAndPort), `/`), capture(pathComponent, optional(repeated(`/`, pathComponent))))
+
+	tagRegexp        = anchoredRegexp(tag)
+	identifierRegexp = anchoredRegexp(identifier)
+)
+
+func optional(res ...string) string {
+	return `(?:` + strings.Join(res, "") + `)?`
+}
+
+func repeated(res ...string) string {
+	return `(?:` + strings.Join(res, "") + `)+`
+}
+
+func capture(res ...string) string {
+	return `(` + strings.Join(res, "") + `)`
+}
+
+func anchoredRegexp(res ...string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + strings.Join(res, "") + `$`)
+}
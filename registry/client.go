@@ -0,0 +1,224 @@
+// registry/client.go
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"prepare.sh/dockermock/oci"
+)
+
+// manifestAccept lists the manifest media types the client understands
+var manifestAccept = []string{
+	oci.MediaTypeImageManifest,
+	oci.MediaTypeImageIndex,
+	oci.MediaTypeDockerManifest,
+}
+
+// Client talks to a registry over the distribution API
+type Client struct {
+	Host string // host[:port] of the registry
+	HTTP *http.Client
+
+	base string
+}
+
+// NewClient returns a client for the registry at host. Like the Docker
+// daemon, it speaks plain HTTP to registries on the loopback interface and
+// HTTPS to everything else.
+func NewClient(host string) *Client {
+	scheme := "https"
+	if IsLocal(host) {
+		scheme = "http"
+	}
+	return &Client{
+		Host: host,
+		HTTP: &http.Client{Timeout: 30 * time.Second},
+		base: scheme + "://" + host,
+	}
+}
+
+// IsLocal reports whether host, with an optional port, is on the loopback
+// interface
+func IsLocal(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// do sends a request and turns error responses into *Error values
+func (c *Client) do(method, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		target = c.base + path
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var errResp errorResponse
+	if json.NewDecoder(resp.Body).Decode(&errResp) == nil && len(errResp.Errors) > 0 {
+		e := errResp.Errors[0]
+		e.status = resp.StatusCode
+		return nil, e
+	}
+	if method == http.MethodHead && resp.StatusCode == http.StatusNotFound {
+		return nil, &Error{Code: CodeBlobUnknown, Message: "blob unknown to registry", status: resp.StatusCode}
+	}
+	return nil, fmt.Errorf("unexpected status from %s %s: %s", method, target, resp.Status)
+}
+
+// Ping checks that the host serves the distribution API
+func (c *Client) Ping() error {
+	resp, err := c.do(http.MethodGet, "/v2/", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// BlobExists reports whether repo has the blob
+func (c *Client) BlobExists(repo, digest string) (bool, error) {
+	resp, err := c.do(http.MethodHead, "/v2/"+repo+"/blobs/"+digest, nil, nil)
+	if regErr, ok := err.(*Error); ok && regErr.status == http.StatusNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// PushBlob uploads content to repo, starting an upload session and
+// completing it in a single PUT
+func (c *Client) PushBlob(repo string, content []byte) (string, error) {
+	digest := oci.Digest(content)
+	resp, err := c.do(http.MethodPost, "/v2/"+repo+"/blobs/uploads/", nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("upload for %s has no location: %v", repo, err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err = c.do(http.MethodPut, location.String(), bytes.NewReader(content), header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return digest, nil
+}
+
+// GetBlob downloads a blob and verifies its digest
+func (c *Client) GetBlob(repo, digest string) ([]byte, error) {
+	resp, err := c.do(http.MethodGet, "/v2/"+repo+"/blobs/"+digest, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if oci.Digest(content) != digest {
+		return nil, fmt.Errorf("blob %s of %s failed verification", digest, repo)
+	}
+	return content, nil
+}
+
+// PutManifest uploads a manifest under a tag or digest and returns its
+// digest
+func (c *Client) PutManifest(repo, ref, mediaType string, content []byte) (string, error) {
+	header := http.Header{"Content-Type": {mediaType}}
+	resp, err := c.do(http.MethodPut, "/v2/"+repo+"/manifests/"+ref, bytes.NewReader(content), header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return oci.Digest(content), nil
+}
+
+// GetManifest downloads the manifest ref, a tag or digest, of repo and
+// returns it with its media type and digest
+func (c *Client) GetManifest(repo, ref string) (content []byte, mediaType, digest string, err error) {
+	header := http.Header{"Accept": {strings.Join(manifestAccept, ", ")}}
+	resp, err := c.do(http.MethodGet, "/v2/"+repo+"/manifests/"+ref, nil, header)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if content, err = io.ReadAll(resp.Body); err != nil {
+		return nil, "", "", err
+	}
+	digest = oci.Digest(content)
+	if strings.HasPrefix(ref, "sha256:") && digest != ref {
+		return nil, "", "", fmt.Errorf("manifest %s of %s failed verification", ref, repo)
+	}
+	return content, resp.Header.Get("Content-Type"), digest, nil
+}
+
+// Tags lists the tags of repo
+func (c *Client) Tags(repo string) ([]string, error) {
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.getJSON("/v2/"+repo+"/tags/list", &list); err != nil {
+		return nil, err
+	}
+	return list.Tags, nil
+}
+
+// Catalog lists the repositories of the registry
+func (c *Client) Catalog() ([]string, error) {
+	var list struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := c.getJSON("/v2/_catalog", &list); err != nil {
+		return nil, err
+	}
+	return list.Repositories, nil
+}
+
+func (c *Client) getJSON(path string, v interface{}) error {
+	resp, err := c.do(http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"prepare.sh/dockermock/oci"
+)
+
+// newTestClient returns a client of a registry served by a test server
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	srv := newTestServer(t)
+	return NewClient(strings.TrimPrefix(srv.URL, "http://"))
+}
+
+func TestPushPull(t *testing.T) {
+	c := newTestClient(t)
+	if err := c.Ping(); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	blobs := map[string][]byte{}
+	add := func(content []byte) oci.Descriptor {
+		digest := oci.Digest(content)
+		blobs[digest] = content
+		return oci.Descriptor{MediaType: oci.MediaTypeImageLayerGzip, Digest: digest, Size: int64(len(content))}
+	}
+	layers := []oci.Descriptor{add([]byte("first layer")), add([]byte("second layer"))}
+	config := add([]byte(`{"architecture":"amd64","os":"linux"}`))
+	config.MediaType = oci.MediaTypeImageConfig
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"digest":%q,"size":%d},"layers":[`+
+		`{"mediaType":%q,"digest":%q,"size":%d},{"mediaType":%q,"digest":%q,"size":%d}]}`,
+		oci.MediaTypeImageManifest, config.MediaType, config.Digest, config.Size,
+		layers[0].MediaType, layers[0].Digest, layers[0].Size, layers[1].MediaType, layers[1].Digest, layers[1].Size))
+	blob := func(digest string) ([]byte, error) {
+		if content, ok := blobs[digest]; ok {
+			return content, nil
+		}
+		return nil, fmt.Errorf("no blob %s", digest)
+	}
+
+	var progress []string
+	record := func(id, status string) { progress = append(progress, id+" "+status) }
+	digest, size, err := Push(c, "team/app", "1.0", manifest, blob, record)
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if digest != oci.Digest(manifest) || size != len(manifest) {
+		t.Errorf("Push() = %s, %d, want %s, %d", digest, size, oci.Digest(manifest), len(manifest))
+	}
+	want := []string{
+		ShortDigest(layers[0].Digest) + " Preparing", ShortDigest(layers[0].Digest) + " Pushed",
+		ShortDigest(layers[1].Digest) + " Preparing", ShortDigest(layers[1].Digest) + " Pushed",
+	}
+	if strings.Join(progress, "\n") != strings.Join(want, "\n") {
+		t.Errorf("push progress:\n%s\nwant:\n%s", strings.Join(progress, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Pushing again under another tag uploads nothing
+	progress = nil
+	if _, _, err := Push(c, "team/app", "latest", manifest, blob, record); err != nil {
+		t.Fatalf("second Push() error = %v", err)
+	}
+	if strings.Count(strings.Join(progress, "\n"), "Layer already exists") != 2 {
+		t.Errorf("second push progress = %q", progress)
+	}
+
+	for _, ref := range []string{"1.0", digest} {
+		pulled, err := Pull(c, "team/app", ref, func(string, string) {})
+		if err != nil {
+			t.Fatalf("Pull(%s) error = %v", ref, err)
+		}
+		if pulled.Digest != digest || !bytes.Equal(pulled.Manifest, manifest) || !bytes.Equal(pulled.Config, blobs[config.Digest]) {
+			t.Errorf("Pull(%s) = %+v", ref, pulled)
+		}
+		if len(pulled.Layers) != 2 || string(pulled.Layers[0]) != "first layer" || string(pulled.Layers[1]) != "second layer" {
+			t.Errorf("Pull(%s) layers = %q", ref, pulled.Layers)
+		}
+		if pulled.Size != config.Size+layers[0].Size+layers[1].Size {
+			t.Errorf("Pull(%s) size = %d", ref, pulled.Size)
+		}
+	}
+
+	tags, err := c.Tags("team/app")
+	if err != nil || strings.Join(tags, " ") != "1.0 latest" {
+		t.Errorf("Tags() = %q, %v", tags, err)
+	}
+	repos, err := c.Catalog()
+	if err != nil || strings.Join(repos, " ") != "team/app" {
+		t.Errorf("Catalog() = %q, %v", repos, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := newTestClient(t)
+
+	_, err := Pull(c, "app", "1.0", func(string, string) {})
+	if regErr, ok := err.(*Error); !ok || regErr.Code != CodeManifestUnknown {
+		t.Errorf("Pull() of a missing image error = %v, want %s", err, CodeManifestUnknown)
+	}
+	if _, err := c.Tags("app"); err == nil || !strings.Contains(err.Error(), "name unknown") {
+		t.Errorf("Tags() of a missing repository error = %v", err)
+	}
+	if exists, err := c.BlobExists("app", oci.Digest([]byte("x"))); exists || err != nil {
+		t.Errorf("BlobExists() = %v, %v", exists, err)
+	}
+
+	manifest := []byte(`{"schemaVersion":2,"config":{"digest":"` + oci.Digest([]byte("missing")) + `"},"layers":[]}`)
+	if _, err := c.PutManifest("app", "1.0", oci.MediaTypeImageManifest, manifest); err == nil || !strings.Contains(err.Error(), "blob unknown") {
+		t.Errorf("PutManifest() with a missing config error = %v", err)
+	}
+}
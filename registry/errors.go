@@ -0,0 +1,78 @@
+// registry/errors.go
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error codes defined by the OCI distribution specification
+const (
+	CodeBlobUnknown         = "BLOB_UNKNOWN"
+	CodeBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	CodeBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	CodeDigestInvalid       = "DIGEST_INVALID"
+	CodeManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	CodeManifestInvalid     = "MANIFEST_INVALID"
+	CodeManifestUnknown     = "MANIFEST_UNKNOWN"
+	CodeNameInvalid         = "NAME_INVALID"
+	CodeNameUnknown         = "NAME_UNKNOWN"
+	CodeSizeInvalid         = "SIZE_INVALID"
+	CodeUnsupported         = "UNSUPPORTED"
+)
+
+// Error is a registry error, as carried in the errors array of a response
+type Error struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Detail  interface{} `json:"detail,omitempty"`
+
+	status int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", strings.ToLower(strings.ReplaceAll(e.Code, "_", " ")), e.Message)
+}
+
+// errorResponse is the body of an error response
+type errorResponse struct {
+	Errors []*Error `json:"errors"`
+}
+
+// errorStatus maps error codes to their HTTP status
+var errorStatus = map[string]int{
+	CodeBlobUnknown:         http.StatusNotFound,
+	CodeBlobUploadInvalid:   http.StatusBadRequest,
+	CodeBlobUploadUnknown:   http.StatusNotFound,
+	CodeDigestInvalid:       http.StatusBadRequest,
+	CodeManifestBlobUnknown: http.StatusBadRequest,
+	CodeManifestInvalid:     http.StatusBadRequest,
+	CodeManifestUnknown:     http.StatusNotFound,
+	CodeNameInvalid:         http.StatusBadRequest,
+	CodeNameUnknown:         http.StatusNotFound,
+	CodeSizeInvalid:         http.StatusBadRequest,
+	CodeUnsupported:         http.StatusMethodNotAllowed,
+}
+
+// newError returns a registry error with the status its code implies
+func newError(code, message string, detail interface{}) *Error {
+	status, ok := errorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	return &Error{Code: code, Message: message, Detail: detail, status: status}
+}
+
+// writeError sends err as an error response. Errors that are not registry
+// errors are internal server errors.
+func writeError(w http.ResponseWriter, err error) {
+	regErr, ok := err.(*Error)
+	if !ok {
+		regErr = &Error{Code: "UNKNOWN", Message: err.Error(), status: http.StatusInternalServerError}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(regErr.status)
+	json.NewEncoder(w).Encode(errorResponse{Errors: []*Error{regErr}})
+}
@@ -0,0 +1,402 @@
+// registry/server.go
+
+// Package registry implements a minimal OCI distribution (v2) registry
+// backed by local disk, and a client for pushing to and pulling from it.
+// The server is an http.Handler so it can be embedded in other programs.
+package registry
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"prepare.sh/dockermock/oci"
+	"prepare.sh/dockermock/reference"
+)
+
+// maxManifestSize bounds manifest uploads, as real registries do
+const maxManifestSize = 4 << 20
+
+// Server serves the distribution API for content stored under a root
+// directory
+type Server struct {
+	store  *storage
+	Logger *log.Logger // logs one line per request when set
+}
+
+// NewServer returns a registry serving content stored under root
+func NewServer(root string) *Server {
+	return &Server{store: &storage{root: root}}
+}
+
+// statusRecorder remembers the status written by a handler for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// ServeHTTP routes distribution API requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	rec.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	s.route(rec, r)
+	if s.Logger != nil {
+		s.Logger.Printf("%s %s %d", r.Method, r.URL.RequestURI(), rec.status)
+	}
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/v2" || path == "/v2/":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	case path == "/v2/_catalog":
+		s.catalog(w, r)
+		return
+	case !strings.HasPrefix(path, "/v2/"):
+		http.NotFound(w, r)
+		return
+	}
+	path = strings.TrimPrefix(path, "/v2/")
+
+	var name, kind, arg string
+	if strings.HasSuffix(path, "/tags/list") {
+		name, kind = strings.TrimSuffix(path, "/tags/list"), "tags"
+	} else if i := strings.LastIndex(path, "/manifests/"); i > 0 {
+		name, kind, arg = path[:i], "manifests", path[i+len("/manifests/"):]
+	} else if i := strings.LastIndex(path, "/blobs/uploads"); i > 0 {
+		name, kind, arg = path[:i], "uploads", strings.TrimPrefix(path[i+len("/blobs/uploads"):], "/")
+	} else if i := strings.LastIndex(path, "/blobs/"); i > 0 {
+		name, kind, arg = path[:i], "blobs", path[i+len("/blobs/"):]
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+	if !validName(name) {
+		writeError(w, newError(CodeNameInvalid, "invalid repository name", map[string]string{"name": name}))
+		return
+	}
+	// Arguments become file names; reject anything but digests, tags and
+	// upload IDs
+	switch {
+	case kind == "manifests" && !oci.ValidDigest(arg) && reference.ValidateTag(arg) != nil:
+		writeError(w, newError(CodeManifestInvalid, "manifest invalid", "invalid reference"))
+		return
+	case kind == "blobs" && !oci.ValidDigest(arg):
+		writeError(w, newError(CodeDigestInvalid, "provided digest is invalid", nil))
+		return
+	case kind == "uploads" && arg != "" && !validUploadID(arg):
+		writeError(w, newError(CodeBlobUploadUnknown, "blob upload unknown to registry", nil))
+		return
+	}
+
+	var err error
+	switch kind + " " + r.Method {
+	case "tags GET":
+		err = s.listTags(w, r, name)
+	case "manifests GET", "manifests HEAD":
+		err = s.getManifest(w, r, name, arg)
+	case "manifests PUT":
+		err = s.putManifest(w, r, name, arg)
+	case "manifests DELETE":
+		err = s.deleteManifest(w, name, arg)
+	case "blobs GET", "blobs HEAD":
+		err = s.getBlob(w, r, arg)
+	case "uploads POST":
+		err = s.startUpload(w, r, name)
+	case "uploads GET":
+		err = s.uploadStatus(w, name, arg)
+	case "uploads PATCH":
+		err = s.patchUpload(w, r, name, arg)
+	case "uploads PUT":
+		err = s.finishUpload(w, r, name, arg)
+	case "uploads DELETE":
+		err = s.cancelUpload(w, arg)
+	default:
+		err = newError(CodeUnsupported, "the operation is unsupported", nil)
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// validName reports whether name is a valid repository path
+func validName(name string) bool {
+	ref, err := reference.Parse(name)
+	return err == nil && ref.IsNameOnly() && ref.Name() == name
+}
+
+// validUploadID reports whether id could have been issued by startUpload
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// paginate applies the n and last query parameters to a sorted list and
+// sets the Link header when more entries follow
+func paginate(w http.ResponseWriter, r *http.Request, list []string) []string {
+	if last := r.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(list, last)
+		if i < len(list) && list[i] == last {
+			i++
+		}
+		list = list[i:]
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n >= 0 && n < len(list) {
+		list = list[:n]
+		if n > 0 {
+			w.Header().Set("Link", fmt.Sprintf("<%s?n=%d&last=%s>; rel=\"next\"", r.URL.Path, n, list[n-1]))
+		}
+	}
+	return list
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) catalog(w http.ResponseWriter, r *http.Request) {
+	repos, err := s.store.repositories()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, map[string][]string{"repositories": paginate(w, r, repos)})
+}
+
+func (s *Server) listTags(w http.ResponseWriter, r *http.Request, name string) error {
+	tags, err := s.store.tags(name)
+	if os.IsNotExist(err) {
+		return newError(CodeNameUnknown, "repository name not known to registry", map[string]string{"name": name})
+	} else if err != nil {
+		return err
+	}
+	return writeJSON(w, struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{name, paginate(w, r, tags)})
+}
+
+func (s *Server) getManifest(w http.ResponseWriter, r *http.Request, name, ref string) error {
+	digest, err := s.store.resolveManifest(name, ref)
+	if err != nil {
+		return newError(CodeManifestUnknown, "manifest unknown", map[string]string{"name": name, "reference": ref})
+	}
+	content, err := s.store.readBlob(digest)
+	if err != nil {
+		return err
+	}
+
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	json.Unmarshal(content, &probe)
+	if probe.MediaType == "" {
+		probe.MediaType = oci.MediaTypeImageManifest
+	}
+
+	w.Header().Set("Content-Type", probe.MediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Etag", `"`+digest+`"`)
+	if r.Method == http.MethodGet {
+		w.Write(content)
+	}
+	return nil
+}
+
+func (s *Server) putManifest(w http.ResponseWriter, r *http.Request, name, ref string) error {
+	content, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		return err
+	}
+	if len(content) > maxManifestSize {
+		return newError(CodeManifestInvalid, "manifest too large", nil)
+	}
+
+	var manifest struct {
+		oci.Manifest
+		Manifests []oci.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return newError(CodeManifestInvalid, "manifest invalid", err.Error())
+	}
+	if manifest.SchemaVersion != 2 {
+		return newError(CodeManifestInvalid, "manifest invalid", "unsupported schema version")
+	}
+
+	// Everything the manifest references must already be uploaded
+	refs := append([]oci.Descriptor{}, manifest.Manifests...)
+	if manifest.Manifests == nil {
+		refs = append([]oci.Descriptor{manifest.Config}, manifest.Layers...)
+	}
+	for _, d := range refs {
+		if _, err := s.store.statBlob(d.Digest); err != nil {
+			return newError(CodeManifestBlobUnknown, "blob unknown to registry", map[string]string{"digest": d.Digest})
+		}
+	}
+
+	tag := ref
+	if strings.HasPrefix(ref, "sha256:") {
+		if oci.Digest(content) != ref {
+			return newError(CodeDigestInvalid, "provided digest did not match uploaded content", nil)
+		}
+		tag = ""
+	} else if reference.ValidateTag(ref) != nil {
+		return newError(CodeManifestInvalid, "manifest invalid", "invalid tag")
+	}
+
+	digest, err := s.store.putManifest(name, tag, content)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (s *Server) deleteManifest(w http.ResponseWriter, name, ref string) error {
+	if !oci.ValidDigest(ref) {
+		return newError(CodeUnsupported, "manifests can only be deleted by digest", nil)
+	}
+	if err := s.store.deleteManifest(name, ref); err != nil {
+		return newError(CodeManifestUnknown, "manifest unknown", map[string]string{"name": name, "reference": ref})
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+func (s *Server) getBlob(w http.ResponseWriter, r *http.Request, digest string) error {
+	size, err := s.store.statBlob(digest)
+	if err != nil {
+		return newError(CodeBlobUnknown, "blob unknown to registry", map[string]string{"digest": digest})
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Etag", `"`+digest+`"`)
+	if r.Method == http.MethodGet {
+		content, err := s.store.readBlob(digest)
+		if err != nil {
+			return err
+		}
+		w.Write(content)
+	}
+	return nil
+}
+
+// startUpload begins a blob upload. A digest parameter makes it a
+// monolithic upload completed by this request; mount and from reuse a blob
+// another repository already has.
+func (s *Server) startUpload(w http.ResponseWriter, r *http.Request, name string) error {
+	query := r.URL.Query()
+	if mount := query.Get("mount"); oci.ValidDigest(mount) {
+		if _, err := s.store.statBlob(mount); err == nil {
+			return blobCreated(w, name, mount)
+		}
+	}
+
+	id, err := s.store.startUpload()
+	if err != nil {
+		return err
+	}
+	if digest := query.Get("digest"); digest != "" {
+		if !oci.ValidDigest(digest) {
+			s.store.cancelUpload(id)
+			return newError(CodeDigestInvalid, "provided digest is invalid", nil)
+		}
+		if _, err := s.store.appendUpload(id, r.Body); err != nil {
+			return err
+		}
+		if err := s.store.finishUpload(id, digest); err != nil {
+			s.store.cancelUpload(id)
+			return err
+		}
+		return blobCreated(w, name, digest)
+	}
+	return uploadAccepted(w, name, id, 0, http.StatusAccepted)
+}
+
+func (s *Server) uploadStatus(w http.ResponseWriter, name, id string) error {
+	size, err := s.store.uploadSize(id)
+	if err != nil {
+		return newError(CodeBlobUploadUnknown, "blob upload unknown to registry", nil)
+	}
+	return uploadAccepted(w, name, id, size, http.StatusNoContent)
+}
+
+func (s *Server) patchUpload(w http.ResponseWriter, r *http.Request, name, id string) error {
+	size, err := s.store.appendUpload(id, r.Body)
+	if os.IsNotExist(err) {
+		return newError(CodeBlobUploadUnknown, "blob upload unknown to registry", nil)
+	} else if err != nil {
+		return err
+	}
+	return uploadAccepted(w, name, id, size, http.StatusAccepted)
+}
+
+func (s *Server) finishUpload(w http.ResponseWriter, r *http.Request, name, id string) error {
+	digest := r.URL.Query().Get("digest")
+	if !oci.ValidDigest(digest) {
+		return newError(CodeDigestInvalid, "provided digest is invalid", nil)
+	}
+	if _, err := s.store.appendUpload(id, r.Body); os.IsNotExist(err) {
+		return newError(CodeBlobUploadUnknown, "blob upload unknown to registry", nil)
+	} else if err != nil {
+		return err
+	}
+	if err := s.store.finishUpload(id, digest); err != nil {
+		return err
+	}
+	return blobCreated(w, name, digest)
+}
+
+func (s *Server) cancelUpload(w http.ResponseWriter, id string) error {
+	if err := s.store.cancelUpload(id); err != nil {
+		return newError(CodeBlobUploadUnknown, "blob upload unknown to registry", nil)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func blobCreated(w http.ResponseWriter, name, digest string) error {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func uploadAccepted(w http.ResponseWriter, name, id string, size int64, status int) error {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max64(size-1, 0)))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+	return nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
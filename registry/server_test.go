@@ -0,0 +1,252 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"prepare.sh/dockermock/oci"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(NewServer(t.TempDir()))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// request sends a request to srv and returns the response with its body
+func request(t *testing.T, srv *httptest.Server, method, path string, body []byte) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, content
+}
+
+// errorCode returns the code of the first error of an error response
+func errorCode(body []byte) string {
+	var errResp errorResponse
+	if json.Unmarshal(body, &errResp) != nil || len(errResp.Errors) == 0 {
+		return ""
+	}
+	return errResp.Errors[0].Code
+}
+
+// uploadBlob uploads content to repo in two chunks and returns its digest
+func uploadBlob(t *testing.T, srv *httptest.Server, repo string, content []byte) string {
+	t.Helper()
+	resp, _ := request(t, srv, http.MethodPost, "/v2/"+repo+"/blobs/uploads/", nil)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST upload: %s", resp.Status)
+	}
+	location := resp.Header.Get("Location")
+	half := len(content) / 2
+	if resp, body := request(t, srv, http.MethodPatch, location, content[:half]); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("PATCH upload: %s: %s", resp.Status, body)
+	}
+	digest := oci.Digest(content)
+	if resp, body := request(t, srv, http.MethodPut, location+"?digest="+digest, content[half:]); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT upload: %s: %s", resp.Status, body)
+	}
+	return digest
+}
+
+// testManifest returns a manifest for a config and layers given by
+// content, uploading those in uploaded to repo
+func testManifest(t *testing.T, srv *httptest.Server, repo string, config []byte, layers [][]byte, uploaded bool) []byte {
+	t.Helper()
+	describe := func(mediaType string, content []byte) oci.Descriptor {
+		if uploaded {
+			uploadBlob(t, srv, repo, content)
+		}
+		return oci.Descriptor{MediaType: mediaType, Digest: oci.Digest(content), Size: int64(len(content))}
+	}
+	m := oci.Manifest{SchemaVersion: 2, MediaType: oci.MediaTypeImageManifest, Config: describe(oci.MediaTypeImageConfig, config)}
+	for _, layer := range layers {
+		m.Layers = append(m.Layers, describe(oci.MediaTypeImageLayerGzip, layer))
+	}
+	content, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	srv := newTestServer(t)
+	layer := []byte("layer content")
+	manifest := testManifest(t, srv, "team/app", []byte(`{"architecture":"amd64"}`), [][]byte{layer}, true)
+	digest := oci.Digest(manifest)
+
+	resp, body := request(t, srv, http.MethodPut, "/v2/team/app/manifests/1.0", manifest)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT manifest: %s: %s", resp.Status, body)
+	}
+	if got := resp.Header.Get("Docker-Content-Digest"); got != digest {
+		t.Errorf("Docker-Content-Digest = %q, want %q", got, digest)
+	}
+	if got := resp.Header.Get("Location"); got != "/v2/team/app/manifests/"+digest {
+		t.Errorf("Location = %q", got)
+	}
+
+	for _, ref := range []string{"1.0", digest} {
+		resp, body := request(t, srv, http.MethodGet, "/v2/team/app/manifests/"+ref, nil)
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(body, manifest) {
+			t.Errorf("GET manifest %s: %s: %s", ref, resp.Status, body)
+		}
+		if resp.Header.Get("Content-Type") != oci.MediaTypeImageManifest || resp.Header.Get("Docker-Content-Digest") != digest {
+			t.Errorf("GET manifest %s headers = %v", ref, resp.Header)
+		}
+	}
+	resp, body = request(t, srv, http.MethodHead, "/v2/team/app/manifests/1.0", nil)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 || resp.ContentLength != int64(len(manifest)) {
+		t.Errorf("HEAD manifest: %s, %d bytes, Content-Length %d", resp.Status, len(body), resp.ContentLength)
+	}
+	if resp, body := request(t, srv, http.MethodGet, "/v2/team/app/blobs/"+oci.Digest(layer), nil); !bytes.Equal(body, layer) {
+		t.Errorf("GET blob: %s: %q", resp.Status, body)
+	}
+
+	// Unknown tags and digests of other repositories are not found
+	for _, path := range []string{"/v2/team/app/manifests/2.0", "/v2/other/manifests/" + digest} {
+		if resp, body := request(t, srv, http.MethodGet, path, nil); resp.StatusCode != http.StatusNotFound || errorCode(body) != CodeManifestUnknown {
+			t.Errorf("GET %s: %s: %s", path, resp.Status, body)
+		}
+	}
+
+	// Deleting by digest removes the tag too
+	if resp, _ := request(t, srv, http.MethodDelete, "/v2/team/app/manifests/"+digest, nil); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("DELETE manifest: %s", resp.Status)
+	}
+	if resp, _ := request(t, srv, http.MethodGet, "/v2/team/app/manifests/1.0", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET deleted manifest: %s", resp.Status)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	srv := newTestServer(t)
+	for _, repo := range []string{"app", "lib/x", "zoo"} {
+		manifest := testManifest(t, srv, repo, []byte(repo), nil, true)
+		for _, tag := range []string{"d", "b", "a", "c"} {
+			if resp, body := request(t, srv, http.MethodPut, "/v2/"+repo+"/manifests/"+tag, manifest); resp.StatusCode != http.StatusCreated {
+				t.Fatalf("PUT %s:%s: %s: %s", repo, tag, resp.Status, body)
+			}
+		}
+	}
+
+	tests := []struct {
+		path     string
+		want     string
+		wantLink string
+	}{
+		{"/v2/app/tags/list", "a b c d", ""},
+		{"/v2/app/tags/list?n=2", "a b", `</v2/app/tags/list?n=2&last=b>; rel="next"`},
+		{"/v2/app/tags/list?n=2&last=b", "c d", ""},
+		{"/v2/app/tags/list?n=1&last=bb", "c", `</v2/app/tags/list?n=1&last=c>; rel="next"`},
+		{"/v2/app/tags/list?last=d", "", ""},
+		{"/v2/app/tags/list?n=10", "a b c d", ""},
+		{"/v2/_catalog", "app lib/x zoo", ""},
+		{"/v2/_catalog?n=1", "app", `</v2/_catalog?n=1&last=app>; rel="next"`},
+		{"/v2/_catalog?n=1&last=app", "lib/x", `</v2/_catalog?n=1&last=lib/x>; rel="next"`},
+		{"/v2/_catalog?n=5&last=lib/x", "zoo", ""},
+	}
+	for _, tt := range tests {
+		resp, body := request(t, srv, http.MethodGet, tt.path, nil)
+		var list struct {
+			Tags         []string `json:"tags"`
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: %s: %s", tt.path, resp.Status, body)
+			continue
+		}
+		got := strings.Join(append(list.Tags, list.Repositories...), " ")
+		if got != tt.want {
+			t.Errorf("GET %s = %q, want %q", tt.path, got, tt.want)
+		}
+		if link := resp.Header.Get("Link"); link != tt.wantLink {
+			t.Errorf("GET %s Link = %q, want %q", tt.path, link, tt.wantLink)
+		}
+	}
+
+	if resp, body := request(t, srv, http.MethodGet, "/v2/nope/tags/list", nil); errorCode(body) != CodeNameUnknown {
+		t.Errorf("GET tags of an unknown repository: %s: %s", resp.Status, body)
+	}
+}
+
+func TestUploadRejectsDigestMismatch(t *testing.T) {
+	content := []byte("blob content")
+	other := oci.Digest([]byte("other content"))
+	tests := []struct {
+		name   string
+		upload func(srv *httptest.Server) (*http.Response, []byte)
+		code   string
+	}{
+		{"chunked", func(srv *httptest.Server) (*http.Response, []byte) {
+			resp, _ := request(t, srv, http.MethodPost, "/v2/app/blobs/uploads/", nil)
+			return request(t, srv, http.MethodPut, resp.Header.Get("Location")+"?digest="+other, content)
+		}, CodeDigestInvalid},
+		{"monolithic", func(srv *httptest.Server) (*http.Response, []byte) {
+			return request(t, srv, http.MethodPost, "/v2/app/blobs/uploads/?digest="+other, content)
+		}, CodeDigestInvalid},
+		{"malformed digest", func(srv *httptest.Server) (*http.Response, []byte) {
+			return request(t, srv, http.MethodPost, "/v2/app/blobs/uploads/?digest=sha256:abc", content)
+		}, CodeDigestInvalid},
+		{"unknown upload", func(srv *httptest.Server) (*http.Response, []byte) {
+			return request(t, srv, http.MethodPut, "/v2/app/blobs/uploads/"+strings.Repeat("0", 32)+"?digest="+oci.Digest(content), content)
+		}, CodeBlobUploadUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			resp, body := tt.upload(srv)
+			if resp.StatusCode < 400 || errorCode(body) != tt.code {
+				t.Errorf("upload: %s: %s, want %s", resp.Status, body, tt.code)
+			}
+			for _, digest := range []string{other, oci.Digest(content)} {
+				if resp, _ := request(t, srv, http.MethodHead, "/v2/app/blobs/"+digest, nil); resp.StatusCode != http.StatusNotFound {
+					t.Errorf("HEAD %s after a rejected upload: %s", digest, resp.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestPutManifestRejectsMissingBlobs(t *testing.T) {
+	srv := newTestServer(t)
+	config := []byte(`{"architecture":"amd64"}`)
+	uploadBlob(t, srv, "app", config)
+	manifest := testManifest(t, srv, "app", config, [][]byte{[]byte("never uploaded")}, false)
+
+	resp, body := request(t, srv, http.MethodPut, "/v2/app/manifests/1.0", manifest)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(body) != CodeManifestBlobUnknown {
+		t.Fatalf("PUT manifest: %s: %s", resp.Status, body)
+	}
+	if !strings.Contains(string(body), oci.Digest([]byte("never uploaded"))) {
+		t.Errorf("error does not name the missing blob: %s", body)
+	}
+	if resp, _ := request(t, srv, http.MethodGet, "/v2/app/manifests/1.0", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("rejected manifest was tagged: %s", resp.Status)
+	}
+
+	// A manifest put by digest must hash to it
+	valid := testManifest(t, srv, "app", config, nil, false)
+	resp, body = request(t, srv, http.MethodPut, "/v2/app/manifests/"+oci.Digest(manifest), valid)
+	if errorCode(body) != CodeDigestInvalid {
+		t.Errorf("PUT manifest under another digest: %s: %s", resp.Status, body)
+	}
+}
@@ -0,0 +1,232 @@
+// registry/storage.go
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"prepare.sh/dockermock/oci"
+)
+
+// storage lays out registry content on disk:
+//
+//	blobs/sha256/<hex>                       content, shared by all repositories
+//	repositories/<name>/_manifests/tags/<tag> digest the tag points to
+//	repositories/<name>/_manifests/revisions/<hex>
+//	                                         marks a manifest as part of name
+//	uploads/<uuid>                           blob uploads in progress
+type storage struct {
+	root string
+}
+
+func (s *storage) blobPath(digest string) string {
+	return filepath.Join(s.root, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (s *storage) manifestsPath(name string) string {
+	return filepath.Join(s.root, "repositories", filepath.FromSlash(name), "_manifests")
+}
+
+func (s *storage) uploadPath(id string) string {
+	return filepath.Join(s.root, "uploads", id)
+}
+
+// statBlob returns the size of a blob
+func (s *storage) statBlob(digest string) (int64, error) {
+	info, err := os.Stat(s.blobPath(digest))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// readBlob returns the content of a blob
+func (s *storage) readBlob(digest string) ([]byte, error) {
+	return os.ReadFile(s.blobPath(digest))
+}
+
+// putBlob stores content under its digest
+func (s *storage) putBlob(content []byte) (string, error) {
+	digest := oci.Digest(content)
+	return digest, writeFile(s.blobPath(digest), content)
+}
+
+// startUpload creates an empty upload and returns its ID
+func (s *storage) startUpload() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	return id, writeFile(s.uploadPath(id), nil)
+}
+
+// appendUpload adds a chunk to an upload and returns its new size
+func (s *storage) appendUpload(id string, chunk io.Reader) (int64, error) {
+	f, err := os.OpenFile(s.uploadPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, chunk); err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// uploadSize returns the number of bytes received so far
+func (s *storage) uploadSize(id string) (int64, error) {
+	info, err := os.Stat(s.uploadPath(id))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// finishUpload moves a completed upload into the blob store if it matches
+// digest
+func (s *storage) finishUpload(id, digest string) error {
+	content, err := os.ReadFile(s.uploadPath(id))
+	if err != nil {
+		return err
+	}
+	if oci.Digest(content) != digest {
+		return newError(CodeDigestInvalid, "provided digest did not match uploaded content", nil)
+	}
+	if _, err := s.putBlob(content); err != nil {
+		return err
+	}
+	return os.Remove(s.uploadPath(id))
+}
+
+// cancelUpload discards an upload
+func (s *storage) cancelUpload(id string) error {
+	return os.Remove(s.uploadPath(id))
+}
+
+// putManifest stores a manifest in repository name, tagging it when tag is
+// not empty
+func (s *storage) putManifest(name, tag string, content []byte) (string, error) {
+	digest, err := s.putBlob(content)
+	if err != nil {
+		return "", err
+	}
+	dir := s.manifestsPath(name)
+	if err := writeFile(filepath.Join(dir, "revisions", strings.TrimPrefix(digest, "sha256:")), nil); err != nil {
+		return "", err
+	}
+	if tag != "" {
+		if err := writeFile(filepath.Join(dir, "tags", tag), []byte(digest)); err != nil {
+			return "", err
+		}
+	}
+	return digest, nil
+}
+
+// resolveManifest returns the digest of a manifest in repository name,
+// given a tag or a digest
+func (s *storage) resolveManifest(name, ref string) (string, error) {
+	dir := s.manifestsPath(name)
+	if !strings.HasPrefix(ref, "sha256:") {
+		digest, err := os.ReadFile(filepath.Join(dir, "tags", ref))
+		if err != nil {
+			return "", err
+		}
+		return string(digest), nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, "revisions", strings.TrimPrefix(ref, "sha256:"))); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+// deleteManifest removes a manifest and every tag pointing to it from
+// repository name
+func (s *storage) deleteManifest(name, digest string) error {
+	dir := s.manifestsPath(name)
+	if err := os.Remove(filepath.Join(dir, "revisions", strings.TrimPrefix(digest, "sha256:"))); err != nil {
+		return err
+	}
+	tags, _ := s.tags(name)
+	for _, tag := range tags {
+		if d, err := s.resolveManifest(name, tag); err == nil && d == digest {
+			os.Remove(filepath.Join(dir, "tags", tag))
+		}
+	}
+	return nil
+}
+
+// tags lists the tags of repository name in lexical order
+func (s *storage) tags(name string) ([]string, error) {
+	if _, err := os.Stat(s.manifestsPath(name)); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(s.manifestsPath(name), "tags"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	tags := []string{}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".") {
+			tags = append(tags, e.Name())
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// repositories lists every repository holding manifests, in lexical order
+func (s *storage) repositories() ([]string, error) {
+	root := filepath.Join(s.root, "repositories")
+	repos := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == "_manifests" {
+			rel, _ := filepath.Rel(root, filepath.Dir(path))
+			repos = append(repos, filepath.ToSlash(rel))
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	sort.Strings(repos)
+	return repos, err
+}
+
+// writeFile writes content to a temp file and renames it into place, so
+// concurrent readers never see partial content
+func writeFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store %s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
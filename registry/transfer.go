@@ -0,0 +1,131 @@
+// registry/transfer.go
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"prepare.sh/dockermock/oci"
+)
+
+// Progress receives per-layer status updates, keyed by the short form of the
+// layer digest, e.g. ("a1b2c3d4e5f6", "Pushed")
+type Progress func(id, status string)
+
+// Pulled is an image downloaded from a registry
+type Pulled struct {
+	Digest   string // digest of the manifest, or of the index it was chosen from
+	Manifest []byte
+	Config   []byte
+	Layers   [][]byte
+	Size     int64 // total size of config and layers
+}
+
+// ShortDigest returns the 12-character form of a digest used in progress
+// output
+func ShortDigest(digest string) string {
+	hex := digest[len("sha256:"):]
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
+
+// Push uploads the layers and config manifest refers to, reading them with
+// blob, then tags the manifest in repo. Blobs the registry already has are
+// not uploaded again. It returns the manifest digest and size.
+func Push(c *Client, repo, tag string, manifest []byte, blob func(digest string) ([]byte, error), progress Progress) (string, int, error) {
+	var parsed oci.Manifest
+	if err := json.Unmarshal(manifest, &parsed); err != nil {
+		return "", 0, fmt.Errorf("invalid image manifest: %v", err)
+	}
+
+	upload := func(digest string) (bool, error) {
+		exists, err := c.BlobExists(repo, digest)
+		if err != nil || exists {
+			return false, err
+		}
+		content, err := blob(digest)
+		if err != nil {
+			return false, err
+		}
+		_, err = c.PushBlob(repo, content)
+		return err == nil, err
+	}
+
+	for _, layer := range parsed.Layers {
+		id := ShortDigest(layer.Digest)
+		progress(id, "Preparing")
+		pushed, err := upload(layer.Digest)
+		if err != nil {
+			return "", 0, err
+		}
+		if pushed {
+			progress(id, "Pushed")
+		} else {
+			progress(id, "Layer already exists")
+		}
+	}
+	if _, err := upload(parsed.Config.Digest); err != nil {
+		return "", 0, err
+	}
+
+	mediaType := parsed.MediaType
+	if mediaType == "" {
+		mediaType = oci.MediaTypeImageManifest
+	}
+	digest, err := c.PutManifest(repo, tag, mediaType, manifest)
+	return digest, len(manifest), err
+}
+
+// Pull downloads the image ref, a tag or digest, of repo. For a
+// multi-platform index the linux/amd64 image is chosen.
+func Pull(c *Client, repo, ref string, progress Progress) (*Pulled, error) {
+	content, mediaType, digest, err := c.GetManifest(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType == oci.MediaTypeImageIndex {
+		var index oci.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, fmt.Errorf("invalid image index: %v", err)
+		}
+		chosen := ""
+		for _, m := range index.Manifests {
+			if m.Platform == nil || (m.Platform.OS == "linux" && m.Platform.Architecture == "amd64") {
+				chosen = m.Digest
+				break
+			}
+		}
+		if chosen == "" {
+			return nil, fmt.Errorf("no matching manifest for linux/amd64 in the manifest list entries")
+		}
+		if content, _, _, err = c.GetManifest(repo, chosen); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest oci.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid image manifest: %v", err)
+	}
+
+	pulled := &Pulled{Digest: digest, Manifest: content}
+	for _, layer := range manifest.Layers {
+		id := ShortDigest(layer.Digest)
+		progress(id, "Pulling fs layer")
+		blob, err := c.GetBlob(repo, layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		progress(id, "Pull complete")
+		pulled.Layers = append(pulled.Layers, blob)
+		pulled.Size += int64(len(blob))
+	}
+	if pulled.Config, err = c.GetBlob(repo, manifest.Config.Digest); err != nil {
+		return nil, err
+	}
+	pulled.Size += int64(len(pulled.Config))
+	return pulled, nil
+}
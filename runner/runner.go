@@ -0,0 +1,68 @@
+// runner/runner.go
+
+// Package runner runs external programs such as gh, git and kubectl behind
+// an interface, so the code driving them can be exercised against fakes.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Cmd describes an external command
+type Cmd struct {
+	Name   string
+	Args   []string
+	Dir    string // working directory; empty means the current one
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// String renders the command line, e.g. "git push -u origin main"
+func (c Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Runner runs external commands
+type Runner interface {
+	// LookPath reports an error if the program name is not installed
+	LookPath(name string) error
+	// Run runs cmd to completion, or until ctx is done
+	Run(ctx context.Context, cmd Cmd) error
+}
+
+// Exec runs commands as processes on this machine
+type Exec struct{}
+
+func (Exec) LookPath(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
+func (Exec) Run(ctx context.Context, cmd Cmd) error {
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Dir = cmd.Dir
+	c.Stdin = cmd.Stdin
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	return c.Run()
+}
+
+// Output runs cmd and returns its standard output. If the command fails,
+// its standard error is part of the error.
+func Output(ctx context.Context, r Runner, cmd Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := r.Run(ctx, cmd); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.Bytes(), fmt.Errorf("%s: %v: %s", cmd.Name, err, msg)
+		}
+		return stdout.Bytes(), fmt.Errorf("%s: %v", cmd.Name, err)
+	}
+	return stdout.Bytes(), nil
+}